@@ -0,0 +1,399 @@
+// backup.go - Snapshots of the data path
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pterm/pterm"
+
+	"eternal/pkg/backup"
+	indexing "eternal/pkg/search"
+)
+
+// Files and directories of the data path that snapshots hold.
+const (
+	databaseFile = "eternaldata.db"
+	vectorsDir   = "vectors"
+	uploadsDir   = "web/uploads"
+	modelsDir    = "models"
+	configName   = "config.yml" // Name of the config file in a snapshot
+	backupsDir   = "backups"    // Default directory of the snapshots under the data path
+)
+
+// snapshotSources are the sources a snapshot may hold. Restores refuse any other, since
+// sources are restored to their name under the data path.
+var snapshotSources = map[string]bool{
+	databaseFile:   true,
+	searchIndexDir: true,
+	vectorsDir:     true,
+	uploadsDir:     true,
+	modelsDir:      true,
+	configName:     true,
+}
+
+// backupMu lets one snapshot or restore run at a time.
+var backupMu sync.Mutex
+
+// BackupConfig sets where snapshots of the data path are written and how long they are kept.
+type BackupConfig struct {
+	Path          string `yaml:"path"`           // Directory of the snapshots, data_path/backups by default
+	Keep          int    `yaml:"keep"`           // Snapshots kept, all when zero
+	MaxAge        string `yaml:"max_age"`        // Duration after which snapshots are deleted, such as 720h
+	Interval      string `yaml:"interval"`       // Duration between snapshots while the server runs; empty disables them
+	IncludeModels bool   `yaml:"include_models"` // Add the downloaded models, which can take many gigabytes
+}
+
+// Validate checks the retention and schedule.
+func (c BackupConfig) Validate() error {
+	if c.Keep < 0 {
+		return fmt.Errorf("keep cannot be negative")
+	}
+	if _, err := c.retention(); err != nil {
+		return err
+	}
+	if _, err := c.interval(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// dir returns the directory of the snapshots.
+func (c BackupConfig) dir(dataPath string) string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(dataPath, backupsDir)
+}
+
+// retention returns the rules that decide which snapshots are kept.
+func (c BackupConfig) retention() (backup.Retention, error) {
+	r := backup.Retention{Keep: c.Keep}
+	if c.MaxAge != "" {
+		age, err := time.ParseDuration(c.MaxAge)
+		if err != nil || age <= 0 {
+			return r, fmt.Errorf("invalid max_age %q", c.MaxAge)
+		}
+		r.MaxAge = age
+	}
+	return r, nil
+}
+
+// interval returns the time between scheduled snapshots, zero when they are disabled.
+func (c BackupConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", c.Interval)
+	}
+	return interval, nil
+}
+
+// SnapshotResult is a snapshot that was just taken.
+type SnapshotResult struct {
+	backup.Snapshot
+	Files  int      `json:"files"`
+	Pruned []string `json:"pruned,omitempty"` // Older snapshots deleted by the retention rules
+}
+
+// createSnapshot writes a snapshot of the database, search indexes, vector collections,
+// uploads and config, and of the models when includeModels is set, then deletes the
+// snapshots past the retention. The stores stay in use: the database, each search index and
+// each vector collection are copied as they are at one point in time into a staging
+// directory first. Documents being ingested during the snapshot are ingested again after a
+// restore.
+func createSnapshot(config *AppConfig, includeModels bool, now time.Time) (SnapshotResult, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	retention, err := config.Backup.retention()
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	dir := config.Backup.dir(config.DataPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return SnapshotResult{}, fmt.Errorf("error creating backup directory: %v", err)
+	}
+	staging, err := os.MkdirTemp(dir, ".staging-")
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	// VACUUM INTO writes a compacted copy from a single read transaction.
+	if err := sqliteDB.db.Exec("VACUUM INTO ?", filepath.Join(staging, databaseFile)).Error; err != nil {
+		return SnapshotResult{}, fmt.Errorf("error copying database: %v", err)
+	}
+	err = searchIndexes.Each(func(name string, index bleve.Index) error {
+		if err := indexing.Snapshot(index, filepath.Join(staging, searchIndexDir, name+".bleve")); err != nil {
+			return fmt.Errorf("error copying search index %s: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	if err := vectorStores.Backup(filepath.Join(staging, vectorsDir)); err != nil {
+		return SnapshotResult{}, fmt.Errorf("error copying vector store: %v", err)
+	}
+
+	sources := []backup.Source{
+		{Name: databaseFile, Path: filepath.Join(staging, databaseFile)},
+		{Name: searchIndexDir, Path: filepath.Join(staging, searchIndexDir)},
+		{Name: vectorsDir, Path: filepath.Join(staging, vectorsDir)},
+		{Name: uploadsDir, Path: filepath.Join(config.DataPath, filepath.FromSlash(uploadsDir))},
+	}
+	if config.path != "" {
+		sources = append(sources, backup.Source{Name: configName, Path: config.path})
+	}
+	if includeModels {
+		sources = append(sources, backup.Source{Name: modelsDir, Path: filepath.Join(config.DataPath, modelsDir)})
+	}
+
+	name := backup.Name(now)
+	manifest, err := backup.Write(osFS, filepath.Join(dir, name), now, sources)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	result := SnapshotResult{
+		Snapshot: backup.Snapshot{Name: name, Size: info.Size(), CreatedAt: manifest.CreatedAt},
+		Files:    len(manifest.Files),
+	}
+	result.Pruned, err = backup.Prune(osFS, dir, retention, now)
+	if err != nil {
+		return result, fmt.Errorf("error deleting old snapshots: %v", err)
+	}
+	return result, nil
+}
+
+// RestoreResult describes a restored snapshot.
+type RestoreResult struct {
+	Manifest backup.Manifest
+	Replaced string // Directory the replaced files were moved to, empty if there were none
+}
+
+// restoreSnapshot replaces the data path with the contents of a snapshot. The snapshot is
+// extracted and checked against its manifest before anything is replaced, and the files it
+// replaces are moved to a replaced-<time> directory under the data path rather than deleted.
+// The config is only replaced when restoreConfig is set. The server must not be running.
+func restoreSnapshot(config *AppConfig, archive string, restoreConfig bool, now time.Time) (RestoreResult, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	stamp := now.UTC().Format("20060102-150405")
+	staging := filepath.Join(config.DataPath, ".restore-"+stamp)
+	defer os.RemoveAll(staging)
+
+	manifest, err := backup.Extract(osFS, archive, staging)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	for _, name := range manifest.Sources {
+		if !snapshotSources[name] {
+			return RestoreResult{}, fmt.Errorf("snapshot has an unknown source %q", name)
+		}
+	}
+
+	result := RestoreResult{Manifest: manifest}
+	replaced := filepath.Join(config.DataPath, "replaced-"+stamp)
+	moveAside := func(name, path string) error {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		target := filepath.Join(replaced, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		result.Replaced = replaced
+		return os.Rename(path, target)
+	}
+
+	// A legacy index would be migrated into the restored indexes on the next start.
+	if err := moveAside(legacySearchIndex, filepath.Join(config.DataPath, legacySearchIndex)); err != nil {
+		return result, err
+	}
+
+	// Sources without files in the snapshot did not exist when it was taken, so the current
+	// ones are moved aside too.
+	for _, name := range manifest.Sources {
+		if name == configName {
+			continue
+		}
+		path := filepath.Join(config.DataPath, filepath.FromSlash(name))
+		if err := moveAside(name, path); err != nil {
+			return result, fmt.Errorf("error replacing %s: %v", name, err)
+		}
+		staged := filepath.Join(staging, filepath.FromSlash(name))
+		if _, err := os.Stat(staged); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return result, err
+		}
+		if err := os.Rename(staged, path); err != nil {
+			return result, fmt.Errorf("error restoring %s: %v", name, err)
+		}
+	}
+
+	if restoreConfig && config.path != "" {
+		data, err := os.ReadFile(filepath.Join(staging, configName))
+		if errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("snapshot has no config")
+		}
+		if err != nil {
+			return result, err
+		}
+		// The config may be on another file system than the data path, so it is copied.
+		if current, err := os.ReadFile(config.path); err == nil {
+			if err := os.MkdirAll(replaced, 0755); err != nil {
+				return result, err
+			}
+			if err := os.WriteFile(filepath.Join(replaced, configName), current, 0644); err != nil {
+				return result, err
+			}
+			result.Replaced = replaced
+		}
+		if err := os.WriteFile(config.path, data, 0644); err != nil {
+			return result, fmt.Errorf("error restoring config: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// snapshotPath returns the file of a snapshot given by name in the backup directory or by
+// path.
+func snapshotPath(config *AppConfig, snapshot string) string {
+	if backup.ValidName(snapshot) {
+		path := filepath.Join(config.Backup.dir(config.DataPath), snapshot)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return snapshot
+}
+
+// runBackupCommand takes a snapshot of the data path with the server stopped, or lists the
+// snapshots with "backup list".
+func runBackupCommand(config *AppConfig, args []string) error {
+	if err := config.Backup.Validate(); err != nil {
+		return fmt.Errorf("invalid backup config: %v", err)
+	}
+
+	if len(args) > 0 && args[0] == "list" {
+		snapshots, err := backup.List(osFS, config.Backup.dir(config.DataPath))
+		if err != nil {
+			return err
+		}
+		tableData := pterm.TableData{{"Snapshot", "Size (MB)", "Created"}}
+		for _, s := range snapshots {
+			tableData = append(tableData, []string{s.Name, fmt.Sprintf("%.1f", float64(s.Size)/(1<<20)), s.CreatedAt.Local().Format(time.DateTime)})
+		}
+		return pterm.DefaultTable.WithData(tableData).WithHasHeader().Render()
+	}
+
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	models := fs.Bool("models", config.Backup.IncludeModels, "Add the downloaded models to the snapshot")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := createDataDirectory(config.DataPath); err != nil {
+		return err
+	}
+	if err := initializeDatabase(config.DataPath); err != nil {
+		return err
+	}
+	if err := initializeSearchIndex(config.DataPath); err != nil {
+		return err
+	}
+	defer searchIndexes.Close()
+	if err := initializeVectorStore(config); err != nil {
+		return err
+	}
+	defer func() {
+		if err := errors.Join(vectorStores.Close(), closeEmbedders()); err != nil {
+			pterm.Error.Println("Error closing stores:", err)
+		}
+	}()
+
+	result, err := createSnapshot(config, *models, time.Now())
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printf("Wrote %s: %d files, %.1f MB\n", filepath.Join(config.Backup.dir(config.DataPath), result.Name), result.Files, float64(result.Size)/(1<<20))
+	for _, name := range result.Pruned {
+		pterm.Info.Println("Deleted old snapshot", name)
+	}
+	return nil
+}
+
+// runRestoreCommand restores a snapshot given by name or path. It must run with the server
+// stopped.
+func runRestoreCommand(config *AppConfig, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	restoreConfig := fs.Bool("config", false, "Also replace config.yml with the config in the snapshot")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: eternal restore [-config] <snapshot name or file>")
+	}
+
+	if err := createDataDirectory(config.DataPath); err != nil {
+		return err
+	}
+	result, err := restoreSnapshot(config, snapshotPath(config, fs.Arg(0)), *restoreConfig, time.Now())
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printf("Restored %d files from the snapshot taken %s\n", len(result.Manifest.Files), result.Manifest.CreatedAt.Local().Format(time.DateTime))
+	if result.Replaced != "" {
+		pterm.Info.Println("The replaced files were moved to", result.Replaced)
+	}
+	return nil
+}
+
+// startBackupSchedule takes a snapshot every interval of the backup config until ctx is
+// canceled.
+func startBackupSchedule(ctx context.Context, config *AppConfig) {
+	interval, err := config.Backup.interval()
+	if err != nil || interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			result, err := createSnapshot(config, config.Backup.IncludeModels, time.Now())
+			if err != nil {
+				log.Errorf("Error taking scheduled snapshot: %v", err)
+				continue
+			}
+			log.Infof("Took snapshot %s (%d files)", result.Name, result.Files)
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/backup"
+	"eternal/pkg/vecstore"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	withTestDB(t)
+	require.NoError(t, db.Create(&Document{ID: "backup-doc", Name: "manual.md", Collection: CollectionDocuments}).Error)
+	defer db.Delete(&Document{ID: "backup-doc"})
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	collections := useTestMemoryStores(t, now)
+	chat, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	require.NoError(t, chat.Put(vecstore.Record{ID: "turn-1", Text: "Remembered", Vector: []float32{1, 0}}))
+
+	dataPath := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(configPath, []byte("data_path: before"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dataPath, "web", "uploads"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, "web", "uploads", "manual.md"), []byte("# Manual"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dataPath, modelsDir, "llama"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, modelsDir, "llama", "model.gguf"), []byte("weights"), 0644))
+	config := &AppConfig{DataPath: dataPath, Backup: BackupConfig{Keep: 1}, path: configPath}
+
+	result, err := createSnapshot(config, false, now)
+	require.NoError(t, err)
+	assert.Equal(t, backup.Name(now), result.Name)
+	assert.Empty(t, result.Pruned)
+	archive := filepath.Join(dataPath, backupsDir, result.Name)
+	assert.FileExists(t, archive)
+	staging, _ := filepath.Glob(filepath.Join(dataPath, backupsDir, ".staging-*"))
+	assert.Empty(t, staging)
+
+	// The data path changes after the snapshot.
+	require.NoError(t, os.Remove(filepath.Join(dataPath, "web", "uploads", "manual.md")))
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, databaseFile), []byte("newer"), 0644))
+	require.NoError(t, os.WriteFile(configPath, []byte("data_path: after"), 0644))
+
+	restored, err := restoreSnapshot(config, snapshotPath(config, result.Name), true, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, result.Files, len(restored.Manifest.Files))
+
+	data, err := os.ReadFile(filepath.Join(dataPath, "web", "uploads", "manual.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# Manual", string(data))
+	data, err = os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "data_path: before", string(data))
+	assert.FileExists(t, filepath.Join(dataPath, modelsDir, "llama", "model.gguf"), "models are left alone")
+
+	// The replaced files are kept.
+	data, err = os.ReadFile(filepath.Join(restored.Replaced, databaseFile))
+	require.NoError(t, err)
+	assert.Equal(t, "newer", string(data))
+	assert.FileExists(t, filepath.Join(restored.Replaced, configName))
+
+	// The restored stores open with their contents.
+	restoredDB, err := NewSQLiteDB(dataPath)
+	require.NoError(t, err)
+	var doc Document
+	require.NoError(t, restoredDB.db.First(&doc, "id = ?", "backup-doc").Error)
+	assert.Equal(t, "manual.md", doc.Name)
+	sqlDB, err := restoredDB.db.DB()
+	require.NoError(t, err)
+	sqlDB.Close()
+
+	indexes, err := OpenSearchIndexes(filepath.Join(dataPath, searchIndexDir), newSearchIndexMapping())
+	require.NoError(t, err)
+	infos, err := indexes.List()
+	require.NoError(t, err)
+	assert.Equal(t, []SearchIndexInfo{{Name: CollectionChat, Documents: 4}}, infos)
+	require.NoError(t, indexes.Close())
+
+	stores, err := vecstore.OpenCollections(filepath.Join(dataPath, vectorsDir), vecstore.Options{})
+	require.NoError(t, err)
+	defer stores.Close()
+	store, err := stores.Get(CollectionChat)
+	require.NoError(t, err)
+	record, ok, err := store.Get("turn-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Remembered", record.Text)
+
+	// Retention keeps one snapshot, and models are added on request.
+	next, err := createSnapshot(config, true, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{result.Name}, next.Pruned)
+	manifest, err := backup.Extract(osFS, filepath.Join(dataPath, backupsDir, next.Name), t.TempDir())
+	require.NoError(t, err)
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	assert.Contains(t, paths, "models/llama/model.gguf")
+
+	_, err = restoreSnapshot(config, snapshotPath(config, result.Name), false, now.Add(3*time.Hour))
+	assert.ErrorIs(t, err, backup.ErrNotFound)
+}
+
+func TestRestoreRejectsUnknownSources(t *testing.T) {
+	parent := t.TempDir()
+	dataPath := filepath.Join(parent, "data")
+	require.NoError(t, os.MkdirAll(dataPath, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "outside.txt"), []byte("keep"), 0644))
+	config := &AppConfig{DataPath: dataPath}
+
+	for _, name := range []string{"../outside.txt", "/etc", "notes"} {
+		archive := filepath.Join(t.TempDir(), backup.Name(time.Now()))
+		_, err := backup.Write(osFS, archive, time.Now(), []backup.Source{{Name: name, Path: filepath.Join(parent, "missing")}})
+		require.NoError(t, err)
+
+		_, err = restoreSnapshot(config, archive, false, time.Now())
+		assert.ErrorContains(t, err, "unknown source", name)
+	}
+	data, err := os.ReadFile(filepath.Join(parent, "outside.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+	entries, err := os.ReadDir(dataPath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBackupConfigValidate(t *testing.T) {
+	assert.NoError(t, BackupConfig{}.Validate())
+	assert.NoError(t, BackupConfig{Keep: 7, MaxAge: "720h", Interval: "24h"}.Validate())
+	assert.Error(t, BackupConfig{Keep: -1}.Validate())
+	assert.Error(t, BackupConfig{MaxAge: "a month"}.Validate())
+	assert.Error(t, BackupConfig{Interval: "-1h"}.Validate())
+}
@@ -0,0 +1,485 @@
+// chatexport.go - Conversation export and import
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"eternal/pkg/web"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export formats supported by the chat export routes.
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatJSON     = "json"
+	ExportFormatHTML     = "html"
+	ExportFormatJSONL    = "jsonl"
+)
+
+// chatExportVersion is bumped whenever the Eternal JSON layout changes.
+const chatExportVersion = 1
+
+// ChatExport is the Eternal JSON export document.
+type ChatExport struct {
+	Format     string            `json:"format"`
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Sessions   []ExportedSession `json:"sessions"`
+}
+
+// ExportedSession is a chat session in the Eternal JSON export.
+type ExportedSession struct {
+	ID        int64          `json:"id,omitempty"`
+	Title     string         `json:"title"`
+	Summary   string         `json:"summary,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Turns     []ExportedTurn `json:"turns"`
+}
+
+// ExportedTurn is a single user prompt and its responses in the Eternal JSON export.
+type ExportedTurn struct {
+	Prompt    string             `json:"prompt"`
+	CreatedAt time.Time          `json:"created_at"`
+	Responses []ExportedResponse `json:"responses"`
+	Sources   []Citation         `json:"sources,omitempty"`
+}
+
+// ExportedResponse is a model response in the Eternal JSON export.
+type ExportedResponse struct {
+	Model     string    `json:"model"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// fineTuneMessage is a message in the OpenAI chat fine-tuning layout.
+type fineTuneMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// fineTuneExample is a single JSONL line in the OpenAI chat fine-tuning layout.
+type fineTuneExample struct {
+	Messages []fineTuneMessage `json:"messages"`
+}
+
+// ExportContentType returns the MIME type and file extension for an export format.
+func ExportContentType(format string) (string, string, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return "text/markdown; charset=utf-8", "md", nil
+	case ExportFormatJSON:
+		return "application/json", "json", nil
+	case ExportFormatHTML:
+		return "text/html; charset=utf-8", "html", nil
+	case ExportFormatJSONL:
+		return "application/jsonl", "jsonl", nil
+	default:
+		return "", "", fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ExportChatSessions renders the sessions in the requested format.
+func ExportChatSessions(sessions []ChatSession, format string) ([]byte, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return []byte(sessionsToMarkdown(sessions)), nil
+	case ExportFormatJSON:
+		return json.MarshalIndent(toChatExport(sessions), "", "  ")
+	case ExportFormatHTML:
+		return sessionsToHTML(sessions), nil
+	case ExportFormatJSONL:
+		return sessionsToJSONL(sessions)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// toChatExport converts database sessions into the Eternal JSON export document.
+func toChatExport(sessions []ChatSession) ChatExport {
+	export := ChatExport{
+		Format:     "eternal",
+		Version:    chatExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Sessions:   make([]ExportedSession, 0, len(sessions)),
+	}
+
+	for _, session := range sessions {
+		exported := ExportedSession{
+			ID:        session.ID,
+			Title:     sessionTitle(session),
+			Summary:   session.Summary,
+			CreatedAt: session.CreatedAt,
+			UpdatedAt: session.UpdatedAt,
+			Turns:     make([]ExportedTurn, 0, len(session.ChatTurns)),
+		}
+
+		for _, turn := range session.ChatTurns {
+			exportedTurn := ExportedTurn{
+				Prompt:    turn.UserPrompt,
+				CreatedAt: turn.CreatedAt,
+				Responses: make([]ExportedResponse, 0, len(turn.Responses)),
+				Sources:   turn.Sources,
+			}
+			for _, response := range turn.Responses {
+				exportedTurn.Responses = append(exportedTurn.Responses, ExportedResponse{
+					Model:     response.Model,
+					Content:   response.Content,
+					CreatedAt: response.CreatedAt,
+				})
+			}
+			exported.Turns = append(exported.Turns, exportedTurn)
+		}
+
+		export.Sessions = append(export.Sessions, exported)
+	}
+
+	return export
+}
+
+// sessionTitle returns the session title, falling back to the first prompt.
+func sessionTitle(session ChatSession) string {
+	if session.Title != "" {
+		return session.Title
+	}
+	if len(session.ChatTurns) > 0 {
+		title := strings.TrimSpace(strings.Split(session.ChatTurns[0].UserPrompt, "\n")[0])
+		if len([]rune(title)) > 80 {
+			title = string([]rune(title)[:80]) + "..."
+		}
+		if title != "" {
+			return title
+		}
+	}
+	return fmt.Sprintf("Chat %d", session.ID)
+}
+
+// sessionsToMarkdown renders the sessions as a Markdown transcript.
+func sessionsToMarkdown(sessions []ChatSession) string {
+	var sb strings.Builder
+
+	for i, session := range sessions {
+		if i > 0 {
+			sb.WriteString("\n---\n\n")
+		}
+
+		sb.WriteString(fmt.Sprintf("# %s\n\n", sessionTitle(session)))
+		sb.WriteString(fmt.Sprintf("_Session %d - %s_\n\n", session.ID, session.CreatedAt.Format("2006-01-02 15:04:05")))
+		if session.Summary != "" {
+			sb.WriteString(fmt.Sprintf("> %s\n\n", strings.ReplaceAll(strings.TrimSpace(session.Summary), "\n", "\n> ")))
+		}
+
+		for _, turn := range session.ChatTurns {
+			sb.WriteString("## User\n\n")
+			sb.WriteString(strings.TrimSpace(turn.UserPrompt))
+			sb.WriteString("\n\n")
+
+			for _, response := range turn.Responses {
+				if response.Model != "" {
+					sb.WriteString(fmt.Sprintf("## Assistant (%s)\n\n", response.Model))
+				} else {
+					sb.WriteString("## Assistant\n\n")
+				}
+				sb.WriteString(strings.TrimSpace(response.Content))
+				sb.WriteString("\n\n")
+			}
+
+			if len(turn.Sources) > 0 {
+				sb.WriteString("### Sources\n\n")
+				for _, source := range turn.Sources {
+					label := source.Label()
+					if link := source.Link(); link != "" {
+						label = fmt.Sprintf("[%s](%s)", label, link)
+					}
+					sb.WriteString(fmt.Sprintf("%d. %s\n", source.Number, label))
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// sessionsToHTML renders the sessions as a standalone HTML document.
+func sessionsToHTML(sessions []ChatSession) []byte {
+	title := "Eternal chat export"
+	if len(sessions) == 1 {
+		title = sessionTitle(sessions[0])
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<!doctype html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	buf.WriteString(fmt.Sprintf("<title>%s</title>\n", html.EscapeString(title)))
+	buf.WriteString("<style>body{font-family:sans-serif;max-width:860px;margin:2rem auto;line-height:1.5}pre{background:#f4f4f4;padding:1rem;overflow-x:auto}</style>\n")
+	buf.WriteString("</head>\n<body>\n")
+	buf.Write(web.MarkdownToHTML([]byte(sessionsToMarkdown(sessions))))
+	buf.WriteString("</body>\n</html>\n")
+
+	return buf.Bytes()
+}
+
+// sessionsToJSONL renders one OpenAI fine-tuning example per session.
+func sessionsToJSONL(sessions []ChatSession) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, session := range sessions {
+		var example fineTuneExample
+		for _, turn := range session.ChatTurns {
+			// Only the first response of a turn is used so the example stays a valid alternating conversation.
+			if len(turn.Responses) == 0 || strings.TrimSpace(turn.Responses[0].Content) == "" {
+				continue
+			}
+			example.Messages = append(example.Messages,
+				fineTuneMessage{Role: "user", Content: turn.UserPrompt},
+				fineTuneMessage{Role: "assistant", Content: turn.Responses[0].Content},
+			)
+		}
+
+		if len(example.Messages) == 0 {
+			continue
+		}
+
+		line, err := json.Marshal(example)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseChatImport converts an Eternal JSON export or a ChatGPT conversations export into chat sessions.
+func ParseChatImport(data []byte) ([]ChatSession, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty import")
+	}
+
+	switch data[0] {
+	case '{':
+		// A single Eternal export document or a single ChatGPT conversation.
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return nil, fmt.Errorf("error parsing import: %w", err)
+		}
+		if _, ok := probe["sessions"]; ok {
+			return parseEternalImport(data)
+		}
+		if _, ok := probe["mapping"]; ok {
+			return parseChatGPTImport([]byte("[" + string(data) + "]"))
+		}
+		return nil, errors.New("unrecognized import format")
+	case '[':
+		return parseChatGPTImport(data)
+	default:
+		return nil, errors.New("unrecognized import format")
+	}
+}
+
+// parseEternalImport converts an Eternal JSON export into chat sessions.
+func parseEternalImport(data []byte) ([]ChatSession, error) {
+	var export ChatExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("error parsing Eternal export: %w", err)
+	}
+
+	sessions := make([]ChatSession, 0, len(export.Sessions))
+	for _, exported := range export.Sessions {
+		session := ChatSession{
+			Title:     exported.Title,
+			Summary:   exported.Summary,
+			CreatedAt: exported.CreatedAt,
+			UpdatedAt: exported.UpdatedAt,
+		}
+
+		for _, exportedTurn := range exported.Turns {
+			turn := ChatTurn{
+				UserPrompt: exportedTurn.Prompt,
+				Sources:    exportedTurn.Sources,
+				CreatedAt:  exportedTurn.CreatedAt,
+			}
+			for _, response := range exportedTurn.Responses {
+				turn.Responses = append(turn.Responses, ChatResponse{
+					Model:     response.Model,
+					Content:   response.Content,
+					CreatedAt: response.CreatedAt,
+				})
+			}
+			session.ChatTurns = append(session.ChatTurns, turn)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// chatGPTConversation is a conversation in a ChatGPT conversations.json export.
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	UpdateTime  float64                `json:"update_time"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+// chatGPTNode is a node in the message tree of a ChatGPT conversation.
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+	Message  *chatGPTMessage `json:"message"`
+}
+
+// chatGPTMessage is a message in a ChatGPT conversation.
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+// text returns the concatenated text parts of the message, skipping attachments.
+func (m *chatGPTMessage) text() string {
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var part string
+		if err := json.Unmarshal(raw, &part); err == nil && strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// parseChatGPTImport converts a ChatGPT conversations.json export into chat sessions.
+func parseChatGPTImport(data []byte) ([]ChatSession, error) {
+	var conversations []chatGPTConversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("error parsing ChatGPT export: %w", err)
+	}
+
+	sessions := make([]ChatSession, 0, len(conversations))
+	for _, conversation := range conversations {
+		session := ChatSession{
+			Title:     conversation.Title,
+			CreatedAt: unixFloatToTime(conversation.CreateTime),
+			UpdatedAt: unixFloatToTime(conversation.UpdateTime),
+		}
+
+		var turn *ChatTurn
+		for _, message := range chatGPTThread(conversation) {
+			content := message.text()
+			if content == "" {
+				continue
+			}
+
+			switch message.Author.Role {
+			case "user":
+				if turn != nil {
+					session.ChatTurns = append(session.ChatTurns, *turn)
+				}
+				turn = &ChatTurn{UserPrompt: content, CreatedAt: unixFloatToTime(message.CreateTime)}
+			case "assistant":
+				if turn == nil {
+					continue
+				}
+				turn.Responses = append(turn.Responses, ChatResponse{
+					Model:     message.Metadata.ModelSlug,
+					Content:   content,
+					CreatedAt: unixFloatToTime(message.CreateTime),
+				})
+			}
+		}
+		if turn != nil {
+			session.ChatTurns = append(session.ChatTurns, *turn)
+		}
+
+		if len(session.ChatTurns) > 0 {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+// chatGPTThread returns the messages on the active branch of a ChatGPT conversation, oldest first.
+func chatGPTThread(conversation chatGPTConversation) []*chatGPTMessage {
+	nodeID := conversation.CurrentNode
+	if nodeID == "" {
+		nodeID = chatGPTLeaf(conversation.Mapping)
+	}
+
+	var thread []*chatGPTMessage
+	visited := make(map[string]bool)
+	for nodeID != "" && !visited[nodeID] {
+		visited[nodeID] = true
+		node, ok := conversation.Mapping[nodeID]
+		if !ok {
+			break
+		}
+		if node.Message != nil {
+			thread = append(thread, node.Message)
+		}
+		nodeID = node.Parent
+	}
+
+	// Reverse the thread so it reads from the root to the leaf.
+	for i, j := 0, len(thread)-1; i < j; i, j = i+1, j-1 {
+		thread[i], thread[j] = thread[j], thread[i]
+	}
+
+	return thread
+}
+
+// chatGPTLeaf returns the most recent leaf node when the export has no current node.
+func chatGPTLeaf(mapping map[string]chatGPTNode) string {
+	var leaves []chatGPTNode
+	for _, node := range mapping {
+		if len(node.Children) == 0 {
+			leaves = append(leaves, node)
+		}
+	}
+	if len(leaves) == 0 {
+		return ""
+	}
+
+	sort.Slice(leaves, func(i, j int) bool {
+		return messageTime(leaves[i]) > messageTime(leaves[j])
+	})
+
+	return leaves[0].ID
+}
+
+// messageTime returns the creation time of a node's message, or zero if it has none.
+func messageTime(node chatGPTNode) float64 {
+	if node.Message == nil {
+		return 0
+	}
+	return node.Message.CreateTime
+}
+
+// unixFloatToTime converts a fractional Unix timestamp into a time.Time.
+func unixFloatToTime(ts float64) time.Time {
+	if ts <= 0 {
+		return time.Now()
+	}
+	sec := int64(ts)
+	nsec := int64((ts - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec)
+}
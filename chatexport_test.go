@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSessions() []ChatSession {
+	return []ChatSession{
+		{
+			ID:    1,
+			Title: "Greetings",
+			ChatTurns: []ChatTurn{
+				{
+					UserPrompt: "Hello",
+					Responses:  []ChatResponse{{Content: "Hi there!", Model: "openai-gpt-4o"}},
+				},
+				{
+					UserPrompt: "How are you?",
+					Responses:  []ChatResponse{{Content: "Doing well.", Model: "openai-gpt-4o"}},
+				},
+			},
+		},
+	}
+}
+
+func TestExportChatSessionsMarkdown(t *testing.T) {
+	data, err := ExportChatSessions(testSessions(), ExportFormatMarkdown)
+	assert.NoError(t, err)
+
+	md := string(data)
+	assert.Contains(t, md, "# Greetings")
+	assert.Contains(t, md, "## User\n\nHello")
+	assert.Contains(t, md, "## Assistant (openai-gpt-4o)\n\nHi there!")
+}
+
+func TestExportChatSessionsJSONL(t *testing.T) {
+	data, err := ExportChatSessions(testSessions(), ExportFormatJSONL)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 1)
+
+	var example fineTuneExample
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &example))
+	assert.Len(t, example.Messages, 4)
+	assert.Equal(t, "user", example.Messages[0].Role)
+	assert.Equal(t, "assistant", example.Messages[1].Role)
+	assert.Equal(t, "Doing well.", example.Messages[3].Content)
+}
+
+func TestExportChatSessionsUnsupported(t *testing.T) {
+	_, err := ExportChatSessions(testSessions(), "pdf")
+	assert.Error(t, err)
+}
+
+func TestEternalExportRoundTrip(t *testing.T) {
+	data, err := ExportChatSessions(testSessions(), ExportFormatJSON)
+	assert.NoError(t, err)
+
+	sessions, err := ParseChatImport(data)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "Greetings", sessions[0].Title)
+	assert.Len(t, sessions[0].ChatTurns, 2)
+	assert.Equal(t, "Hi there!", sessions[0].ChatTurns[0].Responses[0].Content)
+}
+
+func TestParseChatGPTImport(t *testing.T) {
+	data := `[{
+		"title": "Weather",
+		"create_time": 1700000000.5,
+		"update_time": 1700000100.0,
+		"current_node": "c",
+		"mapping": {
+			"root": {"id": "root", "parent": "", "children": ["a"], "message": null},
+			"a": {"id": "a", "parent": "root", "children": ["b", "x"],
+				"message": {"author": {"role": "user"}, "create_time": 1700000001, "content": {"content_type": "text", "parts": ["Is it sunny?"]}}},
+			"x": {"id": "x", "parent": "a", "children": [],
+				"message": {"author": {"role": "assistant"}, "create_time": 1700000002, "content": {"content_type": "text", "parts": ["Abandoned branch"]}}},
+			"b": {"id": "b", "parent": "a", "children": ["c"],
+				"message": {"author": {"role": "assistant"}, "create_time": 1700000003, "content": {"content_type": "text", "parts": ["Yes."]}, "metadata": {"model_slug": "gpt-4"}}},
+			"c": {"id": "c", "parent": "b", "children": [],
+				"message": {"author": {"role": "user"}, "create_time": 1700000004, "content": {"content_type": "text", "parts": ["Thanks"]}}}
+		}
+	}]`
+
+	sessions, err := ParseChatImport([]byte(data))
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	session := sessions[0]
+	assert.Equal(t, "Weather", session.Title)
+	assert.Len(t, session.ChatTurns, 2)
+	assert.Equal(t, "Is it sunny?", session.ChatTurns[0].UserPrompt)
+	assert.Len(t, session.ChatTurns[0].Responses, 1)
+	assert.Equal(t, "Yes.", session.ChatTurns[0].Responses[0].Content)
+	assert.Equal(t, "gpt-4", session.ChatTurns[0].Responses[0].Model)
+	assert.Equal(t, "Thanks", session.ChatTurns[1].UserPrompt)
+	assert.Empty(t, session.ChatTurns[1].Responses)
+}
+
+func TestParseChatImportInvalid(t *testing.T) {
+	_, err := ParseChatImport([]byte("not json"))
+	assert.Error(t, err)
+
+	_, err = ParseChatImport([]byte(`{"foo": "bar"}`))
+	assert.Error(t, err)
+}
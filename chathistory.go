@@ -0,0 +1,158 @@
+// chathistory.go - Session titles, rolling summaries and prompt history
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+const (
+	titleInstructions   = "You write short titles for conversations. Reply with a title of at most six words and nothing else. Do not use quotes."
+	summaryInstructions = "You maintain a running summary of a conversation between a user and an assistant. Merge the new exchanges into the existing summary. Keep names, decisions, code identifiers and open questions. Reply with the updated summary only, in at most 200 words."
+	maxTitleLength      = 80
+)
+
+// summarizing tracks the sessions that have a summary update in flight.
+var summarizing sync.Map
+
+// updateSessionSummary generates a title after the first exchange and folds new turns into
+// the rolling summary of the session. It is meant to run in the background after each turn.
+// Turns that arrive while an update is in flight are picked up by the next update.
+func updateSessionSummary(config *AppConfig, sessionID int64) {
+	modelName := config.ChatHistory.SummaryModel
+	if modelName == "" || sessionID <= 0 {
+		return
+	}
+
+	if _, busy := summarizing.LoadOrStore(sessionID, true); busy {
+		return
+	}
+	defer summarizing.Delete(sessionID)
+
+	session, err := GetChatSession(sqliteDB.db, sessionID)
+	if err != nil {
+		pterm.Error.Printf("Error loading chat session %d for summary: %v\n", sessionID, err)
+		return
+	}
+
+	if session.Title == "" && len(session.ChatTurns) > 0 {
+		title, err := complete(config, modelName, titleInstructions, formatTurns(session.ChatTurns[:1]))
+		if err != nil {
+			pterm.Error.Printf("Error generating title for chat session %d: %v\n", sessionID, err)
+		} else if title = cleanTitle(title); title != "" {
+			if err := UpdateChatSessionTitle(sqliteDB.db, sessionID, title); err != nil {
+				pterm.Error.Printf("Error storing title for chat session %d: %v\n", sessionID, err)
+			}
+		}
+	}
+
+	var newTurns []ChatTurn
+	for _, turn := range session.ChatTurns {
+		if turn.ID > session.SummaryTurnID {
+			newTurns = append(newTurns, turn)
+		}
+	}
+	if len(newTurns) == 0 {
+		return
+	}
+
+	prompt := fmt.Sprintf("EXISTING SUMMARY:\n%s\n\nNEW EXCHANGES:\n%s", session.Summary, formatTurns(newTurns))
+	summary, err := complete(config, modelName, summaryInstructions, prompt)
+	if err != nil {
+		pterm.Error.Printf("Error generating summary for chat session %d: %v\n", sessionID, err)
+		return
+	}
+
+	lastTurnID := newTurns[len(newTurns)-1].ID
+	if err := UpdateChatSessionSummary(sqliteDB.db, sessionID, strings.TrimSpace(summary), lastTurnID); err != nil {
+		pterm.Error.Printf("Error storing summary for chat session %d: %v\n", sessionID, err)
+	}
+}
+
+// formatTurns renders turns as a plain text transcript using the first response of each turn.
+func formatTurns(turns []ChatTurn) string {
+	var sb strings.Builder
+	for _, turn := range turns {
+		sb.WriteString(formatTurn(turn))
+	}
+	return sb.String()
+}
+
+// formatTurn renders a single turn as plain text.
+func formatTurn(turn ChatTurn) string {
+	text := fmt.Sprintf("User: %s\n", strings.TrimSpace(turn.UserPrompt))
+	if len(turn.Responses) > 0 {
+		text += fmt.Sprintf("Assistant: %s\n", strings.TrimSpace(turn.Responses[0].Content))
+	}
+	return text + "\n"
+}
+
+// cleanTitle keeps the first line of a generated title, strips quotes and limits its length.
+func cleanTitle(title string) string {
+	title = strings.TrimSpace(strings.SplitN(strings.TrimSpace(title), "\n", 2)[0])
+	title = strings.Trim(title, "\"'`*# ")
+	title = strings.TrimPrefix(title, "Title: ")
+
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength])
+	}
+	return title
+}
+
+// buildSessionHistory returns the most recent turns of the session that fit in maxChars.
+// When older turns do not fit, the rolling summary stands in for them.
+func buildSessionHistory(session ChatSession, maxChars int) string {
+	if maxChars <= 0 || len(session.ChatTurns) == 0 {
+		return ""
+	}
+
+	var recent []string
+	used := 0
+	first := len(session.ChatTurns)
+	for i := len(session.ChatTurns) - 1; i >= 0; i-- {
+		text := formatTurn(session.ChatTurns[i])
+		if used+len(text) > maxChars {
+			break
+		}
+		recent = append([]string{text}, recent...)
+		used += len(text)
+		first = i
+	}
+
+	var sb strings.Builder
+	if first > 0 && session.Summary != "" {
+		sb.WriteString("SUMMARY OF EARLIER CONVERSATION:\n")
+		sb.WriteString(session.Summary)
+		sb.WriteString("\n\n")
+	}
+	if len(recent) > 0 {
+		sb.WriteString("RECENT CONVERSATION:\n")
+		sb.WriteString(strings.Join(recent, ""))
+	}
+
+	return sb.String()
+}
+
+// withSessionHistory prepends the conversation history of the session to the chat message.
+func withSessionHistory(config *AppConfig, sessionID int64, chatMessage string) string {
+	if config.ChatHistory.MaxChars <= 0 || sessionID <= 0 {
+		return chatMessage
+	}
+
+	session, err := GetChatSession(sqliteDB.db, sessionID)
+	if err != nil {
+		pterm.Error.Printf("Error loading chat session %d for history: %v\n", sessionID, err)
+		return chatMessage
+	}
+
+	history := buildSessionHistory(session, config.ChatHistory.MaxChars)
+	if history == "" {
+		return chatMessage
+	}
+
+	return fmt.Sprintf("%s\nCURRENT QUERY:\n%s", history, chatMessage)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func historySession(turns int) ChatSession {
+	session := ChatSession{ID: 1, Summary: "The user is planning a trip to Lisbon."}
+	for i := 1; i <= turns; i++ {
+		session.ChatTurns = append(session.ChatTurns, ChatTurn{
+			ID:         int64(i),
+			UserPrompt: fmt.Sprintf("question %d", i),
+			Responses:  []ChatResponse{{Content: fmt.Sprintf("answer %d", i)}},
+		})
+	}
+	return session
+}
+
+func TestBuildSessionHistoryFits(t *testing.T) {
+	history := buildSessionHistory(historySession(2), 1000)
+
+	assert.NotContains(t, history, "SUMMARY OF EARLIER CONVERSATION")
+	assert.Contains(t, history, "User: question 1\nAssistant: answer 1")
+	assert.Contains(t, history, "User: question 2\nAssistant: answer 2")
+}
+
+func TestBuildSessionHistoryFallsBackToSummary(t *testing.T) {
+	session := historySession(10)
+	turnLength := len(formatTurn(session.ChatTurns[9]))
+
+	history := buildSessionHistory(session, turnLength*2)
+
+	assert.True(t, strings.HasPrefix(history, "SUMMARY OF EARLIER CONVERSATION:\nThe user is planning a trip to Lisbon."))
+	assert.NotContains(t, history, "question 8\n")
+	assert.Contains(t, history, "question 9")
+	assert.Contains(t, history, "question 10")
+	assert.Less(t, strings.Index(history, "question 9"), strings.Index(history, "question 10"))
+}
+
+func TestBuildSessionHistoryDisabled(t *testing.T) {
+	assert.Empty(t, buildSessionHistory(historySession(3), 0))
+	assert.Empty(t, buildSessionHistory(ChatSession{}, 1000))
+}
+
+func TestCleanTitle(t *testing.T) {
+	assert.Equal(t, "Planning a Lisbon trip", cleanTitle("\"Planning a Lisbon trip\"\nExtra text"))
+	assert.Equal(t, "Go generics", cleanTitle("Title: Go generics"))
+	assert.Len(t, []rune(cleanTitle(strings.Repeat("é", 200))), maxTitleLength)
+}
@@ -0,0 +1,370 @@
+// chatmemory.go - Hybrid retrieval over chat memory
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/gofiber/fiber/v2/log"
+
+	"eternal/pkg/embeddings"
+	"eternal/pkg/ingest"
+	"eternal/pkg/retrieval"
+	"eternal/pkg/vecstore"
+)
+
+// defaultDenseMinScore is the cosine similarity a memory chunk needs when the config does
+// not set one.
+const defaultDenseMinScore = 0.8
+
+// RetrievalConfig holds the fusion settings of hybrid retrieval. Collections override the
+// defaults for a named vector collection.
+type RetrievalConfig struct {
+	retrieval.Config `yaml:",inline"`
+	Collections      map[string]retrieval.Config `yaml:"collections"`
+	Rerank           RerankConfig                `yaml:"rerank"`
+	ReturnParents    bool                        `yaml:"return_parents"` // Return the section a matched chunk comes from in place of the chunk
+	QueryRewrite     QueryRewriteConfig          `yaml:"query_rewrite"`
+}
+
+// RerankConfig selects the reranker applied to fused results before they are added to
+// the prompt.
+type RerankConfig struct {
+	Provider   string  `yaml:"provider"`   // "cross-encoder", "http", or empty to disable reranking
+	Model      string  `yaml:"model"`      // Hugging Face model for cross-encoder, model name sent to http
+	Endpoint   string  `yaml:"endpoint"`   // Rerank URL for http
+	APIKey     string  `yaml:"api_key"`    // Bearer token for http
+	Candidates int     `yaml:"candidates"` // Fused results to rerank
+	Threshold  float64 `yaml:"threshold"`  // Minimum reranker score
+	MaxChunks  int     `yaml:"max_chunks"` // Chunks kept after reranking
+}
+
+// Reranker providers.
+const (
+	RerankCrossEncoder = "cross-encoder"
+	RerankHTTP         = "http"
+)
+
+// rerankers caches loaded rerankers by provider and model, since loading a cross-encoder
+// takes much longer than scoring with it.
+var rerankers = struct {
+	sync.Mutex
+	loaded map[string]retrieval.Reranker
+}{loaded: make(map[string]retrieval.Reranker)}
+
+// loadReranker returns the configured reranker, or nil when reranking is disabled.
+func loadReranker(config *AppConfig) (retrieval.Reranker, error) {
+	rc := config.Retrieval.Rerank
+	if rc.Provider == "" {
+		return nil, nil
+	}
+
+	key := rc.Provider + "|" + rc.Model + "|" + rc.Endpoint
+	rerankers.Lock()
+	defer rerankers.Unlock()
+	if r, ok := rerankers.loaded[key]; ok {
+		return r, nil
+	}
+
+	var r retrieval.Reranker
+	switch rc.Provider {
+	case RerankCrossEncoder:
+		encoder, err := retrieval.NewCrossEncoder(filepath.Join(config.DataPath, "models/HF"), rc.Model)
+		if err != nil {
+			return nil, err
+		}
+		r = encoder
+	case RerankHTTP:
+		if rc.Endpoint == "" {
+			return nil, fmt.Errorf("the http reranker needs an endpoint")
+		}
+		r = &retrieval.HTTPReranker{Endpoint: rc.Endpoint, Model: rc.Model, APIKey: rc.APIKey}
+	default:
+		return nil, fmt.Errorf("unknown reranker %q", rc.Provider)
+	}
+
+	rerankers.loaded[key] = r
+	return r, nil
+}
+
+// rerankOptions returns the rerank stage for a retrieval call, or nil when reranking is
+// disabled or the reranker cannot be loaded.
+func rerankOptions(config *AppConfig) *retrieval.RerankOptions {
+	reranker, err := loadReranker(config)
+	if err != nil {
+		log.Errorf("Error loading reranker: %v", err)
+		return nil
+	}
+	if reranker == nil {
+		return nil
+	}
+
+	rc := config.Retrieval.Rerank
+	return &retrieval.RerankOptions{
+		Reranker:   reranker,
+		Candidates: rc.Candidates,
+		Threshold:  rc.Threshold,
+		MaxChunks:  rc.MaxChunks,
+	}
+}
+
+// ForCollection returns the fusion settings of the named collection.
+func (r RetrievalConfig) ForCollection(name string) retrieval.Config {
+	config, ok := r.Collections[name]
+	if !ok {
+		config = r.Config
+	}
+	if _, ok := config.MinScores[retrieval.Dense]; !ok {
+		minScores := map[string]float64{retrieval.Dense: defaultDenseMinScore}
+		for name, score := range config.MinScores {
+			minScores[name] = score
+		}
+		config.MinScores = minScores
+	}
+	return config
+}
+
+// lexicalRetriever searches chat turns and document chunks in the Bleve index.
+type lexicalRetriever struct {
+	index bleve.Index
+	scope string
+	decay memoryDecay
+}
+
+func (r lexicalRetriever) Name() string { return retrieval.Lexical }
+
+// Retrieve runs a match query rather than a query string query, so that punctuation in a
+// chat message is not parsed as query syntax.
+func (r lexicalRetriever) Retrieve(ctx context.Context, text string, topN int) ([]retrieval.Hit, error) {
+	var q query.Query = bleve.NewMatchQuery(text)
+	if r.scope != "" {
+		scopeQuery := bleve.NewTermQuery(r.scope)
+		scopeQuery.SetField("tags")
+		q = bleve.NewConjunctionQuery(q, scopeQuery)
+	}
+
+	req := bleve.NewSearchRequestOptions(q, topN, 0, false)
+	req.Fields = []string{"response", "prompt", "source", "tags", "url", "session_id", "turn_id", "document_id", "page", "headings", "parent_id", "pinned", "created_at"}
+
+	res, err := r.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]retrieval.Hit, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		text, _ := hit.Fields["response"].(string)
+		if text == "" {
+			continue
+		}
+		metadata := map[string]any{}
+		for _, field := range []string{"source", "tags", "url", "session_id", "turn_id", "document_id", "page", "headings", "parent_id", "pinned", "created_at"} {
+			if v, ok := hit.Fields[field]; ok {
+				metadata[field] = v
+			}
+		}
+		// The prompt of a web chunk is the whole page, so only documents and chat turns
+		// are titled by it.
+		if prompt, ok := hit.Fields["prompt"].(string); ok && metadata["source"] != SourceWeb {
+			metadata["title"] = excerptTitle(prompt)
+		}
+		hits = append(hits, retrieval.Hit{ID: hit.ID, Text: text, Score: hit.Score, Metadata: metadata})
+	}
+	return r.decay.apply(hits), nil
+}
+
+// denseCollection is a vector collection and the embedder of its model.
+type denseCollection struct {
+	store    *vecstore.Store
+	embedder ingest.Embedder
+}
+
+// denseRetriever searches vector collections by embedding similarity. The query is embedded
+// once per model, and each collection is searched with the vector of its own model.
+type denseRetriever struct {
+	collections []denseCollection
+	filter      []vecstore.Condition
+	decay       memoryDecay
+}
+
+func (r denseRetriever) Name() string { return retrieval.Dense }
+
+func (r denseRetriever) Retrieve(ctx context.Context, text string, topN int) ([]retrieval.Hit, error) {
+	vectors := make(map[ingest.Embedder][]float32)
+
+	var hits []retrieval.Hit
+	for _, collection := range r.collections {
+		if collection.store.Len() == 0 {
+			continue
+		}
+
+		vector, ok := vectors[collection.embedder]
+		if !ok {
+			var err error
+			vector, err = collection.embedder.Embed(ctx, text)
+			if err != nil {
+				return nil, err
+			}
+			vectors[collection.embedder] = vector
+		}
+
+		results, err := collection.store.Search(vector, topN, r.filter...)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			hits = append(hits, retrieval.Hit{ID: res.ID, Text: res.Text, Score: res.Score, Metadata: res.Metadata})
+		}
+	}
+	hits = r.decay.apply(hits)
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > topN {
+		hits = hits[:topN]
+	}
+	return hits, nil
+}
+
+// retrieveChatMemory runs lexical and dense search over chat memory and ingested documents
+// in parallel and returns the fused ranking, reranked when a reranker is configured. The
+// sub-queries and hypothetical answer of a rewritten query are searched too and fused into
+// the same ranking. A non-empty scope limits the searches to a knowledge base.
+func retrieveChatMemory(ctx context.Context, config *AppConfig, topN int, scope string, query searchQuery) ([]retrieval.Result, error) {
+	var collections []denseCollection
+	for _, name := range []string{CollectionChat, CollectionDocuments} {
+		store, err := vectorStores.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		embedder, err := collectionEmbedder(config, name)
+		if errors.Is(err, vecstore.ErrModelMismatch) {
+			// Keyword search still covers the collection until it is embedded again with
+			// POST /collections/:name/reembed.
+			log.Warnf("Skipping dense search of %s: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, denseCollection{store: store, embedder: embedder})
+	}
+
+	var filter []vecstore.Condition
+	if scope != "" {
+		filter = append(filter, vecstore.Eq("knowledge_base", scope))
+	}
+
+	// Older memories rank lower under the half-life of their source.
+	decay := loadMemoryDecay(time.Now())
+
+	service, err := retrieval.New(config.Retrieval.ForCollection(CollectionChat),
+		lexicalRetriever{index: searchIndexes.All(), scope: scope, decay: decay},
+		denseRetriever{collections: collections, filter: filter, decay: decay},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retrieval config: %v", err)
+	}
+
+	results, err := service.Search(ctx, retrieval.Query{
+		Text:         query.Text,
+		TopN:         topN,
+		Rerank:       rerankOptions(config),
+		Expansions:   query.SubQueries,
+		Hypothetical: query.Hypothetical,
+	})
+	if err != nil || !config.Retrieval.ReturnParents {
+		return results, err
+	}
+	return expandParents(results), nil
+}
+
+// expandParents replaces chunks that have a parent section with the section, so the model
+// sees the context around a match. Chunks of the same section are merged into the highest
+// ranked one, which keeps the text of the matched chunk in its "chunk" metadata, so fewer
+// results may be returned. Chunks whose section is gone are kept as they are.
+func expandParents(results []retrieval.Result) []retrieval.Result {
+	var ids []string
+	for _, res := range results {
+		if id := metadataString(res.Metadata, "parent_id"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return results
+	}
+
+	sections, err := GetDocumentSections(sqliteDB.db, ids)
+	if err != nil {
+		log.Errorf("Error loading parent sections: %v", err)
+		return results
+	}
+
+	expanded := make([]retrieval.Result, 0, len(results))
+	seen := make(map[string]bool)
+	for _, res := range results {
+		id := metadataString(res.Metadata, "parent_id")
+		section, ok := sections[id]
+		if !ok {
+			expanded = append(expanded, res)
+			continue
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		metadata := make(map[string]any, len(res.Metadata)+1)
+		for k, v := range res.Metadata {
+			metadata[k] = v
+		}
+		metadata["chunk"] = res.Text
+		res.Metadata = metadata
+		res.Text = section.Text
+		expanded = append(expanded, res)
+	}
+	return expanded
+}
+
+// rememberChatMemory embeds lexical hits into the chat collection so that later dense
+// searches can find them by meaning.
+func rememberChatMemory(config *AppConfig, scope string, results []retrieval.Result) error {
+	var document string
+	var ids []string
+	for _, res := range results {
+		for _, source := range res.Sources {
+			if source.Retriever == retrieval.Lexical {
+				document = fmt.Sprintf("%s\n%s", document, res.Text)
+				ids = append(ids, res.ID)
+				break
+			}
+		}
+	}
+	if document == "" {
+		return nil
+	}
+
+	store, err := vectorStores.Get(CollectionChat)
+	if err != nil {
+		return err
+	}
+	embedder, err := collectionEmbedder(config, CollectionChat)
+	if err != nil {
+		return err
+	}
+
+	// Memory generated under a knowledge base scope is only recalled within that scope. The
+	// IDs of the indexed messages it came from let forgetting a message forget it too.
+	metadata := map[string]any{"source": SourceChat, "memory_ids": ids, "created_at": time.Now().Unix()}
+	if scope != "" {
+		metadata["knowledge_base"] = scope
+	}
+	return embeddings.GenerateEmbeddingForTask(store, embedder, "chat", document, "txt", 4096, 1024, metadata)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"eternal/pkg/retrieval"
+)
+
+func TestRetrievalConfigForCollection(t *testing.T) {
+	var config RetrievalConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+fusion: rrf
+weights:
+  lexical: 2
+collections:
+  docs:
+    fusion: weighted
+    min_scores:
+      dense: 0.5
+`), &config))
+
+	chat := config.ForCollection(CollectionChat)
+	assert.Equal(t, retrieval.FusionRRF, chat.Fusion)
+	assert.Equal(t, 2.0, chat.Weights[retrieval.Lexical])
+	assert.Equal(t, defaultDenseMinScore, chat.MinScores[retrieval.Dense])
+
+	docs := config.ForCollection("docs")
+	assert.Equal(t, retrieval.FusionWeighted, docs.Fusion)
+	assert.Equal(t, 0.5, docs.MinScores[retrieval.Dense])
+}
+
+func TestLexicalRetriever(t *testing.T) {
+	index := newTestSearchIndex(t, time.Now())
+	defer index.Close()
+
+	// Query syntax characters in a chat message are matched as plain text.
+	hits, err := lexicalRetriever{index: index}.Retrieve(context.Background(), "goroutines: how?", 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, hits)
+	for _, hit := range hits {
+		assert.NotEmpty(t, hit.Text)
+	}
+
+	hits, err = lexicalRetriever{index: index, scope: "go"}.Retrieve(context.Background(), "goroutines", 10)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "Goroutines and channels in the Go tour.", hits[0].Text)
+	assert.Equal(t, SourceWeb, hits[0].Metadata["source"])
+}
+
+func TestRerankOptions(t *testing.T) {
+	config := &AppConfig{}
+	assert.Nil(t, rerankOptions(config), "reranking is off without a provider")
+
+	config.Retrieval.Rerank = RerankConfig{Provider: RerankHTTP, Endpoint: "http://localhost:8080/v1/rerank", Threshold: 0.2, MaxChunks: 3}
+	opts := rerankOptions(config)
+	require.NotNil(t, opts)
+	assert.Equal(t, 0.2, opts.Threshold)
+	assert.Equal(t, 3, opts.MaxChunks)
+	assert.Same(t, opts.Reranker, rerankOptions(config).Reranker, "rerankers are cached")
+
+	config.Retrieval.Rerank = RerankConfig{Provider: "colbert"}
+	_, err := loadReranker(config)
+	assert.Error(t, err)
+	assert.Nil(t, rerankOptions(config))
+}
+
+func TestExpandParents(t *testing.T) {
+	withTestDB(t)
+
+	require.NoError(t, ReplaceDocumentSections(db, "doc", []DocumentSection{
+		{ID: "parent", DocumentID: "doc", Headings: "Guide", Text: "# Guide\n\nFirst. Second."},
+	}))
+	defer DeleteDocumentSections(db, "doc")
+
+	results := expandParents([]retrieval.Result{
+		{ID: "1", Text: "Second.", Metadata: map[string]any{"parent_id": "parent"}},
+		{ID: "2", Text: "Orphan.", Metadata: map[string]any{"parent_id": "gone"}},
+		{ID: "3", Text: "First.", Metadata: map[string]any{"parent_id": "parent"}},
+		{ID: "4", Text: "Chat turn."},
+	})
+	require.Len(t, results, 3, "chunks of the same section are merged")
+	assert.Equal(t, "# Guide\n\nFirst. Second.", results[0].Text)
+	assert.Equal(t, "Second.", results[0].Metadata["chunk"])
+	assert.Equal(t, "Orphan.", results[1].Text)
+	assert.Equal(t, "Chat turn.", results[2].Text)
+}
+
+func TestTextSplitAndIndexKeepsEveryChunk(t *testing.T) {
+	withTestDB(t)
+	useTestMemoryStores(t, time.Now())
+	index, err := searchIndexes.Get(CollectionChat)
+	require.NoError(t, err)
+	before, err := index.DocCount()
+	require.NoError(t, err)
+
+	defer DeleteDocumentSections(db, documentID(SourceWeb, "https://example.com/guide"))
+
+	text := "https://example.com/guide\n# Guide\n\n" + strings.Repeat("Goroutines are cheap. ", 20) + "\n\n## Channels\n\n" + strings.Repeat("Channels connect goroutines. ", 20)
+	require.NoError(t, handleTextSplitAndIndex("web", text, 100, "test", SourceWeb))
+	after, err := index.DocCount()
+	require.NoError(t, err)
+	assert.Greater(t, after-before, uint64(4), "chunks indexed at the same time keep their own IDs")
+
+	// Indexing the same page again replaces its chunks.
+	require.NoError(t, handleTextSplitAndIndex("web", text, 100, "test", SourceWeb))
+	again, err := index.DocCount()
+	require.NoError(t, err)
+	assert.Equal(t, after, again)
+}
@@ -0,0 +1,302 @@
+// chatsearch.go - Full-text search over chat history and ingested documents
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+
+	indexing "eternal/pkg/search"
+)
+
+// Sources recorded on indexed messages.
+const (
+	SourceChat     = "chat"
+	SourceDocument = "document"
+	SourceWeb      = "web"
+	SourceGit      = "git"
+)
+
+const (
+	defaultSearchPageSize = 10
+	maxSearchPageSize     = 100
+	searchFacetSize       = 10
+)
+
+// ChatSearchParams holds the query, filters and paging options for a search request.
+type ChatSearchParams struct {
+	Query  string
+	Model  string
+	Tag    string
+	Source string
+	From   time.Time
+	To     time.Time
+	Page   int
+	Size   int
+}
+
+// ChatSearchHit is a single search result.
+type ChatSearchHit struct {
+	ID        string              `json:"id"`
+	Score     float64             `json:"score"`
+	SessionID int64               `json:"session_id,omitempty"`
+	TurnID    int64               `json:"turn_id,omitempty"`
+	Model     string              `json:"model,omitempty"`
+	Source    string              `json:"source,omitempty"`
+	Tags      []string            `json:"tags,omitempty"`
+	Prompt    string              `json:"prompt,omitempty"`
+	CreatedAt string              `json:"created_at,omitempty"`
+	Fragments map[string][]string `json:"fragments,omitempty"`
+}
+
+// SearchFacet is a single bucket of a facet. Term facets set Value, date range facets set From and To.
+type SearchFacet struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Count int    `json:"count"`
+}
+
+// ChatSearchResult is the response of a search request.
+type ChatSearchResult struct {
+	Query  string                   `json:"query"`
+	Total  uint64                   `json:"total"`
+	Page   int                      `json:"page"`
+	Size   int                      `json:"size"`
+	Pages  int                      `json:"pages"`
+	Hits   []ChatSearchHit          `json:"hits"`
+	Facets map[string][]SearchFacet `json:"facets"`
+}
+
+// newSearchIndexMapping returns the index mapping used for chat turns and document chunks.
+// Prose is analyzed as English, so a search for "indexes" finds "indexing", and stored with
+// term vectors for highlighting. Model, tags and source are indexed as keywords so they can
+// be used as facets and exact filters. Indexes created with an older mapping keep it until
+// they are rebuilt.
+func newSearchIndexMapping() mapping.IndexMapping {
+	keywordField := indexing.KeywordField()
+	textField := indexing.TextField()
+
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.AddFieldMappingsAt("prompt", textField)
+	docMapping.AddFieldMappingsAt("response", textField)
+	docMapping.AddFieldMappingsAt("model", keywordField)
+	docMapping.AddFieldMappingsAt("tags", keywordField)
+	docMapping.AddFieldMappingsAt("source", keywordField)
+	docMapping.AddFieldMappingsAt("document_id", keywordField)
+	docMapping.AddFieldMappingsAt("headings", textField)
+	docMapping.AddFieldMappingsAt("parent_id", keywordField)
+	docMapping.AddFieldMappingsAt("url", keywordField)
+	docMapping.AddFieldMappingsAt("created_at", bleve.NewDateTimeFieldMapping())
+	docMapping.AddFieldMappingsAt("session_id", bleve.NewNumericFieldMapping())
+	docMapping.AddFieldMappingsAt("turn_id", bleve.NewNumericFieldMapping())
+	docMapping.AddFieldMappingsAt("page", bleve.NewNumericFieldMapping())
+	docMapping.AddFieldMappingsAt("pinned", bleve.NewBooleanFieldMapping())
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = docMapping
+	indexMapping.DefaultAnalyzer = en.AnalyzerName
+
+	return indexMapping
+}
+
+// parseSearchTags splits a comma separated list of tags.
+func parseSearchTags(tags string) []string {
+	var out []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// parseSearchTime parses an RFC 3339 timestamp or a plain date. An empty value yields the zero time.
+func parseSearchTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// normalize applies the default page and size to the search parameters.
+func (p *ChatSearchParams) normalize() {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Size < 1 {
+		p.Size = defaultSearchPageSize
+	}
+	if p.Size > maxSearchPageSize {
+		p.Size = maxSearchPageSize
+	}
+}
+
+// buildChatSearchRequest builds a Bleve search request with highlighting and facets.
+func buildChatSearchRequest(params ChatSearchParams, now time.Time) *bleve.SearchRequest {
+	params.normalize()
+
+	var queries []query.Query
+	if strings.TrimSpace(params.Query) != "" {
+		queries = append(queries, bleve.NewQueryStringQuery(params.Query))
+	} else {
+		queries = append(queries, bleve.NewMatchAllQuery())
+	}
+
+	for field, value := range map[string]string{"model": params.Model, "tags": params.Tag, "source": params.Source} {
+		if value == "" {
+			continue
+		}
+		termQuery := bleve.NewTermQuery(value)
+		termQuery.SetField(field)
+		queries = append(queries, termQuery)
+	}
+
+	if !params.From.IsZero() || !params.To.IsZero() {
+		dateQuery := bleve.NewDateRangeQuery(params.From, params.To)
+		dateQuery.SetField("created_at")
+		queries = append(queries, dateQuery)
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(queries...), params.Size, (params.Page-1)*params.Size, false)
+	req.Fields = []string{"session_id", "turn_id", "model", "source", "tags", "prompt", "created_at"}
+
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	req.Highlight.AddField("prompt")
+	req.Highlight.AddField("response")
+
+	req.AddFacet("model", bleve.NewFacetRequest("model", searchFacetSize))
+	req.AddFacet("tags", bleve.NewFacetRequest("tags", searchFacetSize))
+	req.AddFacet("source", bleve.NewFacetRequest("source", searchFacetSize))
+
+	dateFacet := bleve.NewFacetRequest("created_at", 4)
+	dateFacet.AddDateTimeRange("Past day", now.Add(-24*time.Hour), now)
+	dateFacet.AddDateTimeRange("Past week", now.AddDate(0, 0, -7), now)
+	dateFacet.AddDateTimeRange("Past month", now.AddDate(0, -1, 0), now)
+	dateFacet.AddDateTimeRange("Older", time.Time{}, now.AddDate(0, -1, 0))
+	req.AddFacet("created_at", dateFacet)
+
+	return req
+}
+
+// toChatSearchResult converts a Bleve search result into the API response.
+func toChatSearchResult(params ChatSearchParams, res *bleve.SearchResult) ChatSearchResult {
+	params.normalize()
+
+	result := ChatSearchResult{
+		Query:  params.Query,
+		Total:  res.Total,
+		Page:   params.Page,
+		Size:   params.Size,
+		Pages:  int((res.Total + uint64(params.Size) - 1) / uint64(params.Size)),
+		Hits:   make([]ChatSearchHit, 0, len(res.Hits)),
+		Facets: make(map[string][]SearchFacet),
+	}
+
+	for _, hit := range res.Hits {
+		result.Hits = append(result.Hits, toChatSearchHit(hit))
+	}
+
+	for name, facet := range res.Facets {
+		var buckets []SearchFacet
+		for _, term := range facet.Terms.Terms() {
+			buckets = append(buckets, SearchFacet{Name: term.Term, Value: term.Term, Count: term.Count})
+		}
+		for _, dateRange := range facet.DateRanges {
+			if dateRange.Count == 0 {
+				continue
+			}
+			bucket := SearchFacet{Name: dateRange.Name, Count: dateRange.Count}
+			if dateRange.Start != nil {
+				bucket.From = *dateRange.Start
+			}
+			if dateRange.End != nil {
+				bucket.To = *dateRange.End
+			}
+			buckets = append(buckets, bucket)
+		}
+		result.Facets[name] = buckets
+	}
+
+	return result
+}
+
+// toChatSearchHit converts a Bleve document match into a search hit.
+func toChatSearchHit(hit *search.DocumentMatch) ChatSearchHit {
+	out := ChatSearchHit{
+		ID:        hit.ID,
+		Score:     hit.Score,
+		Fragments: hit.Fragments,
+	}
+
+	if v, ok := hit.Fields["session_id"].(float64); ok {
+		out.SessionID = int64(v)
+	}
+	if v, ok := hit.Fields["turn_id"].(float64); ok {
+		out.TurnID = int64(v)
+	}
+	if v, ok := hit.Fields["model"].(string); ok {
+		out.Model = v
+	}
+	if v, ok := hit.Fields["source"].(string); ok {
+		out.Source = v
+	}
+	if v, ok := hit.Fields["prompt"].(string); ok {
+		out.Prompt = v
+	}
+	if v, ok := hit.Fields["created_at"].(string); ok {
+		out.CreatedAt = v
+	}
+
+	// Multi-valued fields come back as a slice, single values as a string.
+	switch v := hit.Fields["tags"].(type) {
+	case string:
+		out.Tags = []string{v}
+	case []interface{}:
+		for _, tag := range v {
+			out.Tags = append(out.Tags, fmt.Sprint(tag))
+		}
+	}
+
+	return out
+}
+
+// Snippets returns the highlighted fragments of the hit. Bleve escapes the fragment text
+// before wrapping matches in mark tags, so the fragments are safe to render as HTML.
+func (h ChatSearchHit) Snippets() []template.HTML {
+	var snippets []template.HTML
+	for _, field := range []string{"prompt", "response"} {
+		for _, fragment := range h.Fragments[field] {
+			snippets = append(snippets, template.HTML(fragment))
+		}
+	}
+	return snippets
+}
+
+// PrevPage returns the previous page number, or zero on the first page.
+func (r ChatSearchResult) PrevPage() int {
+	if r.Page <= 1 {
+		return 0
+	}
+	return r.Page - 1
+}
+
+// NextPage returns the next page number, or zero on the last page.
+func (r ChatSearchResult) NextPage() int {
+	if r.Page >= r.Pages {
+		return 0
+	}
+	return r.Page + 1
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSearchIndex(t *testing.T, now time.Time) bleve.Index {
+	index, err := bleve.NewMemOnly(newSearchIndexMapping())
+	assert.NoError(t, err)
+	indexTestSearchDocuments(t, index, now)
+	return index
+}
+
+// indexTestSearchDocuments indexes three chat turns and a web page.
+func indexTestSearchDocuments(t *testing.T, index bleve.Index, now time.Time) {
+	docs := []ChatTurnMessage{
+		{SessionID: 1, TurnID: 1, Prompt: "How do goroutines work?", Response: "Goroutines are lightweight threads.", Model: "openai-gpt-4o", Source: SourceChat, CreatedAt: now.Add(-time.Hour)},
+		{SessionID: 1, TurnID: 2, Prompt: "And channels?", Response: "Channels connect goroutines.", Model: "openai-gpt-4o", Source: SourceChat, CreatedAt: now.AddDate(0, 0, -3)},
+		{SessionID: 2, TurnID: 3, Prompt: "Explain goroutines again", Response: "They are scheduled by the Go runtime.", Model: "google-gemini-1.5", Source: SourceChat, CreatedAt: now.AddDate(0, -2, 0)},
+		{Prompt: "https://go.dev", Response: "Goroutines and channels in the Go tour.", Model: "avsolatorio/GIST-small-Embedding-v0", Source: SourceWeb, Tags: []string{"web", "go"}, CreatedAt: now},
+	}
+	for i, doc := range docs {
+		doc.ID = fmt.Sprintf("doc-%d", i)
+		assert.NoError(t, index.Index(doc.ID, doc))
+	}
+}
+
+func TestChatSearchHighlightsAndFacets(t *testing.T) {
+	now := time.Now()
+	index := newTestSearchIndex(t, now)
+	defer index.Close()
+
+	params := ChatSearchParams{Query: "goroutines"}
+	res, err := index.Search(buildChatSearchRequest(params, now))
+	assert.NoError(t, err)
+
+	result := toChatSearchResult(params, res)
+	assert.Equal(t, uint64(4), result.Total)
+	assert.NotEmpty(t, result.Hits[0].Snippets())
+	assert.Contains(t, result.Facets["model"], SearchFacet{Name: "openai-gpt-4o", Value: "openai-gpt-4o", Count: 2})
+	assert.Contains(t, result.Facets["source"], SearchFacet{Name: SourceWeb, Value: SourceWeb, Count: 1})
+	assert.Contains(t, result.Facets["tags"], SearchFacet{Name: "go", Value: "go", Count: 1})
+
+	var dateFacets []string
+	for _, facet := range result.Facets["created_at"] {
+		dateFacets = append(dateFacets, facet.Name)
+	}
+	assert.Contains(t, dateFacets, "Past day")
+	assert.Contains(t, dateFacets, "Older")
+}
+
+func TestChatSearchFilters(t *testing.T) {
+	now := time.Now()
+	index := newTestSearchIndex(t, now)
+	defer index.Close()
+
+	params := ChatSearchParams{Query: "goroutines", Model: "openai-gpt-4o", From: now.AddDate(0, 0, -1)}
+	res, err := index.Search(buildChatSearchRequest(params, now))
+	assert.NoError(t, err)
+
+	result := toChatSearchResult(params, res)
+	assert.Equal(t, uint64(1), result.Total)
+	assert.Equal(t, int64(1), result.Hits[0].SessionID)
+	assert.Equal(t, int64(1), result.Hits[0].TurnID)
+
+	params = ChatSearchParams{Tag: "web"}
+	res, err = index.Search(buildChatSearchRequest(params, now))
+	assert.NoError(t, err)
+	result = toChatSearchResult(params, res)
+	assert.Equal(t, uint64(1), result.Total)
+	assert.ElementsMatch(t, []string{"web", "go"}, result.Hits[0].Tags)
+}
+
+func TestChatSearchPaging(t *testing.T) {
+	now := time.Now()
+	index := newTestSearchIndex(t, now)
+	defer index.Close()
+
+	params := ChatSearchParams{Page: 2, Size: 3}
+	res, err := index.Search(buildChatSearchRequest(params, now))
+	assert.NoError(t, err)
+
+	result := toChatSearchResult(params, res)
+	assert.Equal(t, 2, result.Pages)
+	assert.Len(t, result.Hits, 1)
+	assert.Equal(t, 1, result.PrevPage())
+	assert.Equal(t, 0, result.NextPage())
+}
@@ -0,0 +1,300 @@
+// citations.go - Numbered sources for answers grounded on retrieved context
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/websocket/v2"
+
+	"eternal/pkg/retrieval"
+)
+
+// excerptLength is the number of characters of a source kept with a chat turn.
+const excerptLength = 300
+
+// Citation is a numbered source added to the prompt of a chat turn.
+type Citation struct {
+	Number     int     `json:"number"`
+	Source     string  `json:"source"` // SourceChat, SourceDocument, SourceWeb or SourceGit
+	Title      string  `json:"title,omitempty"`
+	URL        string  `json:"url,omitempty"`
+	DocumentID string  `json:"document_id,omitempty"`
+	Page       int     `json:"page,omitempty"`
+	SessionID  int64   `json:"session_id,omitempty"`
+	TurnID     int64   `json:"turn_id,omitempty"`
+	Excerpt    string  `json:"excerpt"`
+	Score      float64 `json:"score,omitempty"`
+	Cited      bool    `json:"cited"` // Whether the response cites the source
+	text       string  // Full text added to the prompt
+}
+
+// Label returns a short description of where the source came from.
+func (c Citation) Label() string {
+	switch c.Source {
+	case SourceWeb:
+		if c.URL != "" {
+			return c.URL
+		}
+	case SourceDocument:
+		if c.Page > 0 {
+			return fmt.Sprintf("%s, page %d", c.Title, c.Page)
+		}
+		return c.Title
+	case SourceChat:
+		if c.SessionID > 0 {
+			return fmt.Sprintf("Chat %d, turn %d", c.SessionID, c.TurnID)
+		}
+		return "Chat memory"
+	}
+	if c.Title != "" {
+		return c.Title
+	}
+	return c.Source
+}
+
+// Link returns the address of the original source, or an empty string when it cannot be
+// opened.
+func (c Citation) Link() string {
+	switch c.Source {
+	case SourceWeb, SourceGit:
+		return c.URL
+	case SourceDocument:
+		// Uploads are kept per collection and served by document, so chunks indexed
+		// without a document ID have no file to open.
+		if c.DocumentID == "" {
+			return ""
+		}
+		link := "/documents/" + url.PathEscape(c.DocumentID) + "/file"
+		if c.Page > 0 {
+			link = fmt.Sprintf("%s#page=%d", link, c.Page)
+		}
+		return link
+	case SourceChat:
+		if c.SessionID > 0 {
+			return fmt.Sprintf("/sessions/%d#turn-%d", c.SessionID, c.TurnID)
+		}
+	}
+	return ""
+}
+
+// newCitation returns the numbered citation of a retrieved chunk, reading its origin from
+// the metadata that the Bleve index and the vector store attach to chunks.
+func newCitation(number int, res retrieval.Result) Citation {
+	citation := Citation{
+		Number:     number,
+		Source:     metadataString(res.Metadata, "source"),
+		Title:      metadataString(res.Metadata, "title"),
+		URL:        metadataString(res.Metadata, "url"),
+		DocumentID: metadataString(res.Metadata, "document_id"),
+		Page:       int(metadataInt(res.Metadata, "page")),
+		SessionID:  metadataInt(res.Metadata, "session_id"),
+		TurnID:     metadataInt(res.Metadata, "turn_id"),
+		Score:      res.Score,
+		Excerpt:    excerpt(res.Text),
+		text:       res.Text,
+	}
+	if res.RerankScore != 0 {
+		citation.Score = res.RerankScore
+	}
+
+	switch {
+	case citation.Source == SourceWeb:
+		if citation.URL == "" {
+			citation.URL = webSourceURL(res)
+		}
+	case citation.Source == SourceGit:
+	case citation.DocumentID != "":
+		citation.Source = SourceDocument
+	case citation.Source == "":
+		citation.Source = SourceChat
+	}
+	return citation
+}
+
+// appendCitations numbers retrieved chunks after the existing citations, skipping chunks
+// that are already cited.
+func appendCitations(citations []Citation, results []retrieval.Result) []Citation {
+	seen := make(map[string]bool, len(citations))
+	for _, c := range citations {
+		seen[strings.TrimSpace(c.text)] = true
+	}
+	for _, res := range results {
+		text := strings.TrimSpace(res.Text)
+		if text == "" || seen[text] {
+			continue
+		}
+		seen[text] = true
+		citations = append(citations, newCitation(len(citations)+1, res))
+	}
+	return citations
+}
+
+// webCitation returns the citation of a page fetched from a URL.
+func webCitation(number int, pageURL string, text string) Citation {
+	return Citation{Number: number, Source: SourceWeb, Title: pageURL, URL: pageURL, Excerpt: excerpt(text), text: text}
+}
+
+// webSourceURL finds the page URL of a web chunk. The URL is stored as a tag, and the
+// chunk text starts with the tags.
+func webSourceURL(res retrieval.Result) string {
+	var tags []string
+	switch v := res.Metadata["tags"].(type) {
+	case string:
+		tags = []string{v}
+	case []any:
+		for _, tag := range v {
+			if s, ok := tag.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+	}
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "http://") || strings.HasPrefix(tag, "https://") {
+			return tag
+		}
+	}
+	return webURLPattern.FindString(res.Text)
+}
+
+var webURLPattern = regexp.MustCompile(`https?://[^\s\],]+`)
+
+// formatCitationPrompt adds the numbered sources to a query and asks the model to cite them.
+func formatCitationPrompt(citations []Citation, query string) string {
+	if len(citations) == 0 {
+		return query
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SOURCES:\n")
+	for _, c := range citations {
+		fmt.Fprintf(&sb, "[%d] %s\n%s\n\n", c.Number, c.Label(), strings.TrimSpace(c.text))
+	}
+	sb.WriteString("Answer the query using the numbered sources above when they are relevant. ")
+	sb.WriteString("Cite every statement taken from a source with the source number in square brackets, for example [1] or [1][3]. ")
+	sb.WriteString("Do not cite sources you did not use, and say so when the sources do not answer the query.\n\n")
+	fmt.Fprintf(&sb, "QUERY:\n%s", query)
+	return sb.String()
+}
+
+// citationPattern matches citations such as [2] or [1, 3].
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citedNumbers returns the source numbers cited by a response in order of first citation.
+// Numbers outside 1..count are ignored, since the model may have made them up.
+func citedNumbers(response string, count int) []int {
+	var numbers []int
+	seen := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(response, -1) {
+		for _, field := range strings.Split(match[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > count || seen[n] {
+				continue
+			}
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// markCitations returns the citations with Cited set on those the response cites.
+func markCitations(response string, citations []Citation) []Citation {
+	marked := make([]Citation, len(citations))
+	copy(marked, citations)
+
+	byNumber := make(map[int]int, len(marked))
+	for i, c := range marked {
+		byNumber[c.Number] = i
+	}
+	for _, n := range citedNumbers(response, len(marked)) {
+		if i, ok := byNumber[n]; ok {
+			marked[i].Cited = true
+		}
+	}
+	return marked
+}
+
+// excerpt shortens source text for storage with a chat turn.
+func excerpt(text string) string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	if len(runes) <= excerptLength {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:excerptLength])) + "…"
+}
+
+// metadataString returns a string metadata value.
+func metadataString(metadata map[string]any, key string) string {
+	s, _ := metadata[key].(string)
+	return s
+}
+
+// metadataInt returns a numeric metadata value. Values decoded from JSON or read from the
+// Bleve index are float64.
+func metadataInt(metadata map[string]any, key string) int64 {
+	switch v := metadata[key].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case float32:
+		return int64(v)
+	}
+	return 0
+}
+
+// excerptTitle returns the first line of a prompt, shortened to use as a title.
+func excerptTitle(prompt string) string {
+	title := strings.TrimSpace(strings.SplitN(strings.TrimSpace(prompt), "\n", 2)[0])
+	if runes := []rune(title); len(runes) > 80 {
+		title = string(runes[:80]) + "…"
+	}
+	return title
+}
+
+// citationsTemplate renders the sources of a response below it in the chat view.
+var citationsTemplate = template.Must(template.New("sources").Parse(`<div id="response-sources-{{.TurnID}}" class="response-sources mx-1 mt-2 small">
+  <span class="badge bg-secondary">Sources</span>
+  <ol class="list-unstyled mb-0">
+    {{range .Citations}}
+    <li class="{{if not .Cited}}text-secondary{{end}}" title="{{.Excerpt}}">
+      [{{.Number}}] {{with .Link}}<a href="{{.}}" target="_blank" rel="noopener">{{end}}{{.Label}}{{if .Link}}</a>{{end}}
+      {{if .Cited}}<span class="badge text-bg-success">cited</span>{{end}}
+    </li>
+    {{end}}
+  </ol>
+</div>`))
+
+// renderCitations returns the sources element of a chat turn.
+func renderCitations(turnID int, citations []Citation) (string, error) {
+	var buf bytes.Buffer
+	err := citationsTemplate.Execute(&buf, struct {
+		TurnID    int
+		Citations []Citation
+	}{turnID, citations})
+	return buf.String(), err
+}
+
+// sendCitations sends the sources element of a chat turn to the client, which swaps it
+// into the placeholder below the response.
+func sendCitations(c *websocket.Conn, turnID int, citations []Citation) {
+	html, err := renderCitations(turnID, citations)
+	if err != nil {
+		log.Errorf("Error rendering sources: %v", err)
+		return
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte(html)); err != nil {
+		log.Errorf("Error sending sources: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"eternal/pkg/retrieval"
+)
+
+func TestNewCitation(t *testing.T) {
+	doc := newCitation(1, retrieval.Result{
+		Text:     "Goroutines are lightweight threads.",
+		Metadata: map[string]any{"source": "document", "document_id": "abc", "title": "go notes.pdf", "page": float64(3)},
+	})
+	assert.Equal(t, SourceDocument, doc.Source)
+	assert.Equal(t, "go notes.pdf, page 3", doc.Label())
+	assert.Equal(t, "/documents/abc/file#page=3", doc.Link())
+
+	untracked := Citation{Source: SourceDocument, Title: "go notes.pdf", Page: 3}
+	assert.Equal(t, "go notes.pdf, page 3", untracked.Label())
+	assert.Empty(t, untracked.Link())
+
+	web := newCitation(2, retrieval.Result{
+		Text:     "TAGS: [web, https://go.dev/tour]\nA tour of Go.",
+		Metadata: map[string]any{"source": SourceWeb, "tags": []any{"web", "https://go.dev/tour"}},
+	})
+	assert.Equal(t, "https://go.dev/tour", web.URL)
+	assert.Equal(t, "https://go.dev/tour", web.Link())
+
+	chat := newCitation(3, retrieval.Result{
+		Text:     "Channels connect goroutines.",
+		Metadata: map[string]any{"session_id": float64(7), "turn_id": float64(12)},
+	})
+	assert.Equal(t, SourceChat, chat.Source)
+	assert.Equal(t, "/sessions/7#turn-12", chat.Link())
+}
+
+func TestAppendCitationsSkipsDuplicates(t *testing.T) {
+	citations := []Citation{webCitation(1, "https://go.dev", "A tour of Go.")}
+	citations = appendCitations(citations, []retrieval.Result{
+		{Text: "A tour of Go."},
+		{Text: "Channels connect goroutines."},
+		{Text: "  "},
+	})
+	assert.Len(t, citations, 2)
+	assert.Equal(t, 2, citations[1].Number)
+}
+
+func TestFormatCitationPrompt(t *testing.T) {
+	assert.Equal(t, "query", formatCitationPrompt(nil, "query"))
+
+	prompt := formatCitationPrompt([]Citation{webCitation(1, "https://go.dev", "A tour of Go.")}, "What is Go?")
+	assert.True(t, strings.HasPrefix(prompt, "SOURCES:\n[1] https://go.dev\nA tour of Go.\n\n"))
+	assert.True(t, strings.HasSuffix(prompt, "QUERY:\nWhat is Go?"))
+}
+
+func TestMarkCitations(t *testing.T) {
+	citations := []Citation{{Number: 1}, {Number: 2}, {Number: 3}}
+
+	assert.Equal(t, []int{3, 1}, citedNumbers("Yes [3]. Also [1, 3] and [7] and arr[0].", len(citations)))
+
+	marked := markCitations("Goroutines are cheap [2].", citations)
+	assert.False(t, marked[0].Cited)
+	assert.True(t, marked[1].Cited)
+	assert.False(t, citations[1].Cited, "the input is not modified")
+}
+
+func TestRenderCitationsEscapes(t *testing.T) {
+	html, err := renderCitations(4, []Citation{
+		{Number: 1, Source: SourceDocument, Title: "<script>.txt", Cited: true},
+		{Number: 2, Source: SourceChat},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, html, `id="response-sources-4"`)
+	assert.Contains(t, html, "&lt;script&gt;.txt")
+	assert.NotContains(t, html, "<script>")
+	assert.Contains(t, html, "Chat memory")
+}
@@ -0,0 +1,62 @@
+// completion.go - Non-streaming completions for background tasks
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"eternal/pkg/llm"
+	"eternal/pkg/llm/anthropic"
+	"eternal/pkg/llm/google"
+	"eternal/pkg/llm/openai"
+)
+
+// backgroundMaxTokens limits local completions used by background tasks.
+const backgroundMaxTokens = 512
+
+// complete runs a single non-streaming completion against the named model.
+// Remote models are selected by their openai-, anthropic- or google- prefix, followed by the
+// provider's model ID. Any other name is looked up as a local GGUF model.
+func complete(config *AppConfig, modelName string, system string, prompt string) (string, error) {
+	switch {
+	case strings.HasPrefix(modelName, "openai-"):
+		messages := []llm.Message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		}
+		return openai.Completion(strings.TrimPrefix(modelName, "openai-"), messages, 0.2, config.OAIKey)
+
+	case strings.HasPrefix(modelName, "anthropic-"):
+		messages := []anthropic.Message{
+			{Role: "user", Content: fmt.Sprintf("%s\n\n%s", system, prompt)},
+		}
+		return anthropic.Completion(strings.TrimPrefix(modelName, "anthropic-"), messages, 0.2, config.AnthropicKey)
+
+	case strings.HasPrefix(modelName, "google-"):
+		return google.Generate("models/"+strings.TrimPrefix(modelName, "google-"), fmt.Sprintf("%s\n\n%s", system, prompt), config.GoogleKey)
+
+	default:
+		var model ModelParams
+		if err := sqliteDB.First(modelName, &model); err != nil {
+			return "", fmt.Errorf("error getting model %s: %w", modelName, err)
+		}
+
+		fullPrompt := strings.ReplaceAll(model.Options.Prompt, "{prompt}", prompt)
+		fullPrompt = strings.ReplaceAll(fullPrompt, "{system}", system)
+
+		modelOpts := &llm.GGUFOptions{
+			NGPULayers:    config.ServiceHosts["llm"]["llm_host_1"].GgufGPULayers,
+			Model:         model.Options.Model,
+			Prompt:        fullPrompt,
+			CtxSize:       model.Options.CtxSize,
+			Temp:          model.Options.Temp,
+			RepeatPenalty: model.Options.RepeatPenalty,
+			TopP:          model.Options.TopP,
+			TopK:          model.Options.TopK,
+			NPredict:      backgroundMaxTokens,
+		}
+
+		return llm.MakeCompletion(modelOpts, config.DataPath)
+	}
+}
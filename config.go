@@ -3,6 +3,7 @@ package main
 import (
 	"eternal/pkg/llm"
 	"eternal/pkg/sd"
+	"eternal/pkg/vecstore"
 	"time"
 
 	"github.com/spf13/afero"
@@ -24,28 +25,64 @@ type AppConfig struct {
 	GoogleKey      string                            `yaml:"google_key"`
 	LanguageModels []llm.Model                       `yaml:"language_models"`
 	ImageModels    []sd.ImageModel                   `yaml:"image_models"`
-	AssistantRoles []struct {
-		Name         string `yaml:"name"`
-		Instructions string `yaml:"instructions"`
-	} `yaml:"assistant_roles"`
-	Tools struct {
-		Memory struct {
-			Enabled bool `yaml:"enabled"`
-			TopN    int  `yaml:"top_n"`
-		} `yaml:"memory"`
-		WebGet struct {
-			Enabled bool `yaml:"enabled"`
-		} `yaml:"webget"`
-		WebSearch struct {
-			Enabled  bool   `yaml:"enabled"`
-			Name     string `yaml:"name"`
-			Endpoint string `yaml:"endpoint"`
-			TopN     int    `yaml:"top_n"`
-		} `yaml:"websearch"`
-		ImgGen struct {
-			Enabled bool `yaml:"enabled"`
-		} `yaml:"img_gen"`
-	} `yaml:"tools"`
+	AssistantRoles []AssistantRoleConfig             `yaml:"assistant_roles"`
+	Tools          ToolsConfig                       `yaml:"tools"`
+	ChatHistory    struct {
+		SummaryModel string `yaml:"summary_model"` // Model used for session titles and rolling summaries. Empty disables them.
+		MaxChars     int    `yaml:"max_chars"`     // Characters of prior conversation sent with each prompt. Zero disables history.
+	} `yaml:"chat_history"`
+	VectorStore struct {
+		Index string              `yaml:"index"` // "hnsw" for approximate search, anything else searches exhaustively
+		HNSW  vecstore.HNSWConfig `yaml:"hnsw"`
+		// "int8" or "binary" keeps the vectors searched in memory 4x or 32x smaller
+		Quantization vecstore.Quantization `yaml:"quantization"`
+		Rescore      int                   `yaml:"rescore"` // Candidates rescored at full precision per result
+	} `yaml:"vector_store"`
+	Retrieval  RetrievalConfig  `yaml:"retrieval"`
+	Embeddings EmbeddingsConfig `yaml:"embeddings"`
+	Chunking   ChunkingConfig   `yaml:"chunking"`
+	Memory     struct {
+		Policies []MemoryPolicy `yaml:"policies"` // Seed the memory policies on first start; managed through the memory API afterwards
+	} `yaml:"memory"`
+	Backup BackupConfig `yaml:"backup"`
+
+	path string // File the config was loaded from
+}
+
+// AssistantRoleConfig is an assistant role from the config file. The roles seed the
+// database on first start and are managed through the roles API afterwards.
+type AssistantRoleConfig struct {
+	Name          string   `yaml:"name"`
+	Label         string   `yaml:"label"`
+	Instructions  string   `yaml:"instructions"`
+	Model         string   `yaml:"model"`
+	Temperature   float64  `yaml:"temperature"`
+	TopP          float64  `yaml:"top_p"`
+	TopK          int      `yaml:"top_k"`
+	RepeatPenalty float64  `yaml:"repeat_penalty"`
+	Tools         []string `yaml:"tools"`
+	KnowledgeBase string   `yaml:"knowledge_base"`
+}
+
+// ToolsConfig holds the settings of the chat tools. The values in the config file are
+// the defaults for new chat sessions.
+type ToolsConfig struct {
+	Memory struct {
+		Enabled bool `yaml:"enabled"`
+		TopN    int  `yaml:"top_n"`
+	} `yaml:"memory"`
+	WebGet struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"webget"`
+	WebSearch struct {
+		Enabled  bool   `yaml:"enabled"`
+		Name     string `yaml:"name"`
+		Endpoint string `yaml:"endpoint"`
+		TopN     int    `yaml:"top_n"`
+	} `yaml:"websearch"`
+	ImgGen struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"img_gen"`
 }
 
 // BackendHost represents a local or remote backend host.
@@ -74,6 +111,12 @@ func LoadConfig(fs afero.Fs, path string) (*AppConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	config.path = path
 
 	return config, nil
 }
+
+// AnyEnabled reports whether at least one tool is enabled.
+func (t ToolsConfig) AnyEnabled() bool {
+	return t.ImgGen.Enabled || t.Memory.Enabled || t.WebGet.Enabled || t.WebSearch.Enabled
+}
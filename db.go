@@ -5,6 +5,7 @@ package main
 import (
 	"errors"
 	"eternal/pkg/llm"
+	"eternal/pkg/retrieval"
 	"eternal/pkg/sd"
 	"fmt"
 	"reflect"
@@ -19,27 +20,35 @@ type SQLiteDB struct {
 	db *gorm.DB
 }
 
-// TEST
+// ChatSession groups the turns of a single conversation.
 type ChatSession struct {
-	ID        int64 `gorm:"primaryKey;autoIncrement"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	ChatTurns []ChatTurn `gorm:"foreignKey:SessionID"`
+	ID            int64 `gorm:"primaryKey;autoIncrement"`
+	Title         string
+	Summary       string // Rolling summary of the conversation
+	SummaryTurnID int64  // Last turn included in the summary
+	RoleID        int64  // Assistant role attached to the session, zero for the default role
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	ChatTurns     []ChatTurn `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
 }
 
+// ChatTurn is a user prompt and the responses generated for it.
 type ChatTurn struct {
 	ID         int64 `gorm:"primaryKey;autoIncrement"`
-	SessionID  int64
+	SessionID  int64 `gorm:"index"`
 	UserPrompt string
-	Responses  []ChatResponse `gorm:"foreignKey:TurnID"`
+	Responses  []ChatResponse `gorm:"foreignKey:TurnID;constraint:OnDelete:CASCADE"`
+	Sources    []Citation     `gorm:"serializer:json"` // Numbered context added to the prompt
+	CreatedAt  time.Time
 }
 
+// ChatResponse is a single model response to a chat turn.
 type ChatResponse struct {
 	ID        int64 `gorm:"primaryKey;autoIncrement"`
-	TurnID    int64
+	TurnID    int64 `gorm:"index"`
 	Content   string
-	Model     string // Identifier for the LLM model used
-	Host      SystemInfo
+	Model     string     // Identifier for the LLM model used
+	Host      SystemInfo `gorm:"serializer:json"`
 	CreatedAt time.Time
 }
 
@@ -61,7 +70,64 @@ type GPU struct {
 	MetalSupport       string `json:"metal_support"`
 }
 
-// END TEST
+// AssistantRole is an assistant persona with optional defaults for the sessions that use it.
+// Zero values leave the selected model and its settings unchanged.
+type AssistantRole struct {
+	ID            int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name          string    `gorm:"uniqueIndex" json:"name"`
+	Label         string    `json:"label"`
+	Instructions  string    `json:"instructions"`
+	Model         string    `json:"model"`
+	Temperature   float64   `json:"temperature"`
+	TopP          float64   `json:"top_p"`
+	TopK          int       `json:"top_k"`
+	RepeatPenalty float64   `json:"repeat_penalty"`
+	Tools         []string  `gorm:"serializer:json" json:"tools"`
+	KnowledgeBase string    `json:"knowledge_base"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DisplayName returns the label of the role, falling back to its name.
+func (r AssistantRole) DisplayName() string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return r.Name
+}
+
+// Prompt is a reusable prompt template in the prompt library. The current text is kept on
+// the prompt and every saved revision is recorded as a PromptVersion.
+type Prompt struct {
+	ID          int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name        string           `gorm:"uniqueIndex" json:"name"`
+	Description string           `json:"description"`
+	System      string           `json:"system"`
+	Template    string           `json:"template"`
+	Variables   []PromptVariable `gorm:"serializer:json" json:"variables"`
+	Tags        []string         `gorm:"serializer:json" json:"tags"`
+	Version     int              `json:"version"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// PromptVariable is a {placeholder} declared by a prompt template.
+type PromptVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+// PromptVersion is a saved revision of a prompt.
+type PromptVersion struct {
+	ID        int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	PromptID  int64            `gorm:"uniqueIndex:idx_prompt_version" json:"prompt_id"`
+	Version   int              `gorm:"uniqueIndex:idx_prompt_version" json:"version"`
+	System    string           `json:"system"`
+	Template  string           `json:"template"`
+	Variables []PromptVariable `gorm:"serializer:json" json:"variables"`
+	CreatedAt time.Time        `json:"created_at"`
+}
 
 type ModelParams struct {
 	ID         int              `gorm:"primaryKey;autoIncrement"`
@@ -117,6 +183,97 @@ type File struct {
 	ProjectID uint // Foreign key that refers to Project
 }
 
+// Document is an uploaded file and the state of its last ingestion.
+type Document struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	Name          string    `json:"name"`
+	Path          string    `json:"path"`
+	Collection    string    `json:"collection"`
+	KnowledgeBase string    `json:"knowledge_base,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	Status        string    `json:"status"` // Job state of the last ingestion
+	Error         string    `json:"error,omitempty"`
+	Chunks        int       `json:"chunks"`
+	Characters    int       `json:"characters"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// GitRepository is a Git repository indexed as a knowledge base. Files are tagged with the
+// repository name, so a role whose knowledge base is that name answers from the repository.
+type GitRepository struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name       string    `gorm:"uniqueIndex" json:"name"`
+	URL        string    `json:"url,omitempty"` // Clone URL, empty for a repository on disk
+	Path       string    `json:"path"`          // Repository on disk, or where the clone is kept
+	Branch     string    `json:"branch,omitempty"`
+	KeyPath    string    `json:"private_key_path,omitempty"`               // Private key for SSH clone URLs
+	Include    []string  `gorm:"serializer:json" json:"include,omitempty"` // Globs of files to index, all files when empty
+	Exclude    []string  `gorm:"serializer:json" json:"exclude,omitempty"` // Globs of files to skip
+	LastCommit string    `json:"last_commit,omitempty"`                    // Commit of the last completed index
+	Status     string    `json:"status"`                                   // Job state of the last index
+	Error      string    `json:"error,omitempty"`
+	Files      int       `json:"files"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// MemoryPolicy limits how long the memories of a source are kept and how fast they fade
+// from retrieval. Durations are Go durations such as "720h". Pinned memories are exempt.
+type MemoryPolicy struct {
+	Source    string    `gorm:"primaryKey" json:"source" yaml:"source"` // SourceChat, SourceDocument, SourceWeb or SourceGit
+	TTL       string    `json:"ttl,omitempty" yaml:"ttl"`               // Age at which memories are deleted; empty keeps them
+	HalfLife  string    `json:"half_life,omitempty" yaml:"half_life"`   // Age at which retrieval scores are halved; empty disables decay
+	CreatedAt time.Time `json:"created_at" yaml:"-"`
+	UpdatedAt time.Time `json:"updated_at" yaml:"-"`
+}
+
+// DocumentSection is a section of an ingested document or web page that encloses smaller
+// chunks. Retrieval returns the section in place of the chunks that matched.
+type DocumentSection struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	DocumentID string    `gorm:"index" json:"document_id"` // Document, repository file or web page the section belongs to
+	Headings   string    `json:"headings,omitempty"`       // Heading path, such as "Guide > Install"
+	Page       int       `json:"page,omitempty"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// EvalRun is a retrieval evaluation over a labeled dataset. The settings it ran with are
+// kept so runs can be compared as chunking, models and fusion weights change.
+type EvalRun struct {
+	ID                int64             `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name              string            `json:"name,omitempty"`
+	Dataset           string            `json:"dataset,omitempty"`
+	Collection        string            `gorm:"index" json:"collection"`
+	Mode              string            `json:"mode"` // EvalHybrid, EvalLexical or EvalDense
+	K                 int               `json:"k"`
+	Scope             string            `json:"scope,omitempty"`
+	Queries           int               `json:"queries"`
+	retrieval.Metrics `gorm:"embedded"` // Means over the queries
+	Settings          EvalSettings      `gorm:"serializer:json" json:"settings"`
+	Results           []EvalResult      `gorm:"serializer:json" json:"results,omitempty"` // Left out of run lists
+	DurationMS        int64             `json:"duration_ms"`
+	CreatedAt         time.Time         `json:"created_at"`
+}
+
+// EvalSettings are the retrieval settings of an evaluation run.
+type EvalSettings struct {
+	EmbeddingModel string           `json:"embedding_model,omitempty"`
+	Retrieval      retrieval.Config `json:"retrieval"`
+	Chunking       ChunkingConfig   `json:"chunking"` // As configured at the time of the run
+	Rerank         string           `json:"rerank,omitempty"`
+}
+
+// EvalResult is the ranking and metrics of one query of an evaluation run.
+type EvalResult struct {
+	ID        string   `json:"id,omitempty"`
+	Query     string   `json:"query"`
+	Relevant  []string `json:"relevant"`
+	Retrieved []string `json:"retrieved"` // Documents in order of their first chunk
+	retrieval.Metrics
+}
+
 // URLTracking represents the structure for tracking URLs
 type URLTracking struct {
 	ID  int64  `gorm:"primaryKey;autoIncrement"`
@@ -304,6 +461,461 @@ func DeleteChat(db *gorm.DB, id int64) error {
 	return result.Error
 }
 
+// CreateChatSession inserts a new, empty chat session.
+func CreateChatSession(db *gorm.DB) (ChatSession, error) {
+	session := ChatSession{}
+	result := db.Create(&session)
+	return session, result.Error
+}
+
+// ChatSessionExists reports whether a chat session with the ID exists.
+func ChatSessionExists(db *gorm.DB, id int64) (bool, error) {
+	var count int64
+	result := db.Model(&ChatSession{}).Where("id = ?", id).Count(&count)
+	return count > 0, result.Error
+}
+
+// GetChatSession retrieves a chat session with all of its turns and responses.
+func GetChatSession(db *gorm.DB, id int64) (ChatSession, error) {
+	var session ChatSession
+	result := db.Preload("ChatTurns", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("chat_turns.id")
+	}).Preload("ChatTurns.Responses").First(&session, id)
+	return session, result.Error
+}
+
+// ListChatSessions retrieves all chat sessions with their turns and responses.
+func ListChatSessions(db *gorm.DB) ([]ChatSession, error) {
+	var sessions []ChatSession
+	result := db.Preload("ChatTurns", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("chat_turns.id")
+	}).Preload("ChatTurns.Responses").Order("id").Find(&sessions)
+	return sessions, result.Error
+}
+
+// ListRecentChatSessions retrieves all chat sessions without their turns, most recently active first.
+func ListRecentChatSessions(db *gorm.DB) ([]ChatSession, error) {
+	var sessions []ChatSession
+	result := db.Order("updated_at desc").Find(&sessions)
+	return sessions, result.Error
+}
+
+// UpdateChatSessionTitle sets the title of a chat session.
+func UpdateChatSessionTitle(db *gorm.DB, id int64, title string) error {
+	return db.Model(&ChatSession{}).Where("id = ?", id).UpdateColumn("title", title).Error
+}
+
+// UpdateChatSessionSummary sets the rolling summary of a chat session and the last turn it covers.
+func UpdateChatSessionSummary(db *gorm.DB, id int64, summary string, turnID int64) error {
+	return db.Model(&ChatSession{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"summary":         summary,
+		"summary_turn_id": turnID,
+	}).Error
+}
+
+// SetChatSessionRole attaches an assistant role to a chat session.
+func SetChatSessionRole(db *gorm.DB, sessionID, roleID int64) error {
+	return db.Model(&ChatSession{}).Where("id = ?", sessionID).UpdateColumn("role_id", roleID).Error
+}
+
+// AddChatTurn appends a prompt and its response to an existing chat session, along with
+// the sources the prompt was grounded on.
+func AddChatTurn(db *gorm.DB, sessionID int64, prompt, response, model string, sources ...Citation) (ChatTurn, error) {
+	turn := ChatTurn{
+		SessionID:  sessionID,
+		UserPrompt: prompt,
+		Responses:  []ChatResponse{{Content: response, Model: model}},
+		Sources:    sources,
+	}
+	if err := db.Create(&turn).Error; err != nil {
+		return turn, err
+	}
+
+	// Touch the session so it sorts by most recent activity.
+	err := db.Model(&ChatSession{}).Where("id = ?", sessionID).Update("updated_at", time.Now()).Error
+	return turn, err
+}
+
+// ImportChatSessions inserts fully populated chat sessions, including their turns and
+// responses, in one transaction, so a failed import leaves nothing behind.
+func ImportChatSessions(db *gorm.DB, sessions []ChatSession) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range sessions {
+			if err := tx.Create(&sessions[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SeedAssistantRoles inserts the roles from the config file when the database has no roles
+// yet. Once seeded, roles are managed through the API, so roles deleted there stay deleted.
+func SeedAssistantRoles(db *gorm.DB, roles []AssistantRoleConfig) error {
+	var count int64
+	if err := db.Model(&AssistantRole{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 || len(roles) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, cfg := range roles {
+			role := assistantRoleFromConfig(cfg)
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// assistantRoleFromConfig converts a role from the config file into a database record.
+func assistantRoleFromConfig(cfg AssistantRoleConfig) AssistantRole {
+	return AssistantRole{
+		Name:          cfg.Name,
+		Label:         cfg.Label,
+		Instructions:  cfg.Instructions,
+		Model:         cfg.Model,
+		Temperature:   cfg.Temperature,
+		TopP:          cfg.TopP,
+		TopK:          cfg.TopK,
+		RepeatPenalty: cfg.RepeatPenalty,
+		Tools:         cfg.Tools,
+		KnowledgeBase: cfg.KnowledgeBase,
+	}
+}
+
+// ListAssistantRoles retrieves all assistant roles ordered by ID.
+func ListAssistantRoles(db *gorm.DB) ([]AssistantRole, error) {
+	var roles []AssistantRole
+	result := db.Order("id").Find(&roles)
+	return roles, result.Error
+}
+
+// GetAssistantRole retrieves an assistant role by its ID.
+func GetAssistantRole(db *gorm.DB, id int64) (AssistantRole, error) {
+	var role AssistantRole
+	result := db.First(&role, id)
+	return role, result.Error
+}
+
+// GetAssistantRoleByName retrieves an assistant role by its name.
+func GetAssistantRoleByName(db *gorm.DB, name string) (AssistantRole, error) {
+	var role AssistantRole
+	result := db.Where("name = ?", name).First(&role)
+	return role, result.Error
+}
+
+// CreateAssistantRole inserts a new assistant role.
+func CreateAssistantRole(db *gorm.DB, role *AssistantRole) error {
+	return db.Create(role).Error
+}
+
+// UpdateAssistantRole replaces the fields of an assistant role.
+func UpdateAssistantRole(db *gorm.DB, id int64, role *AssistantRole) error {
+	existing, err := GetAssistantRole(db, id)
+	if err != nil {
+		return err
+	}
+	role.ID = existing.ID
+	role.CreatedAt = existing.CreatedAt
+	return db.Save(role).Error
+}
+
+// UpsertAssistantRole inserts an assistant role or updates the existing role with the same name.
+func UpsertAssistantRole(db *gorm.DB, role *AssistantRole) error {
+	existing, err := GetAssistantRoleByName(db, role.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		role.ID = 0
+		return db.Create(role).Error
+	}
+	if err != nil {
+		return err
+	}
+	return UpdateAssistantRole(db, existing.ID, role)
+}
+
+// DeleteAssistantRole deletes an assistant role and detaches it from its sessions.
+func DeleteAssistantRole(db *gorm.DB, id int64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ChatSession{}).Where("role_id = ?", id).UpdateColumn("role_id", 0).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&AssistantRole{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// ListPrompts retrieves the prompts in the library ordered by name, optionally limited to a tag.
+func ListPrompts(db *gorm.DB, tag string) ([]Prompt, error) {
+	var prompts []Prompt
+	if err := db.Order("name").Find(&prompts).Error; err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		return prompts, nil
+	}
+
+	// Tags are stored as JSON, so filter them here rather than in SQL.
+	var tagged []Prompt
+	for _, prompt := range prompts {
+		for _, t := range prompt.Tags {
+			if t == tag {
+				tagged = append(tagged, prompt)
+				break
+			}
+		}
+	}
+	return tagged, nil
+}
+
+// GetPrompt retrieves a prompt by its ID.
+func GetPrompt(db *gorm.DB, id int64) (Prompt, error) {
+	var prompt Prompt
+	result := db.First(&prompt, id)
+	return prompt, result.Error
+}
+
+// CreatePrompt inserts a new prompt and records it as version 1.
+func CreatePrompt(db *gorm.DB, prompt *Prompt) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		prompt.ID = 0
+		prompt.Version = 1
+		if err := tx.Create(prompt).Error; err != nil {
+			return err
+		}
+		return tx.Create(promptVersionOf(prompt)).Error
+	})
+}
+
+// UpdatePrompt replaces the fields of a prompt. A change to the system text, template or
+// variables is recorded as a new version.
+func UpdatePrompt(db *gorm.DB, id int64, prompt *Prompt) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing Prompt
+		if err := tx.First(&existing, id).Error; err != nil {
+			return err
+		}
+
+		prompt.ID = existing.ID
+		prompt.CreatedAt = existing.CreatedAt
+		prompt.Version = existing.Version
+
+		changed := prompt.System != existing.System || prompt.Template != existing.Template ||
+			!reflect.DeepEqual(prompt.Variables, existing.Variables)
+		if changed {
+			prompt.Version++
+			if err := tx.Create(promptVersionOf(prompt)).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Save(prompt).Error
+	})
+}
+
+// promptVersionOf returns the version record for the current text of a prompt.
+func promptVersionOf(prompt *Prompt) *PromptVersion {
+	return &PromptVersion{
+		PromptID:  prompt.ID,
+		Version:   prompt.Version,
+		System:    prompt.System,
+		Template:  prompt.Template,
+		Variables: prompt.Variables,
+	}
+}
+
+// DeletePrompt deletes a prompt and its version history.
+func DeletePrompt(db *gorm.DB, id int64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("prompt_id = ?", id).Delete(&PromptVersion{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&Prompt{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// ListPromptVersions retrieves the version history of a prompt, newest first.
+func ListPromptVersions(db *gorm.DB, promptID int64) ([]PromptVersion, error) {
+	var versions []PromptVersion
+	result := db.Where("prompt_id = ?", promptID).Order("version desc").Find(&versions)
+	return versions, result.Error
+}
+
+// GetPromptVersion retrieves a single version of a prompt.
+func GetPromptVersion(db *gorm.DB, promptID int64, version int) (PromptVersion, error) {
+	var v PromptVersion
+	result := db.Where("prompt_id = ? AND version = ?", promptID, version).First(&v)
+	return v, result.Error
+}
+
+// SaveDocument creates a document or replaces the stored document with the same ID.
+func SaveDocument(db *gorm.DB, doc *Document) error {
+	return db.Save(doc).Error
+}
+
+// GetDocument retrieves a document by ID.
+func GetDocument(db *gorm.DB, id string) (Document, error) {
+	var doc Document
+	result := db.First(&doc, "id = ?", id)
+	return doc, result.Error
+}
+
+// ListDocuments retrieves all documents, most recently updated first.
+func ListDocuments(db *gorm.DB) ([]Document, error) {
+	var docs []Document
+	result := db.Order("updated_at desc").Find(&docs)
+	return docs, result.Error
+}
+
+// CountDocumentsWithPath returns the number of documents whose source is the file at path.
+func CountDocumentsWithPath(db *gorm.DB, path string) (int64, error) {
+	var count int64
+	result := db.Model(&Document{}).Where("path = ?", path).Count(&count)
+	return count, result.Error
+}
+
+// ListDocumentsByStatus retrieves the documents whose last ingestion is in one of the states.
+func ListDocumentsByStatus(db *gorm.DB, statuses ...string) ([]Document, error) {
+	var docs []Document
+	result := db.Where("status IN ?", statuses).Order("created_at").Find(&docs)
+	return docs, result.Error
+}
+
+// CountCollectionDocumentsByStatus returns the number of documents of a collection whose last
+// ingestion is in one of the states.
+func CountCollectionDocumentsByStatus(db *gorm.DB, collection string, statuses ...string) (int64, error) {
+	var count int64
+	result := db.Model(&Document{}).Where("collection = ? AND status IN ?", collection, statuses).Count(&count)
+	return count, result.Error
+}
+
+// UpdateDocumentStatus records the state of a document's ingestion.
+func UpdateDocumentStatus(db *gorm.DB, id string, status string, errMsg string) error {
+	return db.Model(&Document{}).Where("id = ?", id).Updates(map[string]interface{}{"status": status, "error": errMsg}).Error
+}
+
+// DeleteDocument deletes a document record.
+func DeleteDocument(db *gorm.DB, id string) error {
+	result := db.Delete(&Document{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateGitRepository inserts a new Git repository.
+func CreateGitRepository(db *gorm.DB, repo *GitRepository) error {
+	return db.Create(repo).Error
+}
+
+// GetGitRepository retrieves a Git repository by its ID.
+func GetGitRepository(db *gorm.DB, id int64) (GitRepository, error) {
+	var repo GitRepository
+	result := db.First(&repo, id)
+	return repo, result.Error
+}
+
+// GetGitRepositoryByName retrieves a Git repository by its knowledge base name.
+func GetGitRepositoryByName(db *gorm.DB, name string) (GitRepository, error) {
+	var repo GitRepository
+	result := db.Where("name = ?", name).First(&repo)
+	return repo, result.Error
+}
+
+// ListGitRepositories retrieves all Git repositories.
+func ListGitRepositories(db *gorm.DB) ([]GitRepository, error) {
+	var repos []GitRepository
+	result := db.Order("name").Find(&repos)
+	return repos, result.Error
+}
+
+// UpdateGitRepository saves every field of a Git repository.
+func UpdateGitRepository(db *gorm.DB, repo *GitRepository) error {
+	return db.Save(repo).Error
+}
+
+// CountGitRepositoriesByStatus returns the number of repositories whose last index is in one
+// of the states.
+func CountGitRepositoriesByStatus(db *gorm.DB, statuses ...string) (int64, error) {
+	var count int64
+	result := db.Model(&GitRepository{}).Where("status IN ?", statuses).Count(&count)
+	return count, result.Error
+}
+
+// UpdateGitRepositoryStatus records the state of a repository's index.
+func UpdateGitRepositoryStatus(db *gorm.DB, id int64, status string, errMsg string) error {
+	return db.Model(&GitRepository{}).Where("id = ?", id).Updates(map[string]interface{}{"status": status, "error": errMsg}).Error
+}
+
+// DeleteGitRepository deletes a Git repository record.
+func DeleteGitRepository(db *gorm.DB, id int64) error {
+	result := db.Delete(&GitRepository{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SeedMemoryPolicies inserts the policies from the config file for sources that have none yet.
+func SeedMemoryPolicies(db *gorm.DB, policies []MemoryPolicy) error {
+	for _, policy := range policies {
+		if err := db.Where(MemoryPolicy{Source: policy.Source}).FirstOrCreate(&policy).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListMemoryPolicies retrieves the memory policies ordered by source.
+func ListMemoryPolicies(db *gorm.DB) ([]MemoryPolicy, error) {
+	var policies []MemoryPolicy
+	result := db.Order("source").Find(&policies)
+	return policies, result.Error
+}
+
+// SaveMemoryPolicy inserts or replaces the policy of a source.
+func SaveMemoryPolicy(db *gorm.DB, policy *MemoryPolicy) error {
+	var existing MemoryPolicy
+	if err := db.Where("source = ?", policy.Source).First(&existing).Error; err == nil {
+		policy.CreatedAt = existing.CreatedAt
+	}
+	return db.Save(policy).Error
+}
+
+// DeleteMemoryPolicy deletes the policy of a source.
+func DeleteMemoryPolicy(db *gorm.DB, source string) error {
+	result := db.Where("source = ?", source).Delete(&MemoryPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 // CreateURLTracking inserts a new URL into the URLTracking table
 func (sqldb *SQLiteDB) CreateURLTracking(url string) error {
 	var existingURLTracking URLTracking
@@ -350,3 +962,81 @@ func (sqldb *SQLiteDB) DeleteURLTracking(url string) error {
 
 // 	return nil
 // }
+
+// SaveDocumentSections inserts sections, replacing sections with the same ID.
+func SaveDocumentSections(db *gorm.DB, sections []DocumentSection) error {
+	if len(sections) == 0 {
+		return nil
+	}
+	return db.Save(&sections).Error
+}
+
+// ReplaceDocumentSections replaces the sections of a document.
+func ReplaceDocumentSections(db *gorm.DB, documentID string, sections []DocumentSection) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteDocumentSections(tx, documentID); err != nil {
+			return err
+		}
+		return SaveDocumentSections(tx, sections)
+	})
+}
+
+// GetDocumentSections retrieves sections by ID. IDs without a section are left out.
+func GetDocumentSections(db *gorm.DB, ids []string) (map[string]DocumentSection, error) {
+	var sections []DocumentSection
+	if err := db.Where("id IN ?", ids).Find(&sections).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]DocumentSection, len(sections))
+	for _, section := range sections {
+		byID[section.ID] = section
+	}
+	return byID, nil
+}
+
+// DeleteDocumentSections deletes the sections of a document.
+func DeleteDocumentSections(db *gorm.DB, documentID string) error {
+	return db.Where("document_id = ?", documentID).Delete(&DocumentSection{}).Error
+}
+
+// DeleteDocumentSectionsPrefix deletes the sections of every document whose ID starts with
+// the prefix.
+func DeleteDocumentSectionsPrefix(db *gorm.DB, prefix string) error {
+	return db.Where("substr(document_id, 1, ?) = ?", len([]rune(prefix)), prefix).Delete(&DocumentSection{}).Error
+}
+
+// CreateEvalRun stores an evaluation run.
+func CreateEvalRun(db *gorm.DB, run *EvalRun) error {
+	return db.Create(run).Error
+}
+
+// ListEvalRuns retrieves the evaluation runs, newest first, without their per-query results.
+// A non-empty collection limits the runs to that collection.
+func ListEvalRuns(db *gorm.DB, collection string) ([]EvalRun, error) {
+	var runs []EvalRun
+	query := db.Omit("results").Order("id DESC")
+	if collection != "" {
+		query = query.Where("collection = ?", collection)
+	}
+	result := query.Find(&runs)
+	return runs, result.Error
+}
+
+// GetEvalRun retrieves an evaluation run with its per-query results.
+func GetEvalRun(db *gorm.DB, id int64) (EvalRun, error) {
+	var run EvalRun
+	result := db.First(&run, id)
+	return run, result.Error
+}
+
+// DeleteEvalRun deletes an evaluation run.
+func DeleteEvalRun(db *gorm.DB, id int64) error {
+	result := db.Delete(&EvalRun{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
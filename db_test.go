@@ -1,14 +1,40 @@
 package main
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 var db *gorm.DB
 
+func TestMain(m *testing.M) {
+	var err error
+	db, err = gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := db.AutoMigrate(&ChatSession{}, &ChatTurn{}, &ChatResponse{}, &AssistantRole{}, &Prompt{}, &PromptVersion{}, &Document{}, &GitRepository{}, &MemoryPolicy{}, &DocumentSection{}, &EvalRun{}); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// withTestDB points the global database at the test database until the test ends.
+func withTestDB(t *testing.T) {
+	saved := sqliteDB
+	sqliteDB = &SQLiteDB{db: db}
+	t.Cleanup(func() { sqliteDB = saved })
+}
+
 func TestChatSession(t *testing.T) {
 
 	// Test creating a new chat session
@@ -87,6 +113,31 @@ func TestChatTurn(t *testing.T) {
 	db.Delete(&session)
 }
 
+func TestImportChatSessions(t *testing.T) {
+	existing, err := CreateChatSession(db)
+	assert.NoError(t, err)
+	defer db.Delete(&existing)
+
+	// A session that cannot be stored rolls back the whole import.
+	sessions := []ChatSession{
+		{Title: "Imported", ChatTurns: []ChatTurn{{UserPrompt: "Imported hello", Responses: []ChatResponse{{Content: "Hi"}}}}},
+		{ID: existing.ID, Title: "Duplicate"},
+	}
+	assert.Error(t, ImportChatSessions(db, sessions))
+	var count int64
+	db.Model(&ChatSession{}).Where("title = ?", "Imported").Count(&count)
+	assert.Zero(t, count)
+	db.Model(&ChatTurn{}).Where("user_prompt = ?", "Imported hello").Count(&count)
+	assert.Zero(t, count)
+
+	sessions = []ChatSession{{Title: "Imported", ChatTurns: []ChatTurn{{UserPrompt: "Hello"}}}}
+	assert.NoError(t, ImportChatSessions(db, sessions))
+	imported, err := GetChatSession(db, sessions[0].ID)
+	assert.NoError(t, err)
+	assert.Len(t, imported.ChatTurns, 1)
+	db.Delete(&imported)
+}
+
 func TestChatResponse(t *testing.T) {
 
 	// Create test session and turn
@@ -131,3 +182,223 @@ func TestChatResponse(t *testing.T) {
 	db.Delete(&turn)
 	db.Delete(&session)
 }
+
+func TestAssistantRoles(t *testing.T) {
+
+	// Test seeding roles from the config
+	err := SeedAssistantRoles(db, []AssistantRoleConfig{
+		{Name: "reviewer", Label: "Code Review", Instructions: "Review the code.", Tools: []string{"memory"}},
+		{Name: "writer", Instructions: "Write."},
+	})
+	assert.NoError(t, err)
+
+	role, err := GetAssistantRoleByName(db, "reviewer")
+	assert.NoError(t, err)
+	assert.Equal(t, "Code Review", role.DisplayName())
+	assert.Equal(t, []string{"memory"}, role.Tools)
+
+	// Seeding again must not overwrite edits made in the database
+	role.Instructions = "Review the code carefully."
+	assert.NoError(t, UpdateAssistantRole(db, role.ID, &role))
+	assert.NoError(t, SeedAssistantRoles(db, []AssistantRoleConfig{{Name: "reviewer", Instructions: "Review the code."}}))
+
+	role, err = GetAssistantRole(db, role.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Review the code carefully.", role.Instructions)
+
+	// Test upserting by name
+	imported := AssistantRole{Name: "reviewer", Instructions: "Imported.", Temperature: 0.1}
+	assert.NoError(t, UpsertAssistantRole(db, &imported))
+	assert.Equal(t, role.ID, imported.ID)
+
+	// Test deleting a role detaches it from its sessions
+	session, err := CreateChatSession(db)
+	assert.NoError(t, err)
+	assert.NoError(t, SetChatSessionRole(db, session.ID, role.ID))
+
+	assert.NoError(t, DeleteAssistantRole(db, role.ID))
+	fetched, err := GetChatSession(db, session.ID)
+	assert.NoError(t, err)
+	assert.Zero(t, fetched.RoleID)
+
+	assert.Error(t, DeleteAssistantRole(db, role.ID))
+
+	// Deleted roles are not seeded again
+	assert.NoError(t, SeedAssistantRoles(db, []AssistantRoleConfig{{Name: "reviewer", Instructions: "Review the code."}}))
+	_, err = GetAssistantRoleByName(db, "reviewer")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Clean up
+	db.Delete(&fetched)
+	db.Where("name = ?", "writer").Delete(&AssistantRole{})
+}
+
+func TestPromptVersions(t *testing.T) {
+
+	// Test creating a prompt records version 1
+	prompt := Prompt{Name: "summarize", Template: "Summarize {text}", Tags: []string{"daily"}}
+	assert.NoError(t, CreatePrompt(db, &prompt))
+	assert.Equal(t, 1, prompt.Version)
+
+	// Test that only text changes create a new version
+	prompt.Description = "Short summaries"
+	assert.NoError(t, UpdatePrompt(db, prompt.ID, &prompt))
+	assert.Equal(t, 1, prompt.Version)
+
+	prompt.Template = "Summarize {text} in {words} words"
+	assert.NoError(t, UpdatePrompt(db, prompt.ID, &prompt))
+	assert.Equal(t, 2, prompt.Version)
+
+	versions, err := ListPromptVersions(db, prompt.ID)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+
+	first, err := loadPromptVersion(db, prompt.ID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Summarize {text}", first.Template)
+
+	current, err := loadPromptVersion(db, prompt.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, current.Version)
+
+	// Test filtering by tag
+	tagged, err := ListPrompts(db, "daily")
+	assert.NoError(t, err)
+	assert.Len(t, tagged, 1)
+	untagged, err := ListPrompts(db, "weekly")
+	assert.NoError(t, err)
+	assert.Empty(t, untagged)
+
+	// Test deleting removes the history
+	assert.NoError(t, DeletePrompt(db, prompt.ID))
+	versions, err = ListPromptVersions(db, prompt.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestDocuments(t *testing.T) {
+
+	// Test saving a document twice replaces it
+	doc := Document{ID: "doc-1", Name: "notes.md", Collection: "documents", Status: "queued"}
+	assert.NoError(t, SaveDocument(db, &doc))
+	doc.KnowledgeBase = "go"
+	assert.NoError(t, SaveDocument(db, &doc))
+
+	docs, err := ListDocuments(db)
+	assert.NoError(t, err)
+	assert.Len(t, docs, 1)
+	assert.Equal(t, "go", docs[0].KnowledgeBase)
+
+	// Test filtering by status
+	assert.NoError(t, UpdateDocumentStatus(db, doc.ID, "failed", "no text found"))
+	failed, err := ListDocumentsByStatus(db, "queued", "failed")
+	assert.NoError(t, err)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, "no text found", failed[0].Error)
+	running, err := ListDocumentsByStatus(db, "running")
+	assert.NoError(t, err)
+	assert.Empty(t, running)
+
+	// Test deleting
+	assert.NoError(t, DeleteDocument(db, doc.ID))
+	_, err = GetDocument(db, doc.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, DeleteDocument(db, doc.ID), gorm.ErrRecordNotFound)
+}
+
+func TestChatTurnSources(t *testing.T) {
+	session, err := CreateChatSession(db)
+	assert.NoError(t, err)
+
+	sources := []Citation{{Number: 1, Source: SourceWeb, URL: "https://go.dev", Excerpt: "A tour of Go.", Cited: true}}
+	turn, err := AddChatTurn(db, session.ID, "What is Go?", "A language [1].", "model", sources...)
+	assert.NoError(t, err)
+
+	var stored ChatTurn
+	assert.NoError(t, db.First(&stored, turn.ID).Error)
+	assert.Equal(t, sources, stored.Sources)
+}
+
+func TestGitRepositories(t *testing.T) {
+
+	// Test creating a repository keeps its globs
+	repo := GitRepository{Name: "eternal", Path: "/src/eternal", Include: []string{"*.go"}, Exclude: []string{"vendor/"}}
+	assert.NoError(t, CreateGitRepository(db, &repo))
+
+	stored, err := GetGitRepositoryByName(db, "eternal")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*.go"}, stored.Include)
+	assert.Equal(t, []string{"vendor/"}, stored.Exclude)
+
+	// Test recording the indexed commit
+	stored.LastCommit = "abc123"
+	assert.NoError(t, UpdateGitRepository(db, &stored))
+	assert.NoError(t, UpdateGitRepositoryStatus(db, repo.ID, "completed", ""))
+	stored, err = GetGitRepository(db, repo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", stored.LastCommit)
+	assert.Equal(t, "completed", stored.Status)
+
+	// Test deleting
+	assert.NoError(t, DeleteGitRepository(db, repo.ID))
+	repos, err := ListGitRepositories(db)
+	assert.NoError(t, err)
+	assert.Empty(t, repos)
+	assert.ErrorIs(t, DeleteGitRepository(db, repo.ID), gorm.ErrRecordNotFound)
+}
+
+func TestMemoryPolicies(t *testing.T) {
+
+	assert.NoError(t, SeedMemoryPolicies(db, []MemoryPolicy{
+		{Source: SourceWeb, TTL: "720h"},
+		{Source: SourceChat, HalfLife: "168h"},
+	}))
+
+	// Seeding keeps policies changed through the API.
+	assert.NoError(t, SaveMemoryPolicy(db, &MemoryPolicy{Source: SourceWeb, TTL: "24h"}))
+	assert.NoError(t, SeedMemoryPolicies(db, []MemoryPolicy{{Source: SourceWeb, TTL: "720h"}}))
+
+	policies, err := ListMemoryPolicies(db)
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 2) {
+		assert.Equal(t, SourceChat, policies[0].Source)
+		assert.Equal(t, "168h", policies[0].HalfLife)
+		assert.Equal(t, SourceWeb, policies[1].Source)
+		assert.Equal(t, "24h", policies[1].TTL)
+		assert.False(t, policies[1].CreatedAt.IsZero())
+	}
+
+	assert.NoError(t, DeleteMemoryPolicy(db, SourceWeb))
+	assert.ErrorIs(t, DeleteMemoryPolicy(db, SourceWeb), gorm.ErrRecordNotFound)
+}
+
+func TestDocumentSections(t *testing.T) {
+
+	assert.NoError(t, ReplaceDocumentSections(db, "git:1:a.md", []DocumentSection{
+		{ID: "s1", DocumentID: "git:1:a.md", Headings: "Guide", Text: "# Guide"},
+		{ID: "s2", DocumentID: "git:1:a.md", Headings: "Guide > Install", Text: "## Install"},
+	}))
+	assert.NoError(t, SaveDocumentSections(db, []DocumentSection{{ID: "s3", DocumentID: "git:10:b.md", Text: "b"}}))
+
+	// Replacing drops the sections a document no longer has.
+	assert.NoError(t, ReplaceDocumentSections(db, "git:1:a.md", []DocumentSection{
+		{ID: "s2", DocumentID: "git:1:a.md", Headings: "Guide > Install", Text: "## Install"},
+	}))
+	sections, err := GetDocumentSections(db, []string{"s1", "s2", "s3"})
+	assert.NoError(t, err)
+	assert.Len(t, sections, 2)
+	assert.Equal(t, "Guide > Install", sections["s2"].Headings)
+
+	// Deleting by prefix leaves documents of other repositories.
+	assert.NoError(t, DeleteDocumentSectionsPrefix(db, "git:1:"))
+	sections, err = GetDocumentSections(db, []string{"s2", "s3"})
+	assert.NoError(t, err)
+	assert.Len(t, sections, 1)
+	assert.Contains(t, sections, "s3")
+
+	assert.NoError(t, DeleteDocumentSections(db, "git:10:b.md"))
+	sections, err = GetDocumentSections(db, []string{"s3"})
+	assert.NoError(t, err)
+	assert.Empty(t, sections)
+}
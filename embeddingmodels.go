@@ -0,0 +1,304 @@
+// embeddingmodels.go - Embedding models of the vector collections
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+
+	"eternal/pkg/embeddings"
+	"eternal/pkg/jobs"
+	"eternal/pkg/vecstore"
+)
+
+// reembedJobType is the job type of embedding a collection again.
+const reembedJobType = "reembed"
+
+// reembedBatchSize is the number of records embedded and written at a time.
+const reembedBatchSize = 64
+
+// EmbeddingsConfig declares the embedding models and selects the model of each vector
+// collection. Without models every collection uses embeddings.DefaultModel.
+type EmbeddingsConfig struct {
+	Default     string                            `yaml:"default"`     // Model of collections not listed in Collections
+	Models      map[string]embeddings.ModelConfig `yaml:"models"`      // Models by name
+	Collections map[string]string                 `yaml:"collections"` // Model name by collection
+}
+
+// Model returns the named model. The default model can be named without declaring it.
+func (e EmbeddingsConfig) Model(name string) (embeddings.ModelConfig, error) {
+	model, ok := e.Models[name]
+	if !ok {
+		if name == embeddings.DefaultModel.Name {
+			return embeddings.DefaultModel, nil
+		}
+		return model, fmt.Errorf("unknown embedding model %q", name)
+	}
+	model.Name = name
+	return model, nil
+}
+
+// ForCollection returns the embedding model of the named collection.
+func (e EmbeddingsConfig) ForCollection(collection string) (embeddings.ModelConfig, error) {
+	name, ok := e.Collections[collection]
+	if !ok {
+		name = e.Default
+	}
+	if name == "" {
+		if len(e.Models) > 1 {
+			return embeddings.ModelConfig{}, fmt.Errorf("no embedding model for collection %s, set embeddings.default", collection)
+		}
+		name = embeddings.DefaultModel.Name
+		for n := range e.Models {
+			name = n
+		}
+	}
+	return e.Model(name)
+}
+
+// Validate checks every declared model and the models selected for collections.
+func (e EmbeddingsConfig) Validate() error {
+	names := make([]string, 0, len(e.Models))
+	for name := range e.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		model, _ := e.Model(name)
+		if err := model.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if e.Default != "" {
+		if _, err := e.Model(e.Default); err != nil {
+			return err
+		}
+	}
+	for collection, name := range e.Collections {
+		if !vecstore.ValidCollectionName(collection) {
+			return fmt.Errorf("invalid collection name %q", collection)
+		}
+		if _, err := e.Model(name); err != nil {
+			return fmt.Errorf("collection %s: %v", collection, err)
+		}
+	}
+	return nil
+}
+
+// embedders caches loaded embedding models by name, since loading a local model takes much
+// longer than embedding with it. Requests share the loaded models.
+var embedders = struct {
+	sync.Mutex
+	loaded map[string]*embeddings.CachedEmbedder
+}{loaded: make(map[string]*embeddings.CachedEmbedder)}
+
+// loadEmbedder returns the embedder of a model, loading it on first use. Local models are
+// kept under the Hugging Face models directory like other models. Vectors are cached on
+// disk, so a text is only embedded once per model.
+func loadEmbedder(config *AppConfig, model embeddings.ModelConfig) (embeddings.Embedder, error) {
+	embedders.Lock()
+	defer embedders.Unlock()
+
+	if embedder, ok := embedders.loaded[model.Name]; ok {
+		return embedder, nil
+	}
+
+	if model.Provider == embeddings.ProviderOpenAI && model.APIKey == "" {
+		model.APIKey = config.OAIKey
+	}
+	embedder, err := embeddings.Load(embeddingModelsDir(config), model)
+	if err != nil {
+		return nil, fmt.Errorf("error loading embedding model %s: %v", model.Name, err)
+	}
+	cached, err := embeddings.OpenCache(filepath.Join(config.DataPath, "cache", "embeddings"), model, embedder)
+	if err != nil {
+		return nil, err
+	}
+	embedders.loaded[model.Name] = cached
+	return cached, nil
+}
+
+// embeddingModelsDir returns the directory of the local embedding models.
+func embeddingModelsDir(config *AppConfig) string {
+	return filepath.Join(config.DataPath, "models/HF")
+}
+
+// closeEmbedders closes the embedding caches of the loaded models.
+func closeEmbedders() error {
+	embedders.Lock()
+	defer embedders.Unlock()
+
+	var firstErr error
+	for name, embedder := range embedders.loaded {
+		if err := embedder.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error closing embedding cache of %s: %v", name, err)
+		}
+		delete(embedders.loaded, name)
+	}
+	return firstErr
+}
+
+// collectionEmbedder returns the embedder of a collection's model and records the model on
+// the collection. It returns vecstore.ErrModelMismatch when the collection holds vectors
+// of another model.
+func collectionEmbedder(config *AppConfig, collection string) (embeddings.Embedder, error) {
+	model, err := config.Embeddings.ForCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	if err := vectorStores.SetModel(collection, model.CollectionModel()); err != nil {
+		return nil, err
+	}
+	return loadEmbedder(config, model)
+}
+
+// errCollectionIngesting is returned when a collection cannot be embedded again because
+// documents are being ingested into it.
+var errCollectionIngesting = errors.New("documents are being ingested into the collection")
+
+// reembedJobID returns the job ID used to embed a collection again.
+func reembedJobID(collection string) string {
+	return reembedJobType + "-" + collection
+}
+
+// submitReembed queues a job that embeds the records of a collection again with the model
+// the config selects for it.
+func submitReembed(config *AppConfig, queue *jobs.Queue, collection string) error {
+	return queue.Submit(&jobs.Job{
+		ID:    reembedJobID(collection),
+		Type:  reembedJobType,
+		Label: collection,
+		Run: func(ctx context.Context, report func(jobs.Progress)) (any, error) {
+			// Ingestions may have been queued since the job was.
+			if busy, err := collectionIngesting(sqliteDB.db, collection); err != nil {
+				return nil, err
+			} else if busy {
+				return nil, errCollectionIngesting
+			}
+			model, err := config.Embeddings.ForCollection(collection)
+			if err != nil {
+				return nil, err
+			}
+			embedder, err := loadEmbedder(config, model)
+			if err != nil {
+				return nil, err
+			}
+			count, err := reembedCollection(ctx, vectorStores, collection, embedder, model.CollectionModel(), report)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"collection": collection, "records": count}, nil
+		},
+	})
+}
+
+// collectionIngesting reports whether documents or Git repositories are queued or being
+// ingested into the collection. Their writes would fail while it is embedded again.
+func collectionIngesting(db *gorm.DB, collection string) (bool, error) {
+	active := []string{jobs.StateQueued, jobs.StateRunning}
+	count, err := CountCollectionDocumentsByStatus(db, collection, active...)
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	if collection != CollectionDocuments {
+		return false, nil
+	}
+	count, err = CountGitRepositoriesByStatus(db, active...)
+	return count > 0, err
+}
+
+// reembedCollection replaces the vectors of a collection with ones embedded from the stored
+// text of its records and records the model on it. The records keep their IDs and
+// metadata. The collection is searched with its old vectors until every record is embedded,
+// and writes to it wait until then.
+func reembedCollection(ctx context.Context, collections *vecstore.Collections, name string, embedder embeddings.Embedder, model vecstore.CollectionModel, report func(jobs.Progress)) (int, error) {
+	var ids []string
+	err := collections.Rebuild(name, func(store, dst *vecstore.Store) error {
+		ids = store.IDs()
+		for start := 0; start < len(ids); start += reembedBatchSize {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			report(jobs.Progress{Step: "embed", Done: start, Total: len(ids)})
+
+			var records []vecstore.Record
+			var texts []string
+			for _, id := range ids[start:min(start+reembedBatchSize, len(ids))] {
+				record, ok, err := store.Get(id)
+				if err != nil {
+					return err
+				}
+				if ok {
+					records = append(records, record)
+					texts = append(texts, record.Text)
+				}
+			}
+			vectors, err := embeddings.EmbedAll(ctx, embedder, texts)
+			if err != nil {
+				return err
+			}
+			for i := range records {
+				records[i].Vector = vectors[i]
+			}
+			if err := dst.Put(records...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error embedding collection %s again: %v", name, err)
+	}
+	report(jobs.Progress{Step: "embed", Done: len(ids), Total: len(ids)})
+	return len(ids), collections.SetModel(name, model)
+}
+
+// reembedLegacyCollections queues the collections that hold vectors without a recorded
+// model and of other dimensions than their configured model to be embedded again. Stores
+// from before embedding models were configurable hold 128-dimension vectors, which could not
+// be compared with those of any model.
+func reembedLegacyCollections(config *AppConfig, queue *jobs.Queue) {
+	names, err := vectorStores.Names()
+	if err != nil {
+		log.Errorf("Error listing vector collections: %v", err)
+		return
+	}
+	for _, name := range names {
+		if _, ok, err := vectorStores.Model(name); err != nil || ok {
+			continue
+		}
+		model, err := config.Embeddings.ForCollection(name)
+		if err != nil {
+			continue
+		}
+		store, err := vectorStores.Get(name)
+		if err != nil {
+			continue
+		}
+		if dims := store.Dimensions(); dims == 0 || dims == model.Dimensions {
+			continue
+		}
+		busy, err := collectionIngesting(sqliteDB.db, name)
+		if err != nil {
+			log.Errorf("Error checking ingestions into collection %s: %v", name, err)
+			continue
+		}
+		if busy {
+			log.Warnf("Documents are being ingested into vector collection %s; embed it again with POST /collections/%s/reembed once they are done", name, name)
+			continue
+		}
+
+		log.Infof("Embedding vector collection %s again with %s", name, model.Name)
+		if err := submitReembed(config, queue, name); err != nil {
+			log.Errorf("Error queueing collection %s to be embedded again: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/embeddings"
+	"eternal/pkg/jobs"
+	"eternal/pkg/vecstore"
+)
+
+// lengthEmbedder embeds a text as its length and a constant, in three dimensions.
+type lengthEmbedder struct{}
+
+func (lengthEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text)), 1, 0}, nil
+}
+
+func TestEmbeddingsConfigForCollection(t *testing.T) {
+	// Without models every collection uses the built-in default.
+	var empty EmbeddingsConfig
+	model, err := empty.ForCollection(CollectionChat)
+	require.NoError(t, err)
+	assert.Equal(t, embeddings.DefaultModel, model)
+	require.NoError(t, empty.Validate())
+
+	config := EmbeddingsConfig{
+		Default: "small",
+		Models: map[string]embeddings.ModelConfig{
+			"small": {Model: "org/small", Dimensions: 384},
+			"large": {Provider: embeddings.ProviderOpenAI, Model: "text-embedding-3-large", Dimensions: 3072},
+		},
+		Collections: map[string]string{CollectionDocuments: "large", "legacy": embeddings.DefaultModel.Name},
+	}
+	require.NoError(t, config.Validate())
+
+	model, err = config.ForCollection(CollectionChat)
+	require.NoError(t, err)
+	assert.Equal(t, "small", model.Name)
+	assert.Equal(t, 384, model.Dimensions)
+
+	model, err = config.ForCollection(CollectionDocuments)
+	require.NoError(t, err)
+	assert.Equal(t, "large", model.Name)
+	assert.Equal(t, "openai:text-embedding-3-large", model.CollectionModel().Model)
+
+	model, err = config.ForCollection("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, embeddings.DefaultModel, model)
+
+	// A single model is the default even when not named as such.
+	single := EmbeddingsConfig{Models: map[string]embeddings.ModelConfig{"only": {Model: "org/only", Dimensions: 8}}}
+	model, err = single.ForCollection(CollectionChat)
+	require.NoError(t, err)
+	assert.Equal(t, "only", model.Name)
+
+	config.Default = ""
+	_, err = config.ForCollection(CollectionChat)
+	assert.Error(t, err)
+
+	config.Collections[CollectionChat] = "missing"
+	assert.Error(t, config.Validate())
+
+	invalid := EmbeddingsConfig{Models: map[string]embeddings.ModelConfig{"bad": {Model: "org/bad"}}}
+	assert.Error(t, invalid.Validate())
+}
+
+func TestReembedCollection(t *testing.T) {
+	collections, err := vecstore.OpenCollections(t.TempDir(), vecstore.Options{})
+	require.NoError(t, err)
+	defer collections.Close()
+
+	// A collection from before models were recorded, with vectors of another size.
+	legacy, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Put(
+		vecstore.Record{ID: "a", Text: "short", Vector: []float32{1, 0}, Metadata: map[string]any{"source": SourceChat}},
+		vecstore.Record{ID: "b", Text: "a longer memory", Vector: []float32{0, 1}},
+	))
+	model := vecstore.CollectionModel{Name: "small", Model: "small-v1", Dimensions: 3}
+	assert.ErrorIs(t, collections.SetModel(CollectionChat, model), vecstore.ErrModelMismatch)
+
+	var progress []jobs.Progress
+	count, err := reembedCollection(context.Background(), collections, CollectionChat, lengthEmbedder{}, model, func(p jobs.Progress) {
+		progress = append(progress, p)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, jobs.Progress{Step: "embed", Done: 2, Total: 2}, progress[len(progress)-1])
+
+	recorded, ok, err := collections.Model(CollectionChat)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, model, recorded)
+
+	store, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	record, ok, err := store.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []float32{5, 1, 0}, record.Vector)
+	assert.Equal(t, SourceChat, record.Metadata["source"])
+
+	// A canceled job leaves the collection as it was.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = reembedCollection(ctx, collections, CollectionChat, lengthEmbedder{}, model, func(jobs.Progress) {})
+	assert.Error(t, err)
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestCollectionIngesting(t *testing.T) {
+	doc := Document{ID: "ingesting-1", Name: "notes.md", Collection: "notes", Status: jobs.StateRunning}
+	require.NoError(t, SaveDocument(db, &doc))
+	defer DeleteDocument(db, doc.ID)
+
+	busy, err := collectionIngesting(db, "notes")
+	require.NoError(t, err)
+	assert.True(t, busy)
+	busy, err = collectionIngesting(db, "chat")
+	require.NoError(t, err)
+	assert.False(t, busy)
+
+	require.NoError(t, UpdateDocumentStatus(db, doc.ID, jobs.StateCompleted, ""))
+	busy, err = collectionIngesting(db, "notes")
+	require.NoError(t, err)
+	assert.False(t, busy)
+}
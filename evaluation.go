@@ -0,0 +1,409 @@
+// evaluation.go - Offline evaluation of retrieval quality
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"eternal/pkg/embeddings"
+	"eternal/pkg/retrieval"
+	"eternal/pkg/vecstore"
+)
+
+// Retrievers an evaluation runs.
+const (
+	EvalHybrid  = "hybrid"
+	EvalLexical = "lexical"
+	EvalDense   = "dense"
+)
+
+const defaultEvalK = 10
+
+// EvalCase is a labeled query of an evaluation dataset. Relevant documents are given by
+// their ID, their name as uploaded, or their URL, so a dataset can be run against
+// collections that hold the same documents under different IDs.
+type EvalCase struct {
+	ID       string   `json:"id,omitempty"`
+	Query    string   `json:"query"`
+	Relevant []string `json:"relevant"`
+}
+
+// EvalOptions select what an evaluation runs against.
+type EvalOptions struct {
+	Name       string `json:"name"`       // Label of the run
+	Dataset    string `json:"dataset"`    // Name of the dataset
+	Collection string `json:"collection"` // Vector collection, CollectionDocuments by default
+	Mode       string `json:"mode"`       // EvalHybrid (default), EvalLexical or EvalDense
+	K          int    `json:"k"`          // Chunks retrieved per query, 10 by default
+	Scope      string `json:"scope"`      // Knowledge base to search, all when empty
+	Rerank     bool   `json:"rerank"`     // Rerank with the configured cross-encoder
+}
+
+// withDefaults fills in the collection, mode and cutoff.
+func (o EvalOptions) withDefaults() EvalOptions {
+	if o.Collection == "" {
+		o.Collection = CollectionDocuments
+	}
+	if o.Mode == "" {
+		o.Mode = EvalHybrid
+	}
+	if o.K <= 0 {
+		o.K = defaultEvalK
+	}
+	return o
+}
+
+// Validate checks the mode and cutoff.
+func (o EvalOptions) Validate() error {
+	switch o.Mode {
+	case "", EvalHybrid, EvalLexical, EvalDense:
+	default:
+		return fmt.Errorf("unknown evaluation mode %q", o.Mode)
+	}
+	if o.K < 0 {
+		return fmt.Errorf("k cannot be negative")
+	}
+	if o.Collection != "" && !vecstore.ValidCollectionName(o.Collection) {
+		return fmt.Errorf("invalid collection name %q", o.Collection)
+	}
+	return nil
+}
+
+// readEvalDataset reads labeled queries from a JSON array or from JSON lines.
+func readEvalDataset(r io.Reader) ([]EvalCase, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []EvalCase
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &cases); err != nil {
+			return nil, fmt.Errorf("invalid dataset: %v", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var c EvalCase
+			if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+				return nil, fmt.Errorf("invalid dataset line %d: %v", line, err)
+			}
+			cases = append(cases, c)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateEvalCases(cases); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// validateEvalCases checks that every case has a query and a relevant document.
+func validateEvalCases(cases []EvalCase) error {
+	if len(cases) == 0 {
+		return fmt.Errorf("the dataset has no queries")
+	}
+	for i, c := range cases {
+		if strings.TrimSpace(c.Query) == "" {
+			return fmt.Errorf("query %d is empty", i+1)
+		}
+		if len(c.Relevant) == 0 {
+			return fmt.Errorf("query %d has no relevant documents", i+1)
+		}
+	}
+	return nil
+}
+
+// runRetrievalEval runs the labeled queries against a collection and stores the run. The
+// documents of the top K chunks are ranked by their first chunk and scored against the
+// relevant ones. Only local models are used, so an evaluation runs offline and its scores do
+// not depend on a remote service.
+func runRetrievalEval(ctx context.Context, config *AppConfig, cases []EvalCase, opts EvalOptions) (EvalRun, error) {
+	if err := opts.Validate(); err != nil {
+		return EvalRun{}, err
+	}
+	opts = opts.withDefaults()
+	if err := validateEvalCases(cases); err != nil {
+		return EvalRun{}, err
+	}
+
+	service, settings, err := evalService(config, opts)
+	if err != nil {
+		return EvalRun{}, err
+	}
+	var rerank *retrieval.RerankOptions
+	if opts.Rerank {
+		if rerank = rerankOptions(config); rerank == nil {
+			return EvalRun{}, fmt.Errorf("could not load the reranker")
+		}
+	}
+
+	run := EvalRun{
+		Name:       opts.Name,
+		Dataset:    opts.Dataset,
+		Collection: opts.Collection,
+		Mode:       opts.Mode,
+		K:          opts.K,
+		Scope:      opts.Scope,
+		Queries:    len(cases),
+		Settings:   settings,
+	}
+
+	start := time.Now()
+	var metrics []retrieval.Metrics
+	for _, c := range cases {
+		if err := ctx.Err(); err != nil {
+			return EvalRun{}, err
+		}
+
+		results, err := service.Search(ctx, retrieval.Query{Text: c.Query, TopN: opts.K, Rerank: rerank})
+		if err != nil {
+			return EvalRun{}, fmt.Errorf("error retrieving %q: %v", c.Query, err)
+		}
+
+		relevant := make(map[string]bool, len(c.Relevant))
+		for _, r := range c.Relevant {
+			relevant[r] = true
+		}
+		ranked := rankEvalDocuments(results, relevant)
+		m := retrieval.Evaluate(ranked, relevant, opts.K)
+		metrics = append(metrics, m)
+
+		run.Results = append(run.Results, EvalResult{ID: c.ID, Query: c.Query, Relevant: c.Relevant, Retrieved: ranked, Metrics: m})
+	}
+	run.Metrics = retrieval.Mean(metrics)
+	run.DurationMS = time.Since(start).Milliseconds()
+
+	if err := CreateEvalRun(sqliteDB.db, &run); err != nil {
+		return EvalRun{}, err
+	}
+	return run, nil
+}
+
+// evalService returns the retrieval service of an evaluation and the settings it runs with.
+func evalService(config *AppConfig, opts EvalOptions) (*retrieval.Service, EvalSettings, error) {
+	names, err := vectorStores.Names()
+	if err != nil {
+		return nil, EvalSettings{}, err
+	}
+	if !slices.Contains(names, opts.Collection) {
+		return nil, EvalSettings{}, fmt.Errorf("collection %s does not exist", opts.Collection)
+	}
+	store, err := vectorStores.Get(opts.Collection)
+	if err != nil {
+		return nil, EvalSettings{}, err
+	}
+
+	fusion := config.Retrieval.ForCollection(opts.Collection)
+	settings := EvalSettings{Retrieval: fusion, Chunking: config.Chunking}
+	if opts.Rerank {
+		if config.Retrieval.Rerank.Provider != RerankCrossEncoder {
+			return nil, EvalSettings{}, fmt.Errorf("evaluation reranks with a local cross-encoder only")
+		}
+		settings.Rerank = config.Retrieval.Rerank.Model
+	}
+
+	var retrievers []retrieval.Retriever
+	if opts.Mode != EvalDense {
+		index, err := searchIndexes.Get(opts.Collection)
+		if err != nil {
+			return nil, EvalSettings{}, err
+		}
+		retrievers = append(retrievers, collectionLexicalRetriever{
+			lexical: lexicalRetriever{index: index, scope: opts.Scope},
+			store:   store,
+		})
+	}
+
+	if opts.Mode != EvalLexical {
+		model, err := config.Embeddings.ForCollection(opts.Collection)
+		if err != nil {
+			return nil, EvalSettings{}, err
+		}
+		if model.Provider == embeddings.ProviderOpenAI {
+			return nil, EvalSettings{}, fmt.Errorf("collection %s is embedded with the remote model %s; evaluation runs offline", opts.Collection, model.Name)
+		}
+		embedder, err := collectionEmbedder(config, opts.Collection)
+		if err != nil {
+			return nil, EvalSettings{}, err
+		}
+		settings.EmbeddingModel = model.Name
+
+		var filter []vecstore.Condition
+		if opts.Scope != "" {
+			filter = append(filter, vecstore.Eq("knowledge_base", opts.Scope))
+		}
+		retrievers = append(retrievers, denseRetriever{collections: []denseCollection{{store: store, embedder: embedder}}, filter: filter})
+	}
+
+	service, err := retrieval.New(fusion, retrievers...)
+	if err != nil {
+		return nil, EvalSettings{}, fmt.Errorf("invalid retrieval config: %v", err)
+	}
+	return service, settings, nil
+}
+
+// collectionLexicalRetriever limits keyword search to the chunks of a vector collection.
+// The keyword index of a collection holds its chunks under the same IDs, but the chat index
+// also holds turns and web pages that were never embedded.
+type collectionLexicalRetriever struct {
+	lexical lexicalRetriever
+	store   *vecstore.Store
+}
+
+func (r collectionLexicalRetriever) Name() string { return retrieval.Lexical }
+
+// Retrieve returns the hits in the collection, with the metadata stored with their vectors
+// so they are labeled like dense hits. Hits that were not embedded take up candidates, so
+// more are requested than are returned.
+func (r collectionLexicalRetriever) Retrieve(ctx context.Context, text string, topN int) ([]retrieval.Hit, error) {
+	hits, err := r.lexical.Retrieve(ctx, text, topN*3)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []retrieval.Hit
+	for _, hit := range hits {
+		record, ok, err := r.store.Get(hit.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		hit.Metadata = record.Metadata
+		kept = append(kept, hit)
+		if len(kept) == topN {
+			break
+		}
+	}
+	return kept, nil
+}
+
+// rankEvalDocuments returns the documents of the results in order of their first chunk. A
+// document the dataset names is labeled with that name, so it matches the relevant set;
+// others are labeled with their document ID.
+func rankEvalDocuments(results []retrieval.Result, relevant map[string]bool) []string {
+	var ranked []string
+	seen := make(map[string]bool)
+	for _, res := range results {
+		label := metadataString(res.Metadata, "document_id")
+		if label == "" {
+			label = res.ID
+		}
+		for _, key := range []string{label, res.ID, metadataString(res.Metadata, "title"), metadataString(res.Metadata, "url")} {
+			if key != "" && relevant[key] {
+				label = key
+				break
+			}
+		}
+
+		if !seen[label] {
+			seen[label] = true
+			ranked = append(ranked, label)
+		}
+	}
+	return ranked
+}
+
+// runEvalCommand runs the eval subcommand: eternal eval retrieval -dataset queries.jsonl.
+// It opens the stores of the data path without starting the server, so it can run while the
+// server is stopped.
+func runEvalCommand(config *AppConfig, args []string) error {
+	if len(args) == 0 || args[0] != "retrieval" {
+		return fmt.Errorf("usage: eternal eval retrieval -dataset <file> [-collection name] [-mode hybrid|lexical|dense] [-k 10]")
+	}
+
+	fs := flag.NewFlagSet("eval retrieval", flag.ContinueOnError)
+	dataset := fs.String("dataset", "", "JSON or JSON lines file of queries and their relevant documents")
+	var opts EvalOptions
+	fs.StringVar(&opts.Name, "name", "", "Label of the run")
+	fs.StringVar(&opts.Collection, "collection", CollectionDocuments, "Vector collection to search")
+	fs.StringVar(&opts.Mode, "mode", EvalHybrid, "Retrievers to run: hybrid, lexical or dense")
+	fs.IntVar(&opts.K, "k", defaultEvalK, "Chunks retrieved per query")
+	fs.StringVar(&opts.Scope, "scope", "", "Knowledge base to search")
+	fs.BoolVar(&opts.Rerank, "rerank", false, "Rerank with the configured cross-encoder")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *dataset == "" {
+		return fmt.Errorf("-dataset is required")
+	}
+
+	file, err := os.Open(*dataset)
+	if err != nil {
+		return err
+	}
+	cases, err := readEvalDataset(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	opts.Dataset = filepath.Base(*dataset)
+
+	if err := createDataDirectory(config.DataPath); err != nil {
+		return err
+	}
+	if err := initializeDatabase(config.DataPath); err != nil {
+		return err
+	}
+	if err := initializeSearchIndex(config.DataPath); err != nil {
+		return err
+	}
+	defer searchIndexes.Close()
+	if err := initializeVectorStore(config); err != nil {
+		return err
+	}
+	defer func() {
+		if err := errors.Join(vectorStores.Close(), closeEmbedders()); err != nil {
+			pterm.Error.Println("Error closing stores:", err)
+		}
+	}()
+
+	run, err := runRetrievalEval(context.Background(), config, cases, opts)
+	if err != nil {
+		return err
+	}
+	printEvalRun(run)
+	return nil
+}
+
+// printEvalRun prints the metrics of a run and the queries that missed a relevant document.
+func printEvalRun(run EvalRun) {
+	pterm.DefaultTable.WithHasHeader().WithData(pterm.TableData{
+		{"Run", "Collection", "Mode", "Queries", fmt.Sprintf("Recall@%d", run.K), "MRR", fmt.Sprintf("nDCG@%d", run.K)},
+		{fmt.Sprint(run.ID), run.Collection, run.Mode, fmt.Sprint(run.Queries), fmt.Sprintf("%.3f", run.Recall), fmt.Sprintf("%.3f", run.MRR), fmt.Sprintf("%.3f", run.NDCG)},
+	}).Render()
+
+	misses := pterm.TableData{{"Query", "Recall", "Retrieved"}}
+	for _, res := range run.Results {
+		if res.Recall < 1 {
+			misses = append(misses, []string{res.Query, fmt.Sprintf("%.2f", res.Recall), strings.Join(res.Retrieved, ", ")})
+		}
+	}
+	if len(misses) > 1 {
+		pterm.Info.Println("Queries that missed relevant documents:")
+		pterm.DefaultTable.WithHasHeader().WithData(misses).Render()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/retrieval"
+	"eternal/pkg/vecstore"
+)
+
+func TestReadEvalDataset(t *testing.T) {
+	lines := `{"query": "install on linux", "relevant": ["install.md"]}
+
+{"id": "q2", "query": "config file", "relevant": ["config.md", "https://example.com/config"]}
+`
+	cases, err := readEvalDataset(strings.NewReader(lines))
+	require.NoError(t, err)
+	require.Len(t, cases, 2)
+	assert.Equal(t, "q2", cases[1].ID)
+	assert.Equal(t, []string{"config.md", "https://example.com/config"}, cases[1].Relevant)
+
+	cases, err = readEvalDataset(strings.NewReader(` [{"query": "install", "relevant": ["install.md"]}]`))
+	require.NoError(t, err)
+	assert.Len(t, cases, 1)
+
+	_, err = readEvalDataset(strings.NewReader(`{"query": "install", "relevant": []}`))
+	assert.ErrorContains(t, err, "query 1 has no relevant documents")
+	_, err = readEvalDataset(strings.NewReader("{\"query\": \"a\", \"relevant\": [\"b\"]}\nnot json"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = readEvalDataset(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestRankEvalDocuments(t *testing.T) {
+	results := []retrieval.Result{
+		{ID: "c1", Metadata: map[string]any{"document_id": "d1", "title": "install.md"}},
+		{ID: "c2", Metadata: map[string]any{"document_id": "d2", "title": "config.md"}},
+		{ID: "c3", Metadata: map[string]any{"document_id": "d1", "title": "install.md"}},
+		{ID: "turn-1-0"},
+	}
+	ranked := rankEvalDocuments(results, map[string]bool{"install.md": true})
+	assert.Equal(t, []string{"install.md", "d2", "turn-1-0"}, ranked)
+}
+
+func TestRunRetrievalEval(t *testing.T) {
+	collections := useTestMemoryStores(t, time.Now())
+	withTestDB(t)
+
+	store, err := collections.Get("evaldocs")
+	require.NoError(t, err)
+	chunks := []ChatTurnMessage{
+		{ID: "c1", DocumentID: "d1", Prompt: "install.md", Response: "Install the binary on Linux with the package manager.", Source: SourceDocument},
+		{ID: "c2", DocumentID: "d2", Prompt: "config.md", Response: "The config file sets the data path.", Source: SourceDocument},
+		// Chunks of other collections are not searched.
+		{ID: "other", DocumentID: "d3", Prompt: "old.md", Response: "Install notes from another collection.", Source: SourceDocument},
+	}
+	for i, chunk := range chunks {
+		name := "evaldocs"
+		if chunk.ID == "other" {
+			name = CollectionDocuments
+		}
+		index, err := searchIndexes.Get(name)
+		require.NoError(t, err)
+		require.NoError(t, index.Index(chunk.ID, chunk))
+		if chunk.ID == "other" {
+			continue
+		}
+		require.NoError(t, store.Put(vecstore.Record{
+			ID:       chunk.ID,
+			Text:     chunk.Response,
+			Vector:   []float32{float32(i), 1},
+			Metadata: map[string]any{"document_id": chunk.DocumentID, "title": chunk.Prompt},
+		}))
+	}
+
+	cases := []EvalCase{
+		{Query: "install linux", Relevant: []string{"install.md"}},
+		{Query: "data path", Relevant: []string{"d2", "missing.md"}},
+	}
+	run, err := runRetrievalEval(context.Background(), &AppConfig{}, cases, EvalOptions{Name: "baseline", Collection: "evaldocs", Mode: EvalLexical, K: 5})
+	require.NoError(t, err)
+	assert.NotZero(t, run.ID)
+	assert.Equal(t, 2, run.Queries)
+	require.Len(t, run.Results, 2)
+	assert.Equal(t, "install.md", run.Results[0].Retrieved[0])
+	assert.NotContains(t, run.Results[0].Retrieved, "d3")
+	assert.Equal(t, retrieval.Metrics{Recall: 1, MRR: 1, NDCG: 1}, run.Results[0].Metrics)
+	assert.Equal(t, 0.5, run.Results[1].Recall)
+	assert.Equal(t, 0.75, run.Recall)
+
+	runs, err := ListEvalRuns(db, "evaldocs")
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Empty(t, runs[0].Results, "lists leave out per-query results")
+	assert.Equal(t, 0.75, runs[0].Recall)
+
+	stored, err := GetEvalRun(db, run.ID)
+	require.NoError(t, err)
+	assert.Len(t, stored.Results, 2)
+	assert.NoError(t, DeleteEvalRun(db, run.ID))
+
+	_, err = runRetrievalEval(context.Background(), &AppConfig{}, cases, EvalOptions{Collection: "missing"})
+	assert.ErrorContains(t, err, "does not exist")
+	_, err = runRetrievalEval(context.Background(), &AppConfig{}, cases, EvalOptions{Mode: "sparse"})
+	assert.ErrorContains(t, err, "unknown evaluation mode")
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -10,18 +9,15 @@ import (
 
 	embeddings "eternal/pkg/embeddings"
 	store "eternal/pkg/vecstore"
-
-	"github.com/nlpodyssey/cybertron/pkg/models/bert"
-	"github.com/nlpodyssey/cybertron/pkg/tasks"
-	"github.com/nlpodyssey/cybertron/pkg/tasks/textencoding"
 )
 
 // Chunk size should be less than the max tokens for the model used: https://huggingface.co/spaces/mteb/leaderboard
 
 var (
 	modelPathFlag = flag.String("model-path", ".eternal/models/HF/", "The path to the model directory")
-	modelNameFlag = flag.String("model-name", "avsolatorio/GIST-small-Embedding-v0", "The name of the model")
-	limitFlag     = flag.Int("limit", 128, "The limit for the number of dimensions in the embedding vector")
+	modelNameFlag = flag.String("model-name", embeddings.DefaultModel.Model, "The name of the model")
+	dimensionFlag = flag.Int("dimensions", embeddings.DefaultModel.Dimensions, "The number of dimensions of the model's embedding vectors")
+	poolingFlag   = flag.String("pooling", embeddings.DefaultModel.Pooling, "The pooling strategy of the model: mean, cls or max")
 
 	generateCommand = flag.NewFlagSet("generate", flag.ExitOnError)
 	inputFileFlag   = generateCommand.String("input-file", "", "The input file to generate embeddings for")
@@ -52,8 +48,27 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Set the path to the model
-	model := fmt.Sprintf("%s/%s/%s", usr.HomeDir, *modelPathFlag, *modelNameFlag)
+	// Load the model from the models directory
+	model, err := embeddings.LoadEncoder(fmt.Sprintf("%s/%s", usr.HomeDir, *modelPathFlag), embeddings.ModelConfig{
+		Name:       *modelNameFlag,
+		Model:      *modelNameFlag,
+		Dimensions: *dimensionFlag,
+		Pooling:    *poolingFlag,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open the vector store, moving embeddings from an older embeddings.db over if present
+	db, err := store.Open("./vectors")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := store.MigrateJSON(db, "./embeddings.db"); err != nil {
+		log.Fatal(err)
+	}
 
 	switch command {
 	case "generate":
@@ -84,7 +99,7 @@ func main() {
 				document += string(buf[:n])
 			}
 
-			embeddings.GenerateEmbeddingForTask("qa", document, "txt", *chunkSize, *overlapSize, ".")
+			embeddings.GenerateEmbeddingForTask(db, model, "qa", document, "txt", *chunkSize, *overlapSize, map[string]any{"document_id": *inputFileFlag})
 		}
 	case "retrieve":
 		retrieveCommand.Parse(flag.Args()[1:])
@@ -93,51 +108,13 @@ func main() {
 				fmt.Println("Usage: main.go retrieve --prompt <prompt>")
 				return
 			}
-			topEmbeddings := Search(model, *promptFlag, *topNFlag)
+			topEmbeddings := embeddings.Search(db, model, *promptFlag, *topNFlag)
 			fmt.Println("Top", *topNFlag, "similar words or chunks for the given prompt are:")
 			for _, embedding := range topEmbeddings {
-				fmt.Println(embedding.Word, "-", embedding.Similarity)
+				fmt.Println(embedding.Text, "-", embedding.Score)
 			}
 		}
 	default:
 		fmt.Println("Invalid command. Available commands: generate, retrieve")
 	}
 }
-
-func Search(modelPath string, prompt string, topN int) []store.Embedding {
-	db := store.NewEmbeddingDB()
-	embeddings, err := db.LoadEmbeddings("./embeddings.db")
-	if err != nil {
-		fmt.Println("Error loading embeddings:", err)
-		return nil
-	}
-
-	model, err := tasks.Load[textencoding.Interface](&tasks.Config{ModelsDir: modelPath, ModelName: *modelNameFlag})
-	if err != nil {
-		fmt.Println("Error loading model:", err)
-		return nil
-	}
-
-	var vec []float64
-	result, err := model.Encode(context.Background(), prompt, int(bert.MeanPooling))
-	if err != nil {
-		fmt.Println("Error encoding text:", err)
-		return nil
-	}
-	vec = result.Vector.Data().F64()[:*limitFlag]
-
-	embeddingForPrompt := store.Embedding{
-		Word:       prompt,
-		Vector:     vec,
-		Similarity: 0.0,
-	}
-
-	// Retrieve the top N similar embeddings
-	topEmbeddings := store.FindTopNSimilarEmbeddings(embeddingForPrompt, embeddings, topN)
-	if len(topEmbeddings) == 0 {
-		fmt.Println("Error finding similar embeddings.")
-		return nil
-	}
-
-	return topEmbeddings
-}
@@ -0,0 +1,266 @@
+// gitknowledge.go - Git repositories as knowledge bases
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"eternal/pkg/documents"
+	"eternal/pkg/ingest"
+	"eternal/pkg/jobs"
+	"eternal/pkg/vecstore"
+)
+
+// gitIndexJobType is the job type of Git repository indexing.
+const gitIndexJobType = "git-index"
+
+// maxGitFileSize is the size of the largest file indexed from a repository. Larger files
+// are usually generated or vendored.
+const maxGitFileSize = 1 << 20
+
+// GitIndexResult summarizes a run of the Git indexer.
+type GitIndexResult struct {
+	Commit  string `json:"commit"`
+	Full    bool   `json:"full"` // Whether every file was indexed rather than only changed files
+	Indexed int    `json:"indexed"`
+	Deleted int    `json:"deleted"`
+	Skipped int    `json:"skipped"` // Binary, empty or oversized files
+}
+
+// gitIndexJobID returns the job ID used to index a repository.
+func gitIndexJobID(repoID int64) string {
+	return fmt.Sprintf("%s-%d", gitIndexJobType, repoID)
+}
+
+// gitDocumentPrefix returns the prefix of the document IDs of a repository's files.
+func gitDocumentPrefix(repoID int64) string {
+	return fmt.Sprintf("git-%d-", repoID)
+}
+
+// gitDocumentID returns the document ID of a file in a repository.
+func gitDocumentID(repoID int64, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return gitDocumentPrefix(repoID) + hex.EncodeToString(sum[:8])
+}
+
+// gitCloneDir returns where a repository registered by URL is cloned.
+func gitCloneDir(config *AppConfig, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(config.DataPath, "repos", hex.EncodeToString(sum[:8]))
+}
+
+// gitFileURL links to a file on the web page of a repository hosted over HTTPS, which works
+// for GitHub, GitLab and Gitea. Other repositories have no link.
+func gitFileURL(repoURL string, commit string, path string) string {
+	if !strings.HasPrefix(repoURL, "https://") {
+		return ""
+	}
+	return fmt.Sprintf("%s/blob/%s/%s", strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git"), commit, path)
+}
+
+// isSSHURL reports whether a clone URL uses SSH, either as ssh:// or in the scp-like
+// user@host:path form.
+func isSSHURL(url string) bool {
+	if strings.HasPrefix(url, "ssh://") {
+		return true
+	}
+	return !strings.Contains(url, "://") && strings.Contains(url, "@") && strings.Contains(url, ":")
+}
+
+// gitLoader returns the loader of a repository with its include and exclude globs.
+func gitLoader(repo GitRepository) (*documents.GitLoader, error) {
+	filter, err := documents.GlobFilter(repo.Include, repo.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &documents.GitLoader{RepoPath: repo.Path, CloneURL: repo.URL, Branch: repo.Branch, PrivateKeyPath: repo.KeyPath, FileFilter: filter}, nil
+}
+
+// isBinary reports whether file content looks binary rather than text.
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// submitGitIndex queues a job that indexes the files of a repository changed since its last
+// indexed commit, and records the outcome on the repository.
+func submitGitIndex(config *AppConfig, queue *jobs.Queue, repo GitRepository) error {
+	job := &jobs.Job{
+		ID:    gitIndexJobID(repo.ID),
+		Type:  gitIndexJobType,
+		Label: repo.Name,
+		Run: func(ctx context.Context, report func(jobs.Progress)) (any, error) {
+			UpdateGitRepositoryStatus(sqliteDB.db, repo.ID, jobs.StateRunning, "")
+
+			// Read the repository again, since it may have been indexed since it was queued.
+			current, err := GetGitRepository(sqliteDB.db, repo.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := indexGitRepository(ctx, config, &current, report)
+			if err != nil {
+				state := jobs.StateFailed
+				if ctx.Err() != nil {
+					state = jobs.StateCanceled
+				}
+				UpdateGitRepositoryStatus(sqliteDB.db, repo.ID, state, err.Error())
+				return nil, err
+			}
+
+			current.Status = jobs.StateCompleted
+			current.Error = ""
+			if err := UpdateGitRepository(sqliteDB.db, &current); err != nil {
+				return nil, err
+			}
+			return result, nil
+		},
+	}
+
+	// Record the queued state first, since a worker can start the job as soon as it is
+	// submitted.
+	if err := UpdateGitRepositoryStatus(sqliteDB.db, repo.ID, jobs.StateQueued, ""); err != nil {
+		return err
+	}
+	if err := queue.Submit(job); err != nil {
+		UpdateGitRepositoryStatus(sqliteDB.db, repo.ID, jobs.StateFailed, err.Error())
+		return err
+	}
+	return nil
+}
+
+// indexGitRepository brings the chunks of a repository up to date with its branch. Only the
+// files changed since the last indexed commit are embedded again; every file is indexed
+// when the repository has not been indexed before or that commit no longer exists.
+func indexGitRepository(ctx context.Context, config *AppConfig, repo *GitRepository, report func(jobs.Progress)) (GitIndexResult, error) {
+	var result GitIndexResult
+
+	loader, err := gitLoader(*repo)
+	if err != nil {
+		return result, err
+	}
+
+	report(jobs.Progress{Step: "fetch", Message: repo.Name})
+	r, err := loader.Open()
+	if err != nil {
+		return result, fmt.Errorf("error opening repository: %v", err)
+	}
+	if err := loader.Pull(r); err != nil {
+		return result, fmt.Errorf("error pulling repository: %v", err)
+	}
+
+	head, err := loader.Commit(r)
+	if err != nil {
+		return result, err
+	}
+	result.Commit = head.Hash.String()
+
+	files, err := loader.Files(head)
+	if err != nil {
+		return result, err
+	}
+
+	pipeline, err := newIngestPipeline(config, CollectionDocuments)
+	if err != nil {
+		return result, err
+	}
+
+	var changed, deleted []string
+	result.Full = true
+	if repo.LastCommit != "" {
+		if last, err := r.CommitObject(plumbing.NewHash(repo.LastCommit)); err == nil {
+			changed, deleted, err = loader.Changes(last, head)
+			if err != nil {
+				return result, err
+			}
+			result.Full = false
+		}
+	}
+	if result.Full {
+		// The files indexed before are not known, so remove every chunk of the repository.
+		if err := deleteGitChunks(*repo); err != nil {
+			return result, err
+		}
+		changed = files
+	}
+
+	for _, path := range deleted {
+		if err := pipeline.Delete(gitDocumentID(repo.ID, path)); err != nil {
+			return result, err
+		}
+		result.Deleted++
+	}
+
+	for i, path := range changed {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		report(jobs.Progress{Step: ingest.StepIndex, Done: i, Total: len(changed), Message: path})
+
+		docID := gitDocumentID(repo.ID, path)
+		content, err := documents.ReadFile(head, path)
+		if err != nil {
+			return result, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		if len(content) > maxGitFileSize || isBinary(content) || len(bytes.TrimSpace(content)) == 0 {
+			// Remove chunks from when the file was indexable.
+			if err := pipeline.Delete(docID); err != nil {
+				return result, err
+			}
+			result.Skipped++
+			continue
+		}
+
+		_, err = pipeline.IngestText(ctx, ingest.Source{
+			DocumentID: docID,
+			Name:       path,
+			Metadata: map[string]any{
+				"source":         SourceGit,
+				"knowledge_base": repo.Name,
+				"repository":     repo.Name,
+				"path":           path,
+				"commit":         result.Commit,
+				"url":            gitFileURL(repo.URL, result.Commit, path),
+			},
+		}, string(content), nil)
+		if err != nil {
+			return result, fmt.Errorf("error indexing %s: %v", path, err)
+		}
+		result.Indexed++
+	}
+	report(jobs.Progress{Step: ingest.StepIndex, Done: len(changed), Total: len(changed)})
+
+	repo.LastCommit = result.Commit
+	repo.Files = len(files)
+	return result, nil
+}
+
+// deleteGitChunks removes every chunk of a repository from the indexes.
+func deleteGitChunks(repo GitRepository) error {
+	store, err := vectorStores.Get(CollectionDocuments)
+	if err != nil {
+		return err
+	}
+	if _, err := store.DeleteMatching(vecstore.Eq("repository", repo.Name)); err != nil {
+		return err
+	}
+	if err := DeleteDocumentSectionsPrefix(sqliteDB.db, gitDocumentPrefix(repo.ID)); err != nil {
+		return err
+	}
+	index, err := searchIndexes.Get(CollectionDocuments)
+	if err != nil {
+		return err
+	}
+	return bleveDocumentIndex{index: index}.DeletePrefix(gitDocumentPrefix(repo.ID))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/jobs"
+	"eternal/pkg/retrieval"
+)
+
+func TestGitDocumentID(t *testing.T) {
+	id := gitDocumentID(3, "pkg/jobs/jobs.go")
+	assert.True(t, strings.HasPrefix(id, gitDocumentPrefix(3)))
+	assert.Equal(t, id, gitDocumentID(3, "pkg/jobs/jobs.go"))
+	assert.NotEqual(t, id, gitDocumentID(3, "pkg/jobs/jobs_test.go"))
+
+	// Repository 1 must not match the prefix of repository 12.
+	assert.False(t, strings.HasPrefix(gitDocumentID(12, "main.go"), gitDocumentPrefix(1)))
+}
+
+func TestGitFileURL(t *testing.T) {
+	assert.Equal(t, "https://github.com/acme/app/blob/abc123/cmd/main.go", gitFileURL("https://github.com/acme/app.git", "abc123", "cmd/main.go"))
+	assert.Equal(t, "https://gitlab.com/acme/app/blob/abc123/README.md", gitFileURL("https://gitlab.com/acme/app/", "abc123", "README.md"))
+	assert.Empty(t, gitFileURL("git@github.com:acme/app.git", "abc123", "main.go"))
+	assert.Empty(t, gitFileURL("", "abc123", "main.go"))
+}
+
+func TestIsSSHURL(t *testing.T) {
+	assert.True(t, isSSHURL("git@github.com:acme/app.git"))
+	assert.True(t, isSSHURL("ssh://git@example.com/acme/app.git"))
+	assert.False(t, isSSHURL("https://github.com/acme/app.git"))
+	assert.False(t, isSSHURL("https://user@example.com:8443/app.git"))
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, isBinary([]byte("package main\n")))
+	assert.True(t, isBinary([]byte("\x89PNG\r\n\x1a\n\x00\x00")))
+}
+
+func TestGitCitation(t *testing.T) {
+	c := newCitation(1, retrieval.Result{
+		Text:     "func main() {}",
+		Metadata: map[string]any{"source": SourceGit, "document_id": "git-1-abc", "title": "cmd/main.go", "url": "https://github.com/acme/app/blob/abc/cmd/main.go"},
+	})
+	assert.Equal(t, SourceGit, c.Source)
+	assert.Equal(t, "cmd/main.go", c.Label())
+	assert.Equal(t, "https://github.com/acme/app/blob/abc/cmd/main.go", c.Link())
+}
+
+func TestSubmitGitIndexQueueClosed(t *testing.T) {
+	withTestDB(t)
+	repo := GitRepository{Name: "submit-repo", Path: t.TempDir(), Status: jobs.StateCompleted}
+	require.NoError(t, db.Create(&repo).Error)
+	defer db.Delete(&GitRepository{}, repo.ID)
+
+	queue := jobs.NewQueue(1, 1)
+	queue.Close()
+	assert.ErrorIs(t, submitGitIndex(&AppConfig{}, queue, repo), jobs.ErrQueueClosed)
+
+	stored, err := GetGitRepository(db, repo.ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StateFailed, stored.Status)
+}
@@ -6,21 +6,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/nlpodyssey/cybertron/pkg/models/bert"
-	"github.com/nlpodyssey/cybertron/pkg/tasks"
-	"github.com/nlpodyssey/cybertron/pkg/tasks/textencoding"
-
-	"github.com/blevesearch/bleve/v2"
-	index "github.com/blevesearch/bleve_index_api"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/websocket/v2"
@@ -28,75 +24,823 @@ import (
 	"github.com/valyala/fasthttp"
 	"gorm.io/gorm"
 
+	"eternal/pkg/backup"
 	"eternal/pkg/documents"
-	"eternal/pkg/embeddings"
 	"eternal/pkg/hfutils"
+	"eternal/pkg/jobs"
 	"eternal/pkg/llm"
 	"eternal/pkg/llm/anthropic"
 	"eternal/pkg/llm/google"
 	"eternal/pkg/llm/openai"
+	"eternal/pkg/retrieval"
 	"eternal/pkg/sd"
 	"eternal/pkg/vecstore"
 	"eternal/pkg/web"
 )
 
-var assistantRole = "You are a helpful AI assistant that responds in well-structured markdown format. Do not repeat your instructions. Do not deviate from the topic."
-
+// ChatTurnMessage is a chat turn or document chunk stored in the Bleve index.
 type ChatTurnMessage struct {
-	ID       string `json:"id"`
-	Prompt   string `json:"prompt"`
-	Response string `json:"response"`
-	Model    string `json:"model"`
+	ID         string    `json:"id"`
+	SessionID  int64     `json:"session_id"`
+	TurnID     int64     `json:"turn_id"`
+	DocumentID string    `json:"document_id,omitempty"` // Set on chunks of ingested documents
+	Page       int       `json:"page,omitempty"`        // Page of a PDF chunk
+	URL        string    `json:"url,omitempty"`         // Link to the original of a document chunk
+	Prompt     string    `json:"prompt"`
+	Response   string    `json:"response"`
+	Model      string    `json:"model"`
+	Source     string    `json:"source"`
+	Tags       []string  `json:"tags"`
+	Headings   string    `json:"headings,omitempty"`  // Heading path of the section a chunk comes from
+	ParentID   string    `json:"parent_id,omitempty"` // Section returned in place of the chunk by parent retrieval
+	Pinned     bool      `json:"pinned,omitempty"`    // Pinned memories are never expired or decayed
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // handleListProjects retrieves and returns a list of projects from the database.
 func handleListProjects() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		projects, err := sqliteDB.ListProjects()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get projects"})
+		projects, err := sqliteDB.ListProjects()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get projects"})
+		}
+		return c.Status(fiber.StatusOK).JSON(projects)
+	}
+}
+
+// handleUpload saves uploaded files and queues a job to ingest each of them. Files are
+// ingested into the collection named by the "collection" form field, and tagged with the
+// optional "knowledge_base" field. Each collection keeps its uploads in its own directory,
+// so files of the same name in different collections do not replace each other.
+func handleUpload(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid upload"})
+		}
+
+		collection := c.FormValue("collection", CollectionDocuments)
+		if !vecstore.ValidCollectionName(collection) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid collection name"})
+		}
+		knowledgeBase := c.FormValue("knowledge_base")
+
+		uploadDir := filepath.Join(config.DataPath, "web", "uploads", collection)
+		if err := os.MkdirAll(uploadDir, 0755); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not create upload directory"})
+		}
+
+		docs := []Document{}
+		for _, file := range form.File["file"] {
+			name := filepath.Base(file.Filename)
+			id := documentID(collection, name)
+
+			if status, ok := ingestQueue.Status(ingestJobID(id)); ok && !status.Done() {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("%s is still being ingested", name)})
+			}
+
+			filename := filepath.Join(uploadDir, name)
+			if err := c.SaveFile(file, filename); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not save file"})
+			}
+			log.Infof("Uploaded file %s to %s", file.Filename, filename)
+
+			doc := Document{
+				ID:            id,
+				Name:          name,
+				Path:          filename,
+				Collection:    collection,
+				KnowledgeBase: knowledgeBase,
+				Status:        jobs.StateQueued,
+			}
+			if err := SaveDocument(sqliteDB.db, &doc); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not save document"})
+			}
+			if err := submitIngestion(config, ingestQueue, doc); err != nil {
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+			}
+			docs = append(docs, doc)
+		}
+
+		if len(docs) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no files uploaded"})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"documents": docs})
+	}
+}
+
+// handleListDocuments returns all uploaded documents.
+func handleListDocuments() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		docs, err := ListDocuments(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get documents"})
+		}
+		return c.Status(fiber.StatusOK).JSON(docs)
+	}
+}
+
+// handleGetDocument returns an uploaded document by its ID.
+func handleGetDocument() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		doc, err := GetDocument(sqliteDB.db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "document not found"})
+		}
+		return c.Status(fiber.StatusOK).JSON(doc)
+	}
+}
+
+// handleDocumentFile sends the uploaded file of a document.
+func handleDocumentFile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		doc, err := GetDocument(sqliteDB.db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "document not found"})
+		}
+		if _, err := os.Stat(doc.Path); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "document file not found"})
+		}
+		return c.SendFile(doc.Path)
+	}
+}
+
+// removeUpload deletes an uploaded file once no document is ingested from it. Documents
+// uploaded before uploads were kept per collection can share a file.
+func removeUpload(db *gorm.DB, path string) error {
+	count, err := CountDocumentsWithPath(db, path)
+	if err != nil || count > 0 {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// handleReingestDocument queues a job that ingests a document again from its uploaded file.
+func handleReingestDocument(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		doc, err := GetDocument(sqliteDB.db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "document not found"})
+		}
+		if status, ok := ingestQueue.Status(ingestJobID(doc.ID)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "document is still being ingested"})
+		}
+
+		if err := submitIngestion(config, ingestQueue, doc); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		doc.Status = jobs.StateQueued
+		doc.Error = ""
+		return c.Status(fiber.StatusAccepted).JSON(doc)
+	}
+}
+
+// handleDeleteDocument removes a document from the indexes, the database and the uploads
+// directory.
+func handleDeleteDocument(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		doc, err := GetDocument(sqliteDB.db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "document not found"})
+		}
+		if status, ok := ingestQueue.Status(ingestJobID(doc.ID)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "document is still being ingested"})
+		}
+
+		pipeline, err := newIngestPipeline(config, doc.Collection)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := pipeline.Delete(doc.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := DeleteDocument(sqliteDB.db, doc.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete document"})
+		}
+		if err := removeUpload(sqliteDB.db, doc.Path); err != nil {
+			log.Errorf("Error removing %s: %v", doc.Path, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleListGitRepositories returns the Git repositories registered as knowledge bases.
+func handleListGitRepositories() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		repos, err := ListGitRepositories(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get repositories"})
+		}
+		return c.Status(fiber.StatusOK).JSON(repos)
+	}
+}
+
+// handleGetGitRepository returns a Git repository by its ID.
+func handleGetGitRepository() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		repo, err := GetGitRepository(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "repository not found"})
+		}
+		return c.Status(fiber.StatusOK).JSON(repo)
+	}
+}
+
+// handleCreateGitRepository registers a Git repository, given by a clone URL or a path on
+// disk, as a knowledge base and queues a job that indexes it.
+func handleCreateGitRepository(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var repo GitRepository
+		if err := c.BodyParser(&repo); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid repository"})
+		}
+
+		repo.Name = strings.TrimSpace(repo.Name)
+		repo.URL = strings.TrimSpace(repo.URL)
+		repo.KeyPath = strings.TrimSpace(repo.KeyPath)
+		if repo.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+		}
+		if _, err := GetGitRepositoryByName(sqliteDB.db, repo.Name); err == nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "a repository with that name already exists"})
+		}
+		if _, err := documents.GlobFilter(repo.Include, repo.Exclude); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		switch {
+		case repo.URL != "":
+			if isSSHURL(repo.URL) {
+				if repo.KeyPath == "" {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "private_key_path is required for SSH URLs"})
+				}
+				if _, err := os.Stat(repo.KeyPath); err != nil {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "private key not found"})
+				}
+			}
+			repo.Path = gitCloneDir(config, repo.Name)
+		case repo.Path != "":
+			if _, err := os.Stat(filepath.Join(repo.Path, ".git")); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "path is not a Git repository"})
+			}
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "url or path is required"})
+		}
+
+		repo.ID = 0
+		repo.LastCommit = ""
+		repo.Files = 0
+		repo.Error = ""
+		repo.Status = jobs.StateQueued
+		if err := CreateGitRepository(sqliteDB.db, &repo); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not create repository"})
+		}
+		if err := submitGitIndex(config, ingestQueue, repo); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(repo)
+	}
+}
+
+// handleUpdateGitRepository changes the branch and globs of a Git repository. Since the
+// indexed files change with them, the repository is indexed again from scratch.
+func handleUpdateGitRepository(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		repo, err := GetGitRepository(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "repository not found"})
+		}
+		if status, ok := ingestQueue.Status(gitIndexJobID(repo.ID)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "repository is still being indexed"})
+		}
+
+		var update struct {
+			Branch  string   `json:"branch"`
+			Include []string `json:"include"`
+			Exclude []string `json:"exclude"`
+		}
+		if err := c.BodyParser(&update); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid repository"})
+		}
+		if _, err := documents.GlobFilter(update.Include, update.Exclude); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		repo.Branch = update.Branch
+		repo.Include = update.Include
+		repo.Exclude = update.Exclude
+		repo.LastCommit = ""
+		if err := UpdateGitRepository(sqliteDB.db, &repo); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not update repository"})
+		}
+		if err := submitGitIndex(config, ingestQueue, repo); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		repo.Status = jobs.StateQueued
+		return c.Status(fiber.StatusAccepted).JSON(repo)
+	}
+}
+
+// handleRefreshGitRepository queues a job that indexes the files of a Git repository changed
+// since its last indexed commit.
+func handleRefreshGitRepository(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		repo, err := GetGitRepository(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "repository not found"})
+		}
+		if status, ok := ingestQueue.Status(gitIndexJobID(repo.ID)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "repository is still being indexed"})
+		}
+
+		if err := submitGitIndex(config, ingestQueue, repo); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		repo.Status = jobs.StateQueued
+		repo.Error = ""
+		return c.Status(fiber.StatusAccepted).JSON(repo)
+	}
+}
+
+// handleDeleteGitRepository removes a Git repository's chunks from the indexes, and its
+// clone when Eternal cloned it.
+func handleDeleteGitRepository(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		repo, err := GetGitRepository(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "repository not found"})
+		}
+		if status, ok := ingestQueue.Status(gitIndexJobID(repo.ID)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "repository is still being indexed"})
+		}
+
+		if err := deleteGitChunks(repo); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := DeleteGitRepository(sqliteDB.db, repo.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete repository"})
+		}
+
+		// Never remove a repository that was registered by its path.
+		if repo.URL != "" && repo.Path == gitCloneDir(config, repo.Name) {
+			if err := os.RemoveAll(repo.Path); err != nil {
+				log.Errorf("Error removing clone %s: %v", repo.Path, err)
+			}
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleListMemory lists the memories of a store, the Bleve index by default or a vector
+// collection, optionally filtered by source and pin and searched by a query.
+func handleListMemory(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := MemoryQuery{
+			Store:  c.Query("store", MemoryIndex),
+			Query:  c.Query("q"),
+			Source: c.Query("source"),
+			Page:   c.QueryInt("page", 1),
+			Size:   c.QueryInt("size", defaultSearchPageSize),
+		}
+		if pinned := c.Query("pinned"); pinned != "" {
+			value, err := strconv.ParseBool(pinned)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid pinned value"})
+			}
+			q.Pinned = &value
+		}
+
+		page, err := listMemory(c.Context(), config, q)
+		if errors.Is(err, errMemoryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "store not found"})
+		}
+		if err != nil {
+			log.Errorf("Error listing memory: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not list memory"})
+		}
+		return c.Status(fiber.StatusOK).JSON(page)
+	}
+}
+
+// handleGetMemory returns a memory by store and ID.
+func handleGetMemory() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		entry, err := getMemory(c.Params("store"), c.Params("id"))
+		if errors.Is(err, errMemoryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "memory not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(entry)
+	}
+}
+
+// handlePinMemory pins or unpins a memory.
+func handlePinMemory(pinned bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		entry, err := pinMemory(c.Params("store"), c.Params("id"), pinned)
+		if errors.Is(err, errMemoryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "memory not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(entry)
+	}
+}
+
+// handleForgetMemory deletes a memory from the index and the vector collections.
+func handleForgetMemory() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		removal, err := forgetMemory(c.Params("store"), c.Params("id"))
+		if errors.Is(err, errMemoryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "memory not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(removal)
+	}
+}
+
+// handleForgetMemorySource deletes every memory of a source. Pinned memories are kept
+// unless include_pinned is set.
+func handleForgetMemorySource() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		removal, err := forgetMemorySource(c.Params("source"), c.QueryBool("include_pinned"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(removal)
+	}
+}
+
+// handleExpireMemory deletes expired memories without waiting for the hourly expiry.
+func handleExpireMemory() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		removal, err := expireMemories(time.Now())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(removal)
+	}
+}
+
+// handleListMemoryPolicies returns the TTL and decay policies of the memory sources.
+func handleListMemoryPolicies() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		policies, err := ListMemoryPolicies(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get memory policies"})
+		}
+		return c.Status(fiber.StatusOK).JSON(policies)
+	}
+}
+
+// handleSaveMemoryPolicy sets the TTL and decay policy of a memory source.
+func handleSaveMemoryPolicy() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var policy MemoryPolicy
+		if err := c.BodyParser(&policy); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		policy.Source = c.Params("source")
+		if _, _, err := policy.durations(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := SaveMemoryPolicy(sqliteDB.db, &policy); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not save memory policy"})
+		}
+		return c.Status(fiber.StatusOK).JSON(policy)
+	}
+}
+
+// handleDeleteMemoryPolicy removes the policy of a memory source, so its memories are kept
+// and never decay.
+func handleDeleteMemoryPolicy() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := DeleteMemoryPolicy(sqliteDB.db, c.Params("source"))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "memory policy not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete memory policy"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleRunRetrievalEval runs a labeled dataset against a collection and returns the stored
+// run with the metrics of every query.
+func handleRunRetrievalEval(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			EvalOptions
+			Cases []EvalCase `json:"cases"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := req.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := validateEvalCases(req.Cases); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		run, err := runRetrievalEval(c.Context(), config, req.Cases, req.EvalOptions)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusCreated).JSON(run)
+	}
+}
+
+// handleListEvalRuns returns the evaluation runs, newest first, optionally of one
+// collection.
+func handleListEvalRuns() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runs, err := ListEvalRuns(sqliteDB.db, c.Query("collection"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get evaluation runs"})
+		}
+		return c.Status(fiber.StatusOK).JSON(runs)
+	}
+}
+
+// handleGetEvalRun returns an evaluation run with the metrics of every query.
+func handleGetEvalRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		run, err := GetEvalRun(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "evaluation run not found"})
+		}
+		return c.Status(fiber.StatusOK).JSON(run)
+	}
+}
+
+// handleDeleteEvalRun deletes an evaluation run.
+func handleDeleteEvalRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+		err = DeleteEvalRun(sqliteDB.db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "evaluation run not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete evaluation run"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleListSearchIndexes returns the keyword index of each collection and its document count.
+func handleListSearchIndexes() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		infos, err := searchIndexes.List()
+		if err != nil {
+			log.Errorf("Error listing search indexes: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not list search indexes"})
+		}
+		return c.Status(fiber.StatusOK).JSON(infos)
+	}
+}
+
+// handleRebuildSearchIndex recreates a keyword index with the current mapping.
+func handleRebuildSearchIndex() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		info, err := searchIndexes.Rebuild(c.Context(), c.Params("name"))
+		if errors.Is(err, errSearchIndexNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Errorf("Error rebuilding search index: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not rebuild search index"})
+		}
+		return c.Status(fiber.StatusOK).JSON(info)
+	}
+}
+
+// handleDeleteSearchIndex deletes a keyword index. The vectors of its collection are kept.
+func handleDeleteSearchIndex() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := searchIndexes.Drop(c.Params("name"))
+		if errors.Is(err, errSearchIndexNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Errorf("Error deleting search index: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete search index"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleListBackups returns the snapshots of the data path, newest first.
+func handleListBackups(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		snapshots, err := backup.List(osFS, config.Backup.dir(config.DataPath))
+		if err != nil {
+			log.Errorf("Error listing snapshots: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not list snapshots"})
+		}
+		if snapshots == nil {
+			snapshots = []backup.Snapshot{}
+		}
+		return c.Status(fiber.StatusOK).JSON(snapshots)
+	}
+}
+
+// handleCreateBackup takes a snapshot of the data path. The models are added when the
+// request or the backup config asks for them.
+func handleCreateBackup(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := struct {
+			IncludeModels bool `json:"include_models"`
+		}{IncludeModels: config.Backup.IncludeModels}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+			}
+		}
+
+		result, err := createSnapshot(config, req.IncludeModels, time.Now())
+		if err != nil {
+			log.Errorf("Error taking snapshot: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusCreated).JSON(result)
+	}
+}
+
+// handleDownloadBackup sends a snapshot archive.
+func handleDownloadBackup(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !backup.ValidName(name) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid snapshot name"})
+		}
+		path := filepath.Join(config.Backup.dir(config.DataPath), name)
+		if _, err := os.Stat(path); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": backup.ErrNotFound.Error()})
+		}
+		return c.Download(path, name)
+	}
+}
+
+// handleDeleteBackup deletes a snapshot archive.
+func handleDeleteBackup(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !backup.ValidName(name) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid snapshot name"})
+		}
+		err := os.Remove(filepath.Join(config.Backup.dir(config.DataPath), name))
+		if errors.Is(err, os.ErrNotExist) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": backup.ErrNotFound.Error()})
+		}
+		if err != nil {
+			log.Errorf("Error deleting snapshot: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete snapshot"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleReembedCollection queues a job that embeds the records of a vector collection
+// again with the model the config selects for it.
+func handleReembedCollection(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		names, err := vectorStores.Names()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not list collections"})
+		}
+		if !slices.Contains(names, name) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "collection not found"})
+		}
+		if status, ok := ingestQueue.Status(reembedJobID(name)); ok && !status.Done() {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "collection is already being embedded"})
+		}
+		busy, err := collectionIngesting(sqliteDB.db, name)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not check ingestions"})
+		}
+		if busy {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": errCollectionIngesting.Error()})
+		}
+
+		if err := submitReembed(config, ingestQueue, name); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		status, _ := ingestQueue.Status(reembedJobID(name))
+		return c.Status(fiber.StatusAccepted).JSON(status)
+	}
+}
+
+// handleListJobs returns the status of recent background jobs, newest first.
+func handleListJobs() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(ingestQueue.List())
+	}
+}
+
+// handleGetJob returns the status of a background job.
+func handleGetJob() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		status, ok := ingestQueue.Status(c.Params("id"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "job not found"})
 		}
-		return c.Status(fiber.StatusOK).JSON(projects)
+		return c.Status(fiber.StatusOK).JSON(status)
 	}
 }
 
-// handleUpload handles file uploads and saves them to the specified directory.
-func handleUpload(config *AppConfig) fiber.Handler {
+// handleJobEvents streams job status changes as server-sent events. The current status of
+// every known job is sent first, so clients do not miss jobs that started before they
+// connected.
+func handleJobEvents() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		pterm.Warning.Println("Uploads route hit")
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("Transfer-Encoding", "chunked")
 
-		form, err := c.MultipartForm()
-		if err != nil {
-			return err
-		}
+		updates, unsubscribe := ingestQueue.Subscribe()
+		snapshot := ingestQueue.List()
 
-		files := form.File["file"]
-		for _, file := range files {
-			filename := filepath.Join(config.DataPath, "web", "uploads", file.Filename)
-			pterm.Warning.Printf("Uploading file: %s\n", filename)
-			if err := c.SaveFile(file, filename); err != nil {
-				return err
-			}
-			log.Infof("Uploaded file %s to %s", file.Filename, filename)
+		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			defer unsubscribe()
 
-			// If the file is a pdf, extract the text content and print it as Markdown.
-			if strings.HasSuffix(file.Filename, ".pdf") {
-				pdfDoc, err := documents.GetPdfContents(filename)
+			send := func(status jobs.Status) error {
+				data, err := json.Marshal(status)
 				if err != nil {
-					pterm.Error.Println(err)
+					return err
 				}
+				if _, err := fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+					return err
+				}
+				return w.Flush()
+			}
 
-				err = searchIndex.Index(file.Filename, pdfDoc)
-				if err != nil {
-					log.Errorf("Error storing chat message in Bleve: %v", err)
+			for i := len(snapshot) - 1; i >= 0; i-- {
+				if err := send(snapshot[i]); err != nil {
+					return
 				}
+			}
 
-				return c.JSON(fiber.Map{"file": file.Filename, "content": pdfDoc})
+			keepAlive := time.NewTicker(15 * time.Second)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case status, ok := <-updates:
+					if !ok {
+						return
+					}
+					if err := send(status); err != nil {
+						return
+					}
+				case <-keepAlive.C:
+					// Comments keep proxies from closing an idle stream, and detect clients
+					// that have gone away.
+					if _, err := w.WriteString(": keepalive\n\n"); err != nil {
+						return
+					}
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
 			}
-		}
+		}))
 
-		// return the file path of all the documents uploaded
-		return c.JSON(fiber.Map{"files": files})
+		return nil
 	}
 }
 
@@ -125,32 +869,50 @@ func handleToolToggle(config *AppConfig) fiber.Handler {
 		pterm.Info.Println("Params:")
 		pterm.Info.Println(toolName)
 
-		switch toolName {
-		case "memory":
-			pterm.Warning.Sprintf("Memory tool toggled: %t\n", config.Tools.Memory.Enabled)
-			config.Tools.Memory.Enabled = enabledBool
-			config.Tools.Memory.TopN = topNInt
-		case "webget":
-			pterm.Warning.Sprintf("WebGet tool toggled: %t\n", config.Tools.WebGet.Enabled)
-			config.Tools.WebGet.Enabled = !config.Tools.WebGet.Enabled
-		case "websearch":
-			pterm.Warning.Sprintf("WebSearch tool toggled: %t\n", config.Tools.WebSearch.Enabled)
-			config.Tools.WebSearch.Enabled = enabledBool
-			config.Tools.WebSearch.TopN = topNInt
-		case "imggen":
-			config.Tools.ImgGen.Enabled = true
-		default:
+		// Tool settings are scoped to the chat session so concurrent users do not overwrite each other.
+		sessionID, err := sessionFromRequest(c)
+		if err != nil {
+			log.Errorf("Error creating chat session: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Server Error")
+		}
+
+		found := true
+		sessionStates.Get(sessionID).UpdateTools(func(tools *ToolsConfig) {
+			switch toolName {
+			case "memory":
+				tools.Memory.Enabled = enabledBool
+				tools.Memory.TopN = topNInt
+			case "webget":
+				tools.WebGet.Enabled = enabledBool
+			case "websearch":
+				tools.WebSearch.Enabled = enabledBool
+				tools.WebSearch.TopN = topNInt
+			case "imggen":
+				tools.ImgGen.Enabled = enabledBool
+			default:
+				found = false
+			}
+		})
+		if !found {
 			return c.Status(fiber.StatusNotFound).SendString("Tool not found")
 		}
 
+		pterm.Warning.Printf("Tool %s set to %t for session %d\n", toolName, enabledBool, sessionID)
+
 		return c.JSON(fiber.Map{
-			"message": fmt.Sprintf("Tool %s toggled", toolName)})
+			"message":    fmt.Sprintf("Tool %s toggled", toolName),
+			"session_id": sessionID,
+		})
 	}
 }
 
-// handleToolList retrieves and returns a list of tools from the configuration with all parameters.
+// handleToolList retrieves and returns the tool settings of a chat session, or the
+// configuration defaults if no session is given.
 func handleToolList(config *AppConfig) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if sessionID := parseSessionID(c.Query("session_id")); sessionID > 0 {
+			return c.JSON(sessionStates.Get(sessionID).Tools())
+		}
 		return c.JSON(config.Tools)
 	}
 }
@@ -448,55 +1210,460 @@ func handleImgModelDownload(config *AppConfig) fiber.Handler {
 			}()
 		}
 
-		progressErr := "<div name='sse-messages' class='w-100' id='sse-messages' hx-ext='sse' sse-connect='/sseupdates' sse-swap='message'></div>"
+		progressErr := "<div name='sse-messages' class='w-100' id='sse-messages' hx-ext='sse' sse-connect='/sseupdates' sse-swap='message'></div>"
+
+		return c.SendString(progressErr)
+	}
+}
+
+// handleRoleSelection attaches an assistant role to the chat session of the request.
+func handleRoleSelection(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roleName := c.Params("name")
+
+		role, err := GetAssistantRoleByName(sqliteDB.db, roleName)
+		if err != nil {
+			pterm.Warning.Printf("Role %s not found. Defaulting to 'chat'.\n", roleName)
+			role, err = GetAssistantRoleByName(sqliteDB.db, "chat")
+		}
+
+		if err != nil {
+			roles, lerr := ListAssistantRoles(sqliteDB.db)
+			if lerr != nil || len(roles) == 0 {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "No roles configured",
+				})
+			}
+			role = roles[0]
+		}
+
+		sessionID, err := sessionFromRequest(c)
+		if err != nil {
+			log.Errorf("Error creating chat session: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Server Error")
+		}
+
+		// Store the role on the session so it persists across restarts and tabs.
+		if err := SetChatSessionRole(sqliteDB.db, sessionID, role.ID); err != nil {
+			log.Errorf("Error storing role for chat session: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Server Error")
+		}
+		sessionStates.Get(sessionID).SetRole(role)
+
+		pterm.Info.Printf("Role set to: %s for session %d\n", role.Name, sessionID)
+		return c.JSON(fiber.Map{
+			"message":    fmt.Sprintf("Role set to %s", role.DisplayName()),
+			"session_id": sessionID,
+			"role":       role,
+		})
+	}
+}
+
+// handleListRoles returns all assistant roles.
+func handleListRoles() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roles, err := ListAssistantRoles(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get roles"})
+		}
+		return c.Status(fiber.StatusOK).JSON(roles)
+	}
+}
+
+// handleRolesMenu renders the assistant roles as dropdown items for the prompt toolbar.
+func handleRolesMenu() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roles, err := ListAssistantRoles(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Server Error")
+		}
+		return c.Render("templates/rolesmenu", fiber.Map{"roles": roles})
+	}
+}
+
+// handleGetRole returns an assistant role by its ID.
+func handleGetRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		role, err := GetAssistantRole(sqliteDB.db, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "role not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get role"})
+		}
+		return c.Status(fiber.StatusOK).JSON(role)
+	}
+}
+
+// handleCreateRole creates a new assistant role.
+func handleCreateRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role := new(AssistantRole)
+		if err := c.BodyParser(role); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+		}
+		if strings.TrimSpace(role.Name) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+		}
+
+		role.ID = 0
+		if err := CreateAssistantRole(sqliteDB.db, role); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "could not create role"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(role)
+	}
+}
+
+// handleUpdateRole replaces an assistant role by its ID.
+func handleUpdateRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		role := new(AssistantRole)
+		if err := c.BodyParser(role); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+		}
+		if strings.TrimSpace(role.Name) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+		}
+
+		if err := UpdateAssistantRole(sqliteDB.db, id, role); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "role not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not update role"})
+		}
+		sessionStates.UpdateRole(*role)
+		return c.Status(fiber.StatusOK).JSON(role)
+	}
+}
+
+// handleDeleteRole deletes an assistant role by its ID.
+func handleDeleteRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		if err := DeleteAssistantRole(sqliteDB.db, id); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "role not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete role"})
+		}
+		sessionStates.RemoveRole(id)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleExportRoles exports all assistant roles as a JSON download.
+func handleExportRoles() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roles, err := ListAssistantRoles(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get roles"})
+		}
+
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="eternal-roles.json"`)
+		return c.Status(fiber.StatusOK).JSON(roles)
+	}
+}
+
+// handleImportRoles imports assistant roles from a JSON array, updating roles that share a name.
+func handleImportRoles() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		data := c.Body()
+
+		// Prefer an uploaded file when the request is multipart.
+		if file, err := c.FormFile("file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not open uploaded file"})
+			}
+			defer f.Close()
+
+			data, err = io.ReadAll(f)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not read uploaded file"})
+			}
+		}
+
+		var roles []AssistantRole
+		if err := json.Unmarshal(data, &roles); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+		}
+
+		imported := 0
+		for i := range roles {
+			if strings.TrimSpace(roles[i].Name) == "" {
+				continue
+			}
+			if err := UpsertAssistantRole(sqliteDB.db, &roles[i]); err != nil {
+				log.Errorf("Error importing role %s: %v", roles[i].Name, err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not import roles"})
+			}
+			sessionStates.UpdateRole(roles[i])
+			imported++
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"imported": imported})
+	}
+}
+
+// handleListPrompts returns the prompts in the library, optionally filtered by the tag query parameter.
+func handleListPrompts() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		prompts, err := ListPrompts(sqliteDB.db, c.Query("tag"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get prompts"})
+		}
+		return c.Status(fiber.StatusOK).JSON(prompts)
+	}
+}
+
+// handlePromptOptions renders the prompts in the library as options for the prompt picker.
+func handlePromptOptions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		prompts, err := ListPrompts(sqliteDB.db, c.Query("tag"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Server Error")
+		}
+		return c.Render("templates/promptoptions", fiber.Map{"prompts": prompts})
+	}
+}
+
+// handlePromptForm renders the variable inputs of a prompt for the prompt picker.
+func handlePromptForm() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid prompt")
+		}
+
+		prompt, err := GetPrompt(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("Prompt not found")
+		}
+		return c.Render("templates/promptform", fiber.Map{"prompt": prompt})
+	}
+}
+
+// handleGetPrompt returns a prompt by its ID.
+func handleGetPrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		prompt, err := GetPrompt(sqliteDB.db, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "prompt not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get prompt"})
+		}
+		return c.Status(fiber.StatusOK).JSON(prompt)
+	}
+}
+
+// handleCreatePrompt adds a prompt to the library.
+func handleCreatePrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		prompt := new(Prompt)
+		if err := c.BodyParser(prompt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+		}
+		if strings.TrimSpace(prompt.Name) == "" || strings.TrimSpace(prompt.Template) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name and template are required"})
+		}
+
+		declarePromptVariables(prompt)
+		if err := CreatePrompt(sqliteDB.db, prompt); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "could not create prompt"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(prompt)
+	}
+}
+
+// handleUpdatePrompt replaces a prompt by its ID, recording a new version when its text changes.
+func handleUpdatePrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		prompt := new(Prompt)
+		if err := c.BodyParser(prompt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+		}
+		if strings.TrimSpace(prompt.Name) == "" || strings.TrimSpace(prompt.Template) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name and template are required"})
+		}
+
+		declarePromptVariables(prompt)
+		if err := UpdatePrompt(sqliteDB.db, id, prompt); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "prompt not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not update prompt"})
+		}
+		return c.Status(fiber.StatusOK).JSON(prompt)
+	}
+}
+
+// handleDeletePrompt deletes a prompt and its version history.
+func handleDeletePrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		if err := DeletePrompt(sqliteDB.db, id); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "prompt not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not delete prompt"})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleListPromptVersions returns the version history of a prompt.
+func handleListPromptVersions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		versions, err := ListPromptVersions(sqliteDB.db, id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get prompt versions"})
+		}
+		if len(versions) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "prompt not found"})
+		}
+		return c.Status(fiber.StatusOK).JSON(versions)
+	}
+}
+
+// handleRenderPrompt fills in the variables of a prompt and returns the resulting text.
+func handleRenderPrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		version, req, status, err := promptFromRequest(c)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		system, prompt, err := renderPrompt(version.System, version.Template, version.Variables, req.Variables)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 
-		return c.SendString(progressErr)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"version": version.Version,
+			"system":  system,
+			"prompt":  prompt,
+		})
 	}
 }
 
-// handleRoleSelection handles the selection of assistant roles.
-func handleRoleSelection(config *AppConfig) fiber.Handler {
+// handleRunPrompt renders a prompt and runs it against a model. The first selected model
+// is used when the request does not name one.
+func handleRunPrompt(config *AppConfig) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		roleName := c.Params("name")
-		var foundRole *struct {
-			Name         string `yaml:"name"`
-			Instructions string `yaml:"instructions"`
+		version, req, status, err := promptFromRequest(c)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		for i := range config.AssistantRoles {
-			if config.AssistantRoles[i].Name == roleName {
-				foundRole = &config.AssistantRoles[i]
-				break
-			}
+		system, prompt, err := renderPrompt(version.System, version.Template, version.Variables, req.Variables)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		if foundRole == nil {
-			pterm.Warning.Printf("Role %s not found. Defaulting to 'chat'.\n", roleName)
-			for i := range config.AssistantRoles {
-				if config.AssistantRoles[i].Name == "chat" {
-					foundRole = &config.AssistantRoles[i]
-					break
-				}
+		modelName := req.Model
+		if modelName == "" {
+			selectedModels, err := GetSelectedModels(sqliteDB.db)
+			if err != nil || len(selectedModels) == 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no model given or selected"})
 			}
+			modelName = selectedModels[0].ModelName
 		}
 
-		if foundRole == nil && len(config.AssistantRoles) > 0 {
-			foundRole = &config.AssistantRoles[0]
+		response, err := complete(config, modelName, system, prompt)
+		if err != nil {
+			log.Errorf("Error running prompt %d: %v", version.PromptID, err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "could not run prompt"})
 		}
 
-		if foundRole != nil {
-			assistantRole = foundRole.Instructions
-			pterm.Info.Printf("Role set to: %s\n", foundRole.Name)
-			pterm.Info.Println(foundRole.Instructions)
-			return c.JSON(fiber.Map{
-				"message": fmt.Sprintf("Role set to %s", foundRole.Name),
-			})
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"model":    modelName,
+			"version":  version.Version,
+			"system":   system,
+			"prompt":   prompt,
+			"response": response,
+		})
+	}
+}
+
+// promptFromRequest parses a render request and loads the requested prompt version. On
+// failure it returns the HTTP status to respond with.
+func promptFromRequest(c *fiber.Ctx) (PromptVersion, PromptRenderRequest, int, error) {
+	var req PromptRenderRequest
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return PromptVersion{}, req, fiber.StatusBadRequest, errors.New("invalid id")
+	}
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return PromptVersion{}, req, fiber.StatusBadRequest, errors.New("cannot parse JSON")
 		}
+	}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "No roles configured",
-		})
+	version, err := loadPromptVersion(sqliteDB.db, id, req.Version)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PromptVersion{}, req, fiber.StatusNotFound, errors.New("prompt not found")
+		}
+		return PromptVersion{}, req, fiber.StatusInternalServerError, errors.New("could not get prompt")
+	}
+
+	return version, req, fiber.StatusOK, nil
+}
+
+// sessionFromRequest returns the chat session ID sent with the request, creating a new
+// session if the client has not joined one yet or sent one that does not exist.
+func sessionFromRequest(c *fiber.Ctx) (int64, error) {
+	if sessionID := parseSessionID(c.FormValue("session_id", c.Query("session_id"))); sessionID > 0 {
+		exists, err := ChatSessionExists(sqliteDB.db, sessionID)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return sessionID, nil
+		}
+	}
+
+	session, err := CreateChatSession(sqliteDB.db)
+	if err != nil {
+		return 0, err
 	}
+	return session.ID, nil
 }
 
 // handleChatSubmit handles the submission of chat messages.
@@ -505,36 +1672,47 @@ func handleChatSubmit(config *AppConfig) fiber.Handler {
 		userPrompt := c.FormValue("userprompt")
 		var wsroute string
 
-		selectedModels, err := GetSelectedModels(sqliteDB.db)
+		// Start a new chat session if the client has not joined one yet.
+		sessionID, err := sessionFromRequest(c)
 		if err != nil {
-			log.Errorf("Error getting selected models: %v", err)
+			log.Errorf("Error creating chat session: %v", err)
 			return c.Status(500).SendString("Server Error")
 		}
+		state := sessionStates.Get(sessionID)
 
-		if len(selectedModels) > 0 {
-			firstModelName := selectedModels[0].ModelName
-
-			if strings.HasPrefix(firstModelName, "openai-") {
-				wsroute = "/wsoai"
-			} else if strings.HasPrefix(firstModelName, "google-") {
-				wsroute = "/wsgoogle"
-			} else if strings.HasPrefix(firstModelName, "anthropic-") {
-				wsroute = "/wsanthropic"
-			} else {
-				wsroute = fmt.Sprintf("ws://%s:%s/ws", config.ServiceHosts["llm"]["llm_host_1"].Host, config.ServiceHosts["llm"]["llm_host_1"].Port)
+		// The session's role may carry a default model, otherwise the first selected model is used.
+		modelName := state.AssistantRole().Model
+		if modelName == "" {
+			selectedModels, err := GetSelectedModels(sqliteDB.db)
+			if err != nil {
+				log.Errorf("Error getting selected models: %v", err)
+				return c.Status(500).SendString("Server Error")
 			}
+			if len(selectedModels) == 0 {
+				return c.JSON(fiber.Map{"error": "No models selected"})
+			}
+			modelName = selectedModels[0].ModelName
+		}
+
+		if strings.HasPrefix(modelName, "openai-") {
+			wsroute = "/wsoai"
+		} else if strings.HasPrefix(modelName, "google-") {
+			wsroute = "/wsgoogle"
+		} else if strings.HasPrefix(modelName, "anthropic-") {
+			wsroute = "/wsanthropic"
 		} else {
-			return c.JSON(fiber.Map{"error": "No models selected"})
+			wsroute = fmt.Sprintf("ws://%s:%s/ws", config.ServiceHosts["llm"]["llm_host_1"].Host, config.ServiceHosts["llm"]["llm_host_1"].Port)
 		}
 
-		turnID := IncrementTurn()
+		turnID := sessionStates.NextTurn()
 
 		return c.Render("templates/chat", fiber.Map{
 			"username":  config.CurrentUser,
 			"message":   userPrompt,
 			"assistant": config.AssistantName,
-			"model":     selectedModels[0].ModelName,
+			"model":     modelName,
 			"turnID":    turnID,
+			"sessionID": sessionID,
 			"wsRoute":   wsroute,
 			"hosts":     config.ServiceHosts["llm"],
 		})
@@ -610,6 +1788,197 @@ func handleDeleteChat() fiber.Handler {
 	}
 }
 
+// handleExportChats exports every chat session in the requested format.
+func handleExportChats() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessions, err := ListChatSessions(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get chat sessions"})
+		}
+		return sendChatExport(c, sessions, "eternal-chats")
+	}
+}
+
+// handleExportChat exports a single chat session in the requested format.
+func handleExportChat() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		session, err := GetChatSession(sqliteDB.db, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "chat session not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get chat session"})
+		}
+		return sendChatExport(c, []ChatSession{session}, fmt.Sprintf("eternal-chat-%d", id))
+	}
+}
+
+// sendChatExport renders the sessions in the format given by the query string and sends them as a download.
+func sendChatExport(c *fiber.Ctx, sessions []ChatSession, filename string) error {
+	format := c.Query("format", ExportFormatMarkdown)
+
+	contentType, ext, err := ExportContentType(format)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	data, err := ExportChatSessions(sessions, format)
+	if err != nil {
+		log.Errorf("Error exporting chats: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not export chats"})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename+"."+ext))
+	return c.Send(data)
+}
+
+// handleImportChats imports chat sessions from an Eternal JSON export or a ChatGPT conversations.json file.
+func handleImportChats() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		data := c.Body()
+
+		// Prefer an uploaded file when the request is multipart.
+		if file, err := c.FormFile("file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not open uploaded file"})
+			}
+			defer f.Close()
+
+			data, err = io.ReadAll(f)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not read uploaded file"})
+			}
+		}
+
+		sessions, err := ParseChatImport(data)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if err := ImportChatSessions(sqliteDB.db, sessions); err != nil {
+			log.Errorf("Error importing chat sessions: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not import chat sessions"})
+		}
+		ids := make([]int64, len(sessions))
+		for i, session := range sessions {
+			ids[i] = session.ID
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"imported": len(ids), "session_ids": ids})
+	}
+}
+
+// handleSearch searches past conversations and ingested documents.
+// htmx requests receive the rendered results panel, all other requests receive JSON.
+func handleSearch() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		params := ChatSearchParams{
+			Query:  c.Query("q"),
+			Model:  c.Query("model"),
+			Tag:    c.Query("tag"),
+			Source: c.Query("source"),
+			Page:   c.QueryInt("page", 1),
+			Size:   c.QueryInt("size", defaultSearchPageSize),
+		}
+
+		var err error
+		if params.From, err = parseSearchTime(c.Query("from")); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from date"})
+		}
+		if params.To, err = parseSearchTime(c.Query("to")); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to date"})
+		}
+
+		res, err := searchIndexes.All().Search(buildChatSearchRequest(params, time.Now()))
+		if err != nil {
+			log.Errorf("Error searching index: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not search index"})
+		}
+
+		result := toChatSearchResult(params, res)
+
+		if c.Get("HX-Request") == "true" {
+			return c.Render("templates/searchresults", fiber.Map{
+				"result": result,
+				"params": c.Queries(),
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(result)
+	}
+}
+
+// handleListSessions returns the chat sessions with their titles and summaries, most recent first.
+func handleListSessions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessions, err := ListRecentChatSessions(sqliteDB.db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get chat sessions"})
+		}
+		return c.Status(fiber.StatusOK).JSON(sessions)
+	}
+}
+
+// handleGetSession renders the transcript of a chat session into the chat view.
+func handleGetSession(config *AppConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+		}
+
+		session, err := GetChatSession(sqliteDB.db, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "chat session not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not get chat session"})
+		}
+
+		if c.Get("HX-Request") != "true" {
+			return c.Status(fiber.StatusOK).JSON(session)
+		}
+
+		type transcriptResponse struct {
+			Model   string
+			Content template.HTML
+		}
+		type transcriptTurn struct {
+			ID        int64
+			Prompt    string
+			Responses []transcriptResponse
+			Sources   []Citation
+		}
+
+		turns := make([]transcriptTurn, 0, len(session.ChatTurns))
+		for _, turn := range session.ChatTurns {
+			t := transcriptTurn{ID: turn.ID, Prompt: turn.UserPrompt, Sources: turn.Sources}
+			for _, response := range turn.Responses {
+				t.Responses = append(t.Responses, transcriptResponse{
+					Model:   response.Model,
+					Content: template.HTML(web.MarkdownToHTML([]byte(response.Content))),
+				})
+			}
+			turns = append(turns, t)
+		}
+
+		return c.Render("templates/session", fiber.Map{
+			"username":  config.CurrentUser,
+			"assistant": config.AssistantName,
+			"sessionID": session.ID,
+			"title":     sessionTitle(session),
+			"turns":     turns,
+		})
+	}
+}
+
 // handleDPSearch handles search requests using DuckDuckGo.
 func handleDPSearch() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -679,7 +2048,8 @@ func handleWebSocket(config *AppConfig) func(*websocket.Conn) {
 			// Prepare the full prompt for the model.
 			promptTemplate := model.Options.Prompt
 
-			fullInstructions := fmt.Sprintf("%s\n\n%s", assistantRole, chatMessage)
+			state := sessionStates.Get(parseSessionID(wsMessage.SessionID))
+			fullInstructions := fmt.Sprintf("%s\n\n%s", state.Role(), chatMessage)
 
 			fullPrompt := strings.ReplaceAll(promptTemplate, "{prompt}", fullInstructions)
 			fullPrompt = strings.ReplaceAll(fullPrompt, "{system}", "You are a helpful AI assistant.")
@@ -710,8 +2080,23 @@ func handleWebSocket(config *AppConfig) func(*websocket.Conn) {
 				TopK:          model.Options.TopK,
 			}
 
+			// Sampling settings on the session's role override the model defaults.
+			role := state.AssistantRole()
+			if role.Temperature != 0 {
+				modelOpts.Temp = role.Temperature
+			}
+			if role.TopP != 0 {
+				modelOpts.TopP = role.TopP
+			}
+			if role.TopK != 0 {
+				modelOpts.TopK = role.TopK
+			}
+			if role.RepeatPenalty != 0 {
+				modelOpts.RepeatPenalty = role.RepeatPenalty
+			}
+
 			// Make a completion request to the model and send the response over WebSocket.
-			return llm.MakeCompletionWebSocket(*c, chatTurn, modelOpts, config.DataPath)
+			return llm.MakeCompletionWebSocket(*c, wsMessage.Turn(), modelOpts, config.DataPath)
 		})
 	}
 }
@@ -720,54 +2105,41 @@ func handleWebSocket(config *AppConfig) func(*websocket.Conn) {
 func handleOpenAIWebSocket(config *AppConfig) func(*websocket.Conn) {
 	return func(c *websocket.Conn) {
 		handleWebSocketConnection(c, config, func(wsMessage WebSocketMessage, chatMessage string) error {
-			// Get the system template for the chat message.
+			// Get the system template for the chat message and apply the session role.
+			state := sessionStates.Get(parseSessionID(wsMessage.SessionID))
 			cpt := llm.GetSystemTemplate(chatMessage)
-			// Stream the completion response from OpenAI to the WebSocket.
-			return openai.StreamCompletionToWebSocket(c, chatTurn, "gpt-4o", cpt.Messages, 0.3, config.OAIKey)
-		})
-	}
-}
-
-// handleAnthropicWS handles WebSocket connections for Anthropic.
-func handleAnthropicWS(c *websocket.Conn, apiKey string, chatID int) {
-	// Read the initial message from the WebSocket.
-	_, message, err := c.ReadMessage()
-	if err != nil {
-		pterm.PrintOnError(err)
-		return
-	}
-
-	// Unmarshal the JSON message.
-	var wsMessage WebSocketMessage
-	err = json.Unmarshal(message, &wsMessage)
-	if err != nil {
-		c.WriteMessage(websocket.TextMessage, []byte("Error unmarshalling JSON"))
-		return
-	}
-
-	// Extract the chat message value.
-	chatMessage := wsMessage.ChatMessage
+			cpt.Messages[0].Content = state.Role()
 
-	// Prepare the messages for the completion request.
-	messages := []anthropic.Message{
-		{Role: "user", Content: chatMessage},
-	}
+			temperature := 0.3
+			if role := state.AssistantRole(); role.Temperature != 0 {
+				temperature = role.Temperature
+			}
 
-	// Stream the completion response from Anthropic to the WebSocket.
-	res := anthropic.StreamCompletionToWebSocket(c, chatID, "claude-3-5-sonnet-20240620", messages, 0.3, apiKey)
-	if res != nil {
-		pterm.Error.Println("Error in anthropic completion:", res)
+			// Stream the completion response from OpenAI to the WebSocket.
+			return openai.StreamCompletionToWebSocket(c, wsMessage.Turn(), "gpt-4o", cpt.Messages, temperature, config.OAIKey)
+		})
 	}
-
-	// Increment the chat turn counter.
-	chatTurn = chatTurn + 1
 }
 
 // handleAnthropicWebSocket handles WebSocket connections for Anthropic.
 func handleAnthropicWebSocket(config *AppConfig) func(*websocket.Conn) {
 	return func(c *websocket.Conn) {
-		apiKey := config.AnthropicKey
-		handleAnthropicWS(c, apiKey, chatTurn)
+		handleWebSocketConnection(c, config, func(wsMessage WebSocketMessage, chatMessage string) error {
+			// Anthropic takes the session role as the system prompt.
+			state := sessionStates.Get(parseSessionID(wsMessage.SessionID))
+			messages := []anthropic.Message{
+				{Role: "user", Content: chatMessage},
+			}
+
+			temperature := 0.3
+			role := state.AssistantRole()
+			if role.Temperature != 0 {
+				temperature = role.Temperature
+			}
+
+			// Stream the completion response from Anthropic to the WebSocket.
+			return anthropic.StreamCompletionToWebSocket(c, wsMessage.Turn(), "claude-3-5-sonnet-20240620", state.Role(), messages, temperature, role.TopP, config.AnthropicKey)
+		})
 	}
 }
 
@@ -777,8 +2149,22 @@ func handleGoogleWebSocket(config *AppConfig) func(*websocket.Conn) {
 		apiKey := config.GoogleKey
 
 		handleWebSocketConnection(c, config, func(wsMessage WebSocketMessage, chatMessage string) error {
+			state := sessionStates.Get(parseSessionID(wsMessage.SessionID))
+
+			// Gemini answers greedily unless the session's role sets its own sampling.
+			temperature, topP, topK := 0.1, 1.0, 1
+			if role := state.AssistantRole(); role.Temperature != 0 || role.TopP != 0 || role.TopK != 0 {
+				topK = role.TopK
+				if role.Temperature != 0 {
+					temperature = role.Temperature
+				}
+				if role.TopP != 0 {
+					topP = role.TopP
+				}
+			}
+
 			// Stream the Gemini response from Google to the WebSocket.
-			return google.StreamGeminiResponseToWebSocket(c, chatTurn, chatMessage, apiKey)
+			return google.StreamGeminiResponseToWebSocket(c, wsMessage.Turn(), state.Role(), chatMessage, temperature, topP, topK, apiKey)
 		})
 	}
 }
@@ -797,19 +2183,32 @@ func handleWebSocketConnection(c *websocket.Conn, config *AppConfig, processMess
 
 		chatMessage := wsMessage.ChatMessage
 
-		// Only perform the tool workflow if any of the tools are enabled.
-		if config.Tools.ImgGen.Enabled || config.Tools.Memory.Enabled || config.Tools.WebGet.Enabled || config.Tools.WebSearch.Enabled {
+		// Only perform the tool workflow if any of the session's tools are enabled.
+		sessionID := parseSessionID(wsMessage.SessionID)
+		state := sessionStates.Get(sessionID)
+		tools := state.Tools()
+		var citations []Citation
+		if tools.AnyEnabled() {
 
 			// Perform the tool workflow on the chat message.
-			chatMessage = performToolWorkflow(c, config, wsMessage.ChatMessage)
+			chatMessage, citations = performToolWorkflow(c, config, tools, state.AssistantRole().KnowledgeBase, sessionID, wsMessage.Turn(), wsMessage.ChatMessage)
 		}
 
+		// Include the earlier turns of the session, or their summary, so the model can follow the conversation.
+		chatMessage = withSessionHistory(config, sessionID, chatMessage)
+
 		log.Infof("Processed chat message: %s", chatMessage)
 
 		// Process the WebSocket message.
 		err = processMessage(wsMessage, chatMessage)
 		if err != nil {
-			handleError(config, wsMessage, err)
+			// Show the sources of the response, marking the ones it cites.
+			citations = markCitations(err.Error(), citations)
+			if len(citations) > 0 {
+				sendCitations(c, wsMessage.Turn(), citations)
+			}
+
+			handleError(config, wsMessage, citations, err)
 			return
 		}
 
@@ -835,8 +2234,9 @@ func readAndUnmarshalMessage(c *websocket.Conn) (WebSocketMessage, error) {
 	return wsMessage, nil
 }
 
-// handleError handles errors that occur during message processing.
-func handleError(config *AppConfig, message WebSocketMessage, err error) {
+// handleError handles errors that occur during message processing. The citations are the
+// sources the prompt was grounded on, stored with the chat turn.
+func handleError(config *AppConfig, message WebSocketMessage, citations []Citation, err error) {
 	log.Errorf("Chat turn finished: %v", err)
 
 	// Store the chat turn in the sqlite db.
@@ -845,63 +2245,72 @@ func handleError(config *AppConfig, message WebSocketMessage, err error) {
 		return
 	}
 
-	if config.Tools.Memory.Enabled {
+	// Record the turn in its chat session so it can be exported and searched later.
+	var sessionID, turnID int64
+	if id, perr := strconv.ParseInt(message.SessionID, 10, 64); perr == nil && id > 0 {
+		turn, terr := AddChatTurn(sqliteDB.db, id, message.ChatMessage, err.Error(), message.Model, citations...)
+		if terr != nil {
+			pterm.Error.Println("Error storing chat turn in database:", terr)
+		} else {
+			sessionID, turnID = id, turn.ID
 
-		// Get the timestamp for the chat message in human-readable format.
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
+			// Title and summarize the session in the background.
+			go updateSessionSummary(config, sessionID)
+		}
+	}
 
-		memHeader := fmt.Sprintf("Previous chat - %s", timestamp)
+	// Get the timestamp for the chat message in human-readable format.
+	now := time.Now()
+	timestamp := now.Format("2006-01-02 15:04:05")
 
-		// Two examples of how to store chat messages in the Bleve index.
-		// 1. Split the text and store each chunk in the index.
-		// 2. Store the entire chat message in the index.
-		// Split the chat message into chunks 500 characters long with a 200 character overlap.
-		chunks := documents.SplitTextByCount(err.Error(), 500)
+	memHeader := fmt.Sprintf("Previous chat - %s", timestamp)
 
-		// Prepend the header to all chunks.
-		for i, chunk := range chunks {
-			chunks[i] = fmt.Sprintf("%s\n%s", memHeader, chunk)
-		}
+	// Every turn is indexed so it can be found from the search panel. The memory tool
+	// queries the same index when it is enabled.
+	// Split the chat message into chunks 500 characters long.
+	chunks := documents.SplitTextByCount(err.Error(), 500)
 
-		// 1. Store the chunk in Bleve.
-		for _, chunk := range chunks {
-			chatMessage := ChatTurnMessage{
-				ID:       fmt.Sprintf("%d", time.Now().UnixNano()),
-				Prompt:   message.ChatMessage,
-				Response: chunk,
-				Model:    message.Model,
-			}
+	// Prepend the header to all chunks.
+	for i, chunk := range chunks {
+		chunks[i] = fmt.Sprintf("%s\n%s", memHeader, chunk)
+	}
 
-			err = searchIndex.Index(chatMessage.ID, chatMessage)
-			if err != nil {
-				log.Errorf("Error storing chat message in Bleve: %v", err)
-			}
+	// Store each chunk in the chat index.
+	index, ierr := searchIndexes.Get(CollectionChat)
+	if ierr != nil {
+		log.Errorf("Error opening chat search index: %v", ierr)
+		return
+	}
+	for i, chunk := range chunks {
+		chatMessage := ChatTurnMessage{
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			SessionID: sessionID,
+			TurnID:    turnID,
+			Prompt:    message.ChatMessage,
+			Response:  chunk,
+			Model:     message.Model,
+			Source:    SourceChat,
+			CreatedAt: now,
+		}
+		if turnID > 0 {
+			chatMessage.ID = fmt.Sprintf("turn-%d-%d", turnID, i)
 		}
-		// 2. Store the entire chat message in Bleve.
-		// chatMessage := ChatTurnMessage{
-		// 	ID:       fmt.Sprintf("%d", time.Now().UnixNano()),
-		// 	Prompt:   message.ChatMessage,
-		// 	Response: err.Error(),
-		// 	Model:    message.Model,
-		// }
 
-		// err = searchIndex.Index(chatMessage.ID, chatMessage)
-		// if err != nil {
-		// 	log.Errorf("Error storing chat message in Bleve: %v", err)
-		// }
+		if ierr := index.Index(chatMessage.ID, chatMessage); ierr != nil {
+			log.Errorf("Error storing chat message in Bleve: %v", ierr)
+		}
 	}
-
-	// Increment the chat turn counter.
-	chatTurn++
 }
 
-// performToolWorkflow performs the tool workflow on a chat message.
-func performToolWorkflow(c *websocket.Conn, config *AppConfig, chatMessage string) string {
+// performToolWorkflow performs the tool workflow on a chat message. It returns the message
+// with the retrieved context added as numbered sources, and the citations of those sources.
+// Memory and web search look for the message as rewritten with the session's conversation.
+func performToolWorkflow(c *websocket.Conn, config *AppConfig, tools ToolsConfig, scope string, sessionID int64, turnID int, chatMessage string) (string, []Citation) {
 
-	// Begin tool workflow. Tools will add context to the submitted message for the model to use.
-	var document string
+	// Begin tool workflow. Tools will add sources to the submitted message for the model to cite.
+	var citations []Citation
 
-	if config.Tools.ImgGen.Enabled {
+	if tools.ImgGen.Enabled {
 		pterm.Info.Println("Generating image...")
 		sdParams := &sd.SDParams{Prompt: chatMessage}
 
@@ -911,47 +2320,59 @@ func performToolWorkflow(c *websocket.Conn, config *AppConfig, chatMessage strin
 		// Return the image to the client.
 		timestamp := time.Now().UnixNano() // Get the current timestamp in nanoseconds.
 		imgElement := fmt.Sprintf("<img class='rounded-2 object-fit-scale' width='512' height='512' src='public/img/sd_out.png?%d' />", timestamp)
-		formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>", fmt.Sprint(chatTurn), imgElement)
+		formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>", fmt.Sprint(turnID), imgElement)
 		if err := c.WriteMessage(websocket.TextMessage, []byte(formattedContent)); err != nil {
 			pterm.PrintOnError(err)
-			return chatMessage
+			return chatMessage, nil
 		}
 
-		// Increment the chat turn counter.
-		chatTurn = chatTurn + 1
-
 		// End the tool workflow.
-		return chatMessage
+		return chatMessage, nil
 	}
 
-	if config.Tools.Memory.Enabled {
-		document, _ = handleChatMemory(config, chatMessage)
+	query := searchQuery{Text: chatMessage}
+	if tools.Memory.Enabled || tools.WebSearch.Enabled {
+		query = rewriteQuery(config, sessionID, chatMessage)
+		if query.Text != chatMessage || len(query.SubQueries) > 0 {
+			pterm.Info.Printf("Search query: %s %q\n", query.Text, query.SubQueries)
+		}
+	}
+
+	if tools.Memory.Enabled {
+		results, _ := handleChatMemory(config, tools.Memory.TopN, scope, query)
+		citations = appendCitations(citations, results)
 	}
 
-	if config.Tools.WebGet.Enabled {
+	if tools.WebGet.Enabled {
 		url := web.ExtractURLs(chatMessage)
 		if len(url) > 0 {
 			pterm.Info.Println("Retrieving page content...")
 
-			document, _ = web.WebGetHandler(url[0])
-
-			// Add the page content to the chat message.
-
+			page, err := web.WebGetHandler(url[0])
+			if err != nil {
+				log.Errorf("Error fetching URL: %v", err)
+			} else if strings.TrimSpace(page) != "" {
+				// Add the page content to the chat message.
+				citations = append(citations, webCitation(len(citations)+1, url[0], page))
+			}
 		}
 	}
 
-	if config.Tools.WebSearch.Enabled {
-		topN := config.Tools.WebSearch.TopN
+	if tools.WebSearch.Enabled {
+		topN := tools.WebSearch.TopN
 
 		pterm.Info.Println("Searching the web...")
 
-		var urls []string
-		switch config.Tools.WebSearch.Name {
-		case "ddg":
-			urls = web.SearchDDG(chatMessage)
-		case "sxng":
-			urls = web.GetSearXNGResults(config.Tools.WebSearch.Endpoint, chatMessage)
-		}
+		// The rewritten query and its sub-queries are searched, and their results fused.
+		urls := query.searchWeb(func(text string) []string {
+			switch tools.WebSearch.Name {
+			case "ddg":
+				return web.SearchDDG(text)
+			case "sxng":
+				return web.GetSearXNGResults(tools.WebSearch.Endpoint, text)
+			}
+			return nil
+		})
 
 		//pterm.Warning.Printf("URLs to fetch: %v\n", urls)
 
@@ -1059,90 +2480,49 @@ func performToolWorkflow(c *websocket.Conn, config *AppConfig, chatMessage strin
 			pterm.Warning.Printf("New failed URL: %s\n", trackedURL.URL)
 		}
 
+		// Web chunks are recalled through chat memory, so they are labeled with its model.
+		embeddingModel, err := config.Embeddings.ForCollection(CollectionChat)
+		if err != nil {
+			log.Errorf("Error resolving embedding model: %v", err)
+		}
+
 		// Process pages
-		var document string
 		for page := range pagesChan {
 			// Parse the first line of the page to get the URL
 			pageURL := strings.Split(page, "\n")[0]
 			documentTags := fmt.Sprintf("web, %s", pageURL)
-			err := handleTextSplitAndIndex(documentTags, page, 1024, "avsolatorio/GIST-small-Embedding-v0")
+			err := handleTextSplitAndIndex(documentTags, page, 1024, embeddingModel.Model, SourceWeb)
 			if err != nil {
 				log.Errorf("Error handling text split and index: %v", err)
 			}
-			document = fmt.Sprintf("%s\n%s", document, page)
 		}
 
-		pterm.Error.Printf("Fetching web search chunks from memory...")
-		document, _ = handleChatMemory(config, chatMessage)
-		//pterm.Error.Printf("Web Search Document: %s\n", document)
-		chatMessage = fmt.Sprintf("%s Reference the previous information if it is relevant to the next query only. Do not provide any additional information other than what is necessary to answer the next question or respond to the query. Be concise. Do not deviate from the topic of the query.\nQUERY:\n%s", document, chatMessage)
-
-		pterm.Info.Println("Tool workflow complete")
-
-		return chatMessage
+		pterm.Info.Println("Fetching web search chunks from memory...")
+		results, _ := handleChatMemory(config, tools.Memory.TopN, scope, query)
+		citations = appendCitations(citations, results)
 	}
 
-	chatMessage = fmt.Sprintf("REFERENCE DOCUMENT:\n%s\n\nQUERY:\n%s", document, chatMessage)
+	chatMessage = formatCitationPrompt(citations, chatMessage)
 
 	pterm.Info.Println("Tool workflow complete")
 
-	return chatMessage
+	return chatMessage, citations
 }
 
-// handleChatMemory retrieves and returns chat memory.
-func handleChatMemory(config *AppConfig, chatMessage string) (string, error) {
-	var document string
-
-	topN := config.Tools.Memory.TopN
-
-	// Create a search query
-	query := bleve.NewQueryStringQuery(chatMessage)
-
-	// Create a search request with the query and limit the results
-	searchRequest := bleve.NewSearchRequestOptions(query, topN, 0, false)
-
-	// Execute the search
-	searchResults, err := searchIndex.Search(searchRequest)
+// handleChatMemory retrieves the chat memory relevant to a search query. A non-empty scope
+// limits the search to documents with that tag.
+func handleChatMemory(config *AppConfig, topN int, scope string, query searchQuery) ([]retrieval.Result, error) {
+	results, err := retrieveChatMemory(context.Background(), config, topN, scope, query)
 	if err != nil {
-		log.Errorf("Error searching index: %v", err)
-		return "", err
-	}
-
-	// Print the search results
-	for _, hit := range searchResults.Hits {
-		doc, err := searchIndex.Document(hit.ID)
-		if err != nil {
-			log.Errorf("Error retrieving document: %v", err)
-			continue
-		}
-
-		doc.VisitFields(func(field index.Field) {
-			//fmt.Printf("%s: %s\n", field.Name(), field.Value())
-
-			// Append the response field to the document and store it for later use
-			if field.Name() == "response" {
-				document = fmt.Sprintf("%s\n%s", document, field.Value())
-			}
-		})
+		log.Errorf("Error retrieving chat memory: %v", err)
+		return nil, err
 	}
 
-	modelPath := filepath.Join(config.DataPath, "models/HF/avsolatorio/GIST-small-Embedding-v0/avsolatorio/GIST-small-Embedding-v0")
-	embeddings.GenerateEmbeddingForTask("chat", document, "txt", 4096, 1024, modelPath)
-
-	searchRes := searchSimilarEmbeddings(config, "GIST-small-Embedding-v0", modelPath, chatMessage, topN)
-
-	// Retrieve the most similar chunks of text from the chat embeddings
-	for _, res := range searchRes {
-
-		similarity := res.Similarity
-		if similarity > 0.8 {
-			//pterm.Info.Println("Most similar chunk of text:")
-			//pterm.Info.Println(res.Word)
-			document = fmt.Sprintf("%s\n%s", document, res.Word)
-		}
+	if err := rememberChatMemory(config, scope, results); err != nil {
+		log.Errorf("Error storing chat memory: %v", err)
 	}
 
-	return document, nil
+	return results, nil
 }
 
 // storeChat stores a chat in the database and generates embeddings for it.
@@ -1160,14 +2540,43 @@ func handleChatMemory(config *AppConfig, chatMessage string) (string, error) {
 // 	return nil
 // }
 
-// handleTextSplitAndIndex handles the splitting and indexing of text.
-func handleTextSplitAndIndex(inputTags string, inputText string, chunkSize int, modelName string) error {
-	// Split the input text into chunks.
-	chunks := documents.SplitTextByCount(inputText, chunkSize)
+// handleTextSplitAndIndex handles the splitting and indexing of text. The text is split
+// into sections at its Markdown headings and each section into chunks, and the sections are
+// saved as the parents of their chunks. The first line of the text identifies its sections,
+// such as the URL of a web page.
+func handleTextSplitAndIndex(inputTags string, inputText string, chunkSize int, modelName string, source string) error {
+	splitter := documents.MarkdownSplitter{Splitter: documents.CharacterSplitter{ChunkSize: chunkSize}}
+	parents, _, err := splitter.Split(context.Background(), inputText, nil)
+	if err != nil {
+		return err
+	}
 
-	// Prepend the input tags to each chunk.
-	for i, chunk := range chunks {
-		chunks[i] = fmt.Sprintf("TAGS: [%s]\n%s", inputTags, chunk)
+	textID := documentID(source, strings.SplitN(inputText, "\n", 2)[0])
+	var sections []DocumentSection
+	var chunks []ChatTurnMessage
+	for _, parent := range parents {
+		section := DocumentSection{ID: vecstore.ChunkID(textID, parent.Text), DocumentID: textID, Headings: parent.Headings, Text: parent.Text}
+		sections = append(sections, section)
+
+		for i, chunk := range parent.Children {
+			// Prepend the input tags to each chunk. Chunks are numbered within their section, so
+			// indexing the same text again replaces them.
+			chunks = append(chunks, ChatTurnMessage{
+				ID:       fmt.Sprintf("%s-%d", section.ID, i),
+				Response: fmt.Sprintf("TAGS: [%s]\n%s", inputTags, chunk),
+				Headings: parent.Headings,
+				ParentID: section.ID,
+			})
+		}
+	}
+	if err := SaveDocumentSections(sqliteDB.db, sections); err != nil {
+		return err
+	}
+
+	// Web pages are kept with the chat memory they are searched for.
+	index, err := searchIndexes.Get(CollectionChat)
+	if err != nil {
+		return err
 	}
 
 	var wg sync.WaitGroup
@@ -1175,18 +2584,16 @@ func handleTextSplitAndIndex(inputTags string, inputText string, chunkSize int,
 	for _, chunk := range chunks {
 		wg.Add(1)
 
-		go func(c string) {
+		go func(doc ChatTurnMessage) {
 			defer wg.Done()
 
-			docID := fmt.Sprintf("%d", time.Now().UnixNano())
-			doc := ChatTurnMessage{
-				ID:       docID,
-				Prompt:   inputText,
-				Response: c,
-				Model:    modelName,
-			}
+			doc.Prompt = inputText
+			doc.Model = modelName
+			doc.Source = source
+			doc.Tags = parseSearchTags(inputTags)
+			doc.CreatedAt = time.Now()
 
-			if err := searchIndex.Index(docID, doc); err != nil {
+			if err := index.Index(doc.ID, doc); err != nil {
 				log.Errorf("Error indexing chunk in Bleve: %v", err)
 			}
 		}(chunk)
@@ -1197,46 +2604,6 @@ func handleTextSplitAndIndex(inputTags string, inputText string, chunkSize int,
 	return nil
 }
 
-// searchSimilarEmbeddings searches for similar embeddings in the database.
-func searchSimilarEmbeddings(config *AppConfig, modelName string, modelPath string, prompt string, topN int) []vecstore.Embedding {
-	db := vecstore.NewEmbeddingDB()
-	dbPath := fmt.Sprintf("%s/embeddings.db", config.DataPath)
-	embeddings, err := db.LoadEmbeddings(dbPath)
-	if err != nil {
-		fmt.Println("Error loading embeddings:", err)
-		return nil
-	}
-
-	model, err := tasks.Load[textencoding.Interface](&tasks.Config{ModelsDir: modelPath, ModelName: modelName})
-	if err != nil {
-		fmt.Println("Error loading model:", err)
-		return nil
-	}
-
-	var vec []float64
-	result, err := model.Encode(context.Background(), prompt, int(bert.MeanPooling))
-	if err != nil {
-		fmt.Println("Error encoding text:", err)
-		return nil
-	}
-	vec = result.Vector.Data().F64()[:128]
-
-	embeddingForPrompt := vecstore.Embedding{
-		Word:       prompt,
-		Vector:     vec,
-		Similarity: 0.0,
-	}
-
-	// Retrieve the top N similar embeddings
-	topEmbeddings := vecstore.FindTopNSimilarEmbeddings(embeddingForPrompt, embeddings, topN)
-	if len(topEmbeddings) == 0 {
-		fmt.Println("Error finding similar embeddings.")
-		return nil
-	}
-
-	return topEmbeddings
-}
-
 // ToolState represents the state of a tool.
 type ToolState struct {
 	Tool    string `json:"tool"`
@@ -0,0 +1,365 @@
+// ingestion.go - Background ingestion of uploaded documents
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+
+	"eternal/pkg/documents"
+	"eternal/pkg/embeddings"
+	"eternal/pkg/ingest"
+	"eternal/pkg/jobs"
+)
+
+// CollectionDocuments is the default vector collection for uploaded documents.
+const CollectionDocuments = "documents"
+
+// ingestJobType is the job type of document ingestion.
+const ingestJobType = "ingest"
+
+const (
+	ingestWorkers   = 2
+	ingestQueueSize = 100
+)
+
+// Document splitters selected by ChunkingConfig.
+const (
+	SplitterCharacters = "characters" // Chunks of a number of characters
+	SplitterTokens     = "tokens"     // Chunks of a number of embedding model tokens, split between sentences
+	SplitterSemantic   = "semantic"   // Chunks of related sentences, at most a number of tokens
+)
+
+// defaultTokenChunkSize is the chunk size of the token splitters for models without a
+// token limit.
+const defaultTokenChunkSize = 256
+
+// specialTokens is the number of tokens an encoder adds around every text, such as [CLS]
+// and [SEP], which tokenizers leave out of their counts.
+const specialTokens = 2
+
+// ChunkingConfig selects how ingested documents are split into chunks.
+type ChunkingConfig struct {
+	Splitter   string  `yaml:"splitter"`   // characters (default), tokens or semantic
+	ChunkSize  int     `yaml:"chunk_size"` // Characters or tokens per chunk; tokens default to the max_tokens of the embedding model
+	Overlap    int     `yaml:"overlap"`    // Characters or tokens repeated between chunks
+	Threshold  float64 `yaml:"threshold"`  // Similarity of adjacent sentences below which the semantic splitter starts a chunk
+	Percentile float64 `yaml:"percentile"` // Percentage of sentence gaps the semantic splitter breaks at when threshold is 0
+
+	HeadingLevel int `yaml:"heading_level"` // Deepest Markdown heading that starts a section, 3 by default
+	ParentSize   int `yaml:"parent_size"`   // Characters per parent section, 4000 by default
+}
+
+// Validate checks the splitter settings.
+func (c ChunkingConfig) Validate() error {
+	switch c.Splitter {
+	case "", SplitterCharacters, SplitterTokens, SplitterSemantic:
+	default:
+		return fmt.Errorf("unknown splitter %q", c.Splitter)
+	}
+	if c.ChunkSize < 0 || c.Overlap < 0 {
+		return fmt.Errorf("chunk_size and overlap cannot be negative")
+	}
+	if c.Threshold < -1 || c.Threshold > 1 {
+		return fmt.Errorf("threshold must be between -1 and 1")
+	}
+	if c.Percentile < 0 || c.Percentile > 100 {
+		return fmt.Errorf("percentile must be between 0 and 100")
+	}
+	if c.HeadingLevel < 0 || c.HeadingLevel > 6 {
+		return fmt.Errorf("heading_level must be between 1 and 6")
+	}
+	if c.ParentSize < 0 {
+		return fmt.Errorf("parent_size cannot be negative")
+	}
+	return nil
+}
+
+// newSplitter returns the token or semantic splitter of the chunking config for documents
+// embedded with the model, or nil to split by characters. Token chunks never exceed the
+// token limit of the model, and never reach the max_input_length where it cuts text.
+func newSplitter(config *AppConfig, model embeddings.ModelConfig, embedder embeddings.Embedder) (documents.Splitter, error) {
+	chunking := config.Chunking
+	if chunking.Splitter == "" || chunking.Splitter == SplitterCharacters {
+		return nil, nil
+	}
+	if err := chunking.Validate(); err != nil {
+		return nil, err
+	}
+
+	tokenizer, err := model.Tokenizer(embeddingModelsDir(config))
+	if err != nil {
+		return nil, err
+	}
+	limit := model.MaxTokens - specialTokens
+	size := chunking.ChunkSize
+	if size <= 0 {
+		size = limit
+	}
+	if size <= 0 {
+		size = defaultTokenChunkSize
+	}
+	if model.MaxTokens > 0 && size > limit {
+		size = max(limit, 1)
+	}
+	tokens := documents.TokenSplitter{Tokenizer: tokenizer, ChunkSize: size, Overlap: chunking.Overlap, MaxChars: model.MaxInputLength}
+
+	if chunking.Splitter == SplitterTokens {
+		return tokens, nil
+	}
+	return documents.SemanticSplitter{
+		Embedder:   embeddings.Batch(embedder),
+		Tokens:     tokens,
+		Threshold:  chunking.Threshold,
+		Percentile: chunking.Percentile,
+	}, nil
+}
+
+// documentID returns the stable ID of a file uploaded to a collection, so uploading a file
+// with the same name again replaces the earlier version.
+func documentID(collection string, name string) string {
+	sum := sha256.Sum256([]byte(collection + "\x00" + name))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ingestJobID returns the job ID used to ingest a document.
+func ingestJobID(docID string) string {
+	return ingestJobType + "-" + docID
+}
+
+// sectionStore keeps the parent sections of ingested chunks in the database.
+type sectionStore struct {
+	db *gorm.DB
+}
+
+// SaveParents replaces the sections of a document.
+func (s sectionStore) SaveParents(documentID string, parents []ingest.Parent) error {
+	sections := make([]DocumentSection, len(parents))
+	for i, parent := range parents {
+		sections[i] = DocumentSection{ID: parent.ID, DocumentID: documentID, Headings: parent.Headings, Page: parent.Page, Text: parent.Text}
+	}
+	return ReplaceDocumentSections(s.db, documentID, sections)
+}
+
+// DeleteParents deletes the sections of a document.
+func (s sectionStore) DeleteParents(documentID string) error {
+	return DeleteDocumentSections(s.db, documentID)
+}
+
+// bleveDocumentIndex writes document chunks to the search index as document messages.
+type bleveDocumentIndex struct {
+	index bleve.Index
+}
+
+// IndexChunks indexes every chunk in a single batch.
+func (b bleveDocumentIndex) IndexChunks(src ingest.Source, chunks []ingest.Chunk) error {
+	source := SourceDocument
+	if s, ok := src.Metadata["source"].(string); ok && s != "" {
+		source = s
+	}
+	link, _ := src.Metadata["url"].(string)
+
+	tags := []string{source}
+	if kb, ok := src.Metadata["knowledge_base"].(string); ok && kb != "" {
+		tags = append(tags, kb)
+	}
+
+	batch := b.index.NewBatch()
+	now := time.Now()
+	for _, chunk := range chunks {
+		doc := ChatTurnMessage{
+			ID:         chunk.ID,
+			DocumentID: chunk.DocumentID,
+			Page:       chunk.Page,
+			URL:        link,
+			Prompt:     src.Name,
+			Response:   chunk.Text,
+			Source:     source,
+			Tags:       tags,
+			Headings:   chunk.Headings,
+			ParentID:   chunk.ParentID,
+			CreatedAt:  now,
+		}
+		if err := batch.Index(doc.ID, doc); err != nil {
+			return err
+		}
+	}
+	return b.index.Batch(batch)
+}
+
+// DeleteDocument deletes every chunk of a document from the search index.
+func (b bleveDocumentIndex) DeleteDocument(docID string) error {
+	q := bleve.NewTermQuery(docID)
+	q.SetField("document_id")
+	_, err := b.deleteMatching(q)
+	return err
+}
+
+// DeletePrefix deletes the chunks of every document whose ID starts with the prefix.
+func (b bleveDocumentIndex) DeletePrefix(prefix string) error {
+	q := bleve.NewPrefixQuery(prefix)
+	q.SetField("document_id")
+	_, err := b.deleteMatching(q)
+	return err
+}
+
+// deleteMatching deletes the documents matching the query in batches and returns how many
+// were deleted.
+func (b bleveDocumentIndex) deleteMatching(q query.Query) (int, error) {
+	var deleted int
+	for {
+		res, err := b.index.Search(bleve.NewSearchRequestOptions(q, 1000, 0, false))
+		if err != nil {
+			return deleted, err
+		}
+		if len(res.Hits) == 0 {
+			return deleted, nil
+		}
+
+		batch := b.index.NewBatch()
+		for _, hit := range res.Hits {
+			batch.Delete(hit.ID)
+		}
+		if err := b.index.Batch(batch); err != nil {
+			return deleted, err
+		}
+		deleted += len(res.Hits)
+	}
+}
+
+// newIngestPipeline returns a pipeline that writes to the named vector collection and the
+// search index.
+func newIngestPipeline(config *AppConfig, collection string) (*ingest.Pipeline, error) {
+	store, err := vectorStores.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	embedder, err := collectionEmbedder(config, collection)
+	if err != nil {
+		return nil, err
+	}
+	model, err := config.Embeddings.ForCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+	splitter, err := newSplitter(config, model, embedder)
+	if err != nil {
+		return nil, err
+	}
+	index, err := searchIndexes.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	return &ingest.Pipeline{
+		Store:        store,
+		Embedder:     embedder,
+		Lexical:      bleveDocumentIndex{index: index},
+		ChunkSize:    config.Chunking.ChunkSize,
+		Overlap:      config.Chunking.Overlap,
+		Splitter:     splitter,
+		Parents:      sectionStore{db: sqliteDB.db},
+		HeadingLevel: config.Chunking.HeadingLevel,
+		ParentSize:   config.Chunking.ParentSize,
+	}, nil
+}
+
+// submitIngestion queues a job that ingests the document and records the outcome on it.
+func submitIngestion(config *AppConfig, queue *jobs.Queue, doc Document) error {
+	job := &jobs.Job{
+		ID:    ingestJobID(doc.ID),
+		Type:  ingestJobType,
+		Label: doc.Name,
+		Run: func(ctx context.Context, report func(jobs.Progress)) (any, error) {
+			UpdateDocumentStatus(sqliteDB.db, doc.ID, jobs.StateRunning, "")
+
+			result, err := runIngestion(ctx, config, doc, report)
+			if err != nil {
+				// Canceled ingestions are resumed on the next start.
+				state := jobs.StateFailed
+				if ctx.Err() != nil {
+					state = jobs.StateCanceled
+				}
+				UpdateDocumentStatus(sqliteDB.db, doc.ID, state, err.Error())
+				return nil, err
+			}
+
+			sqliteDB.db.Model(&Document{}).Where("id = ?", doc.ID).Updates(map[string]interface{}{
+				"status":     jobs.StateCompleted,
+				"error":      "",
+				"type":       result.Type,
+				"chunks":     result.Chunks,
+				"characters": result.Characters,
+			})
+			return result, nil
+		},
+	}
+
+	// Record the queued state first, since a worker can start the job as soon as it is
+	// submitted.
+	if err := UpdateDocumentStatus(sqliteDB.db, doc.ID, jobs.StateQueued, ""); err != nil {
+		return err
+	}
+	if err := queue.Submit(job); err != nil {
+		UpdateDocumentStatus(sqliteDB.db, doc.ID, jobs.StateFailed, err.Error())
+		return err
+	}
+	return nil
+}
+
+// runIngestion runs the ingestion pipeline for a document.
+func runIngestion(ctx context.Context, config *AppConfig, doc Document, report func(jobs.Progress)) (ingest.Result, error) {
+	pipeline, err := newIngestPipeline(config, doc.Collection)
+	if err != nil {
+		return ingest.Result{}, err
+	}
+
+	metadata := map[string]any{}
+	if doc.KnowledgeBase != "" {
+		metadata["knowledge_base"] = doc.KnowledgeBase
+	}
+
+	return pipeline.Ingest(ctx, ingest.Source{
+		DocumentID: doc.ID,
+		Path:       doc.Path,
+		Name:       doc.Name,
+		Metadata:   metadata,
+	}, report)
+}
+
+// resumeIngestion requeues documents and Git repositories whose ingestion was interrupted
+// by a shutdown.
+func resumeIngestion(config *AppConfig, queue *jobs.Queue) {
+	interrupted := []string{jobs.StateQueued, jobs.StateRunning, jobs.StateCanceled}
+
+	docs, err := ListDocumentsByStatus(sqliteDB.db, interrupted...)
+	if err != nil {
+		log.Errorf("Error listing interrupted documents: %v", err)
+	}
+	for _, doc := range docs {
+		if err := submitIngestion(config, queue, doc); err != nil {
+			log.Errorf("Error requeueing %s: %v", doc.Name, err)
+		}
+	}
+
+	repos, err := ListGitRepositories(sqliteDB.db)
+	if err != nil {
+		log.Errorf("Error listing Git repositories: %v", err)
+	}
+	for _, repo := range repos {
+		if !slices.Contains(interrupted, repo.Status) {
+			continue
+		}
+		if err := submitGitIndex(config, queue, repo); err != nil {
+			log.Errorf("Error requeueing %s: %v", repo.Name, err)
+		}
+	}
+}
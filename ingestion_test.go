@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/documents"
+	"eternal/pkg/embeddings"
+	"eternal/pkg/ingest"
+	"eternal/pkg/jobs"
+)
+
+func TestDocumentID(t *testing.T) {
+	assert.Equal(t, documentID("documents", "notes.md"), documentID("documents", "notes.md"))
+	assert.NotEqual(t, documentID("documents", "notes.md"), documentID("research", "notes.md"))
+	assert.Len(t, documentID("documents", "notes.md"), 16)
+}
+
+func TestSubmitIngestionQueueClosed(t *testing.T) {
+	withTestDB(t)
+	doc := Document{ID: "submit-doc", Name: "notes.md", Collection: CollectionDocuments, Status: jobs.StateCompleted}
+	require.NoError(t, db.Create(&doc).Error)
+	defer db.Delete(&Document{ID: doc.ID})
+
+	queue := jobs.NewQueue(1, 1)
+	queue.Close()
+	assert.ErrorIs(t, submitIngestion(&AppConfig{}, queue, doc), jobs.ErrQueueClosed)
+
+	var stored Document
+	require.NoError(t, db.First(&stored, "id = ?", doc.ID).Error)
+	assert.Equal(t, jobs.StateFailed, stored.Status)
+	assert.Equal(t, jobs.ErrQueueClosed.Error(), stored.Error)
+}
+
+func TestRemoveUploadKeepsSharedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Notes"), 0644))
+	require.NoError(t, db.Create(&Document{ID: "shared-a", Name: "notes.md", Path: path, Collection: CollectionDocuments}).Error)
+	require.NoError(t, db.Create(&Document{ID: "shared-b", Name: "notes.md", Path: path, Collection: "research"}).Error)
+	defer db.Delete(&Document{ID: "shared-b"})
+
+	require.NoError(t, DeleteDocument(db, "shared-a"))
+	require.NoError(t, removeUpload(db, path))
+	assert.FileExists(t, path, "still the source of shared-b")
+
+	require.NoError(t, DeleteDocument(db, "shared-b"))
+	require.NoError(t, removeUpload(db, path))
+	assert.NoFileExists(t, path)
+}
+
+func TestBleveDocumentIndex(t *testing.T) {
+	index, err := bleve.NewMemOnly(newSearchIndexMapping())
+	assert.NoError(t, err)
+	defer index.Close()
+
+	lexical := bleveDocumentIndex{index: index}
+	src := ingest.Source{DocumentID: "doc-1", Name: "notes.md", Metadata: map[string]any{"knowledge_base": "go"}}
+	assert.NoError(t, lexical.IndexChunks(src, []ingest.Chunk{
+		{ID: "chunk-1", DocumentID: "doc-1", Text: "Goroutines are lightweight threads."},
+		{ID: "chunk-2", DocumentID: "doc-1", Text: "Channels connect goroutines."},
+	}))
+	assert.NoError(t, lexical.IndexChunks(ingest.Source{DocumentID: "doc-2", Name: "other.md"}, []ingest.Chunk{
+		{ID: "chunk-3", DocumentID: "doc-2", Text: "Goroutines again."},
+	}))
+
+	count, err := index.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	// Chunks are searchable by knowledge base tag.
+	q := bleve.NewTermQuery("go")
+	q.SetField("tags")
+	res, err := index.Search(bleve.NewSearchRequest(q))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+
+	// Deleting a document leaves the chunks of other documents.
+	assert.NoError(t, lexical.DeleteDocument("doc-1"))
+	count, err = index.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestBleveDocumentIndexDeletePrefix(t *testing.T) {
+	index, err := bleve.NewMemOnly(newSearchIndexMapping())
+	assert.NoError(t, err)
+	defer index.Close()
+
+	lexical := bleveDocumentIndex{index: index}
+	for _, docID := range []string{"git-1-aaa", "git-1-bbb", "git-12-ccc"} {
+		src := ingest.Source{DocumentID: docID, Name: docID, Metadata: map[string]any{"source": SourceGit, "url": "https://example.com/" + docID}}
+		assert.NoError(t, lexical.IndexChunks(src, []ingest.Chunk{{ID: docID + "-0", DocumentID: docID, Text: "package main"}}))
+	}
+
+	doc, err := index.Document("git-1-aaa-0")
+	assert.NoError(t, err)
+	assert.NotNil(t, doc)
+
+	assert.NoError(t, lexical.DeletePrefix(gitDocumentPrefix(1)))
+	count, err := index.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestNewSplitter(t *testing.T) {
+	config := &AppConfig{DataPath: t.TempDir()}
+	model := embeddings.ModelConfig{Name: "openai-small", Provider: embeddings.ProviderOpenAI, Model: "text-embedding-3-small", Dimensions: 1536, MaxTokens: 8191, MaxInputLength: 20000}
+
+	splitter, err := newSplitter(config, model, nil)
+	require.NoError(t, err)
+	assert.Nil(t, splitter, "characters are split by the pipeline")
+
+	config.Chunking = ChunkingConfig{Splitter: SplitterTokens, ChunkSize: 10000, Overlap: 20}
+	splitter, err = newSplitter(config, model, nil)
+	require.NoError(t, err)
+	assert.Equal(t, documents.TokenSplitter{Tokenizer: documents.ApproxTokenizer{}, ChunkSize: 8189, Overlap: 20, MaxChars: 20000}, splitter, "chunks fit the model")
+
+	config.Chunking = ChunkingConfig{Splitter: SplitterSemantic, Percentile: 20}
+	splitter, err = newSplitter(config, model, nil)
+	require.NoError(t, err)
+	semantic, ok := splitter.(documents.SemanticSplitter)
+	require.True(t, ok)
+	assert.Equal(t, 8189, semantic.Tokens.ChunkSize, "the special tokens are reserved")
+	assert.Equal(t, 20.0, semantic.Percentile)
+
+	config.Chunking = ChunkingConfig{Splitter: "paragraphs"}
+	assert.Error(t, config.Chunking.Validate())
+	_, err = newSplitter(config, model, nil)
+	assert.Error(t, err)
+
+	// Local models need the vocabulary downloaded with the model.
+	config.Chunking = ChunkingConfig{Splitter: SplitterTokens}
+	_, err = newSplitter(config, embeddings.DefaultModel, nil)
+	assert.Error(t, err)
+}
@@ -6,18 +6,20 @@ import (
 	"context"
 	"embed"
 	"errors"
+	"eternal/pkg/jobs"
 	"eternal/pkg/llm"
 	"eternal/pkg/sd"
+	"eternal/pkg/vecstore"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
-	"github.com/blevesearch/bleve/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -34,20 +36,33 @@ import (
 var embedfs embed.FS
 
 var (
-	devMode     bool     // If enabled, removes the database and search index on shutdown
-	osFS        afero.Fs = afero.NewOsFs()
-	chatTurn             = 1
-	sqliteDB    *SQLiteDB
-	searchIndex bleve.Index
+	devMode       bool     // If enabled, removes the database and search index on shutdown
+	osFS          afero.Fs = afero.NewOsFs()
+	sqliteDB      *SQLiteDB
+	searchIndexes *SearchIndexes
+	vectorStores  *vecstore.Collections
+	sessionStates *SessionRegistry
+	ingestQueue   *jobs.Queue
 )
 
+// CollectionChat is the vector collection that holds chat memory.
+const CollectionChat = "chat"
+
 // WebSocketMessage represents the structure of a WebSocket message
 type WebSocketMessage struct {
 	ChatMessage string                 `json:"chat_message"`
 	Model       string                 `json:"model"`
+	SessionID   string                 `json:"session_id"`
+	TurnID      string                 `json:"turn_id"`
 	Headers     map[string]interface{} `json:"HEADERS"`
 }
 
+// Turn returns the frontend turn number the message belongs to.
+func (m WebSocketMessage) Turn() int {
+	turn, _ := strconv.Atoi(m.TurnID)
+	return turn
+}
+
 // Tool represents a tool with its name and enabled status
 type Tool struct {
 	Name    string `json:"name"`
@@ -87,6 +102,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Subcommands work on the data path without starting the server.
+	switch flag.Arg(0) {
+	case "eval":
+		if err := runEvalCommand(config, flag.Args()[1:]); err != nil {
+			pterm.Error.Println("Error running evaluation:", err)
+			os.Exit(1)
+		}
+		return
+	case "backup":
+		if err := runBackupCommand(config, flag.Args()[1:]); err != nil {
+			pterm.Error.Println("Error taking snapshot:", err)
+			os.Exit(1)
+		}
+		return
+	case "restore":
+		if err := runRestoreCommand(config, flag.Args()[1:]); err != nil {
+			pterm.Error.Println("Error restoring snapshot:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := config.Backup.Validate(); err != nil {
+		pterm.Error.Println("Invalid backup config:", err)
+		os.Exit(1)
+	}
+
 	// Initialize tools based on config
 	tools := initializeTools(config)
 
@@ -115,12 +157,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Seed the assistant roles on first run and the memory policies of sources without one.
+	if err := SeedAssistantRoles(sqliteDB.db, config.AssistantRoles); err != nil {
+		pterm.Error.Println("Failed to seed assistant roles:", err)
+	}
+	if err := SeedMemoryPolicies(sqliteDB.db, config.Memory.Policies); err != nil {
+		pterm.Error.Println("Failed to seed memory policies:", err)
+	}
+
+	// Per-session chat state starts from the tool defaults in the config.
+	sessionStates = NewSessionRegistry(config, sqliteDB.db)
+
 	// Initialize search index
 	if err := initializeSearchIndex(config.DataPath); err != nil {
 		pterm.Error.Println("Failed to initialize search index:", err)
 		os.Exit(1)
 	}
 
+	// Initialize vector store
+	if err := initializeVectorStore(config); err != nil {
+		pterm.Error.Println("Failed to initialize vector store:", err)
+		os.Exit(1)
+	}
+
+	// Documents are ingested in the background; pick up any left from the last run.
+	ingestQueue = jobs.NewQueue(ingestWorkers, ingestQueueSize)
+	resumeIngestion(config, ingestQueue)
+	reembedLegacyCollections(config, ingestQueue)
+
 	// Load model parameters
 	modelParams, err := loadModelParams(config)
 	if err != nil {
@@ -219,25 +283,95 @@ func initializeDatabase(dataPath string) error {
 		return err
 	}
 
-	return sqliteDB.AutoMigrate(&Project{}, &ModelParams{}, &ImageModel{}, &SelectedModels{}, &Chat{}, &URLTracking{})
+	return sqliteDB.AutoMigrate(&Project{}, &ModelParams{}, &ImageModel{}, &SelectedModels{}, &Chat{}, &ChatSession{}, &ChatTurn{}, &ChatResponse{}, &AssistantRole{}, &Prompt{}, &PromptVersion{}, &Document{}, &GitRepository{}, &MemoryPolicy{}, &DocumentSection{}, &EvalRun{}, &URLTracking{})
 }
 
-// initializeSearchIndex initializes the search index
+// initializeSearchIndex opens the search index of each collection and moves the documents
+// of the single index of earlier versions into them
 func initializeSearchIndex(dataPath string) error {
-	searchDB := fmt.Sprintf("%s/search.bleve", dataPath)
+	var err error
+	searchIndexes, err = OpenSearchIndexes(filepath.Join(dataPath, searchIndexDir), newSearchIndexMapping())
+	if err != nil {
+		return err
+	}
+	return migrateSearchIndex(dataPath, searchIndexes)
+}
+
+// initializeVectorStore opens the vector store and migrates the legacy JSON embeddings file
+func initializeVectorStore(config *AppConfig) error {
+	dataPath := config.DataPath
+
+	if err := config.Embeddings.Validate(); err != nil {
+		return fmt.Errorf("invalid embeddings config: %v", err)
+	}
+	if err := config.Chunking.Validate(); err != nil {
+		return fmt.Errorf("invalid chunking config: %v", err)
+	}
+	if err := config.Retrieval.QueryRewrite.Validate(); err != nil {
+		return fmt.Errorf("invalid query rewrite config: %v", err)
+	}
+
+	opts := vecstore.Options{Quantization: config.VectorStore.Quantization, Rescore: config.VectorStore.Rescore}
+	if config.VectorStore.Index == "hnsw" {
+		opts.HNSW = &config.VectorStore.HNSW
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid vector store config: %v", err)
+	}
+
+	var err error
+	vectorStores, err = vecstore.OpenCollections(filepath.Join(dataPath, "vectors"), opts)
+	if err != nil {
+		return err
+	}
 
-	if _, err := os.Stat(searchDB); os.IsNotExist(err) {
-		mapping := bleve.NewIndexMapping()
-		searchIndex, err = bleve.New(searchDB, mapping)
+	// Stores from before collections existed hold chat memory.
+	if _, err := vectorStores.MoveSegments(CollectionChat); err != nil {
+		return err
+	}
+
+	chatMemory, err := vectorStores.Get(CollectionChat)
+	if err != nil {
+		return err
+	}
+
+	migrated, err := vecstore.MigrateJSON(chatMemory, filepath.Join(dataPath, "embeddings.db"))
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		pterm.Info.Printf("Migrated %d embeddings to the vector store\n", migrated)
+	}
+
+	// Reclaim space once most records on disk are overwritten or deleted.
+	names, err := vectorStores.Names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		store, err := vectorStores.Get(name)
 		if err != nil {
 			return err
 		}
-	} else {
-		searchIndex, err = bleve.Open(searchDB)
+		if stats := store.Stats(); stats.Dead > stats.Live {
+			pterm.Info.Printf("Compacting vector collection %s (%d live, %d dead records)\n", name, stats.Live, stats.Dead)
+			if err := store.Compact(); err != nil {
+				return err
+			}
+		}
+
+		// Collections embedded with another model are not searched by embedding until they
+		// are embedded again. Collections from before models were recorded are embedded
+		// again once the job queue starts.
+		model, err := config.Embeddings.ForCollection(name)
 		if err != nil {
 			return err
 		}
+		if err := vectorStores.SetModel(name, model.CollectionModel()); err != nil {
+			pterm.Warning.Printf("Vector collection %s: %v; embed it again with POST /collections/%s/reembed\n", name, err, name)
+		}
 	}
+
 	return nil
 }
 
@@ -344,13 +478,37 @@ func runFrontendServer(ctx context.Context, config *AppConfig, modelParams []Mod
 	// Setup routes
 	setupRoutes(app, config, modelParams)
 
+	// Expire memories past the TTL of their source until shutdown.
+	startMemoryExpiry(ctx)
+
+	// Take scheduled snapshots of the data path until shutdown.
+	startBackupSchedule(ctx, config)
+
 	// Handle graceful shutdown
 	go func() {
 		<-ctx.Done() // Wait for the context to be cancelled
 
+		// Stop ingestion before closing the indexes it writes to.
+		ingestQueue.Close()
+
+		// Let a running snapshot finish, and keep new ones from starting.
+		backupMu.Lock()
+
+		if err := searchIndexes.Close(); err != nil {
+			pterm.Error.Println("Failed to close search indexes:", err)
+		}
+
+		// Closing the vector store saves its search index.
+		if err := vectorStores.Close(); err != nil {
+			pterm.Error.Println("Failed to close vector store:", err)
+		}
+		if err := closeEmbedders(); err != nil {
+			pterm.Error.Println("Failed to close embedding cache:", err)
+		}
+
 		if devMode {
 			// delete the search index and database
-			if err := os.RemoveAll(filepath.Join(config.DataPath, "search.bleve")); err != nil {
+			if err := os.RemoveAll(filepath.Join(config.DataPath, searchIndexDir)); err != nil {
 				log.Fatalf("Failed to delete search index: %v", err)
 			}
 
@@ -358,6 +516,10 @@ func runFrontendServer(ctx context.Context, config *AppConfig, modelParams []Mod
 				log.Fatalf("Failed to delete database: %v", err)
 			}
 
+			if err := os.RemoveAll(filepath.Join(config.DataPath, "vectors")); err != nil {
+				log.Fatalf("Failed to delete vector store: %v", err)
+			}
+
 			// Loop through the config models and delete the cache
 			for _, model := range modelParams {
 				if model.Downloaded {
@@ -0,0 +1,689 @@
+// memory.go - Browsing, pinning, forgetting and expiring memories
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/gofiber/fiber/v2/log"
+
+	"eternal/pkg/retrieval"
+	"eternal/pkg/vecstore"
+)
+
+// MemoryIndex names the Bleve index among the stores that hold memories. The other stores
+// are the vector collections.
+const MemoryIndex = "index"
+
+// memoryExpiryInterval is how often expired memories are deleted.
+const memoryExpiryInterval = time.Hour
+
+// MemoryEntry is a chat turn, chunk or embedding kept by the memory tool.
+type MemoryEntry struct {
+	ID            string    `json:"id"`
+	Store         string    `json:"store"` // MemoryIndex or the vector collection
+	Source        string    `json:"source"`
+	Title         string    `json:"title,omitempty"`
+	Text          string    `json:"text"`
+	Model         string    `json:"model,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	KnowledgeBase string    `json:"knowledge_base,omitempty"`
+	SessionID     int64     `json:"session_id,omitempty"`
+	TurnID        int64     `json:"turn_id,omitempty"`
+	DocumentID    string    `json:"document_id,omitempty"`
+	URL           string    `json:"url,omitempty"`
+	Headings      string    `json:"headings,omitempty"`
+	Pinned        bool      `json:"pinned"`
+	CreatedAt     time.Time `json:"created_at"`
+	Score         float64   `json:"score,omitempty"` // Set when listing by a query
+}
+
+// MemoryQuery selects the memories of a store to list.
+type MemoryQuery struct {
+	Store  string
+	Query  string // Keywords for the index, a semantic query for a collection
+	Source string
+	Pinned *bool
+	Page   int
+	Size   int
+}
+
+// MemoryPage is a page of memories.
+type MemoryPage struct {
+	Store   string        `json:"store"`
+	Total   int           `json:"total"`
+	Page    int           `json:"page"`
+	Size    int           `json:"size"`
+	Entries []MemoryEntry `json:"entries"`
+}
+
+// MemoryRemoval counts the memories removed from each kind of store.
+type MemoryRemoval struct {
+	Index   int `json:"index"`
+	Vectors int `json:"vectors"`
+}
+
+// normalize applies the default page and size.
+func (q *MemoryQuery) normalize() {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Size < 1 {
+		q.Size = defaultSearchPageSize
+	}
+	if q.Size > maxSearchPageSize {
+		q.Size = maxSearchPageSize
+	}
+}
+
+// durations parses the TTL and half-life of a policy. Zero durations disable them.
+func (p MemoryPolicy) durations() (ttl time.Duration, halfLife time.Duration, err error) {
+	if p.TTL != "" {
+		if ttl, err = time.ParseDuration(p.TTL); err != nil || ttl <= 0 {
+			return 0, 0, fmt.Errorf("invalid ttl %q", p.TTL)
+		}
+	}
+	if p.HalfLife != "" {
+		if halfLife, err = time.ParseDuration(p.HalfLife); err != nil || halfLife <= 0 {
+			return 0, 0, fmt.Errorf("invalid half_life %q", p.HalfLife)
+		}
+	}
+	return ttl, halfLife, nil
+}
+
+// listMemory returns a page of the memories of a store, most recent first unless a query
+// orders them by relevance.
+func listMemory(ctx context.Context, config *AppConfig, q MemoryQuery) (MemoryPage, error) {
+	q.normalize()
+	if q.Store == MemoryIndex {
+		return listIndexMemory(ctx, searchIndexes.All(), q)
+	}
+	return listVectorMemory(ctx, config, q)
+}
+
+// listIndexMemory lists the chat turns and chunks in the Bleve index.
+func listIndexMemory(ctx context.Context, index bleve.Index, q MemoryQuery) (MemoryPage, error) {
+	page := MemoryPage{Store: MemoryIndex, Page: q.Page, Size: q.Size}
+
+	queries := []query.Query{bleve.NewMatchAllQuery()}
+	if strings.TrimSpace(q.Query) != "" {
+		queries[0] = bleve.NewMatchQuery(q.Query)
+	}
+	if q.Source != "" {
+		sourceQuery := bleve.NewTermQuery(q.Source)
+		sourceQuery.SetField("source")
+		queries = append(queries, sourceQuery)
+	}
+
+	var mustNot []query.Query
+	if q.Pinned != nil {
+		if *q.Pinned {
+			queries = append(queries, pinnedQuery())
+		} else {
+			mustNot = append(mustNot, pinnedQuery())
+		}
+	}
+
+	boolQuery := bleve.NewBooleanQuery()
+	boolQuery.AddMust(queries...)
+	boolQuery.AddMustNot(mustNot...)
+
+	req := bleve.NewSearchRequestOptions(boolQuery, q.Size, (q.Page-1)*q.Size, false)
+	req.Fields = []string{"*"}
+	if strings.TrimSpace(q.Query) == "" {
+		req.SortBy([]string{"-created_at", "_id"})
+	}
+
+	res, err := index.SearchInContext(ctx, req)
+	if err != nil {
+		return page, err
+	}
+
+	page.Total = int(res.Total)
+	page.Entries = make([]MemoryEntry, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		entry := indexMemoryEntry(messageFromFields(hit.ID, hit.Fields))
+		if strings.TrimSpace(q.Query) != "" {
+			entry.Score = hit.Score
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// listVectorMemory lists the records of a vector collection. A query searches the
+// collection by meaning with the embedding model of the collection.
+func listVectorMemory(ctx context.Context, config *AppConfig, q MemoryQuery) (MemoryPage, error) {
+	page := MemoryPage{Store: q.Store, Page: q.Page, Size: q.Size}
+
+	store, err := existingCollection(q.Store)
+	if err != nil {
+		return page, err
+	}
+
+	var filter []vecstore.Condition
+	if q.Source != "" {
+		filter = append(filter, vecstore.Eq("source", q.Source))
+	}
+	if q.Pinned != nil && *q.Pinned {
+		filter = append(filter, vecstore.Eq("pinned", true))
+	}
+	keep := func(metadata map[string]any) bool {
+		return q.Pinned == nil || *q.Pinned || metadata["pinned"] != true
+	}
+
+	matches, err := store.Metadata(filter...)
+	if err != nil {
+		return page, err
+	}
+	ids := make([]string, 0, len(matches))
+	for id, metadata := range matches {
+		if keep(metadata) {
+			ids = append(ids, id)
+		}
+	}
+	page.Total = len(ids)
+
+	if strings.TrimSpace(q.Query) != "" {
+		embedder, err := collectionEmbedder(config, q.Store)
+		if err != nil {
+			return page, err
+		}
+		vector, err := embedder.Embed(ctx, q.Query)
+		if err != nil {
+			return page, err
+		}
+		// Every record ranks against the query, so the records dropped after the search
+		// are fetched on top of the page to keep it full.
+		results, err := store.Search(vector, q.Page*q.Size+len(matches)-len(ids), filter...)
+		if err != nil {
+			return page, err
+		}
+
+		var entries []MemoryEntry
+		for _, res := range results {
+			if keep(res.Metadata) {
+				entry := vectorMemoryEntry(q.Store, res.Record)
+				entry.Score = res.Score
+				entries = append(entries, entry)
+			}
+		}
+		page.Entries = pageOf(entries, q.Page, q.Size)
+		return page, nil
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := metadataInt(matches[ids[i]], "created_at"), metadataInt(matches[ids[j]], "created_at")
+		if a != b {
+			return a > b
+		}
+		return ids[i] < ids[j]
+	})
+
+	page.Entries = []MemoryEntry{}
+	for _, id := range pageOf(ids, q.Page, q.Size) {
+		record, ok, err := store.Get(id)
+		if err != nil {
+			return page, err
+		}
+		if ok {
+			page.Entries = append(page.Entries, vectorMemoryEntry(q.Store, record))
+		}
+	}
+	return page, nil
+}
+
+// pageOf returns the items on a page.
+func pageOf[T any](items []T, page int, size int) []T {
+	start := (page - 1) * size
+	if start >= len(items) {
+		return []T{}
+	}
+	return items[start:min(start+size, len(items))]
+}
+
+// existingCollection returns a vector collection without creating it.
+func existingCollection(name string) (*vecstore.Store, error) {
+	names, err := vectorStores.Names()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		if n == name {
+			return vectorStores.Get(name)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errMemoryNotFound, name)
+}
+
+// errMemoryNotFound is returned for memories and stores that do not exist.
+var errMemoryNotFound = errors.New("memory not found")
+
+// getMemory returns a memory by store and ID.
+func getMemory(storeName string, id string) (MemoryEntry, error) {
+	if storeName == MemoryIndex {
+		msg, err := loadIndexMessage(searchIndexes.All(), id)
+		if err != nil {
+			return MemoryEntry{}, err
+		}
+		return indexMemoryEntry(msg), nil
+	}
+
+	store, err := existingCollection(storeName)
+	if err != nil {
+		return MemoryEntry{}, err
+	}
+	record, ok, err := store.Get(id)
+	if err != nil {
+		return MemoryEntry{}, err
+	}
+	if !ok {
+		return MemoryEntry{}, errMemoryNotFound
+	}
+	return vectorMemoryEntry(storeName, record), nil
+}
+
+// pinMemory pins or unpins a memory. Pinned memories are never expired or decayed.
+func pinMemory(storeName string, id string, pinned bool) (MemoryEntry, error) {
+	if storeName == MemoryIndex {
+		index, err := searchIndexes.Locate(id)
+		if err != nil {
+			return MemoryEntry{}, err
+		}
+		msg, err := loadIndexMessage(index, id)
+		if err != nil {
+			return MemoryEntry{}, err
+		}
+		msg.Pinned = pinned
+		if err := index.Index(msg.ID, msg); err != nil {
+			return MemoryEntry{}, err
+		}
+		return indexMemoryEntry(msg), nil
+	}
+
+	store, err := existingCollection(storeName)
+	if err != nil {
+		return MemoryEntry{}, err
+	}
+	record, ok, err := store.Get(id)
+	if err != nil {
+		return MemoryEntry{}, err
+	}
+	if !ok {
+		return MemoryEntry{}, errMemoryNotFound
+	}
+
+	metadata := make(map[string]any, len(record.Metadata)+1)
+	for k, v := range record.Metadata {
+		metadata[k] = v
+	}
+	if pinned {
+		metadata["pinned"] = true
+	} else {
+		delete(metadata, "pinned")
+	}
+	record.Metadata = metadata
+	if err := store.Put(record); err != nil {
+		return MemoryEntry{}, err
+	}
+	return vectorMemoryEntry(storeName, record), nil
+}
+
+// forgetMemory deletes a memory from the index and the vector collections. Document chunks
+// have the same ID in both, and chat memory embedded from an indexed turn records the IDs
+// it came from, so forgetting either removes the other.
+func forgetMemory(storeName string, id string) (MemoryRemoval, error) {
+	var removal MemoryRemoval
+
+	if _, err := getMemory(storeName, id); err != nil {
+		return removal, err
+	}
+
+	if index, err := searchIndexes.Locate(id); err == nil {
+		if err := index.Delete(id); err != nil {
+			return removal, err
+		}
+		removal.Index++
+	}
+
+	names, err := vectorStores.Names()
+	if err != nil {
+		return removal, err
+	}
+	for _, name := range names {
+		store, err := vectorStores.Get(name)
+		if err != nil {
+			return removal, err
+		}
+		matches, err := store.Metadata()
+		if err != nil {
+			return removal, err
+		}
+
+		var ids []string
+		for recordID, metadata := range matches {
+			if recordID == id || (storeName == MemoryIndex && containsString(metadata["memory_ids"], id)) {
+				ids = append(ids, recordID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if err := store.Delete(ids...); err != nil {
+			return removal, err
+		}
+		removal.Vectors += len(ids)
+	}
+	return removal, nil
+}
+
+// containsString reports whether a metadata list holds the value.
+func containsString(list any, value string) bool {
+	values, _ := list.([]any)
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetMemorySource deletes every memory of a source from the index and the vector
+// collections. Pinned memories are kept unless includePinned is set.
+func forgetMemorySource(source string, includePinned bool) (MemoryRemoval, error) {
+	return removeMemories(source, time.Time{}, includePinned)
+}
+
+// removeMemories deletes the memories of a source created before a time, or all of them
+// when the time is zero.
+func removeMemories(source string, before time.Time, includePinned bool) (MemoryRemoval, error) {
+	var removal MemoryRemoval
+
+	sourceQuery := bleve.NewTermQuery(source)
+	sourceQuery.SetField("source")
+	q := bleve.NewBooleanQuery()
+	q.AddMust(sourceQuery)
+	if !before.IsZero() {
+		dateQuery := bleve.NewDateRangeQuery(time.Time{}, before)
+		dateQuery.SetField("created_at")
+		q.AddMust(dateQuery)
+	}
+	if !includePinned {
+		q.AddMustNot(pinnedQuery())
+	}
+	err := searchIndexes.Each(func(name string, index bleve.Index) error {
+		n, err := bleveDocumentIndex{index: index}.deleteMatching(q)
+		removal.Index += n
+		return err
+	})
+	if err != nil {
+		return removal, err
+	}
+
+	conditions := []vecstore.Condition{vecstore.Eq("source", source)}
+	if !before.IsZero() {
+		conditions = append(conditions, vecstore.Lt("created_at", before))
+	}
+
+	names, err := vectorStores.Names()
+	if err != nil {
+		return removal, err
+	}
+	for _, name := range names {
+		store, err := vectorStores.Get(name)
+		if err != nil {
+			return removal, err
+		}
+		matches, err := store.Metadata(conditions...)
+		if err != nil {
+			return removal, err
+		}
+
+		var ids []string
+		for id, metadata := range matches {
+			if includePinned || metadata["pinned"] != true {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if err := store.Delete(ids...); err != nil {
+			return removal, err
+		}
+		removal.Vectors += len(ids)
+	}
+	return removal, nil
+}
+
+// expireMemories deletes the unpinned memories older than the TTL of their source.
+func expireMemories(now time.Time) (MemoryRemoval, error) {
+	var total MemoryRemoval
+
+	policies, err := ListMemoryPolicies(sqliteDB.db)
+	if err != nil {
+		return total, err
+	}
+	for _, policy := range policies {
+		ttl, _, err := policy.durations()
+		if err != nil {
+			log.Errorf("Skipping memory policy of %s: %v", policy.Source, err)
+			continue
+		}
+		if ttl == 0 {
+			continue
+		}
+
+		removal, err := removeMemories(policy.Source, now.Add(-ttl), false)
+		total.Index += removal.Index
+		total.Vectors += removal.Vectors
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// startMemoryExpiry deletes expired memories now and then every memoryExpiryInterval until
+// the context is canceled.
+func startMemoryExpiry(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(memoryExpiryInterval)
+		defer ticker.Stop()
+		for {
+			removal, err := expireMemories(time.Now())
+			if err != nil {
+				log.Errorf("Error expiring memories: %v", err)
+			} else if removal.Index > 0 || removal.Vectors > 0 {
+				log.Infof("Expired %d indexed and %d embedded memories", removal.Index, removal.Vectors)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// memoryDecay lowers the scores of retrieved memories by their age, halving them every
+// half-life of their source. Pinned memories keep their score.
+type memoryDecay struct {
+	halfLives map[string]time.Duration
+	now       time.Time
+}
+
+// loadMemoryDecay returns the decay of the sources with a half-life.
+func loadMemoryDecay(now time.Time) memoryDecay {
+	decay := memoryDecay{halfLives: make(map[string]time.Duration), now: now}
+
+	policies, err := ListMemoryPolicies(sqliteDB.db)
+	if err != nil {
+		log.Errorf("Error loading memory policies: %v", err)
+		return decay
+	}
+	for _, policy := range policies {
+		if _, halfLife, err := policy.durations(); err == nil && halfLife > 0 {
+			decay.halfLives[policy.Source] = halfLife
+		}
+	}
+	return decay
+}
+
+// apply decays the scores of the hits and sorts them by the decayed score.
+func (d memoryDecay) apply(hits []retrieval.Hit) []retrieval.Hit {
+	if len(d.halfLives) == 0 {
+		return hits
+	}
+
+	for i, hit := range hits {
+		if hit.Metadata["pinned"] == true {
+			continue
+		}
+		source := metadataString(hit.Metadata, "source")
+		if source == "" {
+			source = SourceChat
+		}
+		halfLife, ok := d.halfLives[source]
+		if !ok {
+			continue
+		}
+		created, ok := metadataTime(hit.Metadata, "created_at")
+		if !ok {
+			continue
+		}
+		if age := d.now.Sub(created); age > 0 {
+			hits[i].Score = hit.Score * math.Pow(0.5, float64(age)/float64(halfLife))
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	return hits
+}
+
+// metadataTime returns a timestamp stored as Unix seconds by the vector store or as RFC
+// 3339 by the Bleve index.
+func metadataTime(metadata map[string]any, key string) (time.Time, bool) {
+	switch v := metadata[key].(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	case nil:
+		return time.Time{}, false
+	}
+	if n := metadataInt(metadata, key); n > 0 {
+		return time.Unix(n, 0), true
+	}
+	return time.Time{}, false
+}
+
+// pinnedQuery matches pinned messages in the Bleve index.
+func pinnedQuery() query.Query {
+	q := bleve.NewBoolFieldQuery(true)
+	q.SetField("pinned")
+	return q
+}
+
+// loadIndexMessage reads a message back from the fields stored in the Bleve index.
+func loadIndexMessage(index bleve.Index, id string) (ChatTurnMessage, error) {
+	req := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{id}))
+	req.Fields = []string{"*"}
+	res, err := index.Search(req)
+	if err != nil {
+		return ChatTurnMessage{}, err
+	}
+	if len(res.Hits) == 0 {
+		return ChatTurnMessage{}, errMemoryNotFound
+	}
+	return messageFromFields(id, res.Hits[0].Fields), nil
+}
+
+// messageFromFields converts the stored fields of a Bleve document into a message.
+func messageFromFields(id string, fields map[string]interface{}) ChatTurnMessage {
+	msg := ChatTurnMessage{
+		ID:         id,
+		SessionID:  metadataInt(fields, "session_id"),
+		TurnID:     metadataInt(fields, "turn_id"),
+		DocumentID: metadataString(fields, "document_id"),
+		Page:       int(metadataInt(fields, "page")),
+		URL:        metadataString(fields, "url"),
+		Prompt:     metadataString(fields, "prompt"),
+		Response:   metadataString(fields, "response"),
+		Model:      metadataString(fields, "model"),
+		Source:     metadataString(fields, "source"),
+		Tags:       fieldStrings(fields["tags"]),
+		Headings:   metadataString(fields, "headings"),
+		ParentID:   metadataString(fields, "parent_id"),
+	}
+	msg.Pinned, _ = fields["pinned"].(bool)
+	msg.CreatedAt, _ = metadataTime(fields, "created_at")
+	return msg
+}
+
+// fieldStrings returns the values of a multi-valued field, which Bleve returns as a slice,
+// or as a string when there is one value.
+func fieldStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			out = append(out, fmt.Sprint(s))
+		}
+		return out
+	}
+	return nil
+}
+
+// indexMemoryEntry converts a message in the Bleve index into a memory entry.
+func indexMemoryEntry(msg ChatTurnMessage) MemoryEntry {
+	entry := MemoryEntry{
+		ID:         msg.ID,
+		Store:      MemoryIndex,
+		Source:     msg.Source,
+		Text:       msg.Response,
+		Model:      msg.Model,
+		Tags:       msg.Tags,
+		SessionID:  msg.SessionID,
+		TurnID:     msg.TurnID,
+		DocumentID: msg.DocumentID,
+		URL:        msg.URL,
+		Headings:   msg.Headings,
+		Pinned:     msg.Pinned,
+		CreatedAt:  msg.CreatedAt,
+	}
+	if msg.Source != SourceWeb {
+		entry.Title = excerptTitle(msg.Prompt)
+	}
+	return entry
+}
+
+// vectorMemoryEntry converts a record of a vector collection into a memory entry.
+func vectorMemoryEntry(collection string, record vecstore.Record) MemoryEntry {
+	entry := MemoryEntry{
+		ID:            record.ID,
+		Store:         collection,
+		Source:        metadataString(record.Metadata, "source"),
+		Title:         metadataString(record.Metadata, "title"),
+		Text:          record.Text,
+		KnowledgeBase: metadataString(record.Metadata, "knowledge_base"),
+		SessionID:     metadataInt(record.Metadata, "session_id"),
+		TurnID:        metadataInt(record.Metadata, "turn_id"),
+		DocumentID:    metadataString(record.Metadata, "document_id"),
+		URL:           metadataString(record.Metadata, "url"),
+		Headings:      metadataString(record.Metadata, "headings"),
+	}
+	entry.Pinned, _ = record.Metadata["pinned"].(bool)
+	entry.CreatedAt, _ = metadataTime(record.Metadata, "created_at")
+	return entry
+}
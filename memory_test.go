@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/embeddings"
+	"eternal/pkg/retrieval"
+	"eternal/pkg/vecstore"
+)
+
+// useTestMemoryStores points the global indexes and vector collections at test instances,
+// with the test documents in the chat index.
+func useTestMemoryStores(t *testing.T, now time.Time) *vecstore.Collections {
+	indexes, err := OpenSearchIndexes(t.TempDir(), newSearchIndexMapping())
+	require.NoError(t, err)
+	index, err := indexes.Get(CollectionChat)
+	require.NoError(t, err)
+	indexTestSearchDocuments(t, index, now)
+
+	collections, err := vecstore.OpenCollections(t.TempDir(), vecstore.Options{})
+	require.NoError(t, err)
+
+	oldIndexes, oldStores := searchIndexes, vectorStores
+	searchIndexes, vectorStores = indexes, collections
+	t.Cleanup(func() {
+		searchIndexes, vectorStores = oldIndexes, oldStores
+		collections.Close()
+		indexes.Close()
+	})
+	return collections
+}
+
+func TestListIndexMemory(t *testing.T) {
+	now := time.Now()
+	useTestMemoryStores(t, now)
+
+	page, err := listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: MemoryIndex, Size: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 4, page.Total)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "doc-3", page.Entries[0].ID, "newest first")
+	assert.Equal(t, SourceWeb, page.Entries[0].Source)
+	assert.Equal(t, []string{"web", "go"}, page.Entries[0].Tags)
+
+	page, err = listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: MemoryIndex, Query: "channels", Source: SourceChat})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "doc-1", page.Entries[0].ID)
+	assert.NotZero(t, page.Entries[0].Score)
+
+	_, err = listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: "missing"})
+	assert.ErrorIs(t, err, errMemoryNotFound)
+}
+
+func TestListVectorMemoryQueryExcludesPinned(t *testing.T) {
+	collections := useTestMemoryStores(t, time.Now())
+
+	model := embeddings.ModelConfig{Name: "length", Model: "test/length", Dimensions: 3}
+	config := &AppConfig{Embeddings: EmbeddingsConfig{Models: map[string]embeddings.ModelConfig{"length": model}}}
+	embedder, err := embeddings.OpenCache(t.TempDir(), model, lengthEmbedder{})
+	require.NoError(t, err)
+	embedders.Lock()
+	embedders.loaded[model.Name] = embedder
+	embedders.Unlock()
+	t.Cleanup(func() {
+		embedders.Lock()
+		delete(embedders.loaded, model.Name)
+		embedders.Unlock()
+		embedder.Close()
+	})
+
+	// The pinned records match the query best and would fill the page before filtering.
+	store, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	for i, text := range []string{"pin1", "pin2", "a little longer", "the longest of the memories"} {
+		vector, err := lengthEmbedder{}.Embed(context.Background(), text)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(vecstore.Record{
+			ID:       fmt.Sprintf("rec-%d", i),
+			Text:     text,
+			Vector:   vector,
+			Metadata: map[string]any{"source": SourceChat, "pinned": i < 2},
+		}))
+	}
+
+	pinned := false
+	page, err := listMemory(context.Background(), config, MemoryQuery{Store: CollectionChat, Query: "abcd", Pinned: &pinned, Size: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "rec-2", page.Entries[0].ID)
+	assert.Equal(t, "rec-3", page.Entries[1].ID)
+}
+
+func TestPinAndForgetMemory(t *testing.T) {
+	now := time.Now()
+	collections := useTestMemoryStores(t, now)
+
+	chat, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	require.NoError(t, chat.Put(
+		vecstore.Record{ID: "mem-1", Vector: []float32{1, 0}, Text: "goroutines", Metadata: map[string]any{"source": SourceChat, "memory_ids": []any{"doc-0", "doc-1"}, "created_at": now.Unix()}},
+		vecstore.Record{ID: "mem-2", Vector: []float32{0, 1}, Text: "channels", Metadata: map[string]any{"source": SourceChat, "created_at": now.Unix()}},
+	))
+
+	entry, err := pinMemory(MemoryIndex, "doc-2", true)
+	require.NoError(t, err)
+	assert.True(t, entry.Pinned)
+	assert.Equal(t, "They are scheduled by the Go runtime.", entry.Text)
+
+	pinned := true
+	page, err := listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: MemoryIndex, Pinned: &pinned})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "doc-2", page.Entries[0].ID)
+
+	entry, err = pinMemory(CollectionChat, "mem-2", true)
+	require.NoError(t, err)
+	assert.True(t, entry.Pinned)
+
+	// Forgetting an indexed turn removes the chat memory embedded from it.
+	removal, err := forgetMemory(MemoryIndex, "doc-0")
+	require.NoError(t, err)
+	assert.Equal(t, MemoryRemoval{Index: 1, Vectors: 1}, removal)
+	_, err = getMemory(CollectionChat, "mem-1")
+	assert.ErrorIs(t, err, errMemoryNotFound)
+
+	_, err = forgetMemory(MemoryIndex, "doc-0")
+	assert.ErrorIs(t, err, errMemoryNotFound)
+
+	// Pinned memories survive forgetting their source.
+	removal, err = forgetMemorySource(SourceChat, false)
+	require.NoError(t, err)
+	assert.Equal(t, MemoryRemoval{Index: 1, Vectors: 0}, removal)
+
+	removal, err = forgetMemorySource(SourceChat, true)
+	require.NoError(t, err)
+	assert.Equal(t, MemoryRemoval{Index: 1, Vectors: 1}, removal)
+}
+
+func TestRemoveMemoriesBefore(t *testing.T) {
+	now := time.Now()
+	collections := useTestMemoryStores(t, now)
+
+	chat, err := collections.Get(CollectionChat)
+	require.NoError(t, err)
+	require.NoError(t, chat.Put(
+		vecstore.Record{ID: "old", Vector: []float32{1, 0}, Metadata: map[string]any{"source": SourceChat, "created_at": now.AddDate(0, 0, -10).Unix()}},
+		vecstore.Record{ID: "new", Vector: []float32{0, 1}, Metadata: map[string]any{"source": SourceChat, "created_at": now.Unix()}},
+	))
+
+	removal, err := removeMemories(SourceChat, now.AddDate(0, 0, -2), false)
+	require.NoError(t, err)
+	assert.Equal(t, MemoryRemoval{Index: 2, Vectors: 1}, removal)
+
+	page, err := listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: MemoryIndex, Source: SourceChat})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "doc-0", page.Entries[0].ID)
+
+	page, err = listMemory(context.Background(), &AppConfig{}, MemoryQuery{Store: CollectionChat})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "new", page.Entries[0].ID)
+}
+
+func TestMemoryPolicyDurations(t *testing.T) {
+	ttl, halfLife, err := MemoryPolicy{Source: SourceWeb, TTL: "720h", HalfLife: "168h"}.durations()
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, ttl)
+	assert.Equal(t, 168*time.Hour, halfLife)
+
+	_, _, err = MemoryPolicy{TTL: "a month"}.durations()
+	assert.Error(t, err)
+	_, _, err = MemoryPolicy{HalfLife: "-1h"}.durations()
+	assert.Error(t, err)
+}
+
+func TestMemoryDecay(t *testing.T) {
+	now := time.Now()
+	decay := memoryDecay{halfLives: map[string]time.Duration{SourceChat: 24 * time.Hour}, now: now}
+
+	hits := decay.apply([]retrieval.Hit{
+		{ID: "old", Score: 1, Metadata: map[string]any{"source": SourceChat, "created_at": now.Add(-48 * time.Hour).Unix()}},
+		{ID: "pinned", Score: 0.9, Metadata: map[string]any{"source": SourceChat, "pinned": true, "created_at": now.AddDate(-1, 0, 0).Unix()}},
+		{ID: "fresh", Score: 0.5, Metadata: map[string]any{"created_at": now.Format(time.RFC3339)}},
+		{ID: "web", Score: 0.4, Metadata: map[string]any{"source": SourceWeb, "created_at": now.AddDate(-1, 0, 0).Unix()}},
+	})
+
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	assert.Equal(t, []string{"pinned", "fresh", "web", "old"}, ids)
+	assert.InDelta(t, 0.25, hits[3].Score, 0.001)
+}
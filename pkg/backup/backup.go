@@ -0,0 +1,367 @@
+// Package backup writes snapshots of files and directories to gzipped tar archives with a
+// manifest of checksums, restores them, and prunes old snapshots by retention rules.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ManifestName is the archive entry that lists the files of a snapshot. It is written last,
+// once every checksum is known.
+const ManifestName = "manifest.json"
+
+// ManifestVersion is the version of the snapshot layout written by Write.
+const ManifestVersion = 1
+
+// Snapshot names are the time they were taken, so they sort in time order.
+const (
+	namePrefix = "eternal-"
+	nameLayout = "20060102-150405"
+	nameSuffix = ".tar.gz"
+)
+
+// ErrNotFound is returned for snapshots that do not exist.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Source is a file or directory added to a snapshot. Directories are added with every
+// regular file below them.
+type Source struct {
+	Name string // Path in the archive, slash separated
+	Path string // Path on the file system
+}
+
+// File is a file of a snapshot.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes the contents of a snapshot.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Sources   []string  `json:"sources"` // Names of the sources, including those that did not exist
+	Files     []File    `json:"files"`
+}
+
+// Snapshot is an archive in a snapshot directory.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Name returns the file name of a snapshot taken at t.
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format(nameLayout) + nameSuffix
+}
+
+// parseName returns the time a snapshot was taken from its file name.
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if stamp, ok = strings.CutSuffix(stamp, nameSuffix); !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(nameLayout, stamp)
+	return t, err == nil
+}
+
+// ValidName reports whether name is the file name of a snapshot.
+func ValidName(name string) bool {
+	_, ok := parseName(name)
+	return ok
+}
+
+// Write archives the sources to dest and returns the manifest. Sources that do not exist
+// are skipped. The archive is written next to dest and renamed once complete, so dest never
+// holds a partial snapshot.
+func Write(fs afero.Fs, dest string, created time.Time, sources []Source) (Manifest, error) {
+	manifest := Manifest{Version: ManifestVersion, CreatedAt: created.UTC()}
+	if _, err := fs.Stat(dest); err == nil {
+		return manifest, fmt.Errorf("snapshot %s already exists", filepath.Base(dest))
+	}
+
+	tmp := dest + ".tmp"
+	out, err := fs.Create(tmp)
+	if err != nil {
+		return manifest, fmt.Errorf("error creating snapshot: %v", err)
+	}
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	err = func() error {
+		for _, src := range sources {
+			manifest.Sources = append(manifest.Sources, src.Name)
+			files, err := addSource(fs, tw, src)
+			if err != nil {
+				return fmt.Errorf("error adding %s: %v", src.Name, err)
+			}
+			manifest.Files = append(manifest.Files, files...)
+		}
+
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		return out.Sync()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fs.Remove(tmp)
+		return manifest, err
+	}
+	return manifest, fs.Rename(tmp, dest)
+}
+
+// addSource writes the regular files of a source to the archive and returns them. Other
+// file types, such as links, are skipped.
+func addSource(fs afero.Fs, tw *tar.Writer, src Source) ([]File, error) {
+	if _, err := fs.Stat(src.Path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	var files []File
+	err := afero.Walk(fs, src.Path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src.Path, filePath)
+		if err != nil {
+			return err
+		}
+		name := path.Join(src.Name, filepath.ToSlash(rel))
+		file, err := addFile(fs, tw, name, filePath, info)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// addFile writes a file to the archive, hashing it as it is copied. A file that changes
+// size during the copy fails the snapshot.
+func addFile(fs afero.Fs, tw *tar.Writer, name, filePath string, info os.FileInfo) (File, error) {
+	in, err := fs.Open(filePath)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return File{}, err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return File{}, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, hash), in, info.Size()); err != nil {
+		return File{}, fmt.Errorf("error copying %s: %v", name, err)
+	}
+	return File{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Extract unpacks the snapshot at archive into dir and checks every file against the
+// manifest. An error means dir holds a partial or damaged copy and should be discarded.
+func Extract(fs afero.Fs, archive, dir string) (Manifest, error) {
+	var manifest Manifest
+
+	in, err := fs.Open(archive)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, ErrNotFound
+	}
+	if err != nil {
+		return manifest, err
+	}
+	defer in.Close()
+
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return manifest, fmt.Errorf("error reading snapshot: %v", err)
+	}
+	defer gr.Close()
+
+	extracted := make(map[string]File)
+	var found bool
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("error reading snapshot: %v", err)
+		}
+
+		if header.Name == ManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("error reading manifest: %v", err)
+			}
+			found = true
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target, err := entryPath(dir, header.Name)
+		if err != nil {
+			return manifest, err
+		}
+		file, err := extractFile(fs, tr, target, header)
+		if err != nil {
+			return manifest, err
+		}
+		extracted[header.Name] = file
+	}
+
+	if !found {
+		return manifest, fmt.Errorf("snapshot has no manifest")
+	}
+	if manifest.Version > ManifestVersion {
+		return manifest, fmt.Errorf("snapshot version %d is newer than supported version %d", manifest.Version, ManifestVersion)
+	}
+	for _, want := range manifest.Files {
+		got, ok := extracted[want.Path]
+		if !ok {
+			return manifest, fmt.Errorf("snapshot is missing %s", want.Path)
+		}
+		if got != want {
+			return manifest, fmt.Errorf("checksum mismatch for %s", want.Path)
+		}
+		delete(extracted, want.Path)
+	}
+	for name := range extracted {
+		return manifest, fmt.Errorf("%s is not in the manifest", name)
+	}
+	return manifest, nil
+}
+
+// entryPath returns where an archive entry is extracted, refusing names that would leave dir.
+func entryPath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path %q in snapshot", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// extractFile writes an archive entry to target and returns its size and checksum.
+func extractFile(fs afero.Fs, r io.Reader, target string, header *tar.Header) (File, error) {
+	if err := fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return File{}, err
+	}
+	out, err := fs.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return File{}, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("error extracting %s: %v", header.Name, err)
+	}
+	return File{Path: header.Name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// List returns the snapshots in dir, newest first.
+func List(fs afero.Fs, dir string) ([]Snapshot, error) {
+	entries, err := afero.ReadDir(fs, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		created, ok := parseName(entry.Name())
+		if !ok || !entry.Mode().IsRegular() {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), Size: entry.Size(), CreatedAt: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Retention decides which snapshots are kept. The newest snapshot is always kept.
+type Retention struct {
+	Keep   int           // Number of snapshots kept, unlimited when zero
+	MaxAge time.Duration // Age past which snapshots are deleted, unlimited when zero
+}
+
+// Prune deletes the snapshots in dir that the retention does not keep and returns their names.
+func Prune(fs afero.Fs, dir string, r Retention, now time.Time) ([]string, error) {
+	snapshots, err := List(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for i, snapshot := range snapshots {
+		if i == 0 {
+			continue
+		}
+		tooMany := r.Keep > 0 && i >= r.Keep
+		tooOld := r.MaxAge > 0 && now.Sub(snapshot.CreatedAt) > r.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := fs.Remove(filepath.Join(dir, snapshot.Name)); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, snapshot.Name)
+	}
+	return pruned, nil
+}
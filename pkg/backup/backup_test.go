@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteExtract(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/data/eternaldata.db", []byte("database"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/data/vectors/chat/segment-000001.vec", []byte("vectors"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/data/vectors/chat/model.json", []byte("{}"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/app/config.yml", []byte("data_path: /data"), 0644))
+
+	created := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	dest := "/backups/" + Name(created)
+	require.NoError(t, fs.MkdirAll("/backups", 0755))
+	manifest, err := Write(fs, dest, created, []Source{
+		{Name: "eternaldata.db", Path: "/data/eternaldata.db"},
+		{Name: "vectors", Path: "/data/vectors"},
+		{Name: "web/uploads", Path: "/data/web/uploads"},
+		{Name: "config.yml", Path: "/app/config.yml"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eternaldata.db", "vectors", "web/uploads", "config.yml"}, manifest.Sources)
+	require.Len(t, manifest.Files, 4)
+	assert.Equal(t, "vectors/chat/model.json", manifest.Files[1].Path)
+	assert.Equal(t, int64(8), manifest.Files[0].Size)
+
+	_, err = Write(fs, dest, created, nil)
+	assert.Error(t, err, "snapshots are never overwritten")
+
+	restored, err := Extract(fs, dest, "/restore")
+	require.NoError(t, err)
+	assert.Equal(t, manifest, restored)
+	data, err := afero.ReadFile(fs, "/restore/vectors/chat/segment-000001.vec")
+	require.NoError(t, err)
+	assert.Equal(t, "vectors", string(data))
+
+	_, err = Extract(fs, "/backups/missing.tar.gz", "/restore")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// writeArchive writes a snapshot with the given entries as they are.
+func writeArchive(t *testing.T, fs afero.Fs, dest string, entries map[string]string) {
+	out, err := fs.Create(dest)
+	require.NoError(t, err)
+	defer out.Close()
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+	for name, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+}
+
+func TestExtractRejectsDamagedSnapshots(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	manifest := `{"version": 1, "files": [{"path": "a.txt", "size": 1, "sha256": "0000"}]}`
+
+	tests := []struct {
+		name    string
+		entries map[string]string
+	}{
+		{"no manifest", map[string]string{"a.txt": "a"}},
+		{"checksum", map[string]string{"a.txt": "a", ManifestName: manifest}},
+		{"missing file", map[string]string{ManifestName: manifest}},
+		{"traversal", map[string]string{"../escape.txt": "x", ManifestName: manifest}},
+		{"newer version", map[string]string{ManifestName: `{"version": 99}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeArchive(t, fs, "/snapshot.tar.gz", tt.entries)
+			_, err := Extract(fs, "/snapshot.tar.gz", "/restore")
+			assert.Error(t, err)
+		})
+	}
+	exists, err := afero.Exists(fs, "/escape.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestListPrune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	for _, days := range []int{0, 1, 2, 5, 20} {
+		require.NoError(t, afero.WriteFile(fs, "/backups/"+Name(now.AddDate(0, 0, -days)), []byte("x"), 0644))
+	}
+	require.NoError(t, afero.WriteFile(fs, "/backups/notes.txt", []byte("x"), 0644))
+
+	snapshots, err := List(fs, "/backups")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 5)
+	assert.Equal(t, Name(now), snapshots[0].Name)
+	assert.Equal(t, now, snapshots[0].CreatedAt)
+	assert.True(t, ValidName(snapshots[0].Name))
+	assert.False(t, ValidName("notes.txt"))
+
+	pruned, err := Prune(fs, "/backups", Retention{Keep: 4, MaxAge: 7 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{Name(now.AddDate(0, 0, -20))}, pruned)
+
+	pruned, err = Prune(fs, "/backups", Retention{Keep: 2}, now)
+	require.NoError(t, err)
+	assert.Len(t, pruned, 2)
+
+	// The newest snapshot outlives any age limit.
+	pruned, err = Prune(fs, "/backups", Retention{MaxAge: time.Minute}, now.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.Len(t, pruned, 1)
+	snapshots, err = List(fs, "/backups")
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	snapshots, err = List(fs, "/missing")
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
@@ -0,0 +1,109 @@
+package documents
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// plainTextSeparators split prose along paragraphs, lines, sentences and words. They are
+// regular expressions like the language separators.
+var plainTextSeparators = []string{"\n\n", "\n", `\. `, " ", ""}
+
+// Chunk splits text into chunks of at most chunkSize characters using the separators of the
+// language, or prose separators when the language has none. Adjacent pieces are merged up to
+// chunkSize, and each chunk repeats the last overlap characters of the previous one.
+func Chunk(text string, language Language, chunkSize int, overlap int) []string {
+	if chunkSize <= 0 || text == "" {
+		return nil
+	}
+	if overlap >= chunkSize {
+		overlap = chunkSize / 2
+	}
+
+	separators, err := GetSeparatorsForLanguage(language)
+	if err != nil {
+		separators = plainTextSeparators
+	}
+
+	fits := func(s string) bool { return utf8.RuneCountInString(s) <= chunkSize }
+	return MergeSplits(splitBefore(text, separators, fits), chunkSize, overlap)
+}
+
+// splitBefore splits text that does not fit in a chunk at the first separator that occurs
+// in it, and splits the pieces further with the remaining separators. Unlike the recursive
+// splitter, a separator starts the piece that follows it, so a chunk of Go code begins with
+// its func keyword rather than ending with it.
+func splitBefore(text string, separators []string, fits func(string) bool) []string {
+	if fits(text) {
+		return []string{text}
+	}
+
+	for i, sep := range separators {
+		var starts []int
+		for _, loc := range regexp.MustCompile(sep).FindAllStringIndex(text, -1) {
+			// Empty matches would split between every character; MergeSplits cuts long
+			// pieces instead.
+			if loc[0] > 0 && loc[1] > loc[0] {
+				starts = append(starts, loc[0])
+			}
+		}
+		if len(starts) == 0 {
+			continue
+		}
+
+		var pieces []string
+		prev := 0
+		for _, start := range append(starts, len(text)) {
+			if start > prev {
+				pieces = append(pieces, splitBefore(text[prev:start], separators[i+1:], fits)...)
+			}
+			prev = start
+		}
+		return pieces
+	}
+
+	return []string{text}
+}
+
+// MergeSplits joins consecutive pieces of text into chunks of at most chunkSize characters.
+// Pieces longer than chunkSize are cut at character boundaries.
+func MergeSplits(pieces []string, chunkSize int, overlap int) []string {
+	var chunks []string
+	var current []rune
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		chunks = append(chunks, string(current))
+		if overlap > 0 && len(current) > overlap {
+			current = append([]rune(nil), current[len(current)-overlap:]...)
+		} else {
+			current = current[:0]
+		}
+	}
+
+	for _, piece := range pieces {
+		runes := []rune(piece)
+		for len(runes) > 0 {
+			room := chunkSize - len(current)
+			if room <= 0 || (len(runes) > room && len(current) > overlap) {
+				flush()
+				continue
+			}
+			n := len(runes)
+			if n > room {
+				n = room
+			}
+			current = append(current, runes[:n]...)
+			runes = runes[n:]
+		}
+	}
+
+	// After a flush the current chunk holds only the overlap, which is not worth keeping
+	// unless something was added to it.
+	if len(current) > 0 && (len(chunks) == 0 || len(current) > overlap) {
+		chunks = append(chunks, string(current))
+	}
+	return chunks
+}
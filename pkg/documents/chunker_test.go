@@ -0,0 +1,51 @@
+package documents
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkPlainText(t *testing.T) {
+	text := "First paragraph. It has two sentences.\n\nSecond paragraph is here.\n\nThird one closes the text."
+
+	chunks := Chunk(text, "", 40, 0)
+	assert.Equal(t, []string{
+		"First paragraph. It has two sentences.",
+		"\n\nSecond paragraph is here.",
+		"\n\nThird one closes the text.",
+	}, chunks)
+	assert.Equal(t, text, strings.Join(chunks, ""), "chunks without overlap cover the text exactly")
+}
+
+func TestChunkOverlapAndLimits(t *testing.T) {
+	text := strings.Repeat("héllo wörld ", 50)
+
+	chunks := Chunk(text, "", 30, 6)
+	assert.Greater(t, len(chunks), 1)
+	for i, chunk := range chunks {
+		assert.True(t, utf8.ValidString(chunk))
+		assert.LessOrEqual(t, utf8.RuneCountInString(chunk), 30)
+		if i > 0 {
+			prev := []rune(chunks[i-1])
+			assert.True(t, strings.HasPrefix(chunk, string(prev[len(prev)-6:])), "chunk %d starts with the end of the previous chunk", i)
+		}
+	}
+
+	assert.Nil(t, Chunk("", "", 30, 0))
+}
+
+func TestChunkLanguage(t *testing.T) {
+	code := "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn\n}\n"
+
+	chunks := Chunk(code, GO, 30, 0)
+	assert.Equal(t, code, strings.Join(chunks, ""))
+	assert.Contains(t, chunks[len(chunks)-1], "func b()")
+}
+
+func TestMergeSplitsCutsLongPieces(t *testing.T) {
+	chunks := MergeSplits([]string{"ab", strings.Repeat("x", 12), "cd"}, 5, 0)
+	assert.Equal(t, []string{"ab", "xxxxx", "xxxxx", "xxcd"}, chunks)
+}
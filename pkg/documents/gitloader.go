@@ -1,13 +1,19 @@
 package documents
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	golangssh "golang.org/x/crypto/ssh"
 )
@@ -22,7 +28,7 @@ type GitLoader struct {
 	CloneURL           string
 	Branch             string
 	PrivateKeyPath     string
-	FileFilter         func(string) bool
+	FileFilter         func(string) bool // Receives paths relative to RepoPath, with forward slashes
 	InsecureSkipVerify bool
 }
 
@@ -30,6 +36,240 @@ func NewGitLoader(repoPath, cloneURL, branch, privateKeyPath string, fileFilter
 	return &GitLoader{RepoPath: repoPath, CloneURL: cloneURL, Branch: branch, PrivateKeyPath: privateKeyPath, FileFilter: fileFilter, InsecureSkipVerify: insecureSkipVerify}
 }
 
+// Open opens the repository at RepoPath. When no repository exists there and a clone URL
+// is set, the repository is cloned first, using the private key at PrivateKeyPath for SSH
+// URLs.
+func (gl *GitLoader) Open() (*gogit.Repository, error) {
+	if _, err := os.Stat(gl.RepoPath); os.IsNotExist(err) && gl.CloneURL != "" {
+		auth, err := gl.auth()
+		if err != nil {
+			return nil, err
+		}
+		options := &gogit.CloneOptions{URL: gl.CloneURL, Auth: auth}
+		if gl.Branch != "" {
+			options.ReferenceName = plumbing.NewBranchReferenceName(gl.Branch)
+			options.SingleBranch = true
+		}
+		return gogit.PlainClone(gl.RepoPath, false, options)
+	}
+	return gogit.PlainOpen(gl.RepoPath)
+}
+
+// Pull fetches the branch from the clone URL and fast-forwards the working tree. It does
+// nothing for repositories without a clone URL, which are read as they are.
+func (gl *GitLoader) Pull(repo *gogit.Repository) error {
+	if gl.CloneURL == "" {
+		return nil
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	auth, err := gl.auth()
+	if err != nil {
+		return err
+	}
+	options := &gogit.PullOptions{RemoteName: "origin", Auth: auth}
+	if gl.Branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(gl.Branch)
+		options.SingleBranch = true
+	}
+	if err := w.Pull(options); err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+// auth returns the SSH credentials for the clone URL, or nil when no private key is set.
+func (gl *GitLoader) auth() (transport.AuthMethod, error) {
+	if gl.PrivateKeyPath == "" {
+		return nil, nil
+	}
+	sshKey, err := os.ReadFile(gl.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	signer, err := golangssh.ParsePrivateKey(sshKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %w", gl.PrivateKeyPath, err)
+	}
+	auth := &gitssh.PublicKeys{User: "git", Signer: signer}
+	if gl.InsecureSkipVerify {
+		auth.HostKeyCallback = golangssh.InsecureIgnoreHostKey()
+	}
+	return auth, nil
+}
+
+// Commit returns the commit of the branch, or of HEAD when no branch is set.
+func (gl *GitLoader) Commit(repo *gogit.Repository) (*object.Commit, error) {
+	var hash plumbing.Hash
+	if gl.Branch != "" {
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName(gl.Branch), true)
+		if err != nil {
+			ref, err = repo.Reference(plumbing.NewRemoteReferenceName("origin", gl.Branch), true)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("branch %s not found: %w", gl.Branch, err)
+		}
+		hash = ref.Hash()
+	} else {
+		ref, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		hash = ref.Hash()
+	}
+	return repo.CommitObject(hash)
+}
+
+// Files returns the paths of the files tracked at a commit that pass FileFilter. Paths are
+// relative to the repository root and use forward slashes.
+func (gl *GitLoader) Files(commit *object.Commit) ([]string, error) {
+	files, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	err = files.ForEach(func(f *object.File) error {
+		if gl.accept(f.Name) {
+			paths = append(paths, f.Name)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Changes returns the files that were added or modified, and the files that were deleted,
+// between two commits. Renamed files are reported as deleted under their old path and
+// changed under the new one. Only files that pass FileFilter are returned.
+func (gl *GitLoader) Changes(from, to *object.Commit) (changed []string, deleted []string, err error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, change := range changes {
+		if change.From.Name != "" && change.From.Name != change.To.Name && gl.accept(change.From.Name) {
+			deleted = append(deleted, change.From.Name)
+		}
+		if change.To.Name != "" && gl.accept(change.To.Name) {
+			changed = append(changed, change.To.Name)
+		}
+	}
+	return changed, deleted, nil
+}
+
+// ReadFile returns the content of a file at a commit.
+func ReadFile(commit *object.Commit, path string) ([]byte, error) {
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (gl *GitLoader) accept(path string) bool {
+	return gl.FileFilter == nil || gl.FileFilter(path)
+}
+
+// GlobFilter returns a file filter that accepts paths matching any include pattern, or any
+// path when there are none, unless they match an exclude pattern. Patterns use forward
+// slashes; "*" matches within a path segment and "**" matches across segments. A pattern
+// without a slash matches a file or directory name at any depth, and a pattern that
+// matches a directory matches every file below it.
+func GlobFilter(include []string, exclude []string) (func(string) bool, error) {
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var out []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := globRegexp(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			out = append(out, re)
+		}
+		return out, nil
+	}
+
+	includes, err := compile(include)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compile(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	matchAny := func(res []*regexp.Regexp, path string) bool {
+		for _, re := range res {
+			if re.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(path string) bool {
+		path = filepath.ToSlash(path)
+		if matchAny(excludes, path) {
+			return false
+		}
+		return len(includes) == 0 || matchAny(includes, path)
+	}, nil
+}
+
+// globRegexp translates a glob pattern to a regular expression over slash separated paths.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+
+	var sb strings.Builder
+	if !strings.Contains(pattern, "/") {
+		// Match the file name in any directory.
+		sb.WriteString("^(.*/)?")
+	} else {
+		sb.WriteString("^")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	// A pattern naming a directory matches every file below it.
+	sb.WriteString("(/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
 // Load loads the documents from the Git repository specified by the GitLoader.
 // It returns a slice of Document and an error if any.
 // If the repository does not exist at the specified path and a clone URL is provided,
@@ -41,25 +281,9 @@ func NewGitLoader(repoPath, cloneURL, branch, privateKeyPath string, fileFilter
 // The resulting documents are returned as a slice.
 // If any error occurs during the process, it is returned.
 func (gl *GitLoader) Load() ([]Document, error) {
-	var repo *gogit.Repository
-	var err error
-
-	if _, err = os.Stat(gl.RepoPath); os.IsNotExist(err) && gl.CloneURL != "" {
-		sshKey, _ := os.ReadFile(gl.PrivateKeyPath)
-		signer, _ := golangssh.ParsePrivateKey(sshKey)
-		auth := &gitssh.PublicKeys{User: "git", Signer: signer}
-		if gl.InsecureSkipVerify {
-			auth.HostKeyCallback = golangssh.InsecureIgnoreHostKey()
-		}
-		repo, err = gogit.PlainClone(gl.RepoPath, false, &gogit.CloneOptions{URL: gl.CloneURL, Auth: auth})
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		repo, err = gogit.PlainOpen(gl.RepoPath)
-		if err != nil {
-			return nil, err
-		}
+	repo, err := gl.Open()
+	if err != nil {
+		return nil, err
 	}
 
 	if gl.Branch != "" {
@@ -81,10 +305,14 @@ func (gl *GitLoader) Load() ([]Document, error) {
 		}
 
 		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		if gl.FileFilter != nil && !gl.FileFilter(path) {
+		relFilePath, _ := filepath.Rel(gl.RepoPath, path)
+		if !gl.accept(filepath.ToSlash(relFilePath)) {
 			return nil
 		}
 
@@ -94,7 +322,6 @@ func (gl *GitLoader) Load() ([]Document, error) {
 		}
 
 		textContent := string(content)
-		relFilePath, _ := filepath.Rel(gl.RepoPath, path)
 		fileType := filepath.Ext(info.Name())
 
 		metadata := map[string]string{
@@ -0,0 +1,118 @@
+package documents
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobFilter(t *testing.T) {
+	filter, err := GlobFilter([]string{"*.go", "docs/**"}, []string{"vendor/", "**/*_test.go"})
+	require.NoError(t, err)
+
+	tests := map[string]bool{
+		"main.go":                  true,
+		"pkg/jobs/jobs.go":         true,
+		"pkg/jobs/jobs_test.go":    false,
+		"vendor/lib/lib.go":        false,
+		"docs/guide/index.md":      true,
+		"README.md":                false,
+		"cmd/docs/readme.md":       false,
+		"internal/vendor/thing.go": false,
+		"vendored.go":              true,
+	}
+	for path, want := range tests {
+		assert.Equal(t, want, filter(path), path)
+	}
+
+	all, err := GlobFilter(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, all("anything/at/all.bin"))
+}
+
+func commitFiles(t *testing.T, repo *gogit.Repository, dir string, files map[string]string, remove ...string) *object.Commit {
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+	}
+	for _, name := range remove {
+		_, err := w.Remove(name)
+		require.NoError(t, err)
+	}
+
+	hash, err := w.Commit("update", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(hash)
+	require.NoError(t, err)
+	return commit
+}
+
+func TestGitLoaderChanges(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	first := commitFiles(t, repo, dir, map[string]string{
+		"main.go":      "package main",
+		"util/util.go": "package util",
+		"old.go":       "package old",
+		"notes.txt":    "notes",
+	})
+
+	filter, err := GlobFilter([]string{"*.go"}, nil)
+	require.NoError(t, err)
+	loader := &GitLoader{RepoPath: dir, FileFilter: filter}
+
+	opened, err := loader.Open()
+	require.NoError(t, err)
+	head, err := loader.Commit(opened)
+	require.NoError(t, err)
+	assert.Equal(t, first.Hash, head.Hash)
+
+	files, err := loader.Files(head)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"main.go", "util/util.go", "old.go"}, files)
+
+	second := commitFiles(t, repo, dir, map[string]string{
+		"main.go":   "package main\n\nfunc main() {}",
+		"new.go":    "package new",
+		"notes.txt": "more notes",
+	}, "old.go")
+
+	changed, deleted, err := loader.Changes(first, second)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"main.go", "new.go"}, changed)
+	assert.Equal(t, []string{"old.go"}, deleted)
+
+	content, err := ReadFile(second, "main.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nfunc main() {}", string(content))
+}
+
+func TestOpenReportsUnreadableKey(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := NewGitLoader(filepath.Join(dir, "missing"), "git@example.com:repo.git", "", filepath.Join(dir, "id_rsa"), nil, false)
+	_, err := missing.Open()
+	assert.ErrorContains(t, err, "reading private key")
+
+	keyPath := filepath.Join(dir, "garbage")
+	require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0600))
+	garbage := NewGitLoader(filepath.Join(dir, "garbage-repo"), "git@example.com:repo.git", "", keyPath, nil, false)
+	_, err = garbage.Open()
+	assert.ErrorContains(t, err, "parsing private key")
+}
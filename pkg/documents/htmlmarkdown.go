@@ -0,0 +1,117 @@
+package documents
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var (
+	spacePattern      = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToMarkdown returns the visible text of an HTML page as Markdown. Headings, paragraphs,
+// list items and preformatted blocks keep their structure, so the page can be split by its
+// headings; other markup is dropped.
+func HTMLToMarkdown(r io.Reader) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return "", fmt.Errorf("error parsing HTML: %v", err)
+	}
+	return SelectionToMarkdown(doc.Selection), nil
+}
+
+// SelectionToMarkdown returns the visible text of parsed HTML as Markdown.
+func SelectionToMarkdown(sel *goquery.Selection) string {
+	sel.Find("script, style, noscript, template").Remove()
+
+	var b strings.Builder
+	for _, node := range sel.Nodes {
+		writeMarkdown(&b, node)
+	}
+	// Markup leaves spaces around lines, which are trimmed outside code blocks.
+	lines := strings.Split(b.String(), "\n")
+	inCode := false
+	for i, line := range lines {
+		if line == "```" {
+			inCode = !inCode
+			continue
+		}
+		if !inCode {
+			lines[i] = strings.TrimSpace(line)
+		}
+	}
+	text := blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// writeMarkdown writes the Markdown of a node and its children.
+func writeMarkdown(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(spacePattern.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+	default:
+		writeChildren(b, n)
+		return
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Data[1] - '0')
+		if title := inlineText(n); title != "" {
+			fmt.Fprintf(b, "\n\n%s %s\n\n", strings.Repeat("#", level), title)
+		}
+	case "pre":
+		fmt.Fprintf(b, "\n\n```\n%s\n```\n\n", strings.Trim(rawText(n), "\n"))
+	case "li":
+		b.WriteString("\n- ")
+		writeChildren(b, n)
+		b.WriteString("\n")
+	case "br":
+		b.WriteString("\n")
+	case "p", "div", "section", "article", "header", "footer", "main", "aside", "blockquote", "ul", "ol", "table", "tr", "figure", "dl", "dt", "dd":
+		b.WriteString("\n\n")
+		writeChildren(b, n)
+		b.WriteString("\n\n")
+	case "td", "th":
+		writeChildren(b, n)
+		b.WriteString(" ")
+	default:
+		writeChildren(b, n)
+	}
+}
+
+// writeChildren writes the Markdown of the children of a node.
+func writeChildren(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeMarkdown(b, c)
+	}
+}
+
+// inlineText returns the text of a node on a single line.
+func inlineText(n *html.Node) string {
+	return strings.TrimSpace(spacePattern.ReplaceAllString(rawText(n), " "))
+}
+
+// rawText returns the text of a node with its whitespace.
+func rawText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
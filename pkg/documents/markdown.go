@@ -0,0 +1,175 @@
+package documents
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultHeadingLevel = 3
+	defaultParentSize   = 4000
+)
+
+var (
+	atxHeadingPattern    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextHeadingPattern = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	fencePattern         = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// Heading is a Markdown heading.
+type Heading struct {
+	Level int
+	Title string
+}
+
+// HeadingPath joins the titles of nested headings, outermost first, as in
+// "Guide > Install > Linux".
+func HeadingPath(headings []Heading) string {
+	titles := make([]string, len(headings))
+	for i, h := range headings {
+		titles[i] = h.Title
+	}
+	return strings.Join(titles, " > ")
+}
+
+// MarkdownSection is the text under a heading of a Markdown document.
+type MarkdownSection struct {
+	Headings []Heading // Enclosing headings, outermost first, ending with the heading of the section
+	Text     string    // The heading line and the text up to the next section
+}
+
+// SplitMarkdown splits Markdown into sections at ATX (# Title) and setext (Title over ===
+// or ---) headings of level maxLevel or higher; deeper headings stay in the text of their
+// section. Headings in fenced code blocks are ignored. Text before the first heading falls
+// under the headings that are open, which are those left open by the previous page of a
+// document or none. The headings open at the end of the text are returned for the next
+// page. Blank sections are dropped; the others join back into the text.
+func SplitMarkdown(text string, maxLevel int, open []Heading) ([]MarkdownSection, []Heading) {
+	if maxLevel <= 0 {
+		maxLevel = defaultHeadingLevel
+	}
+	headings := append([]Heading(nil), open...)
+
+	var sections []MarkdownSection
+	start := 0
+	addSection := func(end int) {
+		if strings.TrimSpace(text[start:end]) != "" {
+			sections = append(sections, MarkdownSection{Headings: append([]Heading(nil), headings...), Text: text[start:end]})
+		}
+		start = end
+	}
+	openHeading := func(h Heading) {
+		for len(headings) > 0 && headings[len(headings)-1].Level >= h.Level {
+			headings = headings[:len(headings)-1]
+		}
+		headings = append(headings, h)
+	}
+
+	var fence string
+	prevStart, prevLine := -1, "" // The previous line when it can be the title of a setext heading
+	for offset := 0; offset < len(text); {
+		end := strings.IndexByte(text[offset:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += offset + 1
+		}
+		line := strings.TrimRight(text[offset:end], "\r\n")
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			if fence == "" {
+				fence = m[1]
+			} else if m[1] == fence {
+				fence = ""
+			}
+			prevStart = -1
+			offset = end
+			continue
+		}
+		if fence != "" {
+			offset = end
+			continue
+		}
+
+		if m := atxHeadingPattern.FindStringSubmatch(line); m != nil {
+			if level := len(m[1]); level <= maxLevel {
+				addSection(offset)
+				openHeading(Heading{Level: level, Title: strings.TrimSpace(m[2])})
+			}
+			prevStart = -1
+		} else if m := setextHeadingPattern.FindStringSubmatch(line); m != nil && prevStart >= 0 {
+			level := 1
+			if m[1][0] == '-' {
+				level = 2
+			}
+			if level <= maxLevel {
+				addSection(prevStart)
+				openHeading(Heading{Level: level, Title: strings.TrimSpace(prevLine)})
+			}
+			prevStart = -1
+		} else if strings.TrimSpace(line) == "" {
+			prevStart = -1
+		} else {
+			prevStart, prevLine = offset, line
+		}
+		offset = end
+	}
+	addSection(len(text))
+
+	return sections, headings
+}
+
+// CharacterSplitter splits text into chunks of at most ChunkSize characters with Chunk.
+type CharacterSplitter struct {
+	ChunkSize int
+	Overlap   int
+}
+
+// Split splits the text. It never fails.
+func (s CharacterSplitter) Split(ctx context.Context, text string, language Language) ([]string, error) {
+	return Chunk(text, language, s.ChunkSize, s.Overlap), nil
+}
+
+// MarkdownParent is a section of a Markdown document, or a part of a long section, and the
+// chunks it is split into.
+type MarkdownParent struct {
+	Headings string // Heading path of the section
+	Text     string
+	Children []string
+}
+
+// MarkdownSplitter splits Markdown into parent sections at headings, and each parent into
+// smaller child chunks. Retrieval can match a child, which is specific, and return its
+// parent, which has the context around the match.
+type MarkdownSplitter struct {
+	Splitter     Splitter // Splits parents into children
+	HeadingLevel int      // Deepest heading level that starts a section, 3 by default
+	ParentSize   int      // Characters per parent; longer sections are split into several parents, 4000 by default
+}
+
+// Split splits the Markdown of a page. The open headings continue the heading path of the
+// previous page, and the headings open at the end of the page are returned for the next.
+func (s MarkdownSplitter) Split(ctx context.Context, text string, open []Heading) ([]MarkdownParent, []Heading, error) {
+	parentSize := s.ParentSize
+	if parentSize <= 0 {
+		parentSize = defaultParentSize
+	}
+
+	sections, open := SplitMarkdown(text, s.HeadingLevel, open)
+
+	var parents []MarkdownParent
+	for _, section := range sections {
+		for _, part := range Chunk(section.Text, MARKDOWN, parentSize, 0) {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			children, err := s.Splitter.Split(ctx, part, "")
+			if err != nil {
+				return nil, nil, err
+			}
+			parents = append(parents, MarkdownParent{Headings: HeadingPath(section.Headings), Text: part, Children: children})
+		}
+	}
+	return parents, open, nil
+}
@@ -0,0 +1,72 @@
+package documents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	text := "Preface.\n\n# Guide\n\nIntro.\n\n## Install #\n\nSteps.\n\n```sh\n# not a heading\n```\n\n#### Deep\n\nStays in Install.\n\nUsage\n-----\n\nRun it.\n\n# Reference\n"
+
+	sections, open := SplitMarkdown(text, 3, nil)
+	require.Len(t, sections, 5)
+	assert.Equal(t, text, sections[0].Text+sections[1].Text+sections[2].Text+sections[3].Text+sections[4].Text)
+
+	var paths []string
+	for _, section := range sections {
+		paths = append(paths, HeadingPath(section.Headings))
+	}
+	assert.Equal(t, []string{"", "Guide", "Guide > Install", "Guide > Usage", "Reference"}, paths)
+	assert.Contains(t, sections[2].Text, "# not a heading")
+	assert.Contains(t, sections[2].Text, "#### Deep")
+	assert.True(t, strings.HasPrefix(sections[3].Text, "Usage\n-----"))
+	assert.Equal(t, []Heading{{Level: 1, Title: "Reference"}}, open)
+
+	// The next page continues under the open headings.
+	sections, _ = SplitMarkdown("More reference.\n\n## API\n\nCalls.", 3, open)
+	require.Len(t, sections, 2)
+	assert.Equal(t, "Reference", HeadingPath(sections[0].Headings))
+	assert.Equal(t, "Reference > API", HeadingPath(sections[1].Headings))
+}
+
+func TestMarkdownSplitter(t *testing.T) {
+	text := "# Guide\n\n" + strings.Repeat("A sentence about the guide. ", 10) + "\n\n## Short\n\nTiny."
+	splitter := MarkdownSplitter{Splitter: CharacterSplitter{ChunkSize: 60}, ParentSize: 200}
+
+	parents, open, err := splitter.Split(context.Background(), text, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Heading{{Level: 1, Title: "Guide"}, {Level: 2, Title: "Short"}}, open)
+
+	require.Len(t, parents, 3, "the long section is split into two parents")
+	assert.Equal(t, "Guide", parents[0].Headings)
+	assert.Equal(t, "Guide", parents[1].Headings)
+	assert.Equal(t, "Guide > Short", parents[2].Headings)
+	for _, parent := range parents {
+		assert.LessOrEqual(t, len([]rune(parent.Text)), 200)
+		assert.Equal(t, parent.Text, strings.Join(parent.Children, ""))
+	}
+	assert.Greater(t, len(parents[0].Children), 1)
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	page := `<html><head><title>T</title><style>p {}</style></head><body>
+		<h1>Guide</h1>
+		<p>Intro   text with <a href="/x">a link</a>.</p>
+		<h2>Install <small>v2</small></h2>
+		<ul><li>Download</li><li>Unpack</li></ul>
+		<pre>go build
+  ./...</pre>
+		<script>alert(1)</script>
+	</body></html>`
+
+	text, err := HTMLToMarkdown(strings.NewReader(page))
+	require.NoError(t, err)
+	assert.Equal(t, "T\n\n# Guide\n\nIntro text with a link.\n\n## Install v2\n\n- Download\n\n- Unpack\n\n```\ngo build\n  ./...\n```", text)
+
+	sections, _ := SplitMarkdown(text, 3, nil)
+	assert.Equal(t, "Guide > Install v2", HeadingPath(sections[len(sections)-1].Headings))
+}
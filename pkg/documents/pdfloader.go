@@ -10,16 +10,26 @@ import (
 
 // GetPdfContents extracts the text content from the given PDF file and returns it as Markdown
 func GetPdfContents(filePath string) (string, error) {
+	pages, err := GetPdfPages(filePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(pages, ""), nil
+}
+
+// GetPdfPages extracts the text content of each page of the given PDF file as Markdown.
+// Page n of the document is element n-1; pages without content are empty.
+func GetPdfPages(filePath string) ([]string, error) {
 	// Open the PDF file
 	file, reader, err := pdf.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open PDF file: %w", err)
+		return nil, fmt.Errorf("failed to open PDF file: %w", err)
 	}
 	defer file.Close()
 
 	// Iterate through the pages
 	totalPage := reader.NumPage()
-	var sb strings.Builder
+	pages := make([]string, totalPage)
 	for pageIndex := 1; pageIndex <= totalPage; pageIndex++ {
 		page := reader.Page(pageIndex)
 		if page.V.IsNull() {
@@ -29,15 +39,14 @@ func GetPdfContents(filePath string) (string, error) {
 		// Extract text from the page
 		text, err := page.GetPlainText(nil)
 		if err != nil {
-			return "", fmt.Errorf("failed to extract text from page %d: %w", pageIndex, err)
+			return nil, fmt.Errorf("failed to extract text from page %d: %w", pageIndex, err)
 		}
 
 		// Format the text content of the page as Markdown
-		markdownText := formatAsMarkdown(text)
-		sb.WriteString(markdownText)
+		pages[pageIndex-1] = formatAsMarkdown(text)
 	}
 
-	return sb.String(), nil
+	return pages, nil
 }
 
 // formatAsMarkdown formats the given text as Markdown
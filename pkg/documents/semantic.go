@@ -0,0 +1,110 @@
+package documents
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+)
+
+// defaultBreakpointPercentile selects the share of sentence gaps the semantic splitter
+// breaks at when no threshold is set.
+const defaultBreakpointPercentile = 10
+
+// SentenceEmbedder embeds sentences for the semantic splitter. The vectors are returned in
+// the order of the texts.
+type SentenceEmbedder interface {
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// SemanticSplitter splits prose where the topic changes: it embeds every sentence and starts
+// a new chunk between adjacent sentences whose embeddings are least similar. Chunks longer
+// than the chunk size of Tokens are split further by tokens, and source code is split by
+// Tokens alone.
+type SemanticSplitter struct {
+	Embedder   SentenceEmbedder
+	Tokens     TokenSplitter
+	Threshold  float64 // Cosine similarity below which a new chunk starts; zero uses Percentile
+	Percentile float64 // Percentage of the sentence gaps to break at when Threshold is zero, 10 by default
+}
+
+// Split splits the text into chunks of related sentences.
+func (s SemanticSplitter) Split(ctx context.Context, text string, language Language) ([]string, error) {
+	if _, err := GetSeparatorsForLanguage(language); err == nil {
+		return s.Tokens.SplitText(text, language), nil
+	}
+
+	// Whitespace between sentences has no meaning of its own and stays with the sentence
+	// before it.
+	var sentences []string
+	for _, sentence := range SplitSentences(text) {
+		if strings.TrimSpace(sentence) == "" && len(sentences) > 0 {
+			sentences[len(sentences)-1] += sentence
+			continue
+		}
+		sentences = append(sentences, sentence)
+	}
+	if len(sentences) < 3 {
+		return s.Tokens.SplitText(text, ""), nil
+	}
+
+	vectors, err := s.Embedder.EmbedBatch(ctx, sentences)
+	if err != nil {
+		return nil, err
+	}
+	similarities := make([]float64, len(sentences)-1)
+	for i := range similarities {
+		similarities[i] = cosineSimilarity(vectors[i], vectors[i+1])
+	}
+	threshold := s.threshold(similarities)
+
+	var chunks []string
+	var group strings.Builder
+	for i, sentence := range sentences {
+		if i > 0 && similarities[i-1] <= threshold {
+			chunks = append(chunks, s.Tokens.SplitText(group.String(), "")...)
+			group.Reset()
+		}
+		group.WriteString(sentence)
+	}
+	chunks = append(chunks, s.Tokens.SplitText(group.String(), "")...)
+	return chunks, nil
+}
+
+// threshold returns the similarity at or below which a new chunk starts.
+func (s SemanticSplitter) threshold(similarities []float64) float64 {
+	if s.Threshold != 0 {
+		// A gap exactly at the threshold is not a break.
+		return math.Nextafter(s.Threshold, math.Inf(-1))
+	}
+
+	percentile := s.Percentile
+	if percentile <= 0 {
+		percentile = defaultBreakpointPercentile
+	}
+	sorted := append([]float64(nil), similarities...)
+	sort.Float64s(sorted)
+	i := int(math.Ceil(percentile/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		return math.Inf(-1)
+	}
+	return sorted[min(i, len(sorted)-1)]
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors, or 0 when either
+// is zero.
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,63 @@
+package documents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// topicEmbedder embeds sentences about cats and about rockets on different axes.
+type topicEmbedder struct{}
+
+func (topicEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		text = strings.ToLower(text)
+		switch {
+		case strings.Contains(text, "cat"):
+			vectors[i] = []float32{1, 0.1}
+		case strings.Contains(text, "rocket"):
+			vectors[i] = []float32{0.1, 1}
+		default:
+			return nil, errors.New("unknown topic")
+		}
+	}
+	return vectors, nil
+}
+
+func TestSemanticSplitter(t *testing.T) {
+	text := "The cat sleeps. A cat purrs. Cats like the sun. The rocket launches. A rocket needs fuel."
+	splitter := SemanticSplitter{
+		Embedder:  topicEmbedder{},
+		Tokens:    TokenSplitter{Tokenizer: wordTokenizer{}, ChunkSize: 50},
+		Threshold: 0.5,
+	}
+
+	chunks, err := splitter.Split(context.Background(), text, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"The cat sleeps. A cat purrs. Cats like the sun. ",
+		"The rocket launches. A rocket needs fuel.",
+	}, chunks)
+
+	// The least similar gap is the break without a threshold.
+	splitter.Threshold = 0
+	splitter.Percentile = 25
+	chunks, err = splitter.Split(context.Background(), text, "")
+	require.NoError(t, err)
+	assert.Len(t, chunks, 2)
+
+	// Groups longer than a chunk are split by tokens.
+	splitter.Tokens.ChunkSize = 4
+	chunks, err = splitter.Split(context.Background(), text, "")
+	require.NoError(t, err)
+	assert.Equal(t, "The cat sleeps. ", chunks[0])
+	assert.Equal(t, text, strings.Join(chunks, ""))
+
+	_, err = splitter.Split(context.Background(), "One. Two. Three.", "")
+	assert.Error(t, err)
+}
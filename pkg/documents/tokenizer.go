@@ -0,0 +1,73 @@
+package documents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nlpodyssey/cybertron/pkg/tokenizers/wordpiecetokenizer"
+	"github.com/nlpodyssey/cybertron/pkg/vocabulary"
+)
+
+// defaultCharsPerToken is the average length of a token of English text in the BPE
+// vocabularies of OpenAI models.
+const defaultCharsPerToken = 4
+
+// Tokenizer counts the tokens a model reads from text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// ApproxTokenizer estimates token counts from the number of characters, for models whose
+// vocabulary is not available locally.
+type ApproxTokenizer struct {
+	CharsPerToken int // Characters per token, 4 by default
+}
+
+// CountTokens returns the estimated number of tokens in text.
+func (t ApproxTokenizer) CountTokens(text string) int {
+	perToken := t.CharsPerToken
+	if perToken <= 0 {
+		perToken = defaultCharsPerToken
+	}
+	n := utf8.RuneCountInString(text)
+	return (n + perToken - 1) / perToken
+}
+
+// WordPieceTokenizer counts tokens with the WordPiece vocabulary of a BERT model.
+type WordPieceTokenizer struct {
+	tokenizer *wordpiecetokenizer.WordPieceTokenizer
+	lowerCase bool
+}
+
+// LoadWordPieceTokenizer loads the vocabulary of the BERT model in modelDir. Text is
+// lowercased before counting when the tokenizer config of the model asks for it.
+func LoadWordPieceTokenizer(modelDir string) (*WordPieceTokenizer, error) {
+	vocab, err := vocabulary.NewFromFile(filepath.Join(modelDir, "vocab.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading vocabulary: %v", err)
+	}
+
+	var config struct {
+		DoLowerCase bool `json:"do_lower_case"`
+	}
+	if data, err := os.ReadFile(filepath.Join(modelDir, "tokenizer_config.json")); err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error reading tokenizer config: %v", err)
+		}
+	}
+
+	return &WordPieceTokenizer{tokenizer: wordpiecetokenizer.New(vocab), lowerCase: config.DoLowerCase}, nil
+}
+
+// CountTokens returns the number of word pieces in text, not counting the special tokens
+// the model adds around every input.
+func (t *WordPieceTokenizer) CountTokens(text string) int {
+	if t.lowerCase {
+		text = strings.ToLower(text)
+	}
+	return len(t.tokenizer.Tokenize(text))
+}
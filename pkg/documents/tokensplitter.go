@@ -0,0 +1,210 @@
+package documents
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Splitter splits the text of a document into chunks. The language selects the separators
+// of source code and is empty for prose.
+type Splitter interface {
+	Split(ctx context.Context, text string, language Language) ([]string, error)
+}
+
+// wordPattern matches a word and the whitespace after it, or whitespace at the start of text.
+var wordPattern = regexp.MustCompile(`^\s+|\S+\s*`)
+
+// sentenceEnds are the runes that end a sentence when whitespace follows them.
+const sentenceEnds = ".!?…。！？"
+
+// sentenceClosers may follow the end of a sentence before the whitespace, as in `"Stop."`.
+const sentenceClosers = `"')]}’”»`
+
+// SplitSentences splits prose into sentences. A sentence ends at terminal punctuation
+// followed by whitespace, or at a blank line, and keeps the whitespace that follows it, so
+// the sentences join back into the text. Abbreviations such as "e.g. " end a sentence too.
+func SplitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	ended := false // Terminal punctuation or a line break was seen since the last sentence
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		switch {
+		case strings.ContainsRune(sentenceEnds, r):
+			ended = true
+		case r == '\n' && strings.HasPrefix(strings.TrimLeft(text[i+size:], " \t\r"), "\n"):
+			ended = true
+		case unicode.IsSpace(r):
+		case ended && strings.ContainsRune(sentenceClosers, r) && isSentenceEnd(text[:i]):
+		default:
+			if ended && i > start && isSpaceBefore(text[:i]) {
+				sentences = append(sentences, text[start:i])
+				start = i
+			}
+			ended = false
+		}
+		i += size
+	}
+
+	if start < len(text) {
+		sentences = append(sentences, text[start:])
+	}
+	return sentences
+}
+
+// isSentenceEnd reports whether text ends with terminal punctuation or a closer after it.
+func isSentenceEnd(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return strings.ContainsRune(sentenceEnds, r) || strings.ContainsRune(sentenceClosers, r)
+}
+
+// isSpaceBefore reports whether text ends with whitespace.
+func isSpaceBefore(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsSpace(r)
+}
+
+// TokenSplitter splits text into chunks of at most ChunkSize tokens of a model. Prose is
+// split between sentences, and sentences longer than a chunk between words. Source code is
+// split with the separators of its language like Chunk.
+type TokenSplitter struct {
+	Tokenizer Tokenizer
+	ChunkSize int // Tokens per chunk
+	Overlap   int // Tokens repeated from the end of the previous chunk, in whole sentences or words
+	MaxChars  int // Runes per chunk, unlimited when zero
+}
+
+// Split splits the text. It never fails.
+func (s TokenSplitter) Split(ctx context.Context, text string, language Language) ([]string, error) {
+	return s.SplitText(text, language), nil
+}
+
+// SplitText splits the text into chunks. The token count of a chunk is the sum of the
+// counts of its pieces, which may differ from the count of the joined text by a token at
+// each join.
+func (s TokenSplitter) SplitText(text string, language Language) []string {
+	if s.ChunkSize <= 0 || text == "" {
+		return nil
+	}
+	fits := func(text string) bool {
+		return s.fitsChars(utf8.RuneCountInString(text)) && s.Tokenizer.CountTokens(text) <= s.ChunkSize
+	}
+
+	var pieces []string
+	if separators, err := GetSeparatorsForLanguage(language); err == nil {
+		pieces = splitBefore(text, separators, fits)
+	} else {
+		for _, sentence := range SplitSentences(text) {
+			if fits(sentence) {
+				pieces = append(pieces, sentence)
+			} else {
+				pieces = append(pieces, wordPattern.FindAllString(sentence, -1)...)
+			}
+		}
+	}
+	return s.merge(pieces)
+}
+
+// fitsChars reports whether a chunk of n runes is within MaxChars.
+func (s TokenSplitter) fitsChars(n int) bool {
+	return s.MaxChars <= 0 || n <= s.MaxChars
+}
+
+// tokenPiece is a piece of text and its token and rune counts.
+type tokenPiece struct {
+	text   string
+	tokens int
+	chars  int
+}
+
+// merge joins consecutive pieces into chunks of at most ChunkSize tokens and MaxChars
+// runes. Pieces longer than a chunk are cut between runes.
+func (s TokenSplitter) merge(pieces []string) []string {
+	overlap := s.Overlap
+	if overlap >= s.ChunkSize {
+		overlap = s.ChunkSize / 2
+	}
+
+	var chunks []string
+	var current []tokenPiece
+	total, chars := 0, 0
+	fresh := false // current holds more than the overlap of the previous chunk
+
+	flush := func() {
+		var b strings.Builder
+		for _, p := range current {
+			b.WriteString(p.text)
+		}
+		chunks = append(chunks, b.String())
+
+		keep := len(current)
+		total, chars = 0, 0
+		for keep > 0 && total+current[keep-1].tokens <= overlap {
+			keep--
+			total += current[keep].tokens
+			chars += current[keep].chars
+		}
+		current = append([]tokenPiece(nil), current[keep:]...)
+		fresh = false
+	}
+
+	for _, text := range pieces {
+		for _, p := range s.cut(text) {
+			full := func() bool { return total+p.tokens > s.ChunkSize || !s.fitsChars(chars+p.chars) }
+			if fresh && full() {
+				flush()
+			}
+			// Drop overlap that leaves no room for the piece.
+			for len(current) > 0 && full() {
+				total -= current[0].tokens
+				chars -= current[0].chars
+				current = current[1:]
+			}
+			current = append(current, p)
+			total += p.tokens
+			chars += p.chars
+			fresh = true
+		}
+	}
+
+	if fresh {
+		flush()
+	}
+	return chunks
+}
+
+// cut splits text into pieces of at most ChunkSize tokens and MaxChars runes, taking the
+// longest prefix that fits each time.
+func (s TokenSplitter) cut(text string) []tokenPiece {
+	runes := []rune(text)
+	tokens := s.Tokenizer.CountTokens(text)
+	if tokens <= s.ChunkSize && s.fitsChars(len(runes)) {
+		return []tokenPiece{{text: text, tokens: tokens, chars: len(runes)}}
+	}
+
+	var pieces []tokenPiece
+	for len(runes) > 0 {
+		// Binary search for the longest prefix that fits, taking at least one rune.
+		lo, hi := 1, len(runes)
+		if s.MaxChars > 0 {
+			hi = min(hi, s.MaxChars)
+		}
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			if s.Tokenizer.CountTokens(string(runes[:mid])) <= s.ChunkSize {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		piece := string(runes[:lo])
+		pieces = append(pieces, tokenPiece{text: piece, tokens: s.Tokenizer.CountTokens(piece), chars: lo})
+		runes = runes[lo:]
+	}
+	return pieces
+}
@@ -0,0 +1,94 @@
+package documents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wordTokenizer counts words as tokens.
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int { return len(strings.Fields(text)) }
+
+func TestSplitSentences(t *testing.T) {
+	text := `It costs 3.50 dollars. Really? He said "stop." Then left!` + "\n\nA new paragraph\n\nwithout punctuation"
+
+	sentences := SplitSentences(text)
+	assert.Equal(t, []string{
+		"It costs 3.50 dollars. ",
+		"Really? ",
+		`He said "stop." `,
+		"Then left!\n\n",
+		"A new paragraph\n\n",
+		"without punctuation",
+	}, sentences)
+	assert.Equal(t, text, strings.Join(sentences, ""))
+}
+
+func TestTokenSplitter(t *testing.T) {
+	splitter := TokenSplitter{Tokenizer: wordTokenizer{}, ChunkSize: 6, Overlap: 2}
+
+	chunks := splitter.SplitText("One two three. Four five. Six seven eight nine ten eleven twelve thirteen. End.", "")
+	assert.Equal(t, []string{
+		"One two three. Four five. Six ",
+		"Six seven eight nine ten eleven ",
+		"ten eleven twelve thirteen. End.",
+	}, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, wordTokenizer{}.CountTokens(chunk), 6)
+	}
+
+	assert.Nil(t, splitter.SplitText("", ""))
+}
+
+func TestTokenSplitterCutsLongWords(t *testing.T) {
+	splitter := TokenSplitter{Tokenizer: ApproxTokenizer{CharsPerToken: 2}, ChunkSize: 3}
+
+	chunks, err := splitter.Split(context.Background(), strings.Repeat("é", 14), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"éééééé", "éééééé", "éé"}, chunks)
+}
+
+func TestTokenSplitterMaxChars(t *testing.T) {
+	splitter := TokenSplitter{Tokenizer: wordTokenizer{}, ChunkSize: 10, Overlap: 1, MaxChars: 12}
+
+	chunks := splitter.SplitText("alpha beta gamma delta abcdefghijklmnopq", "")
+	assert.Equal(t, []string{"alpha beta ", "beta gamma ", "gamma delta ", "abcdefghijkl", "mnopq"}, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, len([]rune(chunk)), 12)
+	}
+}
+
+func TestTokenSplitterLanguage(t *testing.T) {
+	code := "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn\n}\n"
+	splitter := TokenSplitter{Tokenizer: wordTokenizer{}, ChunkSize: 5}
+
+	chunks := splitter.SplitText(code, GO)
+	assert.Equal(t, code, strings.Join(chunks, ""))
+	assert.True(t, strings.HasPrefix(chunks[len(chunks)-1], "\nfunc b()"))
+}
+
+func TestWordPieceTokenizer(t *testing.T) {
+	dir := t.TempDir()
+	vocab := "[PAD]\n[UNK]\n[CLS]\n[SEP]\n[MASK]\nhello\nworld\nplay\n##ing\n!\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vocab.txt"), []byte(vocab), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tokenizer_config.json"), []byte(`{"do_lower_case": true}`), 0644))
+
+	tokenizer, err := LoadWordPieceTokenizer(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 6, tokenizer.CountTokens("Hello world, playing!"), "hello world [UNK] play ##ing !")
+
+	_, err = LoadWordPieceTokenizer(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestApproxTokenizer(t *testing.T) {
+	assert.Equal(t, 3, ApproxTokenizer{}.CountTokens("nine char"))
+	assert.Equal(t, 0, ApproxTokenizer{}.CountTokens(""))
+}
@@ -52,16 +52,22 @@ func splitTextWithRegex(text string, separator string, keepSeparator bool) []str
 	return splits
 }
 
-// SplitTextByCount splits the given text into chunks of the given size.
+// SplitTextByCount splits the given text into chunks of the given number of characters.
+// Chunks are cut between runes, so multi-byte characters are never split.
 func SplitTextByCount(text string, size int) []string {
+	if size <= 0 {
+		return nil
+	}
+
 	// slice the string into chunks of size
+	runes := []rune(text)
 	var chunks []string
-	for i := 0; i < len(text); i += size {
+	for i := 0; i < len(runes); i += size {
 		end := i + size
-		if end > len(text) {
-			end = len(text)
+		if end > len(runes) {
+			end = len(runes)
 		}
-		chunks = append(chunks, text[i:end])
+		chunks = append(chunks, string(runes[i:end]))
 	}
 	return chunks
 }
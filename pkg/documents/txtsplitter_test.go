@@ -91,3 +91,9 @@ func TestSplitTextByCount(t *testing.T) {
 	result := SplitTextByCount(text, 8)
 	assert.Equal(t, expected, result, "The text should be split correctly by count.")
 }
+
+func TestSplitTextByCountRunes(t *testing.T) {
+	result := SplitTextByCount("héllo wörld", 4)
+	assert.Equal(t, []string{"héll", "o wö", "rld"}, result, "multi-byte characters count once and are never split")
+	assert.Nil(t, SplitTextByCount("text", 0))
+}
@@ -0,0 +1,105 @@
+package embeddings
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchEmbedder embeds several texts at once. The vectors are returned in the order of the
+// texts.
+type BatchEmbedder interface {
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedAll embeds the texts in one batch when the embedder supports it, and one at a time
+// otherwise.
+func EmbedAll(ctx context.Context, e Embedder, texts []string) ([][]float32, error) {
+	if b, ok := e.(BatchEmbedder); ok {
+		return b.EmbedBatch(ctx, texts)
+	}
+	return embedParallel(ctx, texts, 1, e.Embed)
+}
+
+// batchEmbedder embeds batches with EmbedAll.
+type batchEmbedder struct {
+	embedder Embedder
+}
+
+// EmbedBatch embeds the texts.
+func (b batchEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return EmbedAll(ctx, b.embedder, texts)
+}
+
+// Batch returns a BatchEmbedder of any embedder, embedding one text at a time when the
+// embedder has no batches of its own.
+func Batch(e Embedder) BatchEmbedder {
+	if b, ok := e.(BatchEmbedder); ok {
+		return b
+	}
+	return batchEmbedder{embedder: e}
+}
+
+// embedParallel embeds the texts with a pool of workers, stopping at the first error.
+func embedParallel(ctx context.Context, texts []string, workers int, embed func(context.Context, string) ([]float32, error)) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	err := parallel(ctx, len(texts), workers, func(ctx context.Context, i int) error {
+		vector, err := embed(ctx, texts[i])
+		vectors[i] = vector
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// parallel calls fn for 0..n-1 with a pool of workers. The first error cancels the calls
+// that have not started and is returned.
+func parallel(ctx context.Context, n int, workers int, fn func(ctx context.Context, i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	indexes := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
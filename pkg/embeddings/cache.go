@@ -0,0 +1,118 @@
+package embeddings
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	estore "eternal/pkg/vecstore"
+)
+
+// CachedEmbedder embeds text through a persistent cache, so text that was embedded before is
+// never sent to the model again. The cache of a model is a vector store keyed by the
+// sha256 of the normalized text.
+type CachedEmbedder struct {
+	embedder Embedder
+	store    *estore.Store
+}
+
+// OpenCache opens the cache of the model in dir and returns an embedder that consults it
+// before the model. Every setting that changes the vectors of a model selects another cache.
+func OpenCache(dir string, m ModelConfig, embedder Embedder) (*CachedEmbedder, error) {
+	store, err := estore.Open(filepath.Join(dir, m.cacheKey()))
+	if err != nil {
+		return nil, fmt.Errorf("error opening embedding cache of %s: %v", m.Name, err)
+	}
+	return &CachedEmbedder{embedder: embedder, store: store}, nil
+}
+
+// cacheKey identifies the vectors a model makes.
+func (m ModelConfig) cacheKey() string {
+	pooling := ""
+	if m.Provider != ProviderOpenAI {
+		pooling = m.Pooling
+		if pooling == "" {
+			pooling = PoolingMean
+		}
+	}
+	key := fmt.Sprintf("%s|%d|%s|%t|%d", m.CollectionModel().Model, m.Dimensions, pooling, m.Normalize, m.MaxInputLength)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// CacheKey returns the cache key of text: the sha256 of the text with surrounding whitespace
+// removed and inner whitespace collapsed to single spaces.
+func CacheKey(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(text), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Embed returns the cached vector of the text, embedding and caching it on a miss.
+func (c *CachedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := c.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbedBatch returns the cached vectors of the texts and embeds the missing ones in a batch.
+func (c *CachedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+
+	var missing []string
+	positions := make(map[string][]int) // Positions of each missing key in texts
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = CacheKey(text)
+		if _, ok := positions[keys[i]]; ok {
+			positions[keys[i]] = append(positions[keys[i]], i)
+			continue
+		}
+		record, ok, err := c.store.Get(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			vectors[i] = record.Vector
+			continue
+		}
+		positions[keys[i]] = []int{i}
+		missing = append(missing, text)
+	}
+
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := EmbedAll(ctx, c.embedder, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]estore.Record, len(missing))
+	for j, text := range missing {
+		key := CacheKey(text)
+		for _, i := range positions[key] {
+			vectors[i] = embedded[j]
+		}
+		records[j] = estore.Record{ID: key, Vector: embedded[j]}
+	}
+	if err := c.store.Put(records...); err != nil {
+		return nil, fmt.Errorf("error caching embeddings: %v", err)
+	}
+	return vectors, nil
+}
+
+// Len returns the number of cached vectors.
+func (c *CachedEmbedder) Len() int {
+	return c.store.Len()
+}
+
+// Close closes the cache.
+func (c *CachedEmbedder) Close() error {
+	return c.store.Close()
+}
@@ -0,0 +1,119 @@
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	estore "eternal/pkg/vecstore"
+)
+
+// countingEmbedder embeds text as its length and the count of the letter e, and records
+// the texts it embedded.
+type countingEmbedder struct {
+	mu       sync.Mutex
+	embedded []string
+	failOn   string
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failOn != "" && text == e.failOn {
+		return nil, errors.New("model crashed")
+	}
+	e.embedded = append(e.embedded, text)
+	return []float32{float32(len(text)), float32(strings.Count(text, "e"))}, nil
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	dir := t.TempDir()
+	model := ModelConfig{Name: "test", Model: "org/test", Dimensions: 2}
+	counter := &countingEmbedder{}
+
+	cache, err := OpenCache(dir, model, counter)
+	require.NoError(t, err)
+
+	vectors, err := cache.EmbedBatch(context.Background(), []string{"one", "three", "one"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{3, 1}, {5, 2}, {3, 1}}, vectors)
+	assert.Equal(t, []string{"one", "three"}, counter.embedded)
+
+	// Whitespace differences hit the cache.
+	vector, err := cache.Embed(context.Background(), "  one\n")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{3, 1}, vector)
+	assert.Len(t, counter.embedded, 2)
+	assert.Equal(t, 2, cache.Len())
+	require.NoError(t, cache.Close())
+
+	// The cache persists across restarts.
+	counter = &countingEmbedder{}
+	cache, err = OpenCache(dir, model, counter)
+	require.NoError(t, err)
+	_, err = cache.EmbedBatch(context.Background(), []string{"three", "seven"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"seven"}, counter.embedded)
+	require.NoError(t, cache.Close())
+
+	// Other settings of the model use another cache.
+	model.Normalize = true
+	counter = &countingEmbedder{}
+	cache, err = OpenCache(dir, model, counter)
+	require.NoError(t, err)
+	defer cache.Close()
+	_, err = cache.Embed(context.Background(), "three")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"three"}, counter.embedded)
+
+	// Failures are not cached.
+	counter.failOn = "nine"
+	_, err = cache.EmbedBatch(context.Background(), []string{"eight", "nine"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestEmbedParallel(t *testing.T) {
+	texts := make([]string, 50)
+	for i := range texts {
+		texts[i] = strings.Repeat("e", i)
+	}
+
+	counter := &countingEmbedder{}
+	vectors, err := embedParallel(context.Background(), texts, 4, counter.Embed)
+	require.NoError(t, err)
+	require.Len(t, vectors, len(texts))
+	for i, vector := range vectors {
+		assert.Equal(t, []float32{float32(i), float32(i)}, vector)
+	}
+
+	counter = &countingEmbedder{failOn: texts[10]}
+	_, err = embedParallel(context.Background(), texts, 4, counter.Embed)
+	assert.EqualError(t, err, "model crashed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = embedParallel(ctx, texts, 4, (&countingEmbedder{}).Embed)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGenerateEmbeddingForTaskSkipsStoredChunks(t *testing.T) {
+	store, err := estore.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	counter := &countingEmbedder{}
+	metadata := map[string]any{"source": "chat"}
+	require.NoError(t, GenerateEmbeddingForTask(store, counter, "chat", "the same memory", "txt", 4096, 1024, metadata))
+	require.Len(t, counter.embedded, 1)
+	assert.Equal(t, 1, store.Len())
+
+	require.NoError(t, GenerateEmbeddingForTask(store, counter, "chat", "the same memory", "txt", 4096, 1024, metadata))
+	assert.Len(t, counter.embedded, 1)
+	assert.Equal(t, 1, store.Len())
+}
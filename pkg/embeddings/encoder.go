@@ -0,0 +1,67 @@
+package embeddings
+
+import (
+	"context"
+
+	"github.com/nlpodyssey/cybertron/pkg/tasks"
+	"github.com/nlpodyssey/cybertron/pkg/tasks/textencoding"
+)
+
+// Encoder embeds text with a sentence encoder loaded through cybertron. Loading a model is
+// slow, so callers should keep the encoder rather than loading it per request.
+type Encoder struct {
+	models chan textencoding.Interface // Idle model instances; an instance is not safe for concurrent use
+	config ModelConfig
+}
+
+// LoadEncoder loads the local model from modelsDir, downloading and converting it when it
+// is missing. Models are kept in modelsDir under their Hugging Face repository name. One
+// instance of the model is loaded per worker.
+func LoadEncoder(modelsDir string, m ModelConfig) (*Encoder, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	workers := m.workers()
+	e := &Encoder{models: make(chan textencoding.Interface, workers), config: m}
+	for i := 0; i < workers; i++ {
+		model, err := tasks.Load[textencoding.Interface](&tasks.Config{
+			ModelsDir:        modelsDir,
+			ModelName:        m.Model,
+			DownloadPolicy:   tasks.DownloadMissing,
+			ConversionPolicy: tasks.ConvertMissing,
+		})
+		if err != nil {
+			return nil, err
+		}
+		e.models <- model
+	}
+	return e, nil
+}
+
+// Embed returns the pooled embedding of the text.
+func (e *Encoder) Embed(ctx context.Context, text string) ([]float32, error) {
+	pooling, err := e.config.pooling()
+	if err != nil {
+		return nil, err
+	}
+
+	var model textencoding.Interface
+	select {
+	case model = <-e.models:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { e.models <- model }()
+
+	result, err := model.Encode(ctx, e.config.truncate(text), int(pooling))
+	if err != nil {
+		return nil, err
+	}
+	return e.config.vector(result.Vector.Data().F64())
+}
+
+// EmbedBatch embeds the texts with every loaded instance of the model in parallel.
+func (e *Encoder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedParallel(ctx, texts, cap(e.models), e.Embed)
+}
@@ -8,17 +8,9 @@ import (
 
 	estore "eternal/pkg/vecstore"
 
-	"github.com/nlpodyssey/cybertron/pkg/models/bert"
-	"github.com/nlpodyssey/cybertron/pkg/tasks"
-	"github.com/nlpodyssey/cybertron/pkg/tasks/textencoding"
 	"github.com/pterm/pterm"
 )
 
-// var modelName = "BAAI/bge-large-en-v1.5"
-var modelName = "avsolatorio/GIST-small-Embedding-v0"
-
-const limit = 128
-
 var INSTRUCTIONS = map[string]struct {
 	Query string
 	Key   string
@@ -56,7 +48,11 @@ type Embedding struct {
 	Similarity float64
 }
 
-func GenerateEmbeddingForTask(task string, content string, doctype string, chunkSize int, overlapSize int, dataPath string) error {
+// GenerateEmbeddingForTask splits the content into chunks and writes an embedding for each
+// new chunk to the vector store with a copy of the metadata. Chunks are keyed by the
+// metadata's document_id and their text, so the same chunk from another document is kept
+// separately, and chunks already stored keep their vector and metadata.
+func GenerateEmbeddingForTask(store *estore.Store, embedder Embedder, task string, content string, doctype string, chunkSize int, overlapSize int, metadata map[string]any) error {
 
 	_, ok := INSTRUCTIONS[task]
 	if !ok {
@@ -64,8 +60,6 @@ func GenerateEmbeddingForTask(task string, content string, doctype string, chunk
 		return fmt.Errorf("unknown task: %s", task)
 	}
 
-	db := estore.NewEmbeddingDB()
-
 	var chunks []string
 	var separators []string
 
@@ -102,106 +96,62 @@ func GenerateEmbeddingForTask(task string, content string, doctype string, chunk
 		}
 	}
 
-	modelsDir := fmt.Sprintf("%s/data/models/HF/%s/", dataPath, modelName)
-
-	tasksConfig := &tasks.Config{
-		ModelsDir:        modelsDir,
-		ModelName:        modelName,
-		DownloadPolicy:   tasks.DownloadMissing,
-		ConversionPolicy: tasks.ConvertMissing,
+	// Chunks already in the store are not embedded or written again.
+	documentID, _ := metadata["document_id"].(string)
+	var ids, texts []string
+	for _, chunk := range uniqueChunks {
+		id := estore.ChunkID(documentID, chunk)
+		if _, ok, err := store.Get(id); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		ids = append(ids, id)
+		texts = append(texts, chunk)
 	}
-
-	model, err := tasks.Load[textencoding.Interface](tasksConfig)
-	if err != nil {
-		pterm.Error.Println("Error loading model...")
-		return err
+	if len(texts) == 0 {
+		return nil
 	}
 
 	// 3. Embedding Generation
 	pterm.Info.Println("Generating embeddings...")
-	for _, chunk := range uniqueChunks {
-		var vec []float64
-
-		encoder := func(text string) error {
-			result, err := model.Encode(context.Background(), text, int(bert.MeanPooling))
-			if err != nil {
-				return err
-			}
-
-			vec = result.Vector.Data().F64()[:limit]
-			fmt.Println(result.Vector.Data().F64()[:limit])
-			return nil
-		}
-
-		err = encoder(chunk) // Actually invoke the encoder function with the chunk
-		if err != nil {
-			pterm.Error.Println("Error encoding text...")
-			return err
-		}
+	vectors, err := EmbedAll(context.Background(), embedder, texts)
+	if err != nil {
+		pterm.Error.Println("Error encoding text...")
+		return err
+	}
 
-		embedding := estore.Embedding{
-			Word:       chunk,
-			Vector:     vec,
-			Similarity: 0.0,
+	records := make([]estore.Record, len(texts))
+	for i, chunk := range texts {
+		records[i] = estore.Record{
+			ID:       ids[i],
+			Text:     chunk,
+			Vector:   vectors[i],
+			Metadata: metadata,
 		}
-
-		db.AddEmbedding(embedding)
 	}
 
-	// Save the database to a file
+	// Save the embeddings to the vector store
 	pterm.Info.Println("Saving embeddings...")
 
-	dbPath := fmt.Sprintf("%s/embeddings.db", dataPath)
-
-	db.SaveEmbeddings(dbPath)
-
-	return nil
+	return store.Put(records...)
 }
 
-func Search(dataPath string, dbName string, prompt string, topN int) []estore.Embedding {
-	db := estore.NewEmbeddingDB()
-	dbPath := fmt.Sprintf("%s/%s", dataPath, dbName)
-	embeddings, err := db.LoadEmbeddings(dbPath)
-	if err != nil {
-		fmt.Println("Error loading embeddings:", err)
-		return nil
-	}
-
-	embeddingsModelPath := fmt.Sprintf("%s/data/models/HF/", dataPath)
-
-	model, err := tasks.Load[textencoding.Interface](&tasks.Config{
-		ModelsDir:           embeddingsModelPath,
-		ModelName:           modelName,
-		DownloadPolicy:      tasks.DownloadMissing,
-		ConversionPolicy:    tasks.ConvertMissing,
-		ConversionPrecision: tasks.F32,
-	})
-
-	if err != nil {
-		fmt.Println("Error loading model:", err)
-		return nil
-	}
-
-	var vec []float64
-	result, err := model.Encode(context.Background(), prompt, int(bert.MeanPooling))
+// Search returns the topN chunks in the vector store most similar to the prompt that match
+// the filter.
+func Search(store *estore.Store, embedder Embedder, prompt string, topN int, filter ...estore.Condition) []estore.SearchResult {
+	vec, err := embedder.Embed(context.Background(), prompt)
 	if err != nil {
 		fmt.Println("Error encoding text:", err)
 		return nil
 	}
-	vec = result.Vector.Data().F64()[:limit]
-
-	embeddingForPrompt := estore.Embedding{
-		Word:       prompt,
-		Vector:     vec,
-		Similarity: 0.0,
-	}
 
 	// Retrieve the top N similar embeddings
-	topEmbeddings := estore.FindTopNSimilarEmbeddings(embeddingForPrompt, embeddings, topN)
-	if len(topEmbeddings) == 0 {
-		fmt.Println("Error finding similar embeddings.")
+	results, err := store.Search(vec, topN, filter...)
+	if err != nil {
+		fmt.Println("Error finding similar embeddings:", err)
 		return nil
 	}
 
-	return topEmbeddings
+	return results
 }
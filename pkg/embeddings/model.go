@@ -0,0 +1,176 @@
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"eternal/pkg/documents"
+	estore "eternal/pkg/vecstore"
+
+	"github.com/nlpodyssey/cybertron/pkg/models/bert"
+)
+
+// Embedding model providers.
+const (
+	ProviderLocal  = "local"  // Sentence encoder run in process with cybertron
+	ProviderOpenAI = "openai" // OpenAI compatible embeddings endpoint
+)
+
+// Pooling strategies of local sentence encoders.
+const (
+	PoolingMean = "mean"
+	PoolingCLS  = "cls"
+	PoolingMax  = "max"
+)
+
+// DefaultModel is the sentence encoder used when the config declares no embedding models.
+var DefaultModel = ModelConfig{
+	Name:           "gist-small",
+	Provider:       ProviderLocal,
+	Model:          "avsolatorio/GIST-small-Embedding-v0",
+	Dimensions:     384,
+	Pooling:        PoolingMean,
+	MaxInputLength: 2048,
+	MaxTokens:      512,
+}
+
+// ModelConfig declares an embedding model.
+type ModelConfig struct {
+	Name           string `yaml:"-"`                // Name of the model in the config
+	Provider       string `yaml:"provider"`         // ProviderLocal (default) or ProviderOpenAI
+	Model          string `yaml:"model"`            // Hugging Face repository for local models, model name for openai
+	Dimensions     int    `yaml:"dimensions"`       // Length of the vectors the model returns
+	Pooling        string `yaml:"pooling"`          // Pooling of local models: mean (default), cls or max
+	Normalize      bool   `yaml:"normalize"`        // Scale vectors to unit length
+	MaxInputLength int    `yaml:"max_input_length"` // Characters embedded from each text; longer text is cut. Zero embeds everything.
+	MaxTokens      int    `yaml:"max_tokens"`       // Tokens the model reads from a text; token splitters size chunks to fit
+	Workers        int    `yaml:"workers"`          // Instances of a local model, or concurrent openai requests; 1 by default
+	Endpoint       string `yaml:"endpoint"`         // Embeddings URL for openai
+	APIKey         string `yaml:"api_key"`          // Bearer token for openai, OPENAI_API_KEY when empty
+}
+
+// Embedder turns text into a vector.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// Validate checks that the model can be loaded.
+func (m ModelConfig) Validate() error {
+	if m.Model == "" {
+		return fmt.Errorf("embedding model %s has no model", m.Name)
+	}
+	if m.Dimensions <= 0 {
+		return fmt.Errorf("embedding model %s needs dimensions", m.Name)
+	}
+	if m.MaxInputLength < 0 {
+		return fmt.Errorf("embedding model %s has a negative max_input_length", m.Name)
+	}
+	if m.MaxTokens < 0 {
+		return fmt.Errorf("embedding model %s has a negative max_tokens", m.Name)
+	}
+	if m.Workers < 0 {
+		return fmt.Errorf("embedding model %s has a negative number of workers", m.Name)
+	}
+	switch m.Provider {
+	case "", ProviderLocal:
+		if _, err := m.pooling(); err != nil {
+			return err
+		}
+	case ProviderOpenAI:
+	default:
+		return fmt.Errorf("embedding model %s has unknown provider %q", m.Name, m.Provider)
+	}
+	return nil
+}
+
+// Load returns the embedder of the model. Local models are loaded from modelsDir and
+// downloaded there when missing.
+func Load(modelsDir string, m ModelConfig) (Embedder, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.Provider == ProviderOpenAI {
+		return &OpenAIEncoder{config: m}, nil
+	}
+	return LoadEncoder(modelsDir, m)
+}
+
+// Tokenizer returns the tokenizer of the model. Local models count tokens with the
+// vocabulary downloaded with the model to modelsDir; the tokens of openai models are
+// estimated from the length of the text.
+func (m ModelConfig) Tokenizer(modelsDir string) (documents.Tokenizer, error) {
+	if m.Provider == ProviderOpenAI {
+		return documents.ApproxTokenizer{}, nil
+	}
+	tokenizer, err := documents.LoadWordPieceTokenizer(filepath.Join(modelsDir, m.Model))
+	if err != nil {
+		return nil, fmt.Errorf("error loading tokenizer of %s: %v", m.Name, err)
+	}
+	return tokenizer, nil
+}
+
+// pooling returns the cybertron pooling strategy of a local model.
+func (m ModelConfig) pooling() (bert.PoolingStrategyType, error) {
+	switch m.Pooling {
+	case "", PoolingMean:
+		return bert.MeanPooling, nil
+	case PoolingCLS:
+		return bert.ClsTokenPooling, nil
+	case PoolingMax:
+		return bert.MaxPooling, nil
+	}
+	return 0, fmt.Errorf("embedding model %s has unknown pooling %q", m.Name, m.Pooling)
+}
+
+// workers returns the number of texts the model embeds at the same time.
+func (m ModelConfig) workers() int {
+	if m.Workers < 1 {
+		return 1
+	}
+	return m.Workers
+}
+
+// truncate cuts text to the maximum input length of the model.
+func (m ModelConfig) truncate(text string) string {
+	if m.MaxInputLength <= 0 {
+		return text
+	}
+	if runes := []rune(text); len(runes) > m.MaxInputLength {
+		return string(runes[:m.MaxInputLength])
+	}
+	return text
+}
+
+// vector checks that a model returned the declared number of dimensions and normalizes the
+// vector when configured. Vectors are never truncated, since a prefix of an embedding is
+// not an embedding of most models.
+func (m ModelConfig) vector(vec []float64) ([]float32, error) {
+	if len(vec) != m.Dimensions {
+		return nil, fmt.Errorf("embedding model %s returned %d dimensions, the config declares %d", m.Name, len(vec), m.Dimensions)
+	}
+	if m.Normalize {
+		var sum float64
+		for _, v := range vec {
+			sum += v * v
+		}
+		if norm := math.Sqrt(sum); norm > 0 {
+			normalized := make([]float64, len(vec))
+			for i, v := range vec {
+				normalized[i] = v / norm
+			}
+			vec = normalized
+		}
+	}
+	return estore.Float32(vec), nil
+}
+
+// CollectionModel returns the model as recorded on the vector collections it embeds.
+func (m ModelConfig) CollectionModel() estore.CollectionModel {
+	provider := m.Provider
+	if provider == "" {
+		provider = ProviderLocal
+	}
+	return estore.CollectionModel{Name: m.Name, Model: provider + ":" + m.Model, Dimensions: m.Dimensions}
+}
@@ -0,0 +1,73 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelConfigValidate(t *testing.T) {
+	require.NoError(t, DefaultModel.Validate())
+
+	for name, m := range map[string]ModelConfig{
+		"no model":      {Dimensions: 8},
+		"no dimensions": {Model: "org/model"},
+		"provider":      {Provider: "cohere", Model: "org/model", Dimensions: 8},
+		"pooling":       {Model: "org/model", Dimensions: 8, Pooling: "sum"},
+		"input length":  {Model: "org/model", Dimensions: 8, MaxInputLength: -1},
+	} {
+		assert.Error(t, m.Validate(), name)
+	}
+}
+
+func TestModelConfigVector(t *testing.T) {
+	m := ModelConfig{Name: "test", Model: "org/model", Dimensions: 2}
+
+	vec, err := m.vector([]float64{3, 4})
+	require.NoError(t, err)
+	assert.Equal(t, []float32{3, 4}, vec)
+
+	// Vectors of the wrong length are rejected rather than cut.
+	_, err = m.vector([]float64{3, 4, 5})
+	assert.Error(t, err)
+
+	m.Normalize = true
+	vec, err = m.vector([]float64{3, 4})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{0.6, 0.8}, vec, 1e-6)
+
+	m.MaxInputLength = 3
+	assert.Equal(t, "héé", m.truncate("héééé"))
+}
+
+func TestOpenAIEncoder(t *testing.T) {
+	var got EmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(EmbedResponse{Data: []EmbedData{{Embedding: []float64{0, 2}}}})
+	}))
+	defer server.Close()
+
+	embedder, err := Load("", ModelConfig{
+		Name:           "remote",
+		Provider:       ProviderOpenAI,
+		Model:          "nomic-embed-text",
+		Dimensions:     2,
+		Normalize:      true,
+		MaxInputLength: 5,
+		Endpoint:       server.URL,
+		APIKey:         "secret",
+	})
+	require.NoError(t, err)
+
+	vec, err := embedder.Embed(context.Background(), "hello world")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, 1}, vec)
+	assert.Equal(t, EmbedRequest{Model: "nomic-embed-text", Input: "hello"}, got)
+}
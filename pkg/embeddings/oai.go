@@ -2,6 +2,7 @@ package embeddings
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"eternal/pkg/documents"
 	"eternal/pkg/llm/openai"
@@ -26,10 +27,21 @@ type ErrorResponse struct {
 
 // EmbedRequest encapsulates the request data for the OpenAI Embeddings API.
 type EmbedRequest struct {
-	Model string `json:"model"`
-	Input string `json:"input"`
+	Model      string `json:"model"`
+	Input      string `json:"input"`
+	Dimensions int    `json:"dimensions,omitempty"` // Supported by text-embedding-3 and later models
 }
 
+// batchEmbedRequest embeds several inputs in one request.
+type batchEmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+// openAIBatchSize is the number of texts sent in one embeddings request.
+const openAIBatchSize = 64
+
 // EmbedResponse contains the response data from the Embeddings API.
 type EmbedResponse struct {
 	Object string       `json:"object"`
@@ -51,6 +63,9 @@ type UsageMetrics struct {
 	TotalTokens  int `json:"total_tokens"`
 }
 
+// defaultEmbeddingsEndpoint is the OpenAI Embeddings API.
+const defaultEmbeddingsEndpoint = "https://api.openai.com/v1/embeddings"
+
 // GetEmbeddings interacts with the OpenAI Embeddings API to retrieve embeddings based on the provided request.
 // It returns an EmbedResponse pointer and any error encountered during the API call.
 func GetEmbeddings(req EmbedRequest) (*EmbedResponse, error) {
@@ -58,7 +73,11 @@ func GetEmbeddings(req EmbedRequest) (*EmbedResponse, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
 	}
+	return requestEmbeddings(context.Background(), defaultEmbeddingsEndpoint, apiKey, req)
+}
 
+// requestEmbeddings sends an embeddings request to an OpenAI compatible endpoint.
+func requestEmbeddings(ctx context.Context, endpoint string, apiKey string, req any) (*EmbedResponse, error) {
 	client := openai.NewClient(apiKey)
 
 	jsonData, err := json.Marshal(req)
@@ -66,13 +85,15 @@ func GetEmbeddings(req EmbedRequest) (*EmbedResponse, error) {
 		return nil, fmt.Errorf("failed to marshal request data: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new HTTP request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	if apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
 
 	resp, err := client.HTTP.Do(httpReq)
 	if err != nil {
@@ -108,6 +129,101 @@ func GetEmbeddings(req EmbedRequest) (*EmbedResponse, error) {
 	return &embedResponse, nil
 }
 
+// OpenAIEncoder embeds text with an OpenAI compatible embeddings endpoint.
+type OpenAIEncoder struct {
+	config ModelConfig
+}
+
+// endpoint returns the embeddings URL and API key of the model.
+func (e *OpenAIEncoder) endpoint() (string, string) {
+	endpoint := e.config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEmbeddingsEndpoint
+	}
+	apiKey := e.config.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	return endpoint, apiKey
+}
+
+// dimensions returns the dimensions to ask the endpoint for. The OpenAI API is asked for
+// the configured dimensions, which text-embedding-3 models shorten their vectors to. Other
+// endpoints are not, since many reject the field.
+func (e *OpenAIEncoder) dimensions(endpoint string) int {
+	if endpoint == defaultEmbeddingsEndpoint && e.config.Model != "text-embedding-ada-002" {
+		return e.config.Dimensions
+	}
+	return 0
+}
+
+// Embed returns the embedding of the text.
+func (e *OpenAIEncoder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbedBatch embeds the texts in requests of up to openAIBatchSize texts, sending as many
+// requests at a time as the model has workers.
+func (e *OpenAIEncoder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	batches := (len(texts) + openAIBatchSize - 1) / openAIBatchSize
+	err := parallel(ctx, batches, e.config.workers(), func(ctx context.Context, i int) error {
+		start := i * openAIBatchSize
+		end := min(start+openAIBatchSize, len(texts))
+		batch, err := e.embed(ctx, texts[start:end])
+		if err != nil {
+			return err
+		}
+		copy(vectors[start:end], batch)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// embed sends one embeddings request for the texts.
+func (e *OpenAIEncoder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	endpoint, apiKey := e.endpoint()
+
+	inputs := make([]string, len(texts))
+	for i, text := range texts {
+		inputs[i] = e.config.truncate(text)
+	}
+
+	var req any = batchEmbedRequest{Model: e.config.Model, Input: inputs, Dimensions: e.dimensions(endpoint)}
+	if len(inputs) == 1 {
+		// Some compatible servers only accept a single string.
+		req = EmbedRequest{Model: e.config.Model, Input: inputs[0], Dimensions: e.dimensions(endpoint)}
+	}
+
+	resp, err := requestEmbeddings(ctx, endpoint, apiKey, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings response has %d vectors for %d inputs", len(resp.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(texts) || vectors[data.Index] != nil {
+			return nil, fmt.Errorf("embeddings response has an unexpected index %d", data.Index)
+		}
+		vector, err := e.config.vector(data.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		vectors[data.Index] = vector
+	}
+	return vectors, nil
+}
+
 func GenerateEmbeddingOAI() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: main.go <path_to_input_file>")
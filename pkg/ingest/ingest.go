@@ -0,0 +1,491 @@
+// Package ingest turns uploaded files into chunks that are embedded into a vector store and
+// indexed for keyword search.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"eternal/pkg/documents"
+	"eternal/pkg/jobs"
+	"eternal/pkg/vecstore"
+)
+
+// File types recognized by the pipeline.
+const (
+	TypePDF      = "pdf"
+	TypeText     = "text"
+	TypeMarkdown = "markdown"
+	TypeHTML     = "html"
+	TypeCode     = "code"
+)
+
+// Pipeline steps reported as job progress.
+const (
+	StepDetect  = "detect"
+	StepExtract = "extract"
+	StepChunk   = "chunk"
+	StepEmbed   = "embed"
+	StepIndex   = "index"
+)
+
+const (
+	defaultChunkSize = 1000
+	defaultOverlap   = 150
+	embedBatchSize   = 64
+)
+
+// ErrUnsupportedType is returned for files the pipeline cannot extract text from.
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// markdownTypes are the file types split into sections at their headings. PDF pages and
+// HTML are extracted as Markdown.
+var markdownTypes = map[string]bool{
+	TypeMarkdown: true,
+	TypePDF:      true,
+	TypeHTML:     true,
+}
+
+// codeLanguages maps source file extensions to the splitter language of the file.
+var codeLanguages = map[string]documents.Language{
+	".go":   documents.GO,
+	".py":   documents.PYTHON,
+	".js":   documents.JS,
+	".jsx":  documents.JS,
+	".mjs":  documents.JS,
+	".ts":   documents.TS,
+	".tsx":  documents.TS,
+	".json": documents.JSON,
+}
+
+// Source is a file to ingest.
+type Source struct {
+	DocumentID string
+	Path       string
+	Name       string         // Original file name
+	Metadata   map[string]any // Copied to every chunk, for example a knowledge base
+}
+
+// Chunk is a piece of a document written to the indexes.
+type Chunk struct {
+	ID         string
+	DocumentID string
+	Index      int
+	Page       int    // Page of a PDF the chunk starts on, counting from 1; 0 for other types
+	Headings   string // Heading path of the section of a structured document, such as "Guide > Install"
+	ParentID   string // ID of the parent section when the pipeline stores parents
+	Text       string
+	Metadata   map[string]any
+}
+
+// Parent is a section of a structured document that encloses smaller chunks. Retrieval can
+// match a chunk and return its parent, which has the context around the match.
+type Parent struct {
+	ID         string
+	DocumentID string
+	Headings   string
+	Page       int
+	Text       string
+}
+
+// ParentStore keeps the parent sections of chunks.
+type ParentStore interface {
+	SaveParents(documentID string, parents []Parent) error // Replaces the parents of the document
+	DeleteParents(documentID string) error
+}
+
+// Embedder turns text into a vector.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// BatchEmbedder is an Embedder that embeds several texts at once. The pipeline embeds
+// chunks in batches when the embedder supports it.
+type BatchEmbedder interface {
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// LexicalIndex stores chunks for keyword search.
+type LexicalIndex interface {
+	IndexChunks(source Source, chunks []Chunk) error
+	DeleteDocument(documentID string) error
+}
+
+// Result summarizes an ingested document.
+type Result struct {
+	DocumentID string `json:"document_id"`
+	Type       string `json:"type"`
+	Characters int    `json:"characters"`
+	Chunks     int    `json:"chunks"`
+}
+
+// Pipeline detects the type of a file, extracts its text, splits it into chunks, embeds the
+// chunks and writes them to the vector store and the lexical index.
+type Pipeline struct {
+	Store     *vecstore.Store
+	Embedder  Embedder
+	Lexical   LexicalIndex
+	ChunkSize int                // Characters per chunk, 1000 by default
+	Overlap   int                // Characters repeated between chunks, 150 by default
+	Splitter  documents.Splitter // Splits pages instead of ChunkSize and Overlap when set
+
+	Parents      ParentStore // Stores the sections of structured documents as parents of their chunks when set
+	HeadingLevel int         // Deepest heading level that starts a section, 3 by default
+	ParentSize   int         // Characters per parent section, 4000 by default
+}
+
+// Ingest runs the pipeline for one file. Chunks from an earlier ingest of the same
+// document are replaced. The report function receives the progress of each step.
+func (p *Pipeline) Ingest(ctx context.Context, src Source, report func(jobs.Progress)) (Result, error) {
+	if report == nil {
+		report = func(jobs.Progress) {}
+	}
+	result := Result{DocumentID: src.DocumentID}
+
+	report(jobs.Progress{Step: StepDetect, Message: src.Name})
+	fileType, language, err := DetectType(src.Path)
+	if err != nil {
+		return result, err
+	}
+	result.Type = fileType
+
+	report(jobs.Progress{Step: StepExtract, Message: src.Name})
+	pages, err := ExtractPages(src.Path, fileType)
+	if err != nil {
+		return result, err
+	}
+	return p.index(ctx, src, fileType, language, pages, report)
+}
+
+// IngestText runs the pipeline for text that is already in memory, such as a file read
+// from a Git commit. The type and splitter language are taken from the extension of the
+// source name, and files without a known extension are treated as plain text.
+func (p *Pipeline) IngestText(ctx context.Context, src Source, text string, report func(jobs.Progress)) (Result, error) {
+	if report == nil {
+		report = func(jobs.Progress) {}
+	}
+
+	fileType, language, ok := TypeFromName(src.Name)
+	if !ok || fileType == TypePDF {
+		fileType, language = TypeText, ""
+	}
+	if fileType == TypeHTML {
+		extracted, err := extractHTML(strings.NewReader(text))
+		if err != nil {
+			return Result{DocumentID: src.DocumentID, Type: fileType}, err
+		}
+		text = extracted
+	}
+	return p.index(ctx, src, fileType, language, []string{text}, report)
+}
+
+// index chunks, embeds and indexes the extracted pages of a document.
+func (p *Pipeline) index(ctx context.Context, src Source, fileType string, language documents.Language, pages []string, report func(jobs.Progress)) (Result, error) {
+	result := Result{DocumentID: src.DocumentID, Type: fileType}
+
+	text := strings.Join(pages, "")
+	result.Characters = len([]rune(text))
+	if strings.TrimSpace(text) == "" {
+		return result, fmt.Errorf("no text found in %s", src.Name)
+	}
+
+	report(jobs.Progress{Step: StepChunk})
+	chunks, parents, err := p.chunk(ctx, src, fileType, language, pages)
+	if err != nil {
+		return result, fmt.Errorf("error splitting %s: %v", src.Name, err)
+	}
+	result.Chunks = len(chunks)
+
+	// Embed before touching the indexes, so that a document that fails to embed keeps the
+	// chunks of its previous ingest.
+	records, err := p.embed(ctx, chunks, report)
+	if err != nil {
+		return result, err
+	}
+
+	// Replace the chunks of a previous ingest, and remove partial results on failure.
+	report(jobs.Progress{Step: StepIndex, Done: 0, Total: len(chunks)})
+	if err := p.Delete(src.DocumentID); err != nil {
+		return result, err
+	}
+	if err := p.Store.Put(records...); err != nil {
+		p.Delete(src.DocumentID)
+		return result, fmt.Errorf("error storing vectors of %s: %v", src.Name, err)
+	}
+	if err := p.Lexical.IndexChunks(src, chunks); err != nil {
+		p.Delete(src.DocumentID)
+		return result, fmt.Errorf("error indexing %s: %v", src.Name, err)
+	}
+	if p.Parents != nil {
+		if err := p.Parents.SaveParents(src.DocumentID, parents); err != nil {
+			p.Delete(src.DocumentID)
+			return result, fmt.Errorf("error saving sections of %s: %v", src.Name, err)
+		}
+	}
+	report(jobs.Progress{Step: StepIndex, Done: len(chunks), Total: len(chunks)})
+
+	return result, nil
+}
+
+// Delete removes every chunk of a document from both indexes, and its parents.
+func (p *Pipeline) Delete(documentID string) error {
+	if _, err := p.Store.DeleteMatching(vecstore.Eq("document_id", documentID)); err != nil {
+		return fmt.Errorf("error deleting vectors of %s: %v", documentID, err)
+	}
+	if err := p.Lexical.DeleteDocument(documentID); err != nil {
+		return fmt.Errorf("error deleting chunks of %s: %v", documentID, err)
+	}
+	if p.Parents != nil {
+		if err := p.Parents.DeleteParents(documentID); err != nil {
+			return fmt.Errorf("error deleting sections of %s: %v", documentID, err)
+		}
+	}
+	return nil
+}
+
+// chunk splits the text of each page and attaches the document metadata to each chunk.
+// Chunks of a PDF do not span pages, so that every chunk can cite the page it came from.
+// Markdown, PDF and HTML pages are first split into sections at their headings, and each
+// chunk records the heading path of its section. When the pipeline stores parents, the
+// sections are returned as the parents of their chunks.
+func (p *Pipeline) chunk(ctx context.Context, src Source, fileType string, language documents.Language, pages []string) ([]Chunk, []Parent, error) {
+	splitter := p.Splitter
+	if splitter == nil {
+		size, overlap := p.ChunkSize, p.Overlap
+		if size <= 0 {
+			size = defaultChunkSize
+		}
+		if overlap <= 0 {
+			overlap = defaultOverlap
+		}
+		splitter = documents.CharacterSplitter{ChunkSize: size, Overlap: overlap}
+	}
+	markdown := documents.MarkdownSplitter{Splitter: splitter, HeadingLevel: p.HeadingLevel, ParentSize: p.ParentSize}
+
+	now := time.Now().Unix()
+	seen := make(map[string]bool)
+	var chunks []Chunk
+	var parents []Parent
+	var open []documents.Heading // Headings open at the end of the previous page
+	for i, page := range pages {
+		pageNumber := 0
+		if fileType == TypePDF {
+			pageNumber = i + 1
+		}
+
+		var sections []documents.MarkdownParent
+		if markdownTypes[fileType] {
+			var err error
+			if sections, open, err = markdown.Split(ctx, page, open); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			texts, err := splitter.Split(ctx, page, language)
+			if err != nil {
+				return nil, nil, err
+			}
+			sections = []documents.MarkdownParent{{Children: texts}}
+		}
+
+		for _, section := range sections {
+			var parentID string
+			if p.Parents != nil && section.Text != "" {
+				parentID = vecstore.ChunkID(src.DocumentID, section.Text)
+				if !seen["parent:"+parentID] {
+					seen["parent:"+parentID] = true
+					parents = append(parents, Parent{ID: parentID, DocumentID: src.DocumentID, Headings: section.Headings, Page: pageNumber, Text: section.Text})
+				}
+			}
+
+			for _, text := range section.Children {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				id := vecstore.ChunkID(src.DocumentID, text)
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				metadata := map[string]any{
+					"document_id": src.DocumentID,
+					"source":      "document",
+					"title":       src.Name,
+					"type":        fileType,
+					"chunk":       len(chunks),
+					"created_at":  now,
+				}
+				if pageNumber > 0 {
+					metadata["page"] = pageNumber
+				}
+				for k, v := range src.Metadata {
+					metadata[k] = v
+				}
+
+				if section.Headings != "" {
+					metadata["headings"] = section.Headings
+				}
+				if parentID != "" {
+					metadata["parent_id"] = parentID
+				}
+
+				chunks = append(chunks, Chunk{
+					ID:         id,
+					DocumentID: src.DocumentID,
+					Index:      len(chunks),
+					Page:       pageNumber,
+					Headings:   section.Headings,
+					ParentID:   parentID,
+					Text:       text,
+					Metadata:   metadata,
+				})
+			}
+		}
+	}
+	return chunks, parents, nil
+}
+
+// embed embeds the chunks in batches and returns them as vector records.
+func (p *Pipeline) embed(ctx context.Context, chunks []Chunk, report func(jobs.Progress)) ([]vecstore.Record, error) {
+	records := make([]vecstore.Record, 0, len(chunks))
+	for start := 0; start < len(chunks); start += embedBatchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		batch := chunks[start:min(start+embedBatchSize, len(chunks))]
+		vectors, err := p.embedBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, chunk := range batch {
+			records = append(records, vecstore.Record{ID: chunk.ID, Text: chunk.Text, Vector: vectors[i], Metadata: chunk.Metadata})
+		}
+		report(jobs.Progress{Step: StepEmbed, Done: start + len(batch), Total: len(chunks)})
+	}
+	return records, nil
+}
+
+// embedBatch embeds a batch of chunks, in one call when the embedder supports batches.
+func (p *Pipeline) embedBatch(ctx context.Context, chunks []Chunk) ([][]float32, error) {
+	if b, ok := p.Embedder.(BatchEmbedder); ok {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Text
+		}
+		vectors, err := b.EmbedBatch(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("error embedding chunks %d to %d: %v", chunks[0].Index, chunks[len(chunks)-1].Index, err)
+		}
+		return vectors, nil
+	}
+
+	vectors := make([][]float32, len(chunks))
+	for i, chunk := range chunks {
+		vector, err := p.Embedder.Embed(ctx, chunk.Text)
+		if err != nil {
+			return nil, fmt.Errorf("error embedding chunk %d: %v", chunk.Index, err)
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// DetectType returns the file type and splitter language of a file from its extension,
+// falling back to sniffing its content.
+func DetectType(path string) (string, documents.Language, error) {
+	if fileType, language, ok := TypeFromName(path); ok {
+		return fileType, language, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	switch {
+	case strings.HasPrefix(contentType, "application/pdf"):
+		return TypePDF, "", nil
+	case strings.HasPrefix(contentType, "text/html"):
+		return TypeHTML, "", nil
+	case strings.HasPrefix(contentType, "text/"):
+		return TypeText, "", nil
+	}
+	return "", "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+}
+
+// ExtractPages returns the text content of each page of a PDF, or the whole text content
+// of any other file as a single page.
+func ExtractPages(path string, fileType string) ([]string, error) {
+	if fileType == TypePDF {
+		return documents.GetPdfPages(path)
+	}
+	text, err := Extract(path, fileType)
+	if err != nil {
+		return nil, err
+	}
+	return []string{text}, nil
+}
+
+// TypeFromName returns the file type and splitter language for the extension of a file
+// name, and whether the extension is known.
+func TypeFromName(name string) (string, documents.Language, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".pdf":
+		return TypePDF, "", true
+	case ".md", ".markdown":
+		return TypeMarkdown, documents.MARKDOWN, true
+	case ".html", ".htm":
+		return TypeHTML, "", true
+	case ".txt", ".text", ".log", ".csv":
+		return TypeText, "", true
+	}
+	if language, ok := codeLanguages[ext]; ok {
+		return TypeCode, language, true
+	}
+	return "", "", false
+}
+
+// Extract returns the text content of a file of the given type.
+func Extract(path string, fileType string) (string, error) {
+	switch fileType {
+	case TypePDF:
+		return documents.GetPdfContents(path)
+	case TypeHTML:
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return extractHTML(f)
+	case TypeText, TypeMarkdown, TypeCode:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedType, fileType)
+}
+
+// extractHTML returns the visible text of an HTML page as Markdown, keeping its headings.
+func extractHTML(r io.Reader) (string, error) {
+	return documents.HTMLToMarkdown(r)
+}
@@ -0,0 +1,308 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eternal/pkg/documents"
+	"eternal/pkg/jobs"
+	"eternal/pkg/vecstore"
+)
+
+// letterEmbedder embeds text as the counts of a few letters, which is enough to store and
+// search chunks without a model.
+type letterEmbedder struct {
+	failAt int
+	calls  int
+}
+
+func (e *letterEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	e.calls++
+	if e.failAt > 0 && e.calls == e.failAt {
+		return nil, errors.New("model crashed")
+	}
+	vector := make([]float32, 4)
+	for i, letter := range "aeio" {
+		vector[i] = float32(strings.Count(text, string(letter))) + 1
+	}
+	return vector, nil
+}
+
+// batchLetterEmbedder embeds batches with a letterEmbedder and records the batch sizes.
+type batchLetterEmbedder struct {
+	letterEmbedder
+	batches []int
+}
+
+func (e *batchLetterEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	e.batches = append(e.batches, len(texts))
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector, err := e.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+type memoryLexical struct {
+	chunks map[string][]Chunk
+}
+
+func (m *memoryLexical) IndexChunks(source Source, chunks []Chunk) error {
+	m.chunks[source.DocumentID] = chunks
+	return nil
+}
+
+func (m *memoryLexical) DeleteDocument(documentID string) error {
+	delete(m.chunks, documentID)
+	return nil
+}
+
+type memoryParents struct {
+	parents map[string][]Parent
+}
+
+func (m *memoryParents) SaveParents(documentID string, parents []Parent) error {
+	m.parents[documentID] = parents
+	return nil
+}
+
+func (m *memoryParents) DeleteParents(documentID string) error {
+	delete(m.parents, documentID)
+	return nil
+}
+
+func newTestPipeline(t *testing.T) (*Pipeline, *memoryLexical) {
+	store, err := vecstore.Open(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	lexical := &memoryLexical{chunks: make(map[string][]Chunk)}
+	return &Pipeline{Store: store, Embedder: &letterEmbedder{}, Lexical: lexical, ChunkSize: 80, Overlap: 10}, lexical
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestPipelineIngestReingestDelete(t *testing.T) {
+	p, lexical := newTestPipeline(t)
+
+	var text string
+	for i := 0; i < 10; i++ {
+		text += fmt.Sprintf("# Heading %d\n\nSome paragraph about vectors and indexes.\n\n", i)
+	}
+	path := writeFile(t, "notes.md", text)
+
+	var steps []string
+	result, err := p.Ingest(context.Background(), Source{DocumentID: "doc1", Path: path, Name: "notes.md", Metadata: map[string]any{"knowledge_base": "kb"}}, func(progress jobs.Progress) {
+		if len(steps) == 0 || steps[len(steps)-1] != progress.Step {
+			steps = append(steps, progress.Step)
+		}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{StepDetect, StepExtract, StepChunk, StepEmbed, StepIndex}, steps)
+	assert.Equal(t, TypeMarkdown, result.Type)
+	assert.Greater(t, result.Chunks, 1)
+	assert.Equal(t, result.Chunks, p.Store.Len())
+	assert.Len(t, lexical.chunks["doc1"], result.Chunks)
+
+	record, ok, err := p.Store.Get(lexical.chunks["doc1"][0].ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "doc1", record.Metadata["document_id"])
+	assert.Equal(t, "kb", record.Metadata["knowledge_base"])
+	assert.Equal(t, "notes.md", record.Metadata["title"])
+	assert.Equal(t, "Heading 0", record.Metadata["headings"])
+
+	// Ingesting a changed file replaces the old chunks.
+	require.NoError(t, os.WriteFile(path, []byte("A much shorter note."), 0644))
+	result, err = p.Ingest(context.Background(), Source{DocumentID: "doc1", Path: path, Name: "notes.md"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Chunks)
+	assert.Equal(t, 1, p.Store.Len())
+
+	require.NoError(t, p.Delete("doc1"))
+	assert.Zero(t, p.Store.Len())
+	assert.Empty(t, lexical.chunks)
+}
+
+func TestPipelineRemovesPartialResults(t *testing.T) {
+	p, lexical := newTestPipeline(t)
+	p.Embedder = &letterEmbedder{failAt: 3}
+
+	path := writeFile(t, "long.txt", strings.Repeat("words and more words. ", 40))
+	_, err := p.Ingest(context.Background(), Source{DocumentID: "doc2", Path: path, Name: "long.txt"}, nil)
+	assert.ErrorContains(t, err, "model crashed")
+	assert.Zero(t, p.Store.Len())
+	assert.Empty(t, lexical.chunks)
+}
+
+func TestPipelineKeepsPreviousVersionOnFailure(t *testing.T) {
+	p, lexical := newTestPipeline(t)
+
+	path := writeFile(t, "long.txt", strings.Repeat("words and more words. ", 40))
+	result, err := p.Ingest(context.Background(), Source{DocumentID: "doc3", Path: path, Name: "long.txt"}, nil)
+	require.NoError(t, err)
+
+	// A changed file that fails to embed leaves the previous version searchable.
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("other words entirely. ", 40)), 0644))
+	p.Embedder = &letterEmbedder{failAt: 2}
+	_, err = p.Ingest(context.Background(), Source{DocumentID: "doc3", Path: path, Name: "long.txt"}, nil)
+	assert.ErrorContains(t, err, "model crashed")
+	assert.Equal(t, result.Chunks, p.Store.Len())
+	require.Len(t, lexical.chunks["doc3"], result.Chunks)
+	assert.Contains(t, lexical.chunks["doc3"][0].Text, "words and more words")
+}
+
+func TestPipelineEmbedsBatches(t *testing.T) {
+	p, _ := newTestPipeline(t)
+	embedder := &batchLetterEmbedder{}
+	p.Embedder = embedder
+	p.ChunkSize = 20
+
+	var words []string
+	for i := 0; i < 100; i++ {
+		words = append(words, fmt.Sprintf("word%03d", i))
+	}
+	path := writeFile(t, "words.txt", strings.Join(words, " "))
+	result, err := p.Ingest(context.Background(), Source{DocumentID: "doc3", Path: path, Name: "words.txt"}, nil)
+	require.NoError(t, err)
+	require.Greater(t, result.Chunks, embedBatchSize)
+
+	assert.Equal(t, []int{embedBatchSize, result.Chunks - embedBatchSize}, embedder.batches)
+	assert.Equal(t, result.Chunks, p.Store.Len())
+}
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		fileType string
+	}{
+		{"report.pdf", "%PDF-1.4", TypePDF},
+		{"page.htm", "<html></html>", TypeHTML},
+		{"main.go", "package main", TypeCode},
+		{"README", "plain words", TypeText},
+		{"page", "<!DOCTYPE html><html><body>hi</body></html>", TypeHTML},
+	}
+	for _, tt := range tests {
+		fileType, _, err := DetectType(writeFile(t, tt.name, tt.content))
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.fileType, fileType, tt.name)
+	}
+
+	_, _, err := DetectType(writeFile(t, "image", "\x89PNG\r\n\x1a\n\x00\x00"))
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+}
+
+func TestExtractHTML(t *testing.T) {
+	path := writeFile(t, "page.html", "<html><head><script>var x = 1;</script></head><body><p>Visible text</p></body></html>")
+	text, err := Extract(path, TypeHTML)
+	require.NoError(t, err)
+	assert.Equal(t, "Visible text", text)
+}
+
+func TestChunkRecordsPDFPages(t *testing.T) {
+	p, _ := newTestPipeline(t)
+
+	chunks, _, err := p.chunk(context.Background(), Source{DocumentID: "doc3", Name: "paper.pdf"}, TypePDF, "", []string{"First page.", "", "Third page."})
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, 1, chunks[0].Page)
+	assert.Equal(t, 3, chunks[1].Page)
+	assert.Equal(t, 3, chunks[1].Metadata["page"])
+
+	chunks, _, err = p.chunk(context.Background(), Source{DocumentID: "doc4", Name: "notes.txt"}, TypeText, "", []string{"Only page."})
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Zero(t, chunks[0].Page)
+	assert.NotContains(t, chunks[0].Metadata, "page")
+}
+
+func TestChunkWithSplitter(t *testing.T) {
+	p, _ := newTestPipeline(t)
+	p.Splitter = documents.TokenSplitter{Tokenizer: documents.ApproxTokenizer{}, ChunkSize: 5}
+
+	chunks, _, err := p.chunk(context.Background(), Source{DocumentID: "doc5", Name: "notes.txt"}, TypeText, "", []string{"One short line. Another short line."})
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "One short line. ", chunks[0].Text)
+	assert.Equal(t, "Another short line.", chunks[1].Text)
+}
+
+func TestPipelineStoresParentSections(t *testing.T) {
+	p, lexical := newTestPipeline(t)
+	parents := &memoryParents{parents: make(map[string][]Parent)}
+	p.Parents = parents
+	p.ChunkSize = 40
+	p.Overlap = 1
+
+	text := "# Guide\n\nIntro to the guide.\n\n## Install\n\nDownload the archive. Unpack it in your home directory. Add the binary to your path.\n\n### Linux\n\nUse the package manager.\n"
+	path := writeFile(t, "guide.md", text)
+	_, err := p.Ingest(context.Background(), Source{DocumentID: "doc6", Path: path, Name: "guide.md"}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, parents.parents["doc6"], 3)
+	install := parents.parents["doc6"][1]
+	assert.Equal(t, "Guide > Install", install.Headings)
+	assert.True(t, strings.HasPrefix(install.Text, "## Install"))
+
+	var children []Chunk
+	for _, chunk := range lexical.chunks["doc6"] {
+		if chunk.ParentID == install.ID {
+			children = append(children, chunk)
+		}
+	}
+	require.Greater(t, len(children), 1, "the section is split into smaller chunks")
+	for _, chunk := range children {
+		assert.Equal(t, "Guide > Install", chunk.Headings)
+		assert.Equal(t, install.ID, chunk.Metadata["parent_id"])
+		assert.Contains(t, install.Text, chunk.Text)
+	}
+	assert.Equal(t, "Guide > Install > Linux", parents.parents["doc6"][2].Headings)
+
+	require.NoError(t, p.Delete("doc6"))
+	assert.Empty(t, parents.parents)
+}
+
+func TestChunkCarriesHeadingsAcrossPages(t *testing.T) {
+	p, _ := newTestPipeline(t)
+
+	chunks, parents, err := p.chunk(context.Background(), Source{DocumentID: "doc7", Name: "paper.pdf"}, TypePDF, "", []string{"## RESULTS\n\nFirst finding.\n\n", "Continued on the next page.\n\n"})
+	require.NoError(t, err)
+	assert.Empty(t, parents, "parents are only kept with a parent store")
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "RESULTS", chunks[1].Headings)
+	assert.Equal(t, 2, chunks[1].Page)
+	assert.Empty(t, chunks[1].ParentID)
+}
+
+func TestIngestText(t *testing.T) {
+	p, lexical := newTestPipeline(t)
+
+	code := strings.Repeat("func handler() {\n\treturn nil\n}\n\n", 8)
+	result, err := p.IngestText(context.Background(), Source{DocumentID: "git-1", Name: "pkg/server/handler.go", Metadata: map[string]any{"source": "git"}}, code, nil)
+	require.NoError(t, err)
+	assert.Equal(t, TypeCode, result.Type)
+	assert.Greater(t, result.Chunks, 1)
+	assert.Equal(t, "git", lexical.chunks["git-1"][0].Metadata["source"])
+	assert.Equal(t, "pkg/server/handler.go", lexical.chunks["git-1"][0].Metadata["title"])
+
+	result, err = p.IngestText(context.Background(), Source{DocumentID: "git-2", Name: "LICENSE"}, "Permission is granted.", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TypeText, result.Type)
+}
@@ -1,166 +1,271 @@
-package main
+// Package jobs runs work on a bounded pool of background workers and reports the progress
+// of each job to subscribers.
+package jobs
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
-// Constants for different job statuses.
+// Job states.
 const (
-	queued    = "queued"
-	running   = "running"
-	completed = "completed"
-	failed    = "failed"
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
 )
 
-var (
-	jobQueue     = make(chan *Job, 100)
-	jobStatusMap = make(map[string]*jobStatus)
-	mutex        = &sync.Mutex{}
-	wg           sync.WaitGroup
-)
+// ErrQueueFull is returned by Submit when the queue cannot take more jobs.
+var ErrQueueFull = errors.New("job queue is full")
 
-// JobType represents different types of jobs that can be handled.
-type JobType int
+// ErrQueueClosed is returned by Submit after Close.
+var ErrQueueClosed = errors.New("job queue is closed")
 
-// Enumeration of different JobTypes.
-const (
-	WriteTimeToFile JobType = iota
-	AnotherJobType
-	// Future job types should be added here.
-)
+// Progress is reported by a running job.
+type Progress struct {
+	Step    string `json:"step"`
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
 
-// Job defines the structure of a job including its type, payload, and callback.
+// Job is a unit of work. Run reports progress through the report function and should
+// return when ctx is canceled.
 type Job struct {
-	ID       string
-	JobType  JobType
-	Payload  interface{}
-	Callback func(result interface{}, err error)
+	ID    string
+	Type  string
+	Label string // Shown to users, for example a file name
+	Run   func(ctx context.Context, report func(Progress)) (any, error)
 }
 
-// jobStatus represents the current status of a job along with its result or error.
-type jobStatus struct {
-	Status string
-	Result interface{}
-	Error  error
+// Status is the current state of a job.
+type Status struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Label     string    `json:"label,omitempty"`
+	State     string    `json:"state"`
+	Progress  Progress  `json:"progress"`
+	Result    any       `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// worker is a goroutine that processes jobs from the jobQueue.
-func worker() {
-	for job := range jobQueue {
-		processJob(job)
-	}
+// Done reports whether the job has finished.
+func (s Status) Done() bool {
+	return s.State == StateCompleted || s.State == StateFailed || s.State == StateCanceled
 }
 
-// processJob handles the execution and updating of the job status.
-func processJob(job *Job) {
-	status := &jobStatus{Status: running}
-	mutex.Lock()
-	jobStatusMap[job.ID] = status
-	mutex.Unlock()
+// Queue runs submitted jobs on a fixed number of workers.
+type Queue struct {
+	mu          sync.Mutex
+	jobs        chan *Job
+	statuses    map[string]*Status
+	subscribers map[chan Status]struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	closed      bool
 
-	result, err := executeJob(job)
-	updateJobStatus(job.ID, result, err)
+	// History is the number of finished jobs whose status is kept.
+	History int
+}
+
+// NewQueue starts workers that process up to size queued jobs.
+func NewQueue(workers int, size int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		jobs:        make(chan *Job, size),
+		statuses:    make(map[string]*Status),
+		subscribers: make(map[chan Status]struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+		History:     100,
+	}
 
-	job.Callback(result, err)
-	wg.Done()
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q
 }
 
-// executeJob executes the given job based on its JobType.
-func executeJob(job *Job) (interface{}, error) {
-	switch job.JobType {
-	case WriteTimeToFile:
-		return writeTimeToFile(job.Payload)
-	case AnotherJobType:
-		return anotherJobFunction(job.Payload)
+// Submit queues a job. A job with the ID of a job that is still queued or running is
+// rejected.
+func (q *Queue) Submit(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if status, ok := q.statuses[job.ID]; ok && !status.Done() {
+		return fmt.Errorf("job %s is already %s", job.ID, status.State)
+	}
+
+	now := time.Now()
+	status := &Status{ID: job.ID, Type: job.Type, Label: job.Label, State: StateQueued, CreatedAt: now, UpdatedAt: now}
+
+	select {
+	case q.jobs <- job:
 	default:
-		return nil, errors.New("unknown job type")
+		return ErrQueueFull
 	}
-}
 
-// SubmitJob adds a job to the jobQueue and tracks its status.
-func SubmitJob(job *Job) {
-	wg.Add(1)
-	mutex.Lock()
-	jobStatusMap[job.ID] = &jobStatus{Status: queued}
-	mutex.Unlock()
-	jobQueue <- job
+	q.statuses[job.ID] = status
+	q.publish(*status)
+	q.prune()
+	return nil
 }
 
-// GetJobStatus returns the status of a job by its ID.
-func GetJobStatus(jobID string) *jobStatus {
-	mutex.Lock()
-	defer mutex.Unlock()
-	return jobStatusMap[jobID]
-}
+// Status returns the status of a job.
+func (q *Queue) Status(id string) (Status, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-// GetAllJobsStatus returns the status of all jobs.
-func GetAllJobsStatus() map[string]*jobStatus {
-	mutex.Lock()
-	defer mutex.Unlock()
-	return jobStatusMap
+	status, ok := q.statuses[id]
+	if !ok {
+		return Status{}, false
+	}
+	return *status, true
 }
 
-// InitWorkers initializes a specified number of worker goroutines.
-func InitWorkers(numWorkers int) {
-	for i := 0; i < numWorkers; i++ {
-		go worker()
+// List returns the status of all known jobs, newest first.
+func (q *Queue) List() []Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]Status, 0, len(q.statuses))
+	for _, status := range q.statuses {
+		list = append(list, *status)
 	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
 }
 
-// updateJobStatus updates the status of a job in the jobStatusMap.
-func updateJobStatus(jobID string, result interface{}, err error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+// Subscribe returns a channel that receives every status change and a function that
+// stops the subscription. Slow subscribers miss updates rather than blocking workers.
+func (q *Queue) Subscribe() (<-chan Status, func()) {
+	ch := make(chan Status, 64)
+
+	q.mu.Lock()
+	q.subscribers[ch] = struct{}{}
+	q.mu.Unlock()
 
-	status := jobStatusMap[jobID]
-	if err != nil {
-		status.Status = failed
-		status.Error = err
-	} else {
-		status.Status = completed
-		status.Result = result
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			q.mu.Lock()
+			delete(q.subscribers, ch)
+			q.mu.Unlock()
+			close(ch)
+		})
 	}
 }
 
-// writeTimeToFile handles the specific logic for writing the time to a file.
-// This is a function to test the jobs system and not meant for production use.
-func writeTimeToFile(payload interface{}) (interface{}, error) {
-	waitTime, ok := payload.(time.Duration)
-	if !ok {
-		return nil, errors.New("invalid payload")
+// Close stops accepting jobs, cancels running jobs and waits for the workers to exit.
+// Jobs still in the queue are marked canceled.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
 	}
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
 
-	// Wait for the specified time
-	fmt.Println("Waiting for", waitTime)
-	time.Sleep(waitTime)
+	q.cancel()
+	q.wg.Wait()
+}
 
-	// Get the current time
-	currentTime := time.Now().Format(time.RFC3339)
+// worker runs jobs until the queue is closed.
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
 
-	// Create a file in /tmp with the current time
-	filePath := fmt.Sprintf("/tmp/job_%s.txt", currentTime)
-	file, err := os.Create(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file: %v", err)
+// run executes a job and records its result.
+func (q *Queue) run(job *Job) {
+	if q.ctx.Err() != nil {
+		q.update(job.ID, func(s *Status) { s.State = StateCanceled })
+		return
 	}
-	defer file.Close()
 
-	// Write the current time to the file
-	_, err = file.WriteString(currentTime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write to file: %v", err)
+	q.update(job.ID, func(s *Status) { s.State = StateRunning })
+
+	result, err := job.Run(q.ctx, func(p Progress) {
+		q.update(job.ID, func(s *Status) { s.Progress = p })
+	})
+
+	q.update(job.ID, func(s *Status) {
+		switch {
+		case err != nil && q.ctx.Err() != nil:
+			s.State = StateCanceled
+			s.Error = err.Error()
+		case err != nil:
+			s.State = StateFailed
+			s.Error = err.Error()
+		default:
+			s.State = StateCompleted
+			s.Result = result
+		}
+	})
+}
+
+// update changes the status of a job and notifies subscribers.
+func (q *Queue) update(id string, fn func(*Status)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status, ok := q.statuses[id]
+	if !ok {
+		return
 	}
+	fn(status)
+	status.UpdatedAt = time.Now()
+	q.publish(*status)
+}
 
-	return "File created with time: " + currentTime, nil
+// publish sends a status to every subscriber. The caller holds the lock.
+func (q *Queue) publish(status Status) {
+	for ch := range q.subscribers {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
 }
 
-// anotherJobFunction represents a placeholder for future job types.
-func anotherJobFunction(payload interface{}) (interface{}, error) {
-	// Code for another type of job
-	// ...
-	return nil, errors.New("not implemented")
+// prune drops the oldest finished jobs beyond the history limit. The caller holds the lock.
+func (q *Queue) prune() {
+	var finished []*Status
+	for _, status := range q.statuses {
+		if status.Done() {
+			finished = append(finished, status)
+		}
+	}
+	if len(finished) <= q.History {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt.Before(finished[j].UpdatedAt)
+	})
+	for _, status := range finished[:len(finished)-q.History] {
+		delete(q.statuses, status.ID)
+	}
 }
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitFor reads status updates until the job reaches a final state.
+func waitFor(t *testing.T, updates <-chan Status, id string) Status {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case status := <-updates:
+			if status.ID == id && status.Done() {
+				return status
+			}
+		case <-timeout:
+			t.Fatalf("job %s did not finish", id)
+		}
+	}
+}
+
+func TestQueueRunsJobsAndReportsProgress(t *testing.T) {
+	q := NewQueue(2, 10)
+	defer q.Close()
+
+	updates, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, q.Submit(&Job{ID: "ok", Type: "test", Run: func(ctx context.Context, report func(Progress)) (any, error) {
+		report(Progress{Step: "work", Done: 1, Total: 2})
+		report(Progress{Step: "work", Done: 2, Total: 2})
+		return 42, nil
+	}}))
+
+	status := waitFor(t, updates, "ok")
+	assert.Equal(t, StateCompleted, status.State)
+	assert.Equal(t, 42, status.Result)
+	assert.Equal(t, Progress{Step: "work", Done: 2, Total: 2}, status.Progress)
+
+	require.NoError(t, q.Submit(&Job{ID: "bad", Run: func(ctx context.Context, report func(Progress)) (any, error) {
+		return nil, errors.New("unsupported file")
+	}}))
+
+	status = waitFor(t, updates, "bad")
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, "unsupported file", status.Error)
+
+	// A finished job can be submitted again.
+	require.NoError(t, q.Submit(&Job{ID: "ok", Run: func(ctx context.Context, report func(Progress)) (any, error) {
+		return nil, nil
+	}}))
+	waitFor(t, updates, "ok")
+
+	list := q.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "ok", list[0].ID)
+}
+
+func TestQueueRejectsDuplicatesAndCancelsOnClose(t *testing.T) {
+	q := NewQueue(1, 1)
+
+	started := make(chan struct{})
+	require.NoError(t, q.Submit(&Job{ID: "slow", Run: func(ctx context.Context, report func(Progress)) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}))
+	<-started
+
+	assert.Error(t, q.Submit(&Job{ID: "slow"}), "the job is still running")
+
+	require.NoError(t, q.Submit(&Job{ID: "waiting", Run: func(ctx context.Context, report func(Progress)) (any, error) {
+		return nil, nil
+	}}))
+	assert.ErrorIs(t, q.Submit(&Job{ID: "overflow"}), ErrQueueFull)
+
+	q.Close()
+	assert.ErrorIs(t, q.Submit(&Job{ID: "late"}), ErrQueueClosed)
+
+	status, ok := q.Status("slow")
+	require.True(t, ok)
+	assert.Equal(t, StateCanceled, status.State)
+	status, _ = q.Status("waiting")
+	assert.Equal(t, StateCanceled, status.State)
+}
+
+func TestQueueKeepsLimitedHistory(t *testing.T) {
+	q := NewQueue(1, 10)
+	defer q.Close()
+	q.History = 2
+
+	updates, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, q.Submit(&Job{ID: id, Run: func(ctx context.Context, report func(Progress)) (any, error) {
+			return nil, nil
+		}}))
+		waitFor(t, updates, id)
+	}
+
+	// Pruning happens on submit, so the job that just finished is kept on top of the limit.
+	_, ok := q.Status("a")
+	assert.False(t, ok)
+	_, ok = q.Status("d")
+	assert.True(t, ok)
+}
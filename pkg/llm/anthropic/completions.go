@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"eternal/pkg/web"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -21,9 +22,11 @@ type Message struct {
 type CompletionRequest struct {
 	Model       string    `json:"model"`
 	MaxTokens   int       `json:"max_tokens"`
+	System      string    `json:"system,omitempty"`
 	Messages    []Message `json:"messages"`
 	Stream      bool      `json:"stream"`
 	Temperature float64   `json:"temperature"`
+	TopP        float64   `json:"top_p,omitempty"`
 }
 
 type CompletionResponse struct {
@@ -54,14 +57,18 @@ type TextDelta struct {
 	Text string `json:"text"`
 }
 
-func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, messages []Message, temperature float64, apiKey string) error {
+// StreamCompletionToWebSocket streams a completion to the WebSocket. Like the OpenAI stream,
+// it returns the full response as the error once the stream ends, so the caller can store it.
+func StreamCompletionToWebSocket(c *websocket.Conn, turnID int, model string, system string, messages []Message, temperature float64, topP float64, apiKey string) error {
 
 	payload := &CompletionRequest{
 		Model:       model,
 		MaxTokens:   4096,
+		System:      system,
 		Stream:      true,
 		Messages:    messages,
 		Temperature: temperature,
+		TopP:        topP,
 	}
 
 	resp, err := SendRequest(completionsEndpoint, payload, apiKey)
@@ -89,7 +96,7 @@ func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, me
 
 			htmlMsg := web.MarkdownToHTML(msgBuffer.Bytes())
 
-			turnIDStr := strconv.Itoa(chatID)
+			turnIDStr := strconv.Itoa(turnID)
 
 			formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet engine='browser' sandbox='javascript' editor='basic'></codapi-snippet>", turnIDStr, htmlMsg)
 
@@ -105,6 +112,39 @@ func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, me
 		return err
 	}
 
-	return nil
+	return fmt.Errorf("%s", msgBuffer.String())
+}
+
+// Completion requests a non-streaming message completion and returns its text.
+func Completion(model string, messages []Message, temperature float64, apiKey string) (string, error) {
+	payload := &CompletionRequest{
+		Model:       model,
+		MaxTokens:   1024,
+		Messages:    messages,
+		Temperature: temperature,
+	}
+
+	resp, err := SendRequest(completionsEndpoint, payload, apiKey)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("completion request failed with status %d", resp.StatusCode)
+	}
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, content := range completion.Content {
+		if content.Type == "text" {
+			text.WriteString(content.Text)
+		}
+	}
 
+	return text.String(), nil
 }
@@ -11,6 +11,7 @@ import (
 	"log"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -152,12 +153,18 @@ func BuildCommand(cmdPath string, options GGUFOptions) *exec.Cmd {
 	topP := fmt.Sprintf("%f", options.TopP)
 	topK := fmt.Sprintf("%d", options.TopK)
 
+	// -1 = infinity, -2 = until context filled
+	nPredict := "-2"
+	if options.NPredict != 0 {
+		nPredict = fmt.Sprintf("%d", options.NPredict)
+	}
+
 	cmdArgs := []string{
 		"--no-display-prompt",
 		"-m", options.Model,
 		"-p", options.Prompt,
 		"-c", "0", // 0 = loaded from model
-		"--n-predict", "-2", // -1 = infinity, -2 = until context filled
+		"--n-predict", nPredict,
 		"--repeat-penalty", repeatPenalty,
 		"--top-p", topP,
 		"--top-k", topK,
@@ -265,8 +272,22 @@ func CompletionWebSocket(c *websocket.Conn, cmdPath string) {
 	}
 }
 
+// MakeCompletion runs the model to completion and returns its output.
+func MakeCompletion(modelOpts *GGUFOptions, dataPath string) (string, error) {
+	cmd := BuildCommand(dataPath, *modelOpts)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 // MakeCompletionWebSocket creates a closure that captures model parameters and returns a WebSocket handler.
-func MakeCompletionWebSocket(c websocket.Conn, chatID int, modelOpts *GGUFOptions, dataPath string) error {
+func MakeCompletionWebSocket(c websocket.Conn, turnID int, modelOpts *GGUFOptions, dataPath string) error {
 	defer c.Close()
 	var msgBuffer bytes.Buffer // Buffer to accumulate messages
 
@@ -298,8 +319,8 @@ func MakeCompletionWebSocket(c websocket.Conn, chatID int, modelOpts *GGUFOption
 			// Convert the buffer content to HTML
 			htmlMsg := web.MarkdownToHTML(msgBuffer.Bytes())
 
-			// Convert chatID to string for formatting
-			turnIDStr := fmt.Sprint(chatID + TurnCounter)
+			// Convert turnID to string for formatting
+			turnIDStr := fmt.Sprint(turnID)
 
 			// Send the accumulated content
 			// formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet url='http://localhost:1313/v1/exec' sandbox='go' editor='external'></codapi-snippet>", turnIDStr, htmlMsg)
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 
-	"eternal/pkg/llm"
 	"eternal/pkg/web"
 
 	"github.com/gofiber/websocket/v2"
@@ -21,8 +20,10 @@ const (
 	model = "models/gemini-1.5-pro-latest"
 )
 
-// StreamGeminiResponseToWebSocket streams the response from the Gemini API to a WebSocket connection.
-func StreamGeminiResponseToWebSocket(c *websocket.Conn, chatID int, prompt string, apiKey string) error {
+// StreamGeminiResponseToWebSocket streams the response from the Gemini API to a WebSocket
+// connection. The system prompt is sent ahead of the prompt, and a topK of zero leaves it
+// to the model.
+func StreamGeminiResponseToWebSocket(c *websocket.Conn, turnID int, system string, prompt string, temperature float64, topP float64, topK int, apiKey string) error {
 	pterm.Warning.Printfln("Using model: %s", model)
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
@@ -36,12 +37,14 @@ func StreamGeminiResponseToWebSocket(c *websocket.Conn, chatID int, prompt strin
 	generativeModel := client.GenerativeModel(model)
 
 	// Configure model parameters by invoking Set* methods on the model.
-	generativeModel.SetTemperature(0.1)
-	generativeModel.SetTopK(1)
-	generativeModel.SetTopP(1)
+	generativeModel.SetTemperature(float32(temperature))
+	generativeModel.SetTopP(float32(topP))
+	if topK > 0 {
+		generativeModel.SetTopK(int32(topK))
+	}
 
 	pterm.Warning.Printfln("Generating content stream...")
-	iter := generativeModel.GenerateContentStream(ctx, genai.Text(prompt))
+	iter := generativeModel.GenerateContentStream(ctx, genai.Text(fmt.Sprintf("%s\n\n%s", system, prompt)))
 
 	msgBuffer := new(bytes.Buffer)
 	for {
@@ -62,7 +65,7 @@ func StreamGeminiResponseToWebSocket(c *websocket.Conn, chatID int, prompt strin
 
 		htmlMsg := web.MarkdownToHTML(msgBuffer.Bytes())
 
-		turnIDStr := fmt.Sprint(chatID + llm.TurnCounter)
+		turnIDStr := fmt.Sprint(turnID)
 
 		formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet engine='browser' sandbox='javascript' editor='basic'></codapi-snippet>", turnIDStr, htmlMsg)
 
@@ -72,3 +75,36 @@ func StreamGeminiResponseToWebSocket(c *websocket.Conn, chatID int, prompt strin
 		}
 	}
 }
+
+// Generate requests a non-streaming completion from the given Gemini model and returns its text.
+func Generate(modelName string, prompt string, apiKey string) (string, error) {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	generativeModel := client.GenerativeModel(modelName)
+	generativeModel.SetTemperature(0.1)
+
+	resp, err := generativeModel.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+
+	var text bytes.Buffer
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if t, ok := part.(genai.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+		break
+	}
+
+	return text.String(), nil
+}
@@ -14,7 +14,6 @@ import (
 var (
 	downloadProgressMap = make(map[string]DownloadProgress)
 	progressMutex       sync.Mutex
-	TurnCounter         = 0
 )
 
 // DownloadProgress structure to hold download progress information
@@ -199,11 +198,3 @@ func (m *Model) Delete() error {
 
 	return nil
 }
-
-func IncrementTurnCounter() {
-	TurnCounter++
-}
-
-func GetTurnCounter() int {
-	return TurnCounter
-}
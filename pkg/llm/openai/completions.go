@@ -40,7 +40,7 @@ func SendRequest(endpoint string, payload interface{}, apiKey string) (*http.Res
 	return http.DefaultClient.Do(req)
 }
 
-func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, messages []llm.Message, temperature float64, apiKey string) error {
+func StreamCompletionToWebSocket(c *websocket.Conn, turnID int, model string, messages []llm.Message, temperature float64, apiKey string) error {
 	payload := &CompletionRequest{
 		Model:       model,
 		Messages:    messages,
@@ -83,7 +83,7 @@ func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, me
 			// Process the accumulated content after streaming is complete
 			htmlMsg := web.MarkdownToHTML(msgBuffer.Bytes())
 
-			turnIDStr := fmt.Sprint(chatID + llm.TurnCounter)
+			turnIDStr := fmt.Sprint(turnID)
 
 			// TODO: Abstract this into a function that all backends use.
 			//formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet url='http://localhost:1313/v1/exec' sandbox='go' editor='external'></codapi-snippet>", turnIDStr, htmlMsg)
@@ -104,6 +104,40 @@ func StreamCompletionToWebSocket(c *websocket.Conn, chatID int, model string, me
 	return nil
 }
 
+// Completion requests a non-streaming chat completion and returns the content of the first choice.
+func Completion(model string, messages []llm.Message, temperature float64, apiKey string) (string, error) {
+	payload := &CompletionRequest{
+		Model:       model,
+		Messages:    messages,
+		Temperature: temperature,
+	}
+
+	resp, err := SendRequest(completionsEndpoint, payload, apiKey)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return "", fmt.Errorf("completion request failed with status %d", resp.StatusCode)
+		}
+		return "", fmt.Errorf("completion request failed: %s", errResp.Error.Message)
+	}
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", err
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("completion response has no choices")
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
+
 // StreamTTSToFile streams TTS response to a file.
 func StreamTTSToFile(inputText, voice, apiKey, outputFilePath string) error {
 	payload := &AudioSpeechRequest{
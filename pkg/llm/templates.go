@@ -2,9 +2,13 @@ package llm
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
+// placeholderPattern matches template placeholders such as {topic}.
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
 // Message represents a message for the completion API.
 type Message struct {
 	Role    string `json:"role"`
@@ -53,6 +57,19 @@ func (pt *PromptTemplate) Format(vars map[string]string) string {
 	return result
 }
 
+// Variables returns the names of the placeholders in the template in order of first use.
+func (pt *PromptTemplate) Variables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(pt.Template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
 // FormatMessages formats the chat messages with the provided variables.
 func (cpt *ChatPromptTemplate) FormatMessages(vars map[string]string) []Message {
 	var formattedMessages []Message
@@ -0,0 +1,114 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nlpodyssey/cybertron/pkg/models/bert"
+	"github.com/nlpodyssey/cybertron/pkg/tasks"
+	"github.com/nlpodyssey/cybertron/pkg/tasks/textclassification"
+	bertclassification "github.com/nlpodyssey/cybertron/pkg/tasks/textclassification/bert"
+	"github.com/nlpodyssey/cybertron/pkg/tokenizers"
+	"github.com/nlpodyssey/cybertron/pkg/tokenizers/wordpiecetokenizer"
+)
+
+// DefaultCrossEncoderModel is a small cross-encoder trained on MS MARCO passage ranking.
+const DefaultCrossEncoderModel = "cross-encoder/ms-marco-MiniLM-L-6-v2"
+
+// CrossEncoder scores (query, passage) pairs with a BERT sequence classification model
+// loaded through cybertron. The model reads the query and passage together, which ranks
+// far better than comparing separately computed embeddings.
+type CrossEncoder struct {
+	mu        sync.Mutex // The model is not safe for concurrent use
+	model     *bertclassification.TextClassification
+	lowercase bool
+}
+
+// NewCrossEncoder loads the named model from modelsDir, downloading and converting it
+// from Hugging Face when it is missing.
+func NewCrossEncoder(modelsDir string, modelName string) (*CrossEncoder, error) {
+	if modelName == "" {
+		modelName = DefaultCrossEncoderModel
+	}
+
+	model, err := tasks.Load[textclassification.Interface](&tasks.Config{ModelsDir: modelsDir, ModelName: modelName})
+	if err != nil {
+		return nil, fmt.Errorf("error loading cross-encoder %s: %v", modelName, err)
+	}
+	classifier, ok := model.(*bertclassification.TextClassification)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a BERT cross-encoder", modelName)
+	}
+
+	tokenizerConfig, err := bert.ConfigFromFile[bert.TokenizerConfig](filepath.Join(modelsDir, modelName, "tokenizer_config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading tokenizer config of %s: %v", modelName, err)
+	}
+
+	return &CrossEncoder{model: classifier, lowercase: tokenizerConfig.DoLowerCase}, nil
+}
+
+// Score returns the relevance of each passage to the query in [0, 1].
+func (e *CrossEncoder) Score(ctx context.Context, query string, passages []string) ([]float64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	scores := make([]float64, len(passages))
+	for i, passage := range passages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		logits := e.model.Model.Classify(e.tokenize(query, passage)).Value().Data().F64()
+		scores[i] = relevance(logits)
+	}
+	return scores, nil
+}
+
+// tokenize encodes the pair as [CLS] query [SEP] passage [SEP], truncating the passage to
+// the model's maximum sequence length.
+func (e *CrossEncoder) tokenize(query, passage string) []string {
+	if e.lowercase {
+		query = strings.ToLower(query)
+		passage = strings.ToLower(passage)
+	}
+
+	queryTokens := tokenizers.GetStrings(e.model.Tokenizer.Tokenize(query))
+	passageTokens := tokenizers.GetStrings(e.model.Tokenizer.Tokenize(passage))
+
+	maxLen := e.model.Model.Bert.Config.MaxPositionEmbeddings
+	if max := maxLen / 2; len(queryTokens) > max {
+		queryTokens = queryTokens[:max]
+	}
+	if max := maxLen - len(queryTokens) - 3; len(passageTokens) > max {
+		passageTokens = passageTokens[:max]
+	}
+
+	sep := wordpiecetokenizer.DefaultSequenceSeparator
+	tokens := make([]string, 0, len(queryTokens)+len(passageTokens)+3)
+	tokens = append(tokens, wordpiecetokenizer.DefaultClassToken)
+	tokens = append(tokens, queryTokens...)
+	tokens = append(tokens, sep)
+	tokens = append(tokens, passageTokens...)
+	return append(tokens, sep)
+}
+
+// relevance converts classifier logits into a probability. Single-logit rankers use a
+// sigmoid, two-class models the softmax probability of the second, relevant class.
+func relevance(logits []float64) float64 {
+	switch len(logits) {
+	case 0:
+		return 0
+	case 1:
+		return 1 / (1 + math.Exp(-logits[0]))
+	}
+	last := logits[len(logits)-1]
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(l - last)
+	}
+	return 1 / sum
+}
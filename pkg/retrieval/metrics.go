@@ -0,0 +1,71 @@
+package retrieval
+
+import "math"
+
+// Metrics are the ranking metrics of a query, or their mean over a dataset. Relevance is
+// binary: an item is relevant or not.
+type Metrics struct {
+	Recall float64 `json:"recall"` // Share of the relevant items in the top K
+	MRR    float64 `json:"mrr"`    // Reciprocal rank of the first relevant item in the top K, 0 when there is none
+	NDCG   float64 `json:"ndcg"`   // Discounted gain of the top K over the gain of an ideal ranking
+}
+
+// Evaluate scores a ranking against the set of relevant items at cutoff k. Items ranked
+// more than once count at their first rank.
+func Evaluate(ranked []string, relevant map[string]bool, k int) Metrics {
+	var m Metrics
+	if len(relevant) == 0 || k <= 0 {
+		return m
+	}
+
+	var found int
+	var dcg float64
+	seen := make(map[string]bool)
+	rank := 0
+	for _, item := range ranked {
+		if seen[item] {
+			continue
+		}
+		seen[item] = true
+		rank++
+		if rank > k {
+			break
+		}
+		if !relevant[item] {
+			continue
+		}
+
+		found++
+		if m.MRR == 0 {
+			m.MRR = 1 / float64(rank)
+		}
+		dcg += 1 / math.Log2(float64(rank+1))
+	}
+
+	var ideal float64
+	for rank := 1; rank <= min(k, len(relevant)); rank++ {
+		ideal += 1 / math.Log2(float64(rank+1))
+	}
+
+	m.Recall = float64(found) / float64(len(relevant))
+	m.NDCG = dcg / ideal
+	return m
+}
+
+// Mean returns the average of the metrics of several queries.
+func Mean(metrics []Metrics) Metrics {
+	var mean Metrics
+	if len(metrics) == 0 {
+		return mean
+	}
+	for _, m := range metrics {
+		mean.Recall += m.Recall
+		mean.MRR += m.MRR
+		mean.NDCG += m.NDCG
+	}
+	n := float64(len(metrics))
+	mean.Recall /= n
+	mean.MRR /= n
+	mean.NDCG /= n
+	return mean
+}
@@ -0,0 +1,29 @@
+package retrieval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	relevant := map[string]bool{"a": true, "b": true}
+
+	m := Evaluate([]string{"x", "a", "a", "y", "b"}, relevant, 3)
+	assert.Equal(t, 0.5, m.Recall, "b is ranked fourth, past the cutoff")
+	assert.Equal(t, 0.5, m.MRR)
+	assert.InDelta(t, (1/math.Log2(3))/(1+1/math.Log2(3)), m.NDCG, 1e-9)
+
+	m = Evaluate([]string{"b", "a"}, relevant, 10)
+	assert.Equal(t, Metrics{Recall: 1, MRR: 1, NDCG: 1}, m)
+
+	assert.Equal(t, Metrics{}, Evaluate([]string{"x"}, relevant, 10))
+	assert.Equal(t, Metrics{}, Evaluate([]string{"a"}, nil, 10))
+}
+
+func TestMean(t *testing.T) {
+	mean := Mean([]Metrics{{Recall: 1, MRR: 1, NDCG: 1}, {Recall: 0.5, MRR: 0, NDCG: 0.25}})
+	assert.Equal(t, Metrics{Recall: 0.75, MRR: 0.5, NDCG: 0.625}, mean)
+	assert.Equal(t, Metrics{}, Mean(nil))
+}
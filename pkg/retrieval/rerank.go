@@ -0,0 +1,155 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// defaultRerankCandidates is the number of fused results scored by a reranker when the
+// options do not set one.
+const defaultRerankCandidates = 20
+
+// Reranker scores how relevant each passage is to a query. Higher scores are more relevant.
+type Reranker interface {
+	Score(ctx context.Context, query string, passages []string) ([]float64, error)
+}
+
+// RerankOptions configures the reranking stage of a single retrieval call.
+type RerankOptions struct {
+	Reranker   Reranker
+	Candidates int     // Fused results to rerank, 20 by default
+	Threshold  float64 // Results scoring below the threshold are dropped, zero keeps all
+	MaxChunks  int     // Results kept after reranking, zero keeps all
+}
+
+// Rerank scores the top candidates with the reranker and returns them ordered by the
+// reranker score. Results beyond the candidates are dropped.
+func Rerank(ctx context.Context, query string, results []Result, opts RerankOptions) ([]Result, error) {
+	if opts.Reranker == nil || len(results) == 0 {
+		return results, nil
+	}
+
+	candidates := opts.Candidates
+	if candidates <= 0 {
+		candidates = defaultRerankCandidates
+	}
+	if len(results) > candidates {
+		results = results[:candidates]
+	}
+
+	passages := make([]string, len(results))
+	for i, res := range results {
+		passages[i] = res.Text
+	}
+
+	scores, err := opts.Reranker.Score(ctx, query, passages)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(results) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d passages", len(scores), len(results))
+	}
+
+	reranked := make([]Result, 0, len(results))
+	for i, res := range results {
+		// Rerankers score a passage they could not rank as negative infinity.
+		if math.IsInf(scores[i], -1) || (opts.Threshold != 0 && scores[i] < opts.Threshold) {
+			continue
+		}
+		res.RerankScore = scores[i]
+		reranked = append(reranked, res)
+	}
+
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].RerankScore > reranked[j].RerankScore
+	})
+
+	if opts.MaxChunks > 0 && len(reranked) > opts.MaxChunks {
+		reranked = reranked[:opts.MaxChunks]
+	}
+	return reranked, nil
+}
+
+// HTTPReranker calls a rerank endpoint that accepts {"model", "query", "documents"} and
+// answers {"results": [{"index", "relevance_score"}]}, as served by llama.cpp, Jina and
+// Cohere.
+type HTTPReranker struct {
+	Endpoint string
+	Model    string
+	APIKey   string
+	Client   *http.Client
+}
+
+type httpRerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+type httpRerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// Score sends the passages to the rerank endpoint.
+func (r *HTTPReranker) Score(ctx context.Context, query string, passages []string) ([]float64, error) {
+	body, err := json.Marshal(httpRerankRequest{Model: r.Model, Query: query, Documents: passages})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling reranker: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("reranker returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var out httpRerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("error decoding reranker response: %v", err)
+	}
+
+	// Results may be sorted by relevance, so they are placed by index. Passages the
+	// endpoint left out are dropped.
+	scores := make([]float64, len(passages))
+	seen := make([]bool, len(passages))
+	for _, res := range out.Results {
+		if res.Index < 0 || res.Index >= len(passages) {
+			return nil, fmt.Errorf("reranker returned unknown index %d", res.Index)
+		}
+		scores[res.Index] = res.RelevanceScore
+		seen[res.Index] = true
+	}
+	for i := range scores {
+		if !seen[i] {
+			scores[i] = math.Inf(-1)
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,369 @@
+// Package retrieval combines lexical and dense search into a single ranked list of chunks.
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the built-in retrievers.
+const (
+	Lexical = "lexical"
+	Dense   = "dense"
+)
+
+// Fusion methods.
+const (
+	FusionRRF      = "rrf"
+	FusionWeighted = "weighted"
+)
+
+const defaultRRFK = 60
+
+// Hit is a chunk returned by a single retriever. Scores are only comparable within one
+// retriever.
+type Hit struct {
+	ID       string
+	Text     string
+	Score    float64
+	Metadata map[string]any
+}
+
+// Retriever finds the chunks most relevant to a query.
+type Retriever interface {
+	Name() string
+	Retrieve(ctx context.Context, query string, topN int) ([]Hit, error)
+}
+
+// Provenance records where a fused result was found.
+type Provenance struct {
+	Retriever string  `json:"retriever"`
+	ID        string  `json:"id"`
+	Rank      int     `json:"rank"` // 1-based rank in the retriever's list
+	Score     float64 `json:"score"`
+	Query     string  `json:"query,omitempty"` // Query that found the chunk when a search runs several
+}
+
+// Result is a chunk in the fused ranking.
+type Result struct {
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Score    float64        `json:"score"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Sources  []Provenance   `json:"sources"`
+
+	RerankScore float64 `json:"rerank_score,omitempty"` // Set when a reranker ordered the results
+}
+
+// Query is a single retrieval call. Expansions and Hypothetical run as separate searches
+// whose hits are fused with those of Text, so a chunk found for several of them ranks higher.
+type Query struct {
+	Text   string
+	TopN   int
+	Rerank *RerankOptions // Reranks the fused results when set
+
+	Expansions   []string // Other phrasings of the query, such as generated sub-queries
+	Hypothetical string   // A generated answer to the query, searched by the dense retriever only (HyDE)
+}
+
+// lists returns an empty hit list for each search of the query: every retriever for Text
+// and then for each expansion, and the dense retriever for the hypothetical answer.
+// Retrievers are taken in name order, as Fuse does.
+func (q Query) lists(retrievers []Retriever) []HitList {
+	names := make([]string, len(retrievers))
+	for i, r := range retrievers {
+		names[i] = r.Name()
+	}
+	sort.Strings(names)
+
+	var lists []HitList
+	seen := make(map[string]bool)
+	add := func(text string, dense bool) {
+		if strings.TrimSpace(text) == "" || seen[text] {
+			return
+		}
+		seen[text] = true
+		for _, name := range names {
+			// A made-up answer is close in meaning to real ones, but its keywords are guesses.
+			if !dense || name == Dense {
+				lists = append(lists, HitList{Retriever: name, Query: text})
+			}
+		}
+	}
+
+	add(q.Text, false)
+	for _, text := range q.Expansions {
+		add(text, false)
+	}
+	add(q.Hypothetical, true)
+	return lists
+}
+
+// Config controls how retriever results are fused.
+type Config struct {
+	Fusion     string             `yaml:"fusion" json:"fusion"`         // "rrf" (default) or "weighted"
+	RRFK       int                `yaml:"rrf_k" json:"rrf_k"`           // Rank offset for reciprocal rank fusion, 60 by default
+	Weights    map[string]float64 `yaml:"weights" json:"weights"`       // Weight per retriever name, 1 when missing
+	MinScores  map[string]float64 `yaml:"min_scores" json:"min_scores"` // Raw score a hit needs before fusion, per retriever name
+	Candidates int                `yaml:"candidates" json:"candidates"` // Hits requested from each retriever, three times topN by default
+}
+
+// Validate checks the fusion method and weights.
+func (c Config) Validate() error {
+	switch c.Fusion {
+	case "", FusionRRF, FusionWeighted:
+	default:
+		return fmt.Errorf("unknown fusion method %q", c.Fusion)
+	}
+	for name, weight := range c.Weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// weight returns the weight of the named retriever.
+func (c Config) weight(name string) float64 {
+	if w, ok := c.Weights[name]; ok {
+		return w
+	}
+	return 1
+}
+
+// Service runs a set of retrievers in parallel and fuses their results.
+type Service struct {
+	config     Config
+	retrievers []Retriever
+}
+
+// retriever returns the retriever with the name.
+func (s *Service) retriever(name string) Retriever {
+	for _, r := range s.retrievers {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// New returns a service that fuses the results of the retrievers.
+func New(config Config, retrievers ...Retriever) (*Service, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Service{config: config, retrievers: retrievers}, nil
+}
+
+// Retrieve runs every retriever and returns the topN fused results. A failing retriever
+// does not fail the call unless every retriever fails.
+func (s *Service) Retrieve(ctx context.Context, query string, topN int) ([]Result, error) {
+	return s.Search(ctx, Query{Text: query, TopN: topN})
+}
+
+// Search runs a retrieval call. When the query has rerank options, the fused results are
+// reranked against Text before they are cut to TopN, so the reranker sees more candidates
+// than are returned. A failing reranker is logged and leaves the fused order, like a failing
+// retriever.
+func (s *Service) Search(ctx context.Context, q Query) ([]Result, error) {
+	topN := q.TopN
+	if topN <= 0 {
+		return nil, nil
+	}
+	candidates := s.config.Candidates
+	if candidates < topN {
+		candidates = topN * 3
+	}
+	if q.Rerank != nil && q.Rerank.Candidates > candidates {
+		candidates = q.Rerank.Candidates
+	}
+
+	lists := q.lists(s.retrievers)
+	errs := make([]error, len(lists))
+
+	var wg sync.WaitGroup
+	for i := range lists {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := s.retriever(lists[i].Retriever)
+			hits, err := r.Retrieve(ctx, lists[i].Query, candidates)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s retrieval: %w", r.Name(), err)
+				return
+			}
+			lists[i].Hits = hits
+		}(i)
+	}
+	wg.Wait()
+
+	var found []HitList
+	for i, list := range lists {
+		if errs[i] != nil {
+			continue
+		}
+		// The query is only worth recording when there are several.
+		if len(lists) <= len(s.retrievers) {
+			list.Query = ""
+		}
+		found = append(found, list)
+	}
+	if len(found) == 0 && len(lists) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	results := FuseLists(s.config, found)
+	if q.Rerank != nil {
+		reranked, err := Rerank(ctx, q.Text, results, *q.Rerank)
+		if err != nil {
+			log.Printf("Error reranking results, keeping the fused order: %v", err)
+		} else {
+			results = reranked
+		}
+	}
+	if len(results) > topN {
+		results = results[:topN]
+	}
+	return results, nil
+}
+
+// HitList is the ranked hits of a retriever for one query.
+type HitList struct {
+	Retriever string
+	Query     string // Recorded in the provenance of the hits when set
+	Hits      []Hit
+}
+
+// Fuse merges ranked hit lists keyed by retriever name into one ranking. Hits with the same
+// text are merged into a single result that lists every place it was found.
+func Fuse(config Config, lists map[string][]Hit) []Result {
+	names := make([]string, 0, len(lists))
+	for name := range lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hitLists := make([]HitList, len(names))
+	for i, name := range names {
+		hitLists[i] = HitList{Retriever: name, Hits: lists[name]}
+	}
+	return FuseLists(config, hitLists)
+}
+
+// FuseLists merges ranked hit lists into one ranking like Fuse. A retriever may have a list
+// for each of several queries; every list adds to the score of its hits under the weight and
+// minimum score of the retriever.
+func FuseLists(config Config, lists []HitList) []Result {
+	k := config.RRFK
+	if k <= 0 {
+		k = defaultRRFK
+	}
+
+	var results []*Result
+	byKey := make(map[string]*Result)
+	for _, list := range lists {
+		name := list.Retriever
+		hits := filterHits(list.Hits, config.MinScores[name])
+		weight := config.weight(name)
+		normalized := normalizeScores(hits)
+
+		seen := make(map[string]bool)
+		rank := 0
+		for i, hit := range hits {
+			key := dedupKey(hit)
+			// A retriever that returns the same chunk twice only counts its best rank.
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rank++
+
+			var score float64
+			if config.Fusion == FusionWeighted {
+				score = weight * normalized[i]
+			} else {
+				score = weight / float64(k+rank)
+			}
+
+			result, ok := byKey[key]
+			if !ok {
+				result = &Result{ID: hit.ID, Text: hit.Text, Metadata: hit.Metadata}
+				byKey[key] = result
+				results = append(results, result)
+			}
+			result.Score += score
+			result.Sources = append(result.Sources, Provenance{Retriever: name, ID: hit.ID, Rank: rank, Score: hit.Score, Query: list.Query})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	out := make([]Result, len(results))
+	for i, r := range results {
+		out[i] = *r
+	}
+	return out
+}
+
+// filterHits drops hits whose score is below min.
+func filterHits(hits []Hit, min float64) []Hit {
+	if min == 0 {
+		return hits
+	}
+	var kept []Hit
+	for _, hit := range hits {
+		if hit.Score >= min {
+			kept = append(kept, hit)
+		}
+	}
+	return kept
+}
+
+// normalizeScores scales the scores of a list to [0, 1] so that lists with different score
+// ranges can be weighted against each other.
+func normalizeScores(hits []Hit) []float64 {
+	normalized := make([]float64, len(hits))
+	if len(hits) == 0 {
+		return normalized
+	}
+
+	min, max := hits[0].Score, hits[0].Score
+	for _, hit := range hits {
+		if hit.Score < min {
+			min = hit.Score
+		}
+		if hit.Score > max {
+			max = hit.Score
+		}
+	}
+
+	for i, hit := range hits {
+		if max == min {
+			normalized[i] = 1
+		} else {
+			normalized[i] = (hit.Score - min) / (max - min)
+		}
+	}
+	return normalized
+}
+
+// dedupKey identifies a chunk across retrievers. Lexical and dense indexes use different
+// IDs for the same text, so chunks are matched on their whitespace-normalized text.
+func dedupKey(hit Hit) string {
+	text := strings.ToLower(strings.Join(strings.Fields(hit.Text), " "))
+	if text == "" {
+		return "id:" + hit.ID
+	}
+	return text
+}
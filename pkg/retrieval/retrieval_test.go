@@ -0,0 +1,249 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRetriever struct {
+	name string
+	hits []Hit
+	err  error
+}
+
+func (r staticRetriever) Name() string { return r.name }
+
+func (r staticRetriever) Retrieve(ctx context.Context, query string, topN int) ([]Hit, error) {
+	if len(r.hits) > topN {
+		return r.hits[:topN], r.err
+	}
+	return r.hits, r.err
+}
+
+func TestFuseRRFMergesDuplicates(t *testing.T) {
+	lists := map[string][]Hit{
+		Lexical: {
+			{ID: "l1", Text: "Go has  goroutines", Score: 7.5},
+			{ID: "l2", Text: "Rust has ownership", Score: 3.1},
+		},
+		Dense: {
+			{ID: "d1", Text: "go has goroutines", Score: 0.92},
+			{ID: "d2", Text: "Channels connect goroutines", Score: 0.85},
+		},
+	}
+
+	results := Fuse(Config{}, lists)
+	require.Len(t, results, 3)
+
+	// The chunk found by both retrievers ranks first and lists both sources.
+	assert.Equal(t, "d1", results[0].ID)
+	assert.InDelta(t, 2.0/61, results[0].Score, 1e-9)
+	require.Len(t, results[0].Sources, 2)
+	assert.Equal(t, Provenance{Retriever: Dense, ID: "d1", Rank: 1, Score: 0.92}, results[0].Sources[0])
+	assert.Equal(t, Provenance{Retriever: Lexical, ID: "l1", Rank: 1, Score: 7.5}, results[0].Sources[1])
+
+	// Ties on fused score are broken by ID.
+	assert.Equal(t, "d2", results[1].ID)
+	assert.Equal(t, "l2", results[2].ID)
+}
+
+func TestFuseWeighted(t *testing.T) {
+	lists := map[string][]Hit{
+		Lexical: {
+			{ID: "l1", Text: "lexical best", Score: 12},
+			{ID: "l2", Text: "lexical worst", Score: 2},
+		},
+		Dense: {
+			{ID: "d1", Text: "dense best", Score: 0.9},
+			{ID: "d2", Text: "dense worst", Score: 0.5},
+		},
+	}
+
+	config := Config{Fusion: FusionWeighted, Weights: map[string]float64{Lexical: 0.3, Dense: 0.7}}
+	results := Fuse(config, lists)
+	require.Len(t, results, 4)
+	assert.Equal(t, "d1", results[0].ID)
+	assert.InDelta(t, 0.7, results[0].Score, 1e-9)
+	assert.Equal(t, "l1", results[1].ID)
+	assert.InDelta(t, 0.3, results[1].Score, 1e-9)
+	assert.Zero(t, results[3].Score)
+}
+
+func TestFuseMinScores(t *testing.T) {
+	lists := map[string][]Hit{
+		Dense: {
+			{ID: "d1", Text: "close", Score: 0.9},
+			{ID: "d2", Text: "far", Score: 0.4},
+		},
+	}
+
+	results := Fuse(Config{MinScores: map[string]float64{Dense: 0.8}}, lists)
+	require.Len(t, results, 1)
+	assert.Equal(t, "d1", results[0].ID)
+}
+
+func TestServiceRetrieve(t *testing.T) {
+	lexical := staticRetriever{name: Lexical, hits: []Hit{{ID: "a", Text: "alpha", Score: 2}, {ID: "b", Text: "beta", Score: 1}}}
+	failing := staticRetriever{name: Dense, err: errors.New("model not found")}
+
+	service, err := New(Config{}, lexical, failing)
+	require.NoError(t, err)
+
+	// One failing retriever does not fail the call.
+	results, err := service.Retrieve(context.Background(), "query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].ID)
+
+	service, err = New(Config{}, failing)
+	require.NoError(t, err)
+	_, err = service.Retrieve(context.Background(), "query", 1)
+	assert.ErrorContains(t, err, "model not found")
+
+	_, err = New(Config{Fusion: "max"})
+	assert.Error(t, err)
+}
+
+type lengthReranker struct{}
+
+// Score prefers shorter passages, which is enough to tell reranked from fused order.
+func (lengthReranker) Score(ctx context.Context, query string, passages []string) ([]float64, error) {
+	scores := make([]float64, len(passages))
+	for i, p := range passages {
+		scores[i] = 1 / float64(len(p))
+	}
+	return scores, nil
+}
+
+func TestServiceSearchRerank(t *testing.T) {
+	lexical := staticRetriever{name: Lexical, hits: []Hit{
+		{ID: "a", Text: "a long passage about goroutines", Score: 3},
+		{ID: "b", Text: "goroutines", Score: 2},
+		{ID: "c", Text: "go", Score: 1},
+		{ID: "d", Text: "an even longer passage that barely mentions goroutines", Score: 0.5},
+	}}
+	service, err := New(Config{}, lexical)
+	require.NoError(t, err)
+
+	results, err := service.Search(context.Background(), Query{
+		Text: "goroutines",
+		TopN: 3,
+		Rerank: &RerankOptions{
+			Reranker:  lengthReranker{},
+			Threshold: 1.0 / 20,
+			MaxChunks: 2,
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "c", results[0].ID)
+	assert.Equal(t, 0.5, results[0].RerankScore)
+	assert.Equal(t, "b", results[1].ID)
+
+	// A failing reranker leaves the fused order.
+	results, err = service.Search(context.Background(), Query{
+		Text:   "goroutines",
+		TopN:   3,
+		Rerank: &RerankOptions{Reranker: failingReranker{}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "a", results[0].ID)
+	assert.Zero(t, results[0].RerankScore)
+}
+
+type failingReranker struct{}
+
+func (failingReranker) Score(ctx context.Context, query string, passages []string) ([]float64, error) {
+	return nil, errors.New("reranker unavailable")
+}
+
+func TestHTTPReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		var req httpRerankRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "bge-reranker", req.Model)
+		assert.Equal(t, []string{"first", "second", "third"}, req.Documents)
+
+		// Results come back sorted by relevance and without the third passage.
+		w.Write([]byte(`{"results": [{"index": 1, "relevance_score": 0.9}, {"index": 0, "relevance_score": 0.2}]}`))
+	}))
+	defer server.Close()
+
+	reranker := &HTTPReranker{Endpoint: server.URL, Model: "bge-reranker", APIKey: "secret"}
+	scores, err := reranker.Score(context.Background(), "query", []string{"first", "second", "third"})
+	require.NoError(t, err)
+	assert.Equal(t, 0.2, scores[0])
+	assert.Equal(t, 0.9, scores[1])
+	assert.True(t, math.IsInf(scores[2], -1))
+
+	results, err := Rerank(context.Background(), "query", []Result{{ID: "1", Text: "first"}, {ID: "2", Text: "second"}, {ID: "3", Text: "third"}}, RerankOptions{Reranker: reranker})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "2", results[0].ID)
+}
+
+func TestRelevance(t *testing.T) {
+	assert.InDelta(t, 0.5, relevance([]float64{0}), 1e-9)
+	assert.Greater(t, relevance([]float64{4}), 0.95)
+	assert.InDelta(t, 0.5, relevance([]float64{1, 1}), 1e-9)
+	assert.Greater(t, relevance([]float64{-2, 2}), 0.95)
+}
+
+type queryRetriever struct {
+	name string
+	hits map[string][]Hit
+}
+
+func (r queryRetriever) Name() string { return r.name }
+
+func (r queryRetriever) Retrieve(ctx context.Context, query string, topN int) ([]Hit, error) {
+	return r.hits[query], nil
+}
+
+func TestServiceSearchExpansions(t *testing.T) {
+	lexical := queryRetriever{name: Lexical, hits: map[string][]Hit{
+		"go concurrency": {{ID: "l1", Text: "goroutines", Score: 3}},
+		"go channels":    {{ID: "l2", Text: "channels", Score: 2}, {ID: "l3", Text: "goroutines", Score: 1}},
+		"hypothetical":   {{ID: "l4", Text: "keyword guess", Score: 9}},
+	}}
+	dense := queryRetriever{name: Dense, hits: map[string][]Hit{
+		"hypothetical": {{ID: "d1", Text: "select statements", Score: 0.9}},
+	}}
+
+	service, err := New(Config{}, lexical, dense)
+	require.NoError(t, err)
+
+	results, err := service.Search(context.Background(), Query{
+		Text:         "go concurrency",
+		TopN:         10,
+		Expansions:   []string{"go channels", "go concurrency", ""},
+		Hypothetical: "hypothetical",
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3, "the hypothetical answer is not searched by keyword")
+
+	// The chunk found for both queries ranks first and records each query.
+	assert.Equal(t, "goroutines", results[0].Text)
+	require.Len(t, results[0].Sources, 2)
+	assert.Equal(t, "go concurrency", results[0].Sources[0].Query)
+	assert.Equal(t, "go channels", results[0].Sources[1].Query)
+	assert.Equal(t, "hypothetical", results[1].Sources[0].Query)
+	assert.Equal(t, "d1", results[1].ID)
+
+	// A single query leaves the query out of the provenance.
+	results, err = service.Search(context.Background(), Query{Text: "go concurrency", TopN: 10})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Empty(t, results[0].Sources[0].Query)
+}
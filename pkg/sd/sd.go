@@ -157,7 +157,6 @@ func CropAndAdjustContrast(filePath string) error {
 var (
 	downloadProgressMap = make(map[string]DownloadProgress)
 	progressMutex       sync.Mutex
-	TurnCounter         = 0
 )
 
 // DownloadProgress structure to hold download progress information
@@ -1,17 +1,46 @@
 package search
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
 )
 
-// InitIndex initializes or opens a Bleve index at the given path.
-func InitIndex(indexPath string) (bleve.Index, error) {
-	mapping := bleve.NewIndexMapping() // Default mapping; can be customized as needed
-	index, err := bleve.New(indexPath, mapping)
-	if err != nil {
-		return nil, err
+// eachPageSize is the number of documents read per request when walking an index.
+const eachPageSize = 500
+
+// TextField returns the mapping of a prose field. The English analyzer stems words and
+// drops stop words, and the field is stored with term vectors so hits can be highlighted.
+func TextField() *mapping.FieldMapping {
+	field := bleve.NewTextFieldMapping()
+	field.Analyzer = en.AnalyzerName
+	field.Store = true
+	field.IncludeTermVectors = true
+	return field
+}
+
+// KeywordField returns the mapping of a field matched as a whole, such as a tag or an ID,
+// which can be used for exact filters and facets.
+func KeywordField() *mapping.FieldMapping {
+	field := bleve.NewTextFieldMapping()
+	field.Analyzer = keyword.Name
+	field.Store = true
+	return field
+}
+
+// Open opens the index at path, creating it with the mapping when it does not exist. An
+// existing index keeps the mapping it was created with.
+func Open(path string, m mapping.IndexMapping) (bleve.Index, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return bleve.New(path, m)
 	}
-	return index, nil
+	return bleve.Open(path)
 }
 
 // IndexData indexes the given data with the specified ID.
@@ -25,3 +54,102 @@ func Search(index bleve.Index, query string) (*bleve.SearchResult, error) {
 	search := bleve.NewSearchRequest(searchQuery)
 	return (index).Search(search)
 }
+
+// Each calls fn with the ID and stored fields of every document in the index, in ID order.
+// Fields that are not stored are missing.
+func Each(ctx context.Context, index bleve.Index, fn func(id string, fields map[string]interface{}) error) error {
+	var after []string
+	for {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), eachPageSize, 0, false)
+		req.Fields = []string{"*"}
+		req.SortBy([]string{"_id"})
+		if after != nil {
+			req.SetSearchAfter(after)
+		}
+
+		res, err := index.SearchInContext(ctx, req)
+		if err != nil {
+			return err
+		}
+		for _, hit := range res.Hits {
+			if err := fn(hit.ID, hit.Fields); err != nil {
+				return err
+			}
+		}
+		if len(res.Hits) < eachPageSize {
+			return nil
+		}
+		after = []string{res.Hits[len(res.Hits)-1].ID}
+	}
+}
+
+// Copy indexes the stored fields of every document of src in dst, which analyzes them with
+// its own mapping, and returns the number of documents copied.
+func Copy(ctx context.Context, src, dst bleve.Index) (int, error) {
+	var copied int
+	batch := dst.NewBatch()
+	err := Each(ctx, src, func(id string, fields map[string]interface{}) error {
+		if err := batch.Index(id, fields); err != nil {
+			return fmt.Errorf("error copying %s: %v", id, err)
+		}
+		copied++
+		if batch.Size() >= eachPageSize {
+			if err := dst.Batch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+	return copied, dst.Batch(batch)
+}
+
+// Snapshot writes a copy of the index as it is now to the directory at path. The index can
+// take writes during the copy; they are not in it.
+func Snapshot(index bleve.Index, path string) error {
+	copyable, ok := index.(bleve.IndexCopyable)
+	if !ok {
+		return fmt.Errorf("index does not support copies")
+	}
+	return copyable.CopyTo(bleve.FileSystemDirectory(path))
+}
+
+// Rebuild recreates the index at path with the mapping and copies its documents into it, so
+// a changed mapping applies to documents indexed before the change. The index is closed and
+// the rebuilt one is returned. The original is only replaced once every document is copied.
+func Rebuild(ctx context.Context, index bleve.Index, path string, m mapping.IndexMapping) (bleve.Index, int, error) {
+	tmp := path + ".rebuild"
+	if err := os.RemoveAll(tmp); err != nil {
+		return index, 0, err
+	}
+	rebuilt, err := bleve.New(tmp, m)
+	if err != nil {
+		return index, 0, err
+	}
+
+	copied, err := Copy(ctx, index, rebuilt)
+	if err != nil {
+		rebuilt.Close()
+		os.RemoveAll(tmp)
+		return index, 0, err
+	}
+	if err := rebuilt.Close(); err != nil {
+		os.RemoveAll(tmp)
+		return index, 0, err
+	}
+
+	if err := index.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return nil, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, 0, err
+	}
+	rebuilt, err = bleve.Open(path)
+	return rebuilt, copied, err
+}
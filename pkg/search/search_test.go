@@ -0,0 +1,92 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type note struct {
+	Text      string    `json:"text"`
+	Tag       string    `json:"tag"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func TestRebuildAppliesMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.bleve")
+	index, err := Open(path, bleve.NewIndexMapping())
+	require.NoError(t, err)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < eachPageSize+3; i++ {
+		require.NoError(t, index.Index(fmt.Sprintf("n%04d", i), note{Text: "Running goroutines", Tag: "go-lang", CreatedAt: created}))
+	}
+
+	// The default mapping neither stems words nor keeps tags whole.
+	stemmed := bleve.NewMatchQuery("run")
+	stemmed.SetField("text")
+	res, err := index.Search(bleve.NewSearchRequest(stemmed))
+	require.NoError(t, err)
+	assert.Zero(t, res.Total)
+
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.AddFieldMappingsAt("text", TextField())
+	docMapping.AddFieldMappingsAt("tag", KeywordField())
+	docMapping.AddFieldMappingsAt("created_at", bleve.NewDateTimeFieldMapping())
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = docMapping
+
+	index, copied, err := Rebuild(context.Background(), index, path, m)
+	require.NoError(t, err)
+	assert.Equal(t, eachPageSize+3, copied)
+
+	res, err = index.Search(bleve.NewSearchRequest(stemmed))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(eachPageSize+3), res.Total)
+
+	tag := bleve.NewTermQuery("go-lang")
+	tag.SetField("tag")
+	res, err = index.Search(bleve.NewSearchRequest(tag))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(eachPageSize+3), res.Total)
+
+	dates := bleve.NewDateRangeQuery(created.Add(-time.Hour), created.Add(time.Hour))
+	dates.SetField("created_at")
+	res, err = index.Search(bleve.NewSearchRequest(dates))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(eachPageSize+3), res.Total)
+
+	// Reopening keeps the rebuilt mapping.
+	require.NoError(t, index.Close())
+	index, err = Open(path, bleve.NewIndexMapping())
+	require.NoError(t, err)
+	defer index.Close()
+	res, err = index.Search(bleve.NewSearchRequest(stemmed))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(eachPageSize+3), res.Total)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	index, err := Open(filepath.Join(dir, "notes.bleve"), bleve.NewIndexMapping())
+	require.NoError(t, err)
+	defer index.Close()
+	require.NoError(t, index.Index("n1", note{Text: "Copied"}))
+
+	path := filepath.Join(dir, "copy.bleve")
+	require.NoError(t, Snapshot(index, path))
+	require.NoError(t, index.Index("n2", note{Text: "Not copied"}))
+
+	copied, err := bleve.Open(path)
+	require.NoError(t, err)
+	defer copied.Close()
+	count, err := copied.DocCount()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
@@ -0,0 +1,107 @@
+package vecstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backup copies the store into dir as it is at the time of the call. Segments are append
+// only, so each is copied up to its current size, and the HNSW index is written from
+// memory with the state it matches. Writes wait until the copy is done; searches do not.
+func (s *Store) Backup(dir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %v", err)
+	}
+	for _, seg := range s.segments {
+		if err := copyFile(filepath.Join(dir, filepath.Base(seg.path)), seg.path, seg.size); err != nil {
+			return fmt.Errorf("error backing up segment: %v", err)
+		}
+	}
+	if s.hnsw != nil {
+		return s.writeIndexFile(filepath.Join(dir, hnswFile))
+	}
+	return nil
+}
+
+// Backup copies every collection into a directory of the same name under dir. Open
+// collections are copied with Store.Backup; the files of the others are copied as they are.
+// Collections cannot be opened or dropped during the copy.
+func (c *Collections) Backup(dir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names, err := c.Names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		src, dst := filepath.Join(c.dir, name), filepath.Join(dir, name)
+		if store, ok := c.stores[name]; ok {
+			if err := store.Backup(dst); err != nil {
+				return fmt.Errorf("error backing up collection %s: %v", name, err)
+			}
+		} else if err := copyStoreFiles(dst, src); err != nil {
+			return fmt.Errorf("error backing up collection %s: %v", name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(src, modelFile)); err == nil {
+			if err := copyFile(filepath.Join(dst, modelFile), filepath.Join(src, modelFile), -1); err != nil {
+				return fmt.Errorf("error backing up collection %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyStoreFiles copies the segments and HNSW index of a closed store.
+func copyStoreFiles(dst, src string) error {
+	paths, err := filepath.Glob(filepath.Join(src, "segment-*.vec"))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(src, hnswFile)); err == nil {
+		paths = append(paths, filepath.Join(src, hnswFile))
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := copyFile(filepath.Join(dst, filepath.Base(path)), path, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the first size bytes of src to dst, or all of it when size is negative,
+// and syncs the copy.
+func copyFile(dst, src string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		_, err = io.Copy(out, in)
+	} else {
+		_, err = io.CopyN(out, in, size)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
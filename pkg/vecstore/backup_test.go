@@ -0,0 +1,65 @@
+package vecstore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionsBackup(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{HNSW: &HNSWConfig{M: 8}}
+	collections, err := OpenCollections(dir, opts)
+	require.NoError(t, err)
+
+	chat, err := collections.Get("chat")
+	require.NoError(t, err)
+	require.NoError(t, chat.Put(
+		Record{ID: "a", Text: "first", Vector: []float32{1, 0, 0}},
+		Record{ID: "b", Text: "second", Vector: []float32{0, 1, 0}},
+	))
+	require.NoError(t, collections.SetModel("chat", CollectionModel{Name: "local", Model: "minilm", Dimensions: 3}))
+
+	// A collection that is not open is copied from its files.
+	docs, err := collections.Get("docs")
+	require.NoError(t, err)
+	require.NoError(t, docs.Put(Record{ID: "d", Text: "doc", Vector: []float32{0, 0, 1}}))
+	require.NoError(t, collections.Close())
+	collections, err = OpenCollections(dir, opts)
+	require.NoError(t, err)
+	defer collections.Close()
+	chat, err = collections.Get("chat")
+	require.NoError(t, err)
+
+	backup := filepath.Join(t.TempDir(), "vectors")
+	require.NoError(t, collections.Backup(backup))
+
+	// Writes after the backup are not in it.
+	require.NoError(t, chat.Put(Record{ID: "c", Text: "third", Vector: []float32{1, 1, 0}}))
+
+	copied, err := OpenCollections(backup, opts)
+	require.NoError(t, err)
+	defer copied.Close()
+	names, err := copied.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat", "docs"}, names)
+
+	model, ok, err := copied.Model("chat")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "minilm", model.Model)
+
+	store, err := copied.Get("chat")
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.Len())
+	assert.FileExists(t, filepath.Join(backup, "chat", hnswFile))
+	results, err := store.Search([]float32{0, 1, 0}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "second", results[0].Text)
+
+	store, err = copied.Get("docs")
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+}
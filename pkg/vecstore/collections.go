@@ -0,0 +1,276 @@
+package vecstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// collectionNamePattern limits collection names to safe directory names.
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// modelFile records the embedding model of a collection inside its directory.
+const modelFile = "model.json"
+
+// ErrModelMismatch is returned when a collection is used with an embedding model other than
+// the one its vectors were made with.
+var ErrModelMismatch = errors.New("collection was embedded with another model")
+
+// CollectionModel is the embedding model that produced the vectors of a collection.
+type CollectionModel struct {
+	Name       string `json:"name"`  // Name of the model in the config
+	Model      string `json:"model"` // Model identifier, such as a Hugging Face repository
+	Dimensions int    `json:"dimensions"`
+}
+
+// Collections is a set of named vector stores, each in its own directory. Collections keep
+// unrelated vectors such as chat memory and project documents apart.
+type Collections struct {
+	mu     sync.Mutex
+	dir    string
+	opts   Options
+	stores map[string]*Store
+}
+
+// OpenCollections opens the collections in dir. Collections are opened on first use.
+func OpenCollections(dir string, opts Options) (*Collections, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating vector store directory: %v", err)
+	}
+	return &Collections{dir: dir, opts: opts, stores: make(map[string]*Store)}, nil
+}
+
+// ValidCollectionName reports whether name can be used for a collection.
+func ValidCollectionName(name string) bool {
+	return collectionNamePattern.MatchString(name)
+}
+
+// Get returns the named collection, creating it if it does not exist.
+func (c *Collections) Get(name string) (*Store, error) {
+	if !ValidCollectionName(name) {
+		return nil, fmt.Errorf("invalid collection name %q", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if store, ok := c.stores[name]; ok {
+		return store, nil
+	}
+
+	store, err := OpenWithOptions(filepath.Join(c.dir, name), c.opts)
+	if err != nil {
+		return nil, fmt.Errorf("error opening collection %s: %v", name, err)
+	}
+	c.stores[name] = store
+	return store, nil
+}
+
+// Names returns the names of all collections on disk in sorted order.
+func (c *Collections) Names() ([]string, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidCollectionName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Drop closes the named collection and deletes its files.
+func (c *Collections) Drop(name string) error {
+	if !ValidCollectionName(name) {
+		return fmt.Errorf("invalid collection name %q", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if store, ok := c.stores[name]; ok {
+		store.Close()
+		delete(c.stores, name)
+	}
+
+	path := filepath.Join(c.dir, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("collection %s does not exist", name)
+	}
+	return os.RemoveAll(path)
+}
+
+// Close closes every open collection.
+func (c *Collections) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for name, store := range c.stores {
+		if err := store.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error closing collection %s: %v", name, err)
+		}
+		delete(c.stores, name)
+	}
+	return firstErr
+}
+
+// MoveSegments moves the segment files and index of a store opened directly in dir into
+// the named collection. It lets a store created before collections existed become a
+// collection. The collection must not be open.
+func (c *Collections) MoveSegments(name string) (int, error) {
+	if !ValidCollectionName(name) {
+		return 0, fmt.Errorf("invalid collection name %q", name)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.dir, "segment-*.vec"))
+	if err != nil || len(paths) == 0 {
+		return 0, err
+	}
+
+	target := filepath.Join(c.dir, name)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return 0, err
+	}
+	if existing, _ := filepath.Glob(filepath.Join(target, "segment-*.vec")); len(existing) > 0 {
+		return 0, fmt.Errorf("collection %s already has segments", name)
+	}
+
+	// The index is moved too when present; a stale index is rebuilt on open.
+	if _, err := os.Stat(filepath.Join(c.dir, hnswFile)); err == nil {
+		paths = append(paths, filepath.Join(c.dir, hnswFile))
+	}
+	for _, path := range paths {
+		if err := os.Rename(path, filepath.Join(target, filepath.Base(path))); err != nil {
+			return 0, err
+		}
+	}
+	return len(paths), nil
+}
+
+// Model returns the embedding model recorded for the named collection. ok is false when the
+// collection has no model yet.
+func (c *Collections) Model(name string) (model CollectionModel, ok bool, err error) {
+	if !ValidCollectionName(name) {
+		return model, false, fmt.Errorf("invalid collection name %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, name, modelFile))
+	if os.IsNotExist(err) {
+		return model, false, nil
+	}
+	if err != nil {
+		return model, false, err
+	}
+	if err := json.Unmarshal(data, &model); err != nil {
+		return model, false, fmt.Errorf("error reading model of collection %s: %v", name, err)
+	}
+	return model, true, nil
+}
+
+// SetModel records the embedding model of the named collection, creating the collection if
+// it does not exist. A collection keeps the first model recorded for it: a model with another
+// identifier or dimensions, or one whose dimensions differ from the vectors already stored,
+// returns ErrModelMismatch, since vectors of different models cannot be compared. Drop the
+// collection to embed it with another model.
+func (c *Collections) SetModel(name string, model CollectionModel) error {
+	if model.Dimensions <= 0 {
+		return fmt.Errorf("model %s has no dimensions", model.Name)
+	}
+
+	store, err := c.Get(name)
+	if err != nil {
+		return err
+	}
+
+	current, ok, err := c.Model(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if current.Model != model.Model || current.Dimensions != model.Dimensions {
+			return fmt.Errorf("%w: collection %s holds %d-dimension vectors of %s, not %s", ErrModelMismatch, name, current.Dimensions, current.Model, model.Model)
+		}
+		if current.Name == model.Name {
+			return nil
+		}
+	} else if dims := store.Dimensions(); dims != 0 && dims != model.Dimensions {
+		return fmt.Errorf("%w: collection %s holds %d-dimension vectors, model %s makes %d", ErrModelMismatch, name, dims, model.Name, model.Dimensions)
+	}
+
+	data, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash cannot leave a partial model file.
+	path := filepath.Join(c.dir, name, modelFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Rebuild replaces the named collection with a new store that fn fills from the current
+// one, such as to embed its records again with another model. The new store is built next
+// to the collection and takes its place only when fn succeeds. Searches keep using the
+// current store meanwhile; writes to it wait until the rebuild ends and fail with ErrClosed
+// once it is replaced, so none goes missing from the new store silently. The collection
+// loses its recorded model, so the model of the new vectors can be set afterwards.
+func (c *Collections) Rebuild(name string, fn func(src, dst *Store) error) error {
+	src, err := c.Get(name)
+	if err != nil {
+		return err
+	}
+	src.writes.Lock()
+	defer src.writes.Unlock()
+
+	// Names starting with a dot are not valid collections, so the directories in progress
+	// are never listed or opened as collections.
+	tmp := filepath.Join(c.dir, "."+name+".rebuild")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	dst, err := OpenWithOptions(tmp, c.opts)
+	if err != nil {
+		return err
+	}
+	if err := fn(src, dst); err != nil {
+		dst.Close()
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src.Close()
+	if c.stores[name] == src {
+		delete(c.stores, name)
+	}
+	path := filepath.Join(c.dir, name)
+	old := filepath.Join(c.dir, "."+name+".old")
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(path, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,189 @@
+package vecstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionsIsolation(t *testing.T) {
+	dir := t.TempDir()
+	collections, err := OpenCollections(dir, Options{})
+	require.NoError(t, err)
+
+	chat, err := collections.Get("chat")
+	require.NoError(t, err)
+	docs, err := collections.Get("project-docs")
+	require.NoError(t, err)
+
+	same, err := collections.Get("chat")
+	require.NoError(t, err)
+	assert.Same(t, chat, same)
+
+	require.NoError(t, chat.Put(Record{ID: "a", Text: "chat", Vector: []float32{1, 0}}))
+	require.NoError(t, docs.Put(Record{ID: "a", Text: "docs", Vector: []float32{1, 0, 0}}))
+
+	results, err := chat.Search([]float32{1, 0}, 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "chat", results[0].Text)
+
+	names, err := collections.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat", "project-docs"}, names)
+
+	_, err = collections.Get("../escape")
+	assert.Error(t, err)
+
+	require.NoError(t, collections.Drop("project-docs"))
+	names, err = collections.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat"}, names)
+	assert.Error(t, collections.Drop("project-docs"))
+
+	require.NoError(t, collections.Close())
+
+	// Collections are reopened from disk.
+	collections, err = OpenCollections(dir, Options{})
+	require.NoError(t, err)
+	defer collections.Close()
+	chat, err = collections.Get("chat")
+	require.NoError(t, err)
+	assert.Equal(t, 1, chat.Len())
+}
+
+func TestCollectionsMoveSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	// A store created before collections keeps its segments in the root directory.
+	store, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Text: "old", Vector: []float32{1, 2}}))
+	require.NoError(t, store.Close())
+
+	collections, err := OpenCollections(dir, Options{})
+	require.NoError(t, err)
+	defer collections.Close()
+
+	moved, err := collections.MoveSegments("chat")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	_, err = os.Stat(filepath.Join(dir, "segment-000001.vec"))
+	assert.True(t, os.IsNotExist(err))
+
+	chat, err := collections.Get("chat")
+	require.NoError(t, err)
+	record, ok, err := chat.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "old", record.Text)
+
+	// Nothing is left to move.
+	moved, err = collections.MoveSegments("chat")
+	require.NoError(t, err)
+	assert.Zero(t, moved)
+}
+
+func TestCollectionsModel(t *testing.T) {
+	dir := t.TempDir()
+	collections, err := OpenCollections(dir, Options{})
+	require.NoError(t, err)
+	defer collections.Close()
+
+	_, ok, err := collections.Model("chat")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	small := CollectionModel{Name: "small", Model: "org/small", Dimensions: 3}
+	require.NoError(t, collections.SetModel("chat", small))
+	require.NoError(t, collections.SetModel("chat", small))
+
+	model, ok, err := collections.Model("chat")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, small, model)
+
+	// Renaming the model in the config keeps the collection usable.
+	renamed := CollectionModel{Name: "default", Model: "org/small", Dimensions: 3}
+	require.NoError(t, collections.SetModel("chat", renamed))
+
+	err = collections.SetModel("chat", CollectionModel{Name: "large", Model: "org/large", Dimensions: 5})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+	err = collections.SetModel("chat", CollectionModel{Name: "small", Model: "org/small", Dimensions: 4})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+
+	// Collections with vectors but no model only accept a model of the same dimensions.
+	docs, err := collections.Get("docs")
+	require.NoError(t, err)
+	require.NoError(t, docs.Put(Record{ID: "a", Text: "docs", Vector: []float32{1, 0}}))
+	assert.ErrorIs(t, collections.SetModel("docs", small), ErrModelMismatch)
+	require.NoError(t, collections.SetModel("docs", CollectionModel{Name: "tiny", Model: "org/tiny", Dimensions: 2}))
+
+	// Dropping a collection forgets its model.
+	require.NoError(t, collections.Drop("chat"))
+	_, ok, err = collections.Model("chat")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, collections.SetModel("chat", CollectionModel{Name: "large", Model: "org/large", Dimensions: 5}))
+}
+
+func TestCollectionsRebuild(t *testing.T) {
+	dir := t.TempDir()
+	collections, err := OpenCollections(dir, Options{})
+	require.NoError(t, err)
+	defer collections.Close()
+
+	chat, err := collections.Get("chat")
+	require.NoError(t, err)
+	require.NoError(t, chat.Put(Record{ID: "a", Text: "first", Vector: []float32{1, 0}, Metadata: map[string]any{"source": "chat"}}))
+	require.NoError(t, collections.SetModel("chat", CollectionModel{Name: "old", Model: "old", Dimensions: 2}))
+
+	// A failed rebuild leaves the collection as it was.
+	assert.Error(t, collections.Rebuild("chat", func(src, dst *Store) error {
+		return os.ErrInvalid
+	}))
+	assert.Equal(t, 1, chat.Len())
+
+	// A write during the rebuild waits for it and fails instead of going to the old store.
+	written := make(chan error, 1)
+	require.NoError(t, collections.Rebuild("chat", func(src, dst *Store) error {
+		go func() { written <- chat.Put(Record{ID: "b", Text: "late", Vector: []float32{0, 1}}) }()
+		for _, id := range src.IDs() {
+			record, _, err := src.Get(id)
+			if err != nil {
+				return err
+			}
+			record.Vector = []float32{1, 0, 0}
+			if err := dst.Put(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	assert.ErrorIs(t, <-written, ErrClosed)
+
+	names, err := collections.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat"}, names)
+	_, ok, err := collections.Model("chat")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, collections.SetModel("chat", CollectionModel{Name: "new", Model: "new", Dimensions: 3}))
+
+	rebuilt, err := collections.Get("chat")
+	require.NoError(t, err)
+	assert.Equal(t, 3, rebuilt.Dimensions())
+	record, ok, err := rebuilt.Get("a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "first", record.Text)
+	assert.Equal(t, "chat", record.Metadata["source"])
+	assert.Equal(t, 1, rebuilt.Len())
+	segments, err := filepath.Glob(filepath.Join(dir, "chat", "segment-*.vec"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+}
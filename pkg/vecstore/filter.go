@@ -0,0 +1,229 @@
+package vecstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Filter operators.
+const (
+	OpEq  = "eq"
+	OpNe  = "ne"
+	OpIn  = "in"
+	OpGt  = "gt"
+	OpGte = "gte"
+	OpLt  = "lt"
+	OpLte = "lte"
+)
+
+// Condition is a test on a metadata field. A search with conditions only returns records
+// that match all of them. Records without the field never match.
+type Condition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value any    `json:"value"` // A list of values for OpIn
+}
+
+// Eq matches records whose field equals value.
+func Eq(field string, value any) Condition {
+	return Condition{Field: field, Op: OpEq, Value: value}
+}
+
+// Ne matches records whose field is set to something other than value.
+func Ne(field string, value any) Condition {
+	return Condition{Field: field, Op: OpNe, Value: value}
+}
+
+// In matches records whose field equals one of values.
+func In(field string, values ...any) Condition {
+	return Condition{Field: field, Op: OpIn, Value: values}
+}
+
+// Gt matches records whose field is greater than value.
+func Gt(field string, value any) Condition {
+	return Condition{Field: field, Op: OpGt, Value: value}
+}
+
+// Gte matches records whose field is greater than or equal to value.
+func Gte(field string, value any) Condition {
+	return Condition{Field: field, Op: OpGte, Value: value}
+}
+
+// Lt matches records whose field is less than value.
+func Lt(field string, value any) Condition {
+	return Condition{Field: field, Op: OpLt, Value: value}
+}
+
+// Lte matches records whose field is less than or equal to value.
+func Lte(field string, value any) Condition {
+	return Condition{Field: field, Op: OpLte, Value: value}
+}
+
+// Validate checks that the operator is known.
+func (c Condition) Validate() error {
+	switch c.Op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		return nil
+	case OpIn:
+		if _, ok := c.Value.([]any); !ok {
+			return fmt.Errorf("filter on %s: in expects a list of values", c.Field)
+		}
+		return nil
+	default:
+		return fmt.Errorf("filter on %s: unknown operator %q", c.Field, c.Op)
+	}
+}
+
+// Match reports whether the metadata satisfies the condition.
+func (c Condition) Match(metadata map[string]any) bool {
+	value, ok := metadata[c.Field]
+	if !ok {
+		return false
+	}
+
+	switch c.Op {
+	case OpEq:
+		cmp, ok := compareValues(value, c.Value)
+		return ok && cmp == 0
+	case OpNe:
+		cmp, ok := compareValues(value, c.Value)
+		return !ok || cmp != 0
+	case OpIn:
+		values, _ := c.Value.([]any)
+		for _, v := range values {
+			if cmp, ok := compareValues(value, v); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := compareValues(value, c.Value)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case OpGt:
+		return cmp > 0
+	case OpGte:
+		return cmp >= 0
+	case OpLt:
+		return cmp < 0
+	case OpLte:
+		return cmp <= 0
+	}
+	return false
+}
+
+// matchAll reports whether the metadata satisfies every condition.
+func matchAll(metadata map[string]any, conditions []Condition) bool {
+	for _, c := range conditions {
+		if !c.Match(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues compares a stored metadata value with a filter value. Numbers compare
+// numerically, strings lexically and booleans for equality. A time.Time filter value
+// compares with numbers as Unix seconds and with strings as RFC 3339. The second result is
+// false when the values cannot be compared.
+func compareValues(stored, filter any) (int, bool) {
+	if t, ok := filter.(time.Time); ok {
+		if _, isNumber := toFloat(stored); isNumber {
+			filter = t.Unix()
+		} else {
+			filter = t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if a, ok := toFloat(stored); ok {
+		b, ok := toFloat(filter)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch a := stored.(type) {
+	case string:
+		b, ok := filter.(string)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		b, ok := filter.(bool)
+		if !ok || a != b {
+			return 1, ok
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// toFloat converts any numeric value to float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// normalizeMetadata converts metadata to the form it has after a JSON round trip, so that
+// records filter the same before and after the store is reopened.
+func normalizeMetadata(metadata map[string]any) map[string]any {
+	if len(metadata) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil
+	}
+	var normalized map[string]any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil
+	}
+	return normalized
+}
+
+// ChunkID returns a stable record ID for a chunk of text from a document, so the same text
+// in two documents is stored twice and reindexing a document replaces its chunks.
+func ChunkID(documentID string, text string) string {
+	sum := sha256.Sum256([]byte(documentID + "\x00" + text))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,61 @@
+package vecstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionMatch(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	metadata := normalizeMetadata(map[string]any{
+		"source":     "web",
+		"project":    "eternal",
+		"page":       3,
+		"created_at": created.Unix(),
+		"fetched":    created.Format(time.RFC3339),
+		"pinned":     true,
+	})
+
+	tests := []struct {
+		name      string
+		condition Condition
+		want      bool
+	}{
+		{"eq string", Eq("source", "web"), true},
+		{"eq other string", Eq("source", "chat"), false},
+		{"eq number", Eq("page", 3), true},
+		{"eq bool", Eq("pinned", true), true},
+		{"eq type mismatch", Eq("page", "3"), false},
+		{"ne", Ne("source", "chat"), true},
+		{"ne missing field", Ne("session", "abc"), false},
+		{"in", In("project", "other", "eternal"), true},
+		{"in miss", In("project", "other"), false},
+		{"gt", Gt("page", 2), true},
+		{"gte", Gte("page", 3.0), true},
+		{"lt", Lt("page", 3), false},
+		{"lte", Lte("page", int64(3)), true},
+		{"time against unix seconds", Gte("created_at", created.Add(-time.Hour)), true},
+		{"time against unix seconds after", Gt("created_at", created), false},
+		{"time against RFC 3339", Lt("fetched", created.Add(time.Hour)), true},
+		{"missing field", Eq("session", "abc"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.condition.Validate())
+			assert.Equal(t, tt.want, tt.condition.Match(metadata))
+		})
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	assert.Error(t, Condition{Field: "source", Op: "like", Value: "w%"}.Validate())
+	assert.Error(t, Condition{Field: "source", Op: OpIn, Value: "web"}.Validate())
+}
+
+func TestChunkID(t *testing.T) {
+	assert.Equal(t, ChunkID("doc", "text"), ChunkID("doc", "text"))
+	assert.NotEqual(t, ChunkID("doc", "text"), ChunkID("other", "text"))
+	assert.Len(t, ChunkID("", "text"), 32)
+}
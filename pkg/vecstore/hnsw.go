@@ -0,0 +1,601 @@
+package vecstore
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// HNSWConfig holds the parameters of a hierarchical navigable small world graph.
+type HNSWConfig struct {
+	M              int `yaml:"m" json:"m"`                             // Neighbors per node on the upper layers, twice as many on layer 0
+	EfConstruction int `yaml:"ef_construction" json:"ef_construction"` // Candidate list size while inserting
+	EfSearch       int `yaml:"ef_search" json:"ef_search"`             // Candidate list size while searching
+}
+
+// DefaultHNSWConfig returns parameters that work well for a few hundred thousand vectors.
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 200, EfSearch: 64}
+}
+
+// withDefaults fills unset parameters with their defaults.
+func (c HNSWConfig) withDefaults() HNSWConfig {
+	defaults := DefaultHNSWConfig()
+	if c.M <= 1 {
+		c.M = defaults.M
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = defaults.EfConstruction
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = defaults.EfSearch
+	}
+	return c
+}
+
+// Neighbor is an approximate nearest neighbor and its cosine similarity to the query.
+type Neighbor struct {
+	ID    string
+	Score float64
+}
+
+// hnswNode is a vector in the graph with its neighbor lists, one per layer.
+type hnswNode struct {
+	id        string
+	code      vectorCode // Normalized to unit length, then quantized
+	neighbors [][]int32
+	deleted   bool
+}
+
+// HNSW is an approximate nearest neighbor index using cosine similarity. Deleted vectors
+// stay in the graph as waypoints until the index is rebuilt. HNSW is not safe for
+// concurrent writes; searches may run concurrently with each other.
+type HNSW struct {
+	config       HNSWConfig
+	quantization Quantization
+	dims         int
+	nodes        []hnswNode
+	ids          map[string]int32
+	entry        int32
+	maxLevel     int
+	deleted      int
+	levelMul     float64
+	rng          *rand.Rand
+}
+
+// NewHNSW creates an empty index for vectors of the given dimensions.
+func NewHNSW(dims int, config HNSWConfig) *HNSW {
+	return NewQuantizedHNSW(dims, config, QuantizeNone)
+}
+
+// NewQuantizedHNSW creates an empty index that keeps its vectors quantized. Similarities are
+// estimated from the quantized vectors, so callers that need exact scores rescore the
+// results.
+func NewQuantizedHNSW(dims int, config HNSWConfig, q Quantization) *HNSW {
+	config = config.withDefaults()
+	return &HNSW{
+		config:       config,
+		quantization: q,
+		dims:         dims,
+		ids:          make(map[string]int32),
+		entry:        -1,
+		levelMul:     1 / math.Log(float64(config.M)),
+		rng:          rand.New(rand.NewSource(42)),
+	}
+}
+
+// Config returns the parameters of the index.
+func (h *HNSW) Config() HNSWConfig {
+	return h.config
+}
+
+// Quantization returns how the index compresses its vectors.
+func (h *HNSW) Quantization() Quantization {
+	return h.quantization
+}
+
+// VectorBytes returns the memory taken by the vectors of the index, deleted ones included.
+func (h *HNSW) VectorBytes() int64 {
+	var n int64
+	for _, node := range h.nodes {
+		n += node.code.size()
+	}
+	return n
+}
+
+// SetEfSearch changes the candidate list size used by Search.
+func (h *HNSW) SetEfSearch(ef int) {
+	if ef > 0 {
+		h.config.EfSearch = ef
+	}
+}
+
+// Len returns the number of live vectors in the index.
+func (h *HNSW) Len() int {
+	return len(h.ids)
+}
+
+// Deleted returns the number of deleted vectors still kept in the graph.
+func (h *HNSW) Deleted() int {
+	return h.deleted
+}
+
+// Insert adds a vector to the index, replacing the vector previously inserted with the same ID.
+func (h *HNSW) Insert(id string, vector []float32) error {
+	if len(vector) != h.dims {
+		return fmt.Errorf("vector %s has %d dimensions, expected %d", id, len(vector), h.dims)
+	}
+	h.Delete(id)
+
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMul)
+	unit := normalized(vector)
+	q := newProbe(h.quantization, unit)
+	node := hnswNode{id: id, code: encodeVector(h.quantization, unit), neighbors: make([][]int32, level+1)}
+	n := int32(len(h.nodes))
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = n
+
+	if h.entry < 0 {
+		h.entry = n
+		h.maxLevel = level
+		return nil
+	}
+
+	// Descend greedily through the layers above the new node.
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedyClosest(q, ep, l)
+	}
+
+	// Connect the node on each of its layers.
+	entries := []int32{ep}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(q, entries, h.config.EfConstruction, l)
+		neighbors := h.selectNeighbors(candidates, h.maxNeighbors(l))
+		h.nodes[n].neighbors[l] = neighbors
+
+		for _, nb := range neighbors {
+			h.connect(nb, n, l)
+		}
+
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.node)
+		}
+	}
+
+	if level > h.maxLevel {
+		h.entry = n
+		h.maxLevel = level
+	}
+	return nil
+}
+
+// Delete removes a vector from search results. Unknown IDs are ignored.
+func (h *HNSW) Delete(id string) {
+	n, ok := h.ids[id]
+	if !ok {
+		return
+	}
+	h.nodes[n].deleted = true
+	delete(h.ids, id)
+	h.deleted++
+}
+
+// Search returns up to k live vectors closest to the query, most similar first.
+func (h *HNSW) Search(query []float32, k int) ([]Neighbor, error) {
+	return h.SearchFunc(query, k, nil)
+}
+
+// SearchFunc is like Search but only returns vectors whose ID is accepted. Rejected vectors
+// are still used to navigate the graph. The candidate list grows until k vectors are found
+// or the whole graph has been considered.
+func (h *HNSW) SearchFunc(query []float32, k int, accept func(id string) bool) ([]Neighbor, error) {
+	if len(query) != h.dims {
+		return nil, fmt.Errorf("query has %d dimensions, expected %d", len(query), h.dims)
+	}
+	if k <= 0 || h.entry < 0 || len(h.ids) == 0 {
+		return nil, nil
+	}
+
+	q := newProbe(h.quantization, normalized(query))
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedyClosest(q, ep, l)
+	}
+
+	for ef := max(h.config.EfSearch, k); ; ef *= 2 {
+		results := h.collect(h.searchLayer(q, []int32{ep}, ef, 0), k, accept)
+		if len(results) == k || ef >= len(h.nodes) {
+			return results, nil
+		}
+	}
+}
+
+// collect returns the first k live and accepted candidates.
+func (h *HNSW) collect(candidates []candidate, k int, accept func(id string) bool) []Neighbor {
+	results := make([]Neighbor, 0, k)
+	for _, c := range candidates {
+		if h.nodes[c.node].deleted {
+			continue
+		}
+		if accept != nil && !accept(h.nodes[c.node].id) {
+			continue
+		}
+		results = append(results, Neighbor{ID: h.nodes[c.node].id, Score: 1 - c.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+// maxNeighbors returns the neighbor list size on a layer.
+func (h *HNSW) maxNeighbors(level int) int {
+	if level == 0 {
+		return h.config.M * 2
+	}
+	return h.config.M
+}
+
+// distance returns the cosine distance between a normalized vector and a node.
+func (h *HNSW) distance(q probe, n int32) float64 {
+	return 1 - float64(h.nodes[n].code.similarity(q))
+}
+
+// greedyClosest walks a layer from ep to the node closest to q.
+func (h *HNSW) greedyClosest(q probe, ep int32, level int) int32 {
+	best := ep
+	bestDist := h.distance(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, nb := range h.nodes[best].neighbors[level] {
+			if d := h.distance(q, nb); d < bestDist {
+				best, bestDist = nb, d
+				changed = true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns up to ef nodes on a layer closest to q, sorted by distance.
+func (h *HNSW) searchLayer(q probe, entries []int32, ef int, level int) []candidate {
+	visited := make(map[int32]bool, ef*4)
+	queue := &candidateQueue{}          // Closest first
+	found := &candidateQueue{max: true} // Farthest first
+
+	for _, ep := range entries {
+		if visited[ep] {
+			continue
+		}
+		visited[ep] = true
+		c := candidate{node: ep, dist: h.distance(q, ep)}
+		heap.Push(queue, c)
+		heap.Push(found, c)
+	}
+	for found.Len() > ef {
+		heap.Pop(found)
+	}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(candidate)
+		if current.dist > found.items[0].dist && found.Len() >= ef {
+			break
+		}
+
+		for _, nb := range h.nodes[current.node].neighbors[level] {
+			if visited[nb] {
+				continue
+			}
+			visited[nb] = true
+
+			d := h.distance(q, nb)
+			if found.Len() < ef || d < found.items[0].dist {
+				heap.Push(queue, candidate{node: nb, dist: d})
+				heap.Push(found, candidate{node: nb, dist: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	results := append([]candidate(nil), found.items...)
+	sort.Slice(results, func(i, j int) bool { return results[i].dist < results[j].dist })
+	return results
+}
+
+// selectNeighbors picks up to m neighbors from candidates sorted by distance. A candidate
+// is skipped when it is closer to an already selected neighbor than to the base node, which
+// keeps links spread out across clusters. Skipped candidates fill any remaining slots.
+func (h *HNSW) selectNeighbors(candidates []candidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var skipped []int32
+
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		var q probe
+		if len(selected) > 0 {
+			q = h.nodes[c.node].code.probe(h.dims)
+		}
+		for _, s := range selected {
+			if h.distance(q, s) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+
+	for _, s := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, s)
+	}
+	return selected
+}
+
+// connect adds a link from node to neighbor on a layer, pruning the node's links when the
+// list is full.
+func (h *HNSW) connect(node, neighbor int32, level int) {
+	links := append(h.nodes[node].neighbors[level], neighbor)
+	if len(links) <= h.maxNeighbors(level) {
+		h.nodes[node].neighbors[level] = links
+		return
+	}
+
+	q := h.nodes[node].code.probe(h.dims)
+	candidates := make([]candidate, len(links))
+	for i, l := range links {
+		candidates[i] = candidate{node: l, dist: h.distance(q, l)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	h.nodes[node].neighbors[level] = h.selectNeighbors(candidates, h.maxNeighbors(level))
+}
+
+// normalized returns a unit length copy of the vector.
+func normalized(vector []float32) []float32 {
+	return Normalize(append([]float32(nil), vector...))
+}
+
+// candidate is a node and its distance to the query.
+type candidate struct {
+	node int32
+	dist float64
+}
+
+// candidateQueue is a heap of candidates ordered by distance, closest first unless max is set.
+type candidateQueue struct {
+	items []candidate
+	max   bool
+}
+
+func (q candidateQueue) Len() int { return len(q.items) }
+func (q candidateQueue) Less(i, j int) bool {
+	if q.max {
+		return q.items[i].dist > q.items[j].dist
+	}
+	return q.items[i].dist < q.items[j].dist
+}
+func (q candidateQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *candidateQueue) Push(x any)   { q.items = append(q.items, x.(candidate)) }
+func (q *candidateQueue) Pop() any {
+	n := len(q.items)
+	x := q.items[n-1]
+	q.items = q.items[:n-1]
+	return x
+}
+
+// The index file stores the graph including the vectors, so that deleted nodes can keep
+// serving as waypoints after a restart:
+//
+//	header: magic "HNSW" | uint32 version | uint32 M | uint32 efConstruction | uint32 efSearch |
+//	        uint32 dimensions | int32 entry | uint32 max level | uint32 node count |
+//	        uint32 quantization (0 none, 1 int8, 2 binary)
+//	node:   uint32 id length | id | uint8 deleted | vector |
+//	        uint32 layer count | per layer: uint32 link count | link count x int32
+//
+// The vector is dimensions x float32 without quantization, a float32 scale and dimensions
+// x int8 for int8, and ceil(dimensions / 64) x uint64 sign bits for binary. Version 1 files
+// have no quantization field and float32 vectors.
+const (
+	hnswMagic   = "HNSW"
+	hnswVersion = 2
+)
+
+// WriteTo writes the index to w.
+func (h *HNSW) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	header := []uint32{hnswVersion, uint32(h.config.M), uint32(h.config.EfConstruction), uint32(h.config.EfSearch),
+		uint32(h.dims), uint32(h.entry), uint32(h.maxLevel), uint32(len(h.nodes)), h.quantization.code()}
+	cw.Write([]byte(hnswMagic))
+	binary.Write(cw, binary.LittleEndian, header)
+
+	for _, node := range h.nodes {
+		binary.Write(cw, binary.LittleEndian, uint32(len(node.id)))
+		cw.Write([]byte(node.id))
+
+		var deleted uint8
+		if node.deleted {
+			deleted = 1
+		}
+		binary.Write(cw, binary.LittleEndian, deleted)
+		switch h.quantization {
+		case QuantizeInt8:
+			binary.Write(cw, binary.LittleEndian, node.code.scale)
+			binary.Write(cw, binary.LittleEndian, node.code.int8s)
+		case QuantizeBinary:
+			binary.Write(cw, binary.LittleEndian, node.code.bits)
+		default:
+			binary.Write(cw, binary.LittleEndian, node.code.floats)
+		}
+
+		binary.Write(cw, binary.LittleEndian, uint32(len(node.neighbors)))
+		for _, links := range node.neighbors {
+			binary.Write(cw, binary.LittleEndian, uint32(len(links)))
+			binary.Write(cw, binary.LittleEndian, links)
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadHNSW reads an index written by WriteTo.
+func ReadHNSW(r io.Reader) (*HNSW, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(hnswMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != hnswMagic {
+		return nil, fmt.Errorf("not an HNSW index")
+	}
+
+	var version uint32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("error reading HNSW header: %v", err)
+	}
+	if version != 1 && version != hnswVersion {
+		return nil, fmt.Errorf("unsupported HNSW index version %d", version)
+	}
+	header := make([]uint32, 8)
+	if version == 1 {
+		header = header[:7]
+	}
+	if err := binary.Read(br, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("error reading HNSW header: %v", err)
+	}
+	header = append([]uint32{version}, header...)
+
+	var quantization Quantization
+	if version > 1 {
+		var err error
+		if quantization, err = quantizationFromCode(header[8]); err != nil {
+			return nil, err
+		}
+	}
+
+	h := NewQuantizedHNSW(int(header[4]), HNSWConfig{M: int(header[1]), EfConstruction: int(header[2]), EfSearch: int(header[3])}, quantization)
+	h.entry = int32(header[5])
+	h.maxLevel = int(header[6])
+	count := int(header[7])
+
+	h.nodes = make([]hnswNode, count)
+	for i := range h.nodes {
+		node, err := readHNSWNode(br, h.dims, count, quantization)
+		if err != nil {
+			return nil, fmt.Errorf("error reading HNSW node %d: %v", i, err)
+		}
+		h.nodes[i] = node
+		if node.deleted {
+			h.deleted++
+		} else {
+			h.ids[node.id] = int32(i)
+		}
+	}
+
+	if count > 0 && (h.entry < 0 || int(h.entry) >= count) {
+		return nil, fmt.Errorf("invalid HNSW entry point %d", h.entry)
+	}
+	return h, nil
+}
+
+// readHNSWNode reads a single node, checking that its links point inside the graph.
+func readHNSWNode(r io.Reader, dims, count int, q Quantization) (hnswNode, error) {
+	var node hnswNode
+
+	var idLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &idLen); err != nil {
+		return node, err
+	}
+	id := make([]byte, idLen)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return node, err
+	}
+	node.id = string(id)
+
+	var deleted uint8
+	if err := binary.Read(r, binary.LittleEndian, &deleted); err != nil {
+		return node, err
+	}
+	node.deleted = deleted == 1
+
+	if err := readVectorCode(r, dims, q, &node.code); err != nil {
+		return node, err
+	}
+
+	var layers uint32
+	if err := binary.Read(r, binary.LittleEndian, &layers); err != nil {
+		return node, err
+	}
+	node.neighbors = make([][]int32, layers)
+	for l := range node.neighbors {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return node, err
+		}
+		links := make([]int32, n)
+		if err := binary.Read(r, binary.LittleEndian, links); err != nil {
+			return node, err
+		}
+		for _, link := range links {
+			if link < 0 || int(link) >= count {
+				return node, fmt.Errorf("link %d out of range", link)
+			}
+		}
+		node.neighbors[l] = links
+	}
+	return node, nil
+}
+
+// readVectorCode reads a vector in the encoding of the quantization.
+func readVectorCode(r io.Reader, dims int, q Quantization, code *vectorCode) error {
+	switch q {
+	case QuantizeInt8:
+		code.int8s = make([]int8, dims)
+		if err := binary.Read(r, binary.LittleEndian, &code.scale); err != nil {
+			return err
+		}
+		return binary.Read(r, binary.LittleEndian, code.int8s)
+	case QuantizeBinary:
+		code.bits = make([]uint64, (dims+63)/64)
+		return binary.Read(r, binary.LittleEndian, code.bits)
+	default:
+		code.floats = make([]float32, dims)
+		return binary.Read(r, binary.LittleEndian, code.floats)
+	}
+}
+
+// countingWriter counts written bytes and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
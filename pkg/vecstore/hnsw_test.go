@@ -0,0 +1,271 @@
+package vecstore
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// randomVectors returns n clustered vectors, which resembles text embeddings more closely
+// than uniform noise.
+func randomVectors(rng *rand.Rand, n, dims int) [][]float32 {
+	centers := make([][]float32, 16)
+	for i := range centers {
+		centers[i] = make([]float32, dims)
+		for j := range centers[i] {
+			centers[i][j] = float32(rng.NormFloat64())
+		}
+	}
+
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		center := centers[rng.Intn(len(centers))]
+		vectors[i] = make([]float32, dims)
+		for j := range vectors[i] {
+			vectors[i][j] = center[j] + float32(rng.NormFloat64()*1.5)
+		}
+	}
+	return vectors
+}
+
+// exactNeighbors is the brute-force baseline for recall measurements.
+func exactNeighbors(vectors [][]float32, query []float32, k int) []string {
+	type scored struct {
+		id    string
+		score float64
+	}
+	scores := make([]scored, len(vectors))
+	for i, v := range vectors {
+		scores[i] = scored{id: fmt.Sprint(i), score: cosine(v, query)}
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+
+	ids := make([]string, k)
+	for i := range ids {
+		ids[i] = scores[i].id
+	}
+	return ids
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func buildHNSW(t testing.TB, vectors [][]float32, config HNSWConfig) *HNSW {
+	h := NewHNSW(len(vectors[0]), config)
+	for i, v := range vectors {
+		require.NoError(t, h.Insert(fmt.Sprint(i), v))
+	}
+	return h
+}
+
+func TestHNSWRecallAgainstBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Queries come from the same distribution but are not in the index.
+	vectors := randomVectors(rng, 3050, 64)
+	vectors, queries := vectors[:3000], vectors[3000:]
+	const k = 10
+
+	h := buildHNSW(t, vectors, DefaultHNSWConfig())
+
+	var hits int
+	var approxTime, exactTime time.Duration
+	for _, q := range queries {
+		start := time.Now()
+		expected := exactNeighbors(vectors, q, k)
+		exactTime += time.Since(start)
+
+		start = time.Now()
+		results, err := h.Search(q, k)
+		approxTime += time.Since(start)
+		require.NoError(t, err)
+
+		found := make(map[string]bool)
+		for _, r := range results {
+			found[r.ID] = true
+		}
+		for _, id := range expected {
+			if found[id] {
+				hits++
+			}
+		}
+	}
+
+	recall := float64(hits) / float64(len(queries)*k)
+	t.Logf("recall@%d: %.3f, HNSW %v/query, brute force %v/query", k, recall,
+		approxTime/time.Duration(len(queries)), exactTime/time.Duration(len(queries)))
+	assert.GreaterOrEqual(t, recall, 0.95)
+}
+
+func TestHNSWDeleteAndReinsert(t *testing.T) {
+	h := NewHNSW(2, HNSWConfig{M: 4})
+	require.NoError(t, h.Insert("east", []float32{1, 0}))
+	require.NoError(t, h.Insert("north", []float32{0, 1}))
+	require.NoError(t, h.Insert("west", []float32{-1, 0}))
+
+	results, err := h.Search([]float32{1, 0.1}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "east", results[0].ID)
+
+	h.Delete("east")
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, 1, h.Deleted())
+
+	results, err = h.Search([]float32{1, 0.1}, 3)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "north", results[0].ID)
+
+	// Reinserting an ID replaces its vector.
+	require.NoError(t, h.Insert("north", []float32{1, 0}))
+	results, err = h.Search([]float32{1, 0}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "north", results[0].ID)
+	assert.InDelta(t, 1.0, results[0].Score, 1e-6)
+
+	assert.Error(t, h.Insert("bad", []float32{1}))
+}
+
+func TestHNSWWriteRead(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vectors := randomVectors(rng, 305, 16)
+	vectors, queries := vectors[:300], vectors[300:]
+	h := buildHNSW(t, vectors, HNSWConfig{M: 8, EfConstruction: 64, EfSearch: 32})
+	h.Delete("7")
+
+	var buf bytes.Buffer
+	_, err := h.WriteTo(&buf)
+	require.NoError(t, err)
+
+	loaded, err := ReadHNSW(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, h.Config(), loaded.Config())
+	assert.Equal(t, h.Len(), loaded.Len())
+	assert.Equal(t, 1, loaded.Deleted())
+
+	for _, q := range queries {
+		expected, err := h.Search(q, 5)
+		require.NoError(t, err)
+		actual, err := loaded.Search(q, 5)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	_, err = ReadHNSW(bytes.NewReader([]byte("nope")))
+	assert.Error(t, err)
+}
+
+func TestStoreHNSWPersistence(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{HNSW: &HNSWConfig{M: 8}}
+
+	store, err := OpenWithOptions(dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(
+		Record{ID: "a", Text: "alpha", Vector: []float32{1, 0, 0}},
+		Record{ID: "b", Text: "beta", Vector: []float32{0, 1, 0}},
+		Record{ID: "c", Text: "gamma", Vector: []float32{0, 0, 1}},
+	))
+	require.NoError(t, store.Delete("b"))
+	require.NoError(t, store.Close())
+
+	store, err = OpenWithOptions(dir, opts)
+	require.NoError(t, err)
+	require.NotNil(t, store.hnsw)
+	assert.Equal(t, 1, store.hnsw.Deleted(), "the saved index is loaded rather than rebuilt")
+
+	results, err := store.Search([]float32{0, 0.9, 0.1}, 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "gamma", results[0].Text)
+
+	// Compaction rebuilds the index without the deleted node.
+	require.NoError(t, store.Compact())
+	assert.Zero(t, store.hnsw.Deleted())
+
+	// A write after the last save makes the saved index stale, so it is rebuilt on open.
+	require.NoError(t, store.Put(Record{ID: "d", Text: "delta", Vector: []float32{0, 1, 1}}))
+	store.hnsw = nil
+	require.NoError(t, store.Close())
+
+	store, err = OpenWithOptions(dir, opts)
+	require.NoError(t, err)
+	defer store.Close()
+
+	results, err = store.Search([]float32{0, 1, 1}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "delta", results[0].Text)
+}
+
+func BenchmarkSearch(b *testing.B) {
+	rng := rand.New(rand.NewSource(3))
+	vectors := randomVectors(rng, 10100, 128)
+	vectors, queries := vectors[:10000], vectors[10000:]
+
+	store, err := OpenWithOptions(b.TempDir(), Options{HNSW: &HNSWConfig{}})
+	require.NoError(b, err)
+	defer store.Close()
+
+	records := make([]Record, len(vectors))
+	for i, v := range vectors {
+		records[i] = Record{ID: fmt.Sprint(i), Vector: v}
+	}
+	require.NoError(b, store.Put(records...))
+
+	b.Run("hnsw", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store.Search(queries[i%len(queries)], 10)
+		}
+	})
+	b.Run("exact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store.SearchExact(queries[i%len(queries)], 10)
+		}
+	})
+}
+
+func TestStoreHNSWFilteredSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vectors := randomVectors(rng, exactFilterLimit*2+10, 16)
+	vectors, queries := vectors[:exactFilterLimit*2], vectors[exactFilterLimit*2:]
+
+	store, err := OpenWithOptions(t.TempDir(), Options{HNSW: &HNSWConfig{M: 8, EfConstruction: 64}})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Every fourth record is rare, so filtering on it uses the exact path while filtering
+	// on the common value goes through the graph.
+	records := make([]Record, len(vectors))
+	for i, v := range vectors {
+		kind := "common"
+		if i%4 == 0 {
+			kind = "rare"
+		}
+		records[i] = Record{ID: fmt.Sprint(i), Vector: v, Metadata: map[string]any{"kind": kind}}
+	}
+	require.NoError(t, store.Put(records...))
+
+	for _, kind := range []string{"rare", "common"} {
+		for _, q := range queries {
+			results, err := store.Search(q, 10, Eq("kind", kind))
+			require.NoError(t, err)
+			assert.Len(t, results, 10)
+			for _, r := range results {
+				assert.Equal(t, kind, r.Metadata["kind"])
+			}
+		}
+	}
+}
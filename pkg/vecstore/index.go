@@ -0,0 +1,156 @@
+package vecstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// indexState identifies the segment files an HNSW index was saved against. An index saved
+// for a different state is stale and gets rebuilt from the segments.
+type indexState struct {
+	Segments uint32
+	Bytes    int64
+}
+
+// state returns the current index state of the store. The caller holds the lock.
+func (s *Store) state() indexState {
+	st := indexState{Segments: uint32(len(s.segments))}
+	for _, seg := range s.segments {
+		st.Bytes += seg.size
+	}
+	return st
+}
+
+// updateIndex applies a written record to the HNSW index. The caller holds the lock.
+func (s *Store) updateIndex(op byte, record Record) {
+	if s.hnswConfig == nil {
+		return
+	}
+	if s.hnsw == nil {
+		if op != opPut {
+			return
+		}
+		s.hnsw = NewQuantizedHNSW(s.dims, *s.hnswConfig, s.quantization)
+	}
+
+	switch op {
+	case opPut:
+		// Dimensions are checked before the record is written, so this cannot fail.
+		s.hnsw.Insert(record.ID, record.Vector)
+	case opDelete:
+		s.hnsw.Delete(record.ID)
+	}
+}
+
+// loadIndex reads the persisted HNSW index, rebuilding it when it is missing, stale or was
+// built with different graph parameters or quantization. The caller holds the lock or owns the store.
+func (s *Store) loadIndex() error {
+	config := s.hnswConfig.withDefaults()
+
+	h, st, err := readIndexFile(filepath.Join(s.dir, hnswFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		// A damaged index is only a cache of the segments.
+	case st != s.state() || h.dims != s.dims:
+	case h.config.M != config.M || h.config.EfConstruction != config.EfConstruction:
+	case h.quantization != s.quantization:
+	default:
+		h.SetEfSearch(config.EfSearch)
+		s.hnsw = h
+		return nil
+	}
+
+	return s.rebuildIndex()
+}
+
+// rebuildIndex builds a new HNSW index from the live records. The caller holds the lock.
+func (s *Store) rebuildIndex() error {
+	s.hnsw = nil
+	if s.dims == 0 {
+		return nil
+	}
+
+	h := NewQuantizedHNSW(s.dims, *s.hnswConfig, s.quantization)
+	for _, id := range sortedKeys(s.index) {
+		loc := s.index[id]
+		record, err := loc.segment.readRecord(loc.offset, s.dims)
+		if err != nil {
+			return fmt.Errorf("error rebuilding HNSW index: %v", err)
+		}
+		if err := h.Insert(id, record.Vector); err != nil {
+			return fmt.Errorf("error rebuilding HNSW index: %v", err)
+		}
+	}
+	s.hnsw = h
+	return nil
+}
+
+// saveIndex writes the HNSW index next to the segments, replacing the previous file
+// atomically. The caller holds the lock.
+func (s *Store) saveIndex() error {
+	if s.hnsw == nil {
+		return nil
+	}
+	return s.writeIndexFile(filepath.Join(s.dir, hnswFile))
+}
+
+// writeIndexFile writes the HNSW index and the store state to path, replacing the file
+// atomically. The caller holds the lock for reading or writing.
+func (s *Store) writeIndexFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error creating HNSW index: %v", err)
+	}
+
+	if err := binary.Write(f, binary.LittleEndian, s.state()); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing HNSW index: %v", err)
+	}
+	if _, err := s.hnsw.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing HNSW index: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing HNSW index: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing HNSW index: %v", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readIndexFile reads a persisted HNSW index and the store state it was saved against.
+func readIndexFile(path string) (*HNSW, indexState, error) {
+	var st indexState
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, st, err
+	}
+	defer f.Close()
+
+	if err := binary.Read(f, binary.LittleEndian, &st); err != nil {
+		return nil, st, fmt.Errorf("error reading HNSW index state: %v", err)
+	}
+
+	h, err := ReadHNSW(f)
+	return h, st, err
+}
+
+// sortedKeys returns the IDs in the index in sorted order so rebuilds are deterministic.
+func sortedKeys(index map[string]location) []string {
+	ids := make([]string, 0, len(index))
+	for id := range index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,293 @@
+package vecstore
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// keyBlock is the number of key rows compared with every query before moving on, so the
+// block stays in cache: 128 rows of 384 dimensions take 192 KiB.
+const keyBlock = 128
+
+// Dot returns the dot product of two vectors of the same length. Four accumulators let
+// the CPU overlap the multiply-adds instead of waiting on a single sum, and slicing four
+// values at a time lets the compiler drop the bounds checks.
+func Dot(a, b []float32) float32 {
+	var s0, s1, s2, s3 float32
+	for len(a) >= 4 && len(b) >= 4 {
+		a4, b4 := a[:4:4], b[:4:4]
+		s0 += a4[0] * b4[0]
+		s1 += a4[1] * b4[1]
+		s2 += a4[2] * b4[2]
+		s3 += a4[3] * b4[3]
+		a, b = a[4:], b[4:]
+	}
+	for i := range a {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// dot4 returns the dot products of x with four vectors, loading each value of x once.
+func dot4(x, y0, y1, y2, y3 []float32) (float32, float32, float32, float32) {
+	n := len(x)
+	y0, y1, y2, y3 = y0[:n], y1[:n], y2[:n], y3[:n]
+	var s0, s1, s2, s3 float32
+	for i, v := range x {
+		s0 += v * y0[i]
+		s1 += v * y1[i]
+		s2 += v * y2[i]
+		s3 += v * y3[i]
+	}
+	return s0, s1, s2, s3
+}
+
+// scoreBlock writes the similarities of a query with keys [start, end) to out, four keys
+// at a time.
+func scoreBlock(q []float32, keys *Matrix, start, end int, out []float32) {
+	j := start
+	for ; j+4 <= end; j += 4 {
+		o := out[j-start : j-start+4 : j-start+4]
+		o[0], o[1], o[2], o[3] = dot4(q, keys.Row(j), keys.Row(j+1), keys.Row(j+2), keys.Row(j+3))
+	}
+	for ; j < end; j++ {
+		out[j-start] = Dot(q, keys.Row(j))
+	}
+}
+
+// Normalize scales a vector to unit length in place and returns it. Zero vectors are left
+// as they are.
+func Normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range v {
+		v[i] *= scale
+	}
+	return v
+}
+
+// Matrix holds unit length vectors row by row in a single slice. The dot product of two
+// rows is their cosine similarity, so comparisons need no norms and read memory in order.
+type Matrix struct {
+	dims int
+	data []float32
+}
+
+// NewMatrix creates an empty matrix for vectors of the given dimensions.
+func NewMatrix(dims, capacity int) *Matrix {
+	return &Matrix{dims: dims, data: make([]float32, 0, dims*capacity)}
+}
+
+// NormalizedMatrix copies the vectors into a matrix and normalizes them.
+func NormalizedMatrix(vectors [][]float32) (*Matrix, error) {
+	if len(vectors) == 0 {
+		return NewMatrix(0, 0), nil
+	}
+	m := NewMatrix(len(vectors[0]), len(vectors))
+	for _, v := range vectors {
+		if err := m.Append(v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Append adds a normalized copy of the vector as the last row.
+func (m *Matrix) Append(v []float32) error {
+	if len(v) != m.dims {
+		return fmt.Errorf("vector has %d dimensions, expected %d", len(v), m.dims)
+	}
+	start := len(m.data)
+	m.data = append(m.data, v...)
+	Normalize(m.data[start:])
+	return nil
+}
+
+// Dims returns the length of the rows.
+func (m *Matrix) Dims() int {
+	return m.dims
+}
+
+// Len returns the number of rows.
+func (m *Matrix) Len() int {
+	if m.dims == 0 {
+		return 0
+	}
+	return len(m.data) / m.dims
+}
+
+// Row returns row i. The slice shares memory with the matrix.
+func (m *Matrix) Row(i int) []float32 {
+	return m.data[i*m.dims : (i+1)*m.dims : (i+1)*m.dims]
+}
+
+// Similarities returns the cosine similarity of every query with every key, with the
+// similarities of query i in out[i*keys.Len():(i+1)*keys.Len()]. Blocks of keys are spread
+// over the CPUs, and each block is compared with all queries while it is in cache.
+func Similarities(queries, keys *Matrix) ([]float32, error) {
+	if queries.Len() > 0 && keys.Len() > 0 && queries.dims != keys.dims {
+		return nil, fmt.Errorf("queries have %d dimensions, keys %d", queries.dims, keys.dims)
+	}
+
+	n := keys.Len()
+	out := make([]float32, queries.Len()*n)
+	parallelBlocks(n, keyBlock, func(_, start, end int) {
+		for i := 0; i < queries.Len(); i++ {
+			scoreBlock(queries.Row(i), keys, start, end, out[i*n+start:i*n+end])
+		}
+	})
+	return out, nil
+}
+
+// Match is a key row and its similarity to a query.
+type Match struct {
+	Index int
+	Score float32
+}
+
+// TopK returns the k keys most similar to each query, most similar first, with ties in row
+// order. Each worker keeps a heap of at most k matches per query for the blocks it
+// compares, and the heaps are merged at the end, so memory does not grow with the keys.
+func TopK(queries, keys *Matrix, k int) ([][]Match, error) {
+	if queries.Len() > 0 && keys.Len() > 0 && queries.dims != keys.dims {
+		return nil, fmt.Errorf("queries have %d dimensions, keys %d", queries.dims, keys.dims)
+	}
+
+	results := make([][]Match, queries.Len())
+	if k <= 0 || keys.Len() == 0 {
+		return results, nil
+	}
+
+	workers := blockWorkers(keys.Len(), keyBlock)
+	heaps := make([][]matchHeap, workers)
+	scores := make([][]float32, workers)
+	for w := range heaps {
+		heaps[w] = make([]matchHeap, queries.Len())
+		scores[w] = make([]float32, keyBlock)
+	}
+	parallelBlocks(keys.Len(), keyBlock, func(worker, start, end int) {
+		block := scores[worker][:end-start]
+		for i := 0; i < queries.Len(); i++ {
+			scoreBlock(queries.Row(i), keys, start, end, block)
+			h := &heaps[worker][i]
+			for j, score := range block {
+				h.offer(Match{Index: start + j, Score: score}, k)
+			}
+		}
+	})
+
+	for i := range results {
+		var merged []Match
+		for w := range heaps {
+			merged = append(merged, heaps[w][i]...)
+		}
+		sortMatches(merged)
+		results[i] = merged[:min(k, len(merged))]
+	}
+	return results, nil
+}
+
+// blockWorkers returns the number of goroutines that compare n rows in blocks.
+func blockWorkers(n, block int) int {
+	blocks := (n + block - 1) / block
+	return max(1, min(runtime.GOMAXPROCS(0), blocks))
+}
+
+// parallelBlocks calls fn for each block of rows [start, end) of n, spreading the blocks
+// over the CPUs. Workers take the next block when they finish one, so uneven blocks do not
+// leave CPUs idle. Small inputs run on the calling goroutine.
+func parallelBlocks(n, block int, fn func(worker, start, end int)) {
+	workers := blockWorkers(n, block)
+	if workers == 1 {
+		for start := 0; start < n; start += block {
+			fn(0, start, min(start+block, n))
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				start := int(next.Add(int64(block))) - block
+				if start >= n {
+					return
+				}
+				fn(worker, start, min(start+block, n))
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// matchHeap is a min-heap of at most k matches, keeping the best seen so far at the
+// bottom and the weakest at the root.
+type matchHeap []Match
+
+// weaker reports whether a ranks below b: a lower score, or a later row on a tie.
+func weaker(a, b Match) bool {
+	if a.Score == b.Score {
+		return a.Index > b.Index
+	}
+	return a.Score < b.Score
+}
+
+// offer adds a match if the heap has fewer than k or the match beats the weakest.
+func (h *matchHeap) offer(m Match, k int) {
+	if len(*h) < k {
+		*h = append(*h, m)
+		h.up(len(*h) - 1)
+		return
+	}
+	if !weaker((*h)[0], m) {
+		return
+	}
+	(*h)[0] = m
+	h.down(0)
+}
+
+func (h matchHeap) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !weaker(h[i], h[parent]) {
+			return
+		}
+		h[i], h[parent] = h[parent], h[i]
+		i = parent
+	}
+}
+
+func (h matchHeap) down(i int) {
+	for {
+		least := i
+		if l := 2*i + 1; l < len(h) && weaker(h[l], h[least]) {
+			least = l
+		}
+		if r := 2*i + 2; r < len(h) && weaker(h[r], h[least]) {
+			least = r
+		}
+		if least == i {
+			return
+		}
+		h[i], h[least] = h[least], h[i]
+		i = least
+	}
+}
+
+// sortMatches orders matches from most to least similar, ties in row order.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool { return weaker(matches[j], matches[i]) })
+}
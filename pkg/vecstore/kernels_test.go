@@ -0,0 +1,200 @@
+package vecstore
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDot(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	for _, n := range []int{0, 1, 3, 4, 7, 384} {
+		a, b := make([]float32, n), make([]float32, n)
+		var expected float64
+		for i := range a {
+			a[i], b[i] = float32(rng.NormFloat64()), float32(rng.NormFloat64())
+			expected += float64(a[i]) * float64(b[i])
+		}
+		assert.InDelta(t, expected, Dot(a, b), 1e-4, "length %d", n)
+	}
+}
+
+func TestSimilaritiesAndTopK(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	// More keys than one block, so several workers compare them.
+	vectors := randomVectors(rng, 3020, 64)
+	vectors, queryVectors := vectors[:3000], vectors[3000:]
+
+	keys, err := NormalizedMatrix(vectors)
+	require.NoError(t, err)
+	queries, err := NormalizedMatrix(queryVectors)
+	require.NoError(t, err)
+	assert.Equal(t, 3000, keys.Len())
+	assert.Equal(t, 64, keys.Dims())
+
+	scores, err := Similarities(queries, keys)
+	require.NoError(t, err)
+	require.Len(t, scores, 20*3000)
+	for _, j := range []int{0, 255, 256, 2999} {
+		assert.InDelta(t, cosine(queryVectors[3], vectors[j]), scores[3*3000+j], 1e-5)
+	}
+
+	const k = 10
+	matches, err := TopK(queries, keys, k)
+	require.NoError(t, err)
+	require.Len(t, matches, len(queryVectors))
+	for i, q := range queryVectors {
+		require.Len(t, matches[i], k)
+		ids := make([]string, k)
+		for r, m := range matches[i] {
+			ids[r] = fmt.Sprint(m.Index)
+			assert.InDelta(t, scores[i*3000+m.Index], m.Score, 1e-6)
+		}
+		assert.Equal(t, exactNeighbors(vectors, q, k), ids)
+	}
+
+	// Asking for more than there are returns every key in order.
+	few, err := NormalizedMatrix(vectors[:3])
+	require.NoError(t, err)
+	matches, err = TopK(queries, few, 5)
+	require.NoError(t, err)
+	require.Len(t, matches[0], 3)
+	assert.True(t, sort.SliceIsSorted(matches[0], func(a, b int) bool { return matches[0][a].Score > matches[0][b].Score }))
+
+	other, err := NormalizedMatrix([][]float32{{1, 0}})
+	require.NoError(t, err)
+	_, err = TopK(queries, other, 1)
+	assert.Error(t, err)
+	assert.Error(t, other.Append([]float32{1, 0, 0}))
+}
+
+func TestLegacySimilarityHelpers(t *testing.T) {
+	embeddings := map[string]Embedding{
+		"east":  {Word: "east", Vector: []float64{1, 0}},
+		"north": {Word: "north", Vector: []float64{0, 2}},
+		"ne":    {Word: "ne", Vector: []float64{1, 1}},
+	}
+	target := Embedding{Word: "target", Vector: []float64{1, 0.1}}
+
+	top := FindTopNSimilarEmbeddings(target, embeddings, 2)
+	require.Len(t, top, 2)
+	assert.Equal(t, "east", top[0].Word)
+	assert.Equal(t, "ne", top[1].Word)
+	assert.InDelta(t, CosineSimilarity(target.Vector, embeddings["ne"].Vector), top[1].Similarity, 1e-6)
+
+	matrix := ComputeSimilarityMatrix([]Embedding{target}, []Embedding{embeddings["east"], embeddings["north"]})
+	require.Len(t, matrix, 1)
+	assert.InDelta(t, CosineSimilarity(target.Vector, []float64{1, 0}), matrix[0][0], 1e-6)
+	assert.InDelta(t, CosineSimilarity(target.Vector, []float64{0, 2}), matrix[0][1], 1e-6)
+}
+
+// The benchmarks compare the kernels with the pairwise float64 comparison they replace on
+// 100k vectors of 384 dimensions, the size of the default local embedding model.
+const benchVectors, benchDims = 100_000, 384
+
+func benchmarkVectors(b *testing.B) ([][]float32, [][]float32) {
+	rng := rand.New(rand.NewSource(8))
+	vectors := randomVectors(rng, benchVectors+16, benchDims)
+	return vectors[:benchVectors], vectors[benchVectors:]
+}
+
+func BenchmarkTopK(b *testing.B) {
+	vectors, queryVectors := benchmarkVectors(b)
+	keys, err := NormalizedMatrix(vectors)
+	require.NoError(b, err)
+
+	wide := make([][]float64, len(vectors))
+	for i, v := range vectors {
+		wide[i] = make([]float64, len(v))
+		for j, x := range v {
+			wide[i][j] = float64(x)
+		}
+	}
+
+	b.Run("pairwise", func(b *testing.B) {
+		q := make([]float64, benchDims)
+		for j, x := range queryVectors[0] {
+			q[j] = float64(x)
+		}
+		for i := 0; i < b.N; i++ {
+			scores := make([]SimilarityWithKey, len(wide))
+			for j, v := range wide {
+				scores[j] = SimilarityWithKey{Similarity: CosineSimilarity(q, v)}
+			}
+			sort.Slice(scores, func(a, c int) bool { return scores[a].Similarity > scores[c].Similarity })
+		}
+	})
+	for _, n := range []int{1, 16} {
+		queries, err := NormalizedMatrix(queryVectors[:n])
+		require.NoError(b, err)
+		b.Run(fmt.Sprintf("kernel/%d queries", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TopK(queries, keys, 10)
+			}
+		})
+	}
+}
+
+func BenchmarkSimilarities(b *testing.B) {
+	vectors, queryVectors := benchmarkVectors(b)
+	keys, err := NormalizedMatrix(vectors)
+	require.NoError(b, err)
+	queries, err := NormalizedMatrix(queryVectors)
+	require.NoError(b, err)
+
+	queryEmbeddings := make([]Embedding, len(queryVectors))
+	for i, v := range queryVectors {
+		queryEmbeddings[i].Vector = make([]float64, len(v))
+		for j, x := range v {
+			queryEmbeddings[i].Vector[j] = float64(x)
+		}
+	}
+	keyEmbeddings := make([]Embedding, len(vectors))
+	for i, v := range vectors {
+		keyEmbeddings[i].Vector = make([]float64, len(v))
+		for j, x := range v {
+			keyEmbeddings[i].Vector[j] = float64(x)
+		}
+	}
+
+	b.Run("pairwise", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, q := range queryEmbeddings {
+				for _, k := range keyEmbeddings {
+					CosineSimilarity(q.Vector, k.Vector)
+				}
+			}
+		}
+	})
+	b.Run("kernel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Similarities(queries, keys)
+		}
+	})
+}
+
+func BenchmarkStoreSearchExact(b *testing.B) {
+	vectors, queries := benchmarkVectors(b)
+	for _, q := range []Quantization{QuantizeNone, QuantizeInt8, QuantizeBinary} {
+		b.Run(fmt.Sprintf("quantization=%s", q), func(b *testing.B) {
+			store, err := OpenWithOptions(b.TempDir(), Options{Quantization: q})
+			require.NoError(b, err)
+			defer store.Close()
+
+			records := make([]Record, len(vectors))
+			for i, v := range vectors {
+				records[i] = Record{ID: fmt.Sprint(i), Vector: v}
+			}
+			require.NoError(b, store.Put(records...))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store.SearchExact(queries[i%len(queries)], 10)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package vecstore
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MigrateJSON copies the embeddings from a legacy JSON embeddings file into the store and
+// renames the file with a .migrated suffix so the migration runs only once. It returns the
+// number of migrated embeddings. A missing file is not an error.
+func MigrateJSON(store *Store, path string) (int, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	embeddings, err := NewEmbeddingDB().LoadEmbeddings(path)
+	if err != nil {
+		return 0, fmt.Errorf("error loading %s: %v", path, err)
+	}
+
+	keys := make([]string, 0, len(embeddings))
+	for key := range embeddings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		embedding := embeddings[key]
+		if len(embedding.Vector) == 0 {
+			continue
+		}
+		records = append(records, Record{ID: ChunkID("", embedding.Word), Text: embedding.Word, Vector: Float32(embedding.Vector)})
+	}
+
+	if err := store.Put(records...); err != nil {
+		return 0, fmt.Errorf("error migrating %s: %v", path, err)
+	}
+
+	if err := os.Rename(path, path+".migrated"); err != nil {
+		return len(records), fmt.Errorf("error renaming %s after migration: %v", path, err)
+	}
+
+	return len(records), nil
+}
+
+// Float32 converts a float64 vector to the float32 vectors kept in the store.
+func Float32(vec []float64) []float32 {
+	out := make([]float32, len(vec))
+	for i, v := range vec {
+		out[i] = float32(v)
+	}
+	return out
+}
+
+// Float64 converts a stored float32 vector back to float64.
+func Float64(vec []float32) []float64 {
+	out := make([]float64, len(vec))
+	for i, v := range vec {
+		out[i] = float64(v)
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package vecstore
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Quantization selects how the vectors held in memory for search are compressed. The
+// segments keep every vector at full precision, and the best candidates found with the
+// compressed vectors are rescored with them.
+type Quantization string
+
+const (
+	QuantizeNone   Quantization = ""       // float32, 4 bytes per dimension
+	QuantizeInt8   Quantization = "int8"   // One byte per dimension and a scale per vector, about 4x smaller
+	QuantizeBinary Quantization = "binary" // The sign of each dimension, 32x smaller
+)
+
+// Default number of candidates rescored at full precision per result. Binary codes rank
+// more coarsely, so they need more candidates for the same recall.
+const (
+	defaultInt8Rescore   = 4
+	defaultBinaryRescore = 10
+)
+
+// Validate checks that the quantization is known.
+func (q Quantization) Validate() error {
+	switch q {
+	case QuantizeNone, QuantizeInt8, QuantizeBinary:
+		return nil
+	}
+	return fmt.Errorf("unknown quantization %q, expected int8 or binary", q)
+}
+
+// rescore returns the number of candidates compared at full precision per result, using
+// the default of the quantization when n is zero.
+func (q Quantization) rescore(n int) int {
+	switch {
+	case q == QuantizeNone:
+		return 1
+	case n > 0:
+		return n
+	case q == QuantizeBinary:
+		return defaultBinaryRescore
+	default:
+		return defaultInt8Rescore
+	}
+}
+
+// code returns the identifier of the quantization in index files.
+func (q Quantization) code() uint32 {
+	switch q {
+	case QuantizeInt8:
+		return 1
+	case QuantizeBinary:
+		return 2
+	}
+	return 0
+}
+
+// quantizationFromCode is the inverse of code.
+func quantizationFromCode(code uint32) (Quantization, error) {
+	switch code {
+	case 0:
+		return QuantizeNone, nil
+	case 1:
+		return QuantizeInt8, nil
+	case 2:
+		return QuantizeBinary, nil
+	}
+	return "", fmt.Errorf("unknown quantization %d", code)
+}
+
+// vectorCode is a unit length vector in the form of a quantization. Only the fields of the
+// quantization are set.
+type vectorCode struct {
+	floats []float32
+	int8s  []int8
+	scale  float32  // Value of one int8 step
+	bits   []uint64 // Sign bits, set for positive values
+}
+
+// probe is a unit length query prepared for comparison with vector codes.
+type probe struct {
+	vector []float32 // Unset for binary codes turned back into a probe
+	bits   []uint64  // Sign bits for binary codes
+	dims   int
+}
+
+// encodeVector compresses a unit length vector. The vector is kept as it is without
+// quantization.
+func encodeVector(q Quantization, unit []float32) vectorCode {
+	switch q {
+	case QuantizeInt8:
+		var maxAbs float32
+		for _, v := range unit {
+			maxAbs = max(maxAbs, float32(math.Abs(float64(v))))
+		}
+		code := vectorCode{int8s: make([]int8, len(unit))}
+		if maxAbs == 0 {
+			return code
+		}
+		code.scale = maxAbs / 127
+		for i, v := range unit {
+			code.int8s[i] = int8(math.Round(float64(v / code.scale)))
+		}
+		return code
+	case QuantizeBinary:
+		return vectorCode{bits: signBits(unit)}
+	default:
+		return vectorCode{floats: unit}
+	}
+}
+
+// signBits packs the signs of a vector into words.
+func signBits(vector []float32) []uint64 {
+	words := make([]uint64, (len(vector)+63)/64)
+	for i, v := range vector {
+		if v > 0 {
+			words[i/64] |= 1 << (i % 64)
+		}
+	}
+	return words
+}
+
+// newProbe prepares a unit length query for the quantization.
+func newProbe(q Quantization, unit []float32) probe {
+	p := probe{vector: unit, dims: len(unit)}
+	if q == QuantizeBinary {
+		p.bits = signBits(unit)
+	}
+	return p
+}
+
+// similarity estimates the cosine similarity of the code and the probe. Int8 codes are
+// compared with the query at full precision; binary codes by the share of matching signs,
+// which ranges from -1 to 1 like a cosine.
+func (c vectorCode) similarity(p probe) float32 {
+	switch {
+	case c.int8s != nil:
+		var dot float32
+		for i, v := range c.int8s {
+			dot += float32(v) * p.vector[i]
+		}
+		return dot * c.scale
+	case c.bits != nil:
+		var differ int
+		for i, w := range c.bits {
+			differ += bits.OnesCount64(w ^ p.bits[i])
+		}
+		return 1 - 2*float32(differ)/float32(p.dims)
+	default:
+		return Dot(c.floats, p.vector)
+	}
+}
+
+// probe turns the code back into a query, to compare stored vectors with each other.
+func (c vectorCode) probe(dims int) probe {
+	switch {
+	case c.int8s != nil:
+		vector := make([]float32, len(c.int8s))
+		for i, v := range c.int8s {
+			vector[i] = float32(v) * c.scale
+		}
+		return probe{vector: vector, dims: dims}
+	case c.bits != nil:
+		return probe{bits: c.bits, dims: dims}
+	default:
+		return probe{vector: c.floats, dims: dims}
+	}
+}
+
+// size returns the bytes the code takes in memory, without slice headers.
+func (c vectorCode) size() int64 {
+	switch {
+	case c.int8s != nil:
+		return int64(len(c.int8s)) + 4
+	case c.bits != nil:
+		return int64(len(c.bits)) * 8
+	default:
+		return int64(len(c.floats)) * 4
+	}
+}
@@ -0,0 +1,129 @@
+package vecstore
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantizedSearchRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vectors := randomVectors(rng, 2050, 256)
+	vectors, queries := vectors[:2000], vectors[2000:]
+	const k = 10
+
+	records := make([]Record, len(vectors))
+	for i, v := range vectors {
+		records[i] = Record{ID: fmt.Sprint(i), Vector: v}
+	}
+
+	tests := []struct {
+		name         string
+		opts         Options
+		minRecall    float64
+		minReduction float64
+	}{
+		{"exact", Options{}, 1, 1},
+		{"int8", Options{Quantization: QuantizeInt8}, 0.98, 3.9},
+		{"binary", Options{Quantization: QuantizeBinary}, 0.9, 32},
+		{"hnsw", Options{HNSW: &HNSWConfig{M: 12, EfConstruction: 100}}, 0.95, 1},
+		{"hnsw int8", Options{HNSW: &HNSWConfig{M: 12, EfConstruction: 100}, Quantization: QuantizeInt8}, 0.95, 3.9},
+		{"hnsw binary", Options{HNSW: &HNSWConfig{M: 12, EfConstruction: 100}, Quantization: QuantizeBinary}, 0.85, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := OpenWithOptions(t.TempDir(), tt.opts)
+			require.NoError(t, err)
+			defer store.Close()
+			require.NoError(t, store.Put(records...))
+
+			var hits int
+			for _, q := range queries {
+				results, err := store.Search(q, k)
+				require.NoError(t, err)
+				require.Len(t, results, k)
+
+				// Rescoring reports the full precision similarity.
+				assert.InDelta(t, cosine(results[0].Vector, q), results[0].Score, 1e-5)
+
+				found := make(map[string]bool)
+				for _, r := range results {
+					found[r.ID] = true
+				}
+				for _, id := range exactNeighbors(vectors, q, k) {
+					if found[id] {
+						hits++
+					}
+				}
+			}
+
+			recall := float64(hits) / float64(len(queries)*k)
+			stats := store.Stats()
+			reduction := float64(len(vectors)*256*4) / float64(stats.VectorBytes)
+			t.Logf("recall@%d: %.3f, %d vector bytes in memory, %.1fx smaller", k, recall, stats.VectorBytes, reduction)
+			assert.GreaterOrEqual(t, recall, tt.minRecall)
+			assert.GreaterOrEqual(t, reduction, tt.minReduction)
+		})
+	}
+}
+
+func TestQuantizedHNSWWriteRead(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	vectors := randomVectors(rng, 205, 70)
+	vectors, queries := vectors[:200], vectors[200:]
+
+	for _, q := range []Quantization{QuantizeInt8, QuantizeBinary} {
+		t.Run(string(q), func(t *testing.T) {
+			h := NewQuantizedHNSW(70, HNSWConfig{M: 8, EfConstruction: 64}, q)
+			for i, v := range vectors {
+				require.NoError(t, h.Insert(fmt.Sprint(i), v))
+			}
+
+			var buf bytes.Buffer
+			_, err := h.WriteTo(&buf)
+			require.NoError(t, err)
+			loaded, err := ReadHNSW(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, q, loaded.Quantization())
+			assert.Equal(t, h.VectorBytes(), loaded.VectorBytes())
+
+			for _, query := range queries {
+				expected, err := h.Search(query, 5)
+				require.NoError(t, err)
+				actual, err := loaded.Search(query, 5)
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestStoreQuantizationChange(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenWithOptions(dir, Options{HNSW: &HNSWConfig{M: 8}})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(
+		Record{ID: "a", Vector: []float32{1, 0, 0}},
+		Record{ID: "b", Vector: []float32{0, 1, 0}},
+	))
+	require.NoError(t, store.Close())
+
+	// An index saved without quantization is rebuilt with it.
+	store, err = OpenWithOptions(dir, Options{HNSW: &HNSWConfig{M: 8}, Quantization: QuantizeInt8})
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, QuantizeInt8, store.hnsw.Quantization())
+
+	results, err := store.Search([]float32{0.1, 1, 0}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "b", results[0].ID)
+
+	_, err = OpenWithOptions(t.TempDir(), Options{Quantization: "pq"})
+	assert.Error(t, err)
+	_, err = OpenWithOptions(t.TempDir(), Options{Quantization: QuantizeInt8, Rescore: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package vecstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+
+	"github.com/blevesearch/mmap-go"
+)
+
+// Segment files start with a fixed header followed by append-only records:
+//
+//	header: magic "EVEC" | uint16 format version | uint16 reserved | uint32 dimensions | uint32 reserved
+//	record: uint8 op | uint32 id length | uint32 text length | uint32 metadata length |
+//	        id | text | metadata (JSON) | vector (dimensions x float32, puts only) | uint32 CRC-32
+//
+// All integers and floats are little endian. A delete record is a tombstone for its ID.
+const (
+	segmentMagic      = "EVEC"
+	formatVersion     = 1
+	headerSize        = 16
+	recordHeaderSize  = 13
+	recordTrailerSize = 4
+
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// segment is a single append-only segment file and its read-only memory map.
+type segment struct {
+	path string
+	file *os.File
+	data mmap.MMap
+	size int64
+}
+
+// createSegment creates a new empty segment file for vectors of the given dimensions.
+func createSegment(path string, dims int) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating segment: %v", err)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, segmentMagic)
+	binary.LittleEndian.PutUint16(header[4:], formatVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(dims))
+
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing segment header: %v", err)
+	}
+
+	seg := &segment{path: path, file: f, size: headerSize}
+	if err := seg.remap(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return seg, nil
+}
+
+// openSegment opens an existing segment file and returns it with its vector dimensions.
+func openSegment(path string) (*segment, int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening segment: %v", err)
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("error reading segment header of %s: %v", path, err)
+	}
+	if string(header[:4]) != segmentMagic {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s is not a vector segment", path)
+	}
+	if version := binary.LittleEndian.Uint16(header[4:]); version != formatVersion {
+		f.Close()
+		return nil, 0, fmt.Errorf("unsupported segment format version %d in %s", version, path)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("error reading segment size: %v", err)
+	}
+
+	seg := &segment{path: path, file: f, size: info.Size()}
+	if err := seg.remap(); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return seg, int(binary.LittleEndian.Uint32(header[8:])), nil
+}
+
+// remap replaces the memory map so that it covers the whole file.
+func (s *segment) remap() error {
+	if s.data != nil {
+		if err := s.data.Unmap(); err != nil {
+			return fmt.Errorf("error unmapping segment: %v", err)
+		}
+		s.data = nil
+	}
+
+	data, err := mmap.MapRegion(s.file, int(s.size), mmap.RDONLY, 0, 0)
+	if err != nil {
+		return fmt.Errorf("error mapping segment: %v", err)
+	}
+	s.data = data
+	return nil
+}
+
+// append writes encoded records to the end of the segment. The memory map is not updated
+// until remap is called.
+func (s *segment) append(records []byte) error {
+	if _, err := s.file.WriteAt(records, s.size); err != nil {
+		return fmt.Errorf("error writing segment: %v", err)
+	}
+	s.size += int64(len(records))
+	return nil
+}
+
+// truncate drops a partially written record from the end of the segment.
+func (s *segment) truncate(size int64) error {
+	if err := s.file.Truncate(size); err != nil {
+		return fmt.Errorf("error truncating segment: %v", err)
+	}
+	s.size = size
+	return s.remap()
+}
+
+// close unmaps and closes the segment file.
+func (s *segment) close() error {
+	if s.data != nil {
+		if err := s.data.Unmap(); err != nil {
+			return err
+		}
+		s.data = nil
+	}
+	return s.file.Close()
+}
+
+// recordInfo describes a record found while scanning a segment.
+type recordInfo struct {
+	op       byte
+	id       string
+	offset   int64 // Start of the record
+	vector   int64 // Start of the vector, puts only
+	metadata map[string]any
+}
+
+// scan calls fn for every record in the segment and returns the offset after the last
+// valid record. Scanning stops at the first truncated or corrupt record.
+func (s *segment) scan(dims int, fn func(recordInfo)) int64 {
+	offset := int64(headerSize)
+	for offset < s.size {
+		info, size, err := s.readRecordInfo(offset, dims)
+		if err != nil {
+			break
+		}
+		fn(info)
+		offset += size
+	}
+	return offset
+}
+
+// readRecordInfo validates the record at offset and returns its info and encoded size.
+func (s *segment) readRecordInfo(offset int64, dims int) (recordInfo, int64, error) {
+	data := s.data[offset:]
+	if len(data) < recordHeaderSize {
+		return recordInfo{}, 0, errCorruptRecord
+	}
+
+	op := data[0]
+	idLen := int64(binary.LittleEndian.Uint32(data[1:]))
+	textLen := int64(binary.LittleEndian.Uint32(data[5:]))
+	metaLen := int64(binary.LittleEndian.Uint32(data[9:]))
+
+	var vectorLen int64
+	switch op {
+	case opPut:
+		vectorLen = int64(dims) * 4
+	case opDelete:
+	default:
+		return recordInfo{}, 0, errCorruptRecord
+	}
+
+	body := recordHeaderSize + idLen + textLen + metaLen + vectorLen
+	size := body + recordTrailerSize
+	if int64(len(data)) < size {
+		return recordInfo{}, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(data[:body]) != binary.LittleEndian.Uint32(data[body:]) {
+		return recordInfo{}, 0, errCorruptRecord
+	}
+
+	info := recordInfo{
+		op:     op,
+		id:     string(data[recordHeaderSize : recordHeaderSize+idLen]),
+		offset: offset,
+	}
+	if op == opPut {
+		metaStart := offset + recordHeaderSize + idLen + textLen
+		if metaLen > 0 {
+			if err := json.Unmarshal(s.data[metaStart:metaStart+metaLen], &info.metadata); err != nil {
+				return recordInfo{}, 0, errCorruptRecord
+			}
+		}
+		info.vector = metaStart + metaLen
+	}
+	return info, size, nil
+}
+
+// readRecord decodes the put record at offset.
+func (s *segment) readRecord(offset int64, dims int) (Record, error) {
+	data := s.data[offset:]
+	idLen := int64(binary.LittleEndian.Uint32(data[1:]))
+	textLen := int64(binary.LittleEndian.Uint32(data[5:]))
+	metaLen := int64(binary.LittleEndian.Uint32(data[9:]))
+
+	pos := int64(recordHeaderSize)
+	record := Record{ID: string(data[pos : pos+idLen])}
+	pos += idLen
+	record.Text = string(data[pos : pos+textLen])
+	pos += textLen
+
+	if metaLen > 0 {
+		if err := json.Unmarshal(data[pos:pos+metaLen], &record.Metadata); err != nil {
+			return Record{}, fmt.Errorf("error decoding metadata of %s: %v", record.ID, err)
+		}
+	}
+	pos += metaLen
+
+	record.Vector = decodeVector(data[pos:], dims)
+	return record, nil
+}
+
+// decodeVector decodes the vector at the start of data.
+func decodeVector(data []byte, dims int) []float32 {
+	vector := make([]float32, dims)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector
+}
+
+// encodeRecord appends the encoding of a put or delete record to buf.
+func encodeRecord(buf []byte, op byte, record Record) ([]byte, error) {
+	var meta []byte
+	if op == opPut && len(record.Metadata) > 0 {
+		var err error
+		if meta, err = json.Marshal(record.Metadata); err != nil {
+			return nil, fmt.Errorf("error encoding metadata of %s: %v", record.ID, err)
+		}
+	}
+
+	text := record.Text
+	if op == opDelete {
+		text = ""
+	}
+
+	start := len(buf)
+	buf = append(buf, op)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(record.ID)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(text)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(meta)))
+	buf = append(buf, record.ID...)
+	buf = append(buf, text...)
+	buf = append(buf, meta...)
+	if op == opPut {
+		for _, v := range record.Vector {
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+		}
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:])), nil
+}
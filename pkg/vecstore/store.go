@@ -0,0 +1,760 @@
+package vecstore
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxSegmentSize is the size at which the store starts a new segment file.
+const DefaultMaxSegmentSize = 64 << 20
+
+// ErrClosed is returned for writes to a store after it is closed.
+var ErrClosed = errors.New("vector store is closed")
+
+// exactFilterLimit is the number of matching records below which filtered searches skip the
+// HNSW index, since the graph would have to be walked far to find enough matches.
+const exactFilterLimit = 2048
+
+// Record is a vector stored with the text it was generated from. Metadata values are
+// stored as JSON, so numbers come back as float64. Store timestamps as Unix seconds to
+// filter them by range.
+type Record struct {
+	ID       string
+	Text     string
+	Vector   []float32
+	Metadata map[string]any
+}
+
+// SearchResult is a record and its cosine similarity to the query.
+type SearchResult struct {
+	Record
+	Score float64
+}
+
+// Stats describes the contents of the store on disk.
+type Stats struct {
+	Dimensions int   `json:"dimensions"`
+	Segments   int   `json:"segments"`
+	Live       int   `json:"live"`
+	Dead       int   `json:"dead"` // Overwritten and deleted records waiting for compaction
+	Bytes      int64 `json:"bytes"`
+	// Vectors searched in memory: the HNSW graph, the quantized vectors of an exhaustive
+	// search, or the vectors paged in from the segments otherwise
+	VectorBytes int64 `json:"vector_bytes"`
+}
+
+// Options configures how a store is opened.
+type Options struct {
+	HNSW *HNSWConfig // Approximate search with an HNSW index. Nil searches exhaustively.
+
+	// Quantization compresses the vectors searched in memory. The segments keep them at full
+	// precision, and the best Rescore candidates per result are rescored with them.
+	Quantization Quantization
+	Rescore      int // Candidates rescored per result; 4 for int8 and 10 for binary by default
+}
+
+// Validate checks the quantization settings.
+func (o Options) Validate() error {
+	if err := o.Quantization.Validate(); err != nil {
+		return err
+	}
+	if o.Rescore < 0 {
+		return fmt.Errorf("rescore cannot be negative")
+	}
+	return nil
+}
+
+// hnswFile is the name of the persisted HNSW index inside the store directory.
+const hnswFile = "hnsw.idx"
+
+// location points to the latest put record for an ID and keeps its metadata for filtering.
+type location struct {
+	segment  *segment
+	offset   int64
+	vector   int64
+	metadata map[string]any
+	norm     float32     // Length of the vector, so searches only compute dot products
+	code     *vectorCode // Quantized vector for exhaustive search
+}
+
+// Store is a segment-based vector store. Writes are appended to the newest segment, reads
+// go through memory-mapped segment files and an in-memory index of ID to record offset that
+// is rebuilt when the store is opened.
+type Store struct {
+	writes         sync.Mutex // Held by writes for their whole duration, so they can be paused
+	mu             sync.RWMutex
+	closed         bool
+	dir            string
+	dims           int
+	segments       []*segment
+	nextSegment    int
+	index          map[string]location
+	dead           int
+	hnsw           *HNSW
+	hnswConfig     *HNSWConfig
+	quantization   Quantization
+	rescore        int
+	MaxSegmentSize int64
+}
+
+// Open opens the vector store in dir with exhaustive search, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	return OpenWithOptions(dir, Options{})
+}
+
+// OpenWithOptions opens the vector store in dir, creating the directory if needed.
+func OpenWithOptions(dir string, opts Options) (*Store, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating vector store directory: %v", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "segment-*.vec"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	s := &Store{
+		dir:            dir,
+		index:          make(map[string]location),
+		nextSegment:    1,
+		hnswConfig:     opts.HNSW,
+		quantization:   opts.Quantization,
+		rescore:        opts.Quantization.rescore(opts.Rescore),
+		MaxSegmentSize: DefaultMaxSegmentSize,
+	}
+
+	for i, path := range paths {
+		seg, dims, err := openSegment(path)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+
+		if s.dims == 0 {
+			s.dims = dims
+		} else if dims != s.dims {
+			s.Close()
+			return nil, fmt.Errorf("segment %s has %d dimensions, expected %d", path, dims, s.dims)
+		}
+
+		end := seg.scan(dims, func(info recordInfo) { s.applyTo(seg, info) })
+		if end < seg.size {
+			// Only the newest segment can end in a partial write.
+			if i != len(paths)-1 {
+				s.Close()
+				return nil, fmt.Errorf("segment %s is corrupt at offset %d", path, end)
+			}
+			if err := seg.truncate(end); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(path), "segment-%06d.vec", &n); err == nil && n >= s.nextSegment {
+			s.nextSegment = n + 1
+		}
+	}
+
+	if s.hnswConfig != nil {
+		if err := s.loadIndex(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// applyTo updates the index with a record stored in seg.
+func (s *Store) applyTo(seg *segment, info recordInfo) {
+	if _, exists := s.index[info.id]; exists {
+		s.dead++
+	}
+
+	switch info.op {
+	case opPut:
+		loc := location{segment: seg, offset: info.offset, vector: info.vector, metadata: info.metadata, norm: normAt(seg.data[info.vector:], s.dims)}
+		if s.quantizedScan() {
+			code := encodeVector(s.quantization, Normalize(decodeVector(seg.data[info.vector:], s.dims)))
+			loc.code = &code
+		}
+		s.index[info.id] = loc
+	case opDelete:
+		delete(s.index, info.id)
+		s.dead++
+	}
+}
+
+// quantizedScan reports whether exhaustive searches compare quantized vectors kept in
+// memory. With an HNSW index, the graph holds the quantized vectors instead.
+func (s *Store) quantizedScan() bool {
+	return s.quantization != QuantizeNone && s.hnswConfig == nil
+}
+
+// Dimensions returns the vector dimensions of the store, or zero if it is empty.
+func (s *Store) Dimensions() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dims
+}
+
+// Len returns the number of live records.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// Put appends records to the store, replacing records with the same ID. The first record
+// written to an empty store sets its dimensions.
+func (s *Store) Put(records ...Record) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	dims := s.dims
+	if dims == 0 {
+		dims = len(records[0].Vector)
+	}
+	for _, record := range records {
+		if record.ID == "" {
+			return fmt.Errorf("record ID is required")
+		}
+		if len(record.Vector) != dims || dims == 0 {
+			return fmt.Errorf("record %s has %d dimensions, expected %d", record.ID, len(record.Vector), dims)
+		}
+	}
+
+	s.dims = dims
+	return s.write(opPut, records)
+}
+
+// Delete writes tombstones for the given IDs. IDs that are not in the store are ignored.
+func (s *Store) Delete(ids ...string) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+	for _, id := range ids {
+		if _, exists := s.index[id]; exists {
+			records = append(records, Record{ID: id})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	return s.write(opDelete, records)
+}
+
+// DeleteMatching deletes every record that matches all conditions and returns how many
+// were deleted. At least one condition is required.
+func (s *Store) DeleteMatching(conditions ...Condition) (int, error) {
+	if len(conditions) == 0 {
+		return 0, fmt.Errorf("delete needs at least one condition")
+	}
+	for _, c := range conditions {
+		if err := c.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+	for _, id := range sortedKeys(s.index) {
+		if matchAll(s.index[id].metadata, conditions) {
+			records = append(records, Record{ID: id})
+		}
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	return len(records), s.write(opDelete, records)
+}
+
+// write appends records to the active segment and indexes them. The caller holds the lock.
+func (s *Store) write(op byte, records []Record) error {
+	if s.closed {
+		return ErrClosed
+	}
+	seg, err := s.activeSegment()
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	infos := make([]recordInfo, len(records))
+	for i, record := range records {
+		infos[i] = recordInfo{op: op, id: record.ID, offset: seg.size + int64(len(buf)), metadata: normalizeMetadata(record.Metadata)}
+		if buf, err = encodeRecord(buf, op, record); err != nil {
+			return err
+		}
+		if op == opPut {
+			// The vector sits right before the checksum at the end of the record.
+			infos[i].vector = seg.size + int64(len(buf)) - recordTrailerSize - int64(s.dims)*4
+		}
+	}
+
+	if err := seg.append(buf); err != nil {
+		return err
+	}
+	if err := seg.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing segment: %v", err)
+	}
+	if err := seg.remap(); err != nil {
+		return err
+	}
+
+	for i, info := range infos {
+		s.applyTo(seg, info)
+		s.updateIndex(info.op, records[i])
+	}
+	return nil
+}
+
+// activeSegment returns the segment that receives writes, starting a new one when the
+// current segment is full. The caller holds the lock.
+func (s *Store) activeSegment() (*segment, error) {
+	if n := len(s.segments); n > 0 && s.segments[n-1].size < s.MaxSegmentSize {
+		return s.segments[n-1], nil
+	}
+
+	seg, err := createSegment(filepath.Join(s.dir, fmt.Sprintf("segment-%06d.vec", s.nextSegment)), s.dims)
+	if err != nil {
+		return nil, err
+	}
+	s.nextSegment++
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+// Get returns the record with the given ID.
+func (s *Store) Get(id string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	loc, ok := s.index[id]
+	if !ok {
+		return Record{}, false, nil
+	}
+	record, err := loc.segment.readRecord(loc.offset, s.dims)
+	return record, err == nil, err
+}
+
+// IDs returns the IDs of all live records in sorted order.
+func (s *Store) IDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedKeys(s.index)
+}
+
+// Metadata returns the metadata of the live records that match all conditions, keyed by
+// ID. Without conditions every record is returned. The maps are copies the caller may keep.
+func (s *Store) Metadata(conditions ...Condition) (map[string]map[string]any, error) {
+	for _, c := range conditions {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make(map[string]map[string]any)
+	for id, loc := range s.index {
+		if !matchAll(loc.metadata, conditions) {
+			continue
+		}
+		metadata := make(map[string]any, len(loc.metadata))
+		for k, v := range loc.metadata {
+			metadata[k] = v
+		}
+		matches[id] = metadata
+	}
+	return matches, nil
+}
+
+// Search returns the topN records most similar to the query vector by cosine similarity
+// that match all filter conditions. Stores opened with an HNSW index return approximate
+// results, except for filters that match few records, which are compared exhaustively.
+func (s *Store) Search(query []float32, topN int, filter ...Condition) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range filter {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.hnsw == nil {
+		return s.searchExact(query, topN, filter)
+	}
+
+	var accept func(id string) bool
+	if len(filter) > 0 {
+		matches := 0
+		for _, loc := range s.index {
+			if matchAll(loc.metadata, filter) {
+				matches++
+			}
+		}
+		if matches <= exactFilterLimit {
+			return s.searchExact(query, topN, filter)
+		}
+		accept = func(id string) bool { return matchAll(s.index[id].metadata, filter) }
+	}
+
+	neighbors, err := s.hnsw.SearchFunc(query, topN*s.rescore, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]scoredID, 0, len(neighbors))
+	for _, nb := range neighbors {
+		if _, ok := s.index[nb.ID]; ok {
+			candidates = append(candidates, scoredID{id: nb.ID, score: nb.Score})
+		}
+	}
+	if s.quantization != QuantizeNone {
+		return s.rescoreCandidates(query, candidates, topN)
+	}
+	return s.readResults(candidates)
+}
+
+// SearchExact compares the query with every record matching the filter and returns the
+// topN most similar.
+func (s *Store) SearchExact(query []float32, topN int, filter ...Condition) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range filter {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return s.searchExact(query, topN, filter)
+}
+
+// searchExact is the exhaustive search. The caller holds the lock.
+func (s *Store) searchExact(query []float32, topN int, filter []Condition) ([]SearchResult, error) {
+	if topN <= 0 || len(s.index) == 0 {
+		return nil, nil
+	}
+	if len(query) != s.dims {
+		return nil, fmt.Errorf("query has %d dimensions, expected %d", len(query), s.dims)
+	}
+
+	unit := normalized(query)
+
+	// Quantized vectors only pick the candidates; the segments score them.
+	quantized := s.quantizedScan()
+	var q probe
+	keep := topN
+	if quantized {
+		q = newProbe(s.quantization, unit)
+		keep = topN * s.rescore
+	}
+
+	items := make([]scanItem, 0, len(s.index))
+	for id, loc := range s.index {
+		if len(filter) == 0 || matchAll(loc.metadata, filter) {
+			items = append(items, scanItem{id: id, loc: loc})
+		}
+	}
+
+	// Each worker keeps the best candidates of the blocks it compares.
+	heaps := make([]resultHeap, blockWorkers(len(items), scanBlock))
+	parallelBlocks(len(items), scanBlock, func(worker, start, end int) {
+		h := &heaps[worker]
+		for _, item := range items[start:end] {
+			var score float64
+			if quantized {
+				score = float64(item.loc.code.similarity(q))
+			} else {
+				score = item.loc.cosine(unit)
+			}
+			h.offer(scoredID{id: item.id, score: score}, keep)
+		}
+	})
+
+	var candidates []scoredID
+	for _, h := range heaps {
+		candidates = append(candidates, h...)
+	}
+	sortScored(candidates)
+	candidates = candidates[:min(keep, len(candidates))]
+	if quantized {
+		return s.rescoreCandidates(query, candidates, topN)
+	}
+	return s.readResults(candidates)
+}
+
+// scanBlock is the number of records a worker compares before taking the next block in an
+// exhaustive search. Stores smaller than one block are searched on a single goroutine.
+const scanBlock = 4096
+
+// scanItem is a record compared during an exhaustive search.
+type scanItem struct {
+	id  string
+	loc location
+}
+
+// rescoreCandidates scores the candidates with the full precision vectors of the segments
+// and returns the topN. The caller holds the lock.
+func (s *Store) rescoreCandidates(query []float32, candidates []scoredID, topN int) ([]SearchResult, error) {
+	unit := normalized(query)
+	for i, c := range candidates {
+		candidates[i].score = s.index[c.id].cosine(unit)
+	}
+	sortScored(candidates)
+	return s.readResults(candidates[:min(topN, len(candidates))])
+}
+
+// readResults reads the records of scored candidates. The caller holds the lock.
+func (s *Store) readResults(candidates []scoredID) ([]SearchResult, error) {
+	results := make([]SearchResult, len(candidates))
+	for i, c := range candidates {
+		loc := s.index[c.id]
+		record, err := loc.segment.readRecord(loc.offset, s.dims)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = SearchResult{Record: record, Score: c.score}
+	}
+	return results, nil
+}
+
+// cosine computes the cosine similarity between the stored vector and a unit length query.
+func (loc location) cosine(unit []float32) float64 {
+	if loc.norm == 0 {
+		return 0
+	}
+	return float64(dotAt(loc.segment.data[loc.vector:], unit) / loc.norm)
+}
+
+// dotAt computes the dot product of the encoded vector at the start of data and a query,
+// decoding the vector as it goes.
+func dotAt(data []byte, query []float32) float32 {
+	data = data[:len(query)*4]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(query); i += 4 {
+		s0 += math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])) * query[i]
+		s1 += math.Float32frombits(binary.LittleEndian.Uint32(data[i*4+4:])) * query[i+1]
+		s2 += math.Float32frombits(binary.LittleEndian.Uint32(data[i*4+8:])) * query[i+2]
+		s3 += math.Float32frombits(binary.LittleEndian.Uint32(data[i*4+12:])) * query[i+3]
+	}
+	for ; i < len(query); i++ {
+		s0 += math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])) * query[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// normAt returns the length of the encoded vector at the start of data.
+func normAt(data []byte, dims int) float32 {
+	var norm float64
+	for i := 0; i < dims; i++ {
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		norm += v * v
+	}
+	return float32(math.Sqrt(norm))
+}
+
+// Stats returns the current size of the store.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := Stats{Dimensions: s.dims, Segments: len(s.segments), Live: len(s.index), Dead: s.dead}
+	for _, seg := range s.segments {
+		stats.Bytes += seg.size
+	}
+
+	switch {
+	case s.hnsw != nil:
+		stats.VectorBytes = s.hnsw.VectorBytes()
+	case s.quantizedScan():
+		for _, loc := range s.index {
+			stats.VectorBytes += loc.code.size()
+		}
+	default:
+		stats.VectorBytes = int64(len(s.index)) * int64(s.dims) * 4
+	}
+	return stats
+}
+
+// Compact rewrites the live records into new segments and removes the old segment files,
+// dropping overwritten records and tombstones.
+func (s *Store) Compact() error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	old := s.segments
+	oldIndex := s.index
+	oldDead := s.dead
+
+	// The HNSW index is rebuilt once the records are copied, which also drops its deleted nodes.
+	hnsw := s.hnsw
+	s.hnsw = nil
+
+	ids := make([]string, 0, len(oldIndex))
+	for id := range oldIndex {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	s.segments = nil
+	s.index = make(map[string]location, len(ids))
+	s.dead = 0
+
+	// Copy records in batches so a large store is not held in memory at once.
+	const batchSize = 1024
+	for start := 0; start < len(ids); start += batchSize {
+		end := min(start+batchSize, len(ids))
+		records := make([]Record, 0, end-start)
+		for _, id := range ids[start:end] {
+			loc := oldIndex[id]
+			record, err := loc.segment.readRecord(loc.offset, s.dims)
+			if err != nil {
+				s.hnsw = hnsw
+				return s.abortCompaction(old, oldIndex, oldDead, err)
+			}
+			records = append(records, record)
+		}
+		if err := s.write(opPut, records); err != nil {
+			s.hnsw = hnsw
+			return s.abortCompaction(old, oldIndex, oldDead, err)
+		}
+	}
+
+	// The new segments are synced, so the old ones can go. A crash before this point leaves
+	// both sets on disk, and the newer copies win when the store is reopened.
+	for _, seg := range old {
+		seg.close()
+		if err := os.Remove(seg.path); err != nil {
+			return fmt.Errorf("error removing compacted segment: %v", err)
+		}
+	}
+
+	if s.hnswConfig != nil {
+		if err := s.rebuildIndex(); err != nil {
+			return err
+		}
+		return s.saveIndex()
+	}
+	return nil
+}
+
+// abortCompaction removes the segments written by a failed compaction and restores the
+// previous state. The caller holds the lock.
+func (s *Store) abortCompaction(old []*segment, oldIndex map[string]location, oldDead int, cause error) error {
+	for _, seg := range s.segments {
+		seg.close()
+		os.Remove(seg.path)
+	}
+	s.segments = old
+	s.index = oldIndex
+	s.dead = oldDead
+	return fmt.Errorf("error compacting vector store: %v", cause)
+}
+
+// Close closes all segment files. Writes to a closed store return ErrClosed.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var firstErr error
+	if s.hnsw != nil {
+		firstErr = s.saveIndex()
+		s.hnsw = nil
+	}
+	for _, seg := range s.segments {
+		if err := seg.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.segments = nil
+	s.index = make(map[string]location)
+	return firstErr
+}
+
+// scoredID is a candidate result during search.
+type scoredID struct {
+	id    string
+	score float64
+}
+
+// resultHeap is a min-heap of candidates, keeping the best topN results seen so far.
+type resultHeap []scoredID
+
+func (h resultHeap) Len() int { return len(h) }
+func (h resultHeap) Less(i, j int) bool {
+	if h[i].score == h[j].score {
+		return h[i].id > h[j].id
+	}
+	return h[i].score < h[j].score
+}
+func (h resultHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)   { *h = append(*h, x.(scoredID)) }
+func (h *resultHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// offer adds a candidate if the heap holds fewer than k or the candidate beats the worst.
+func (h *resultHeap) offer(c scoredID, k int) {
+	if h.Len() < k {
+		heap.Push(h, c)
+	} else if k > 0 && c.score > (*h)[0].score {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// sortScored orders candidates from most to least similar, ties by ID.
+func sortScored(candidates []scoredID) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score == candidates[j].score {
+			return candidates[i].id < candidates[j].id
+		}
+		return candidates[i].score > candidates[j].score
+	})
+}
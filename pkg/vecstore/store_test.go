@@ -0,0 +1,236 @@
+package vecstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePutSearchDelete(t *testing.T) {
+	store, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Put(
+		Record{ID: "a", Text: "alpha", Vector: []float32{1, 0, 0}},
+		Record{ID: "b", Text: "beta", Vector: []float32{0.9, 0.1, 0}, Metadata: map[string]any{"source": "web"}},
+		Record{ID: "c", Text: "gamma", Vector: []float32{0, 0, 1}},
+	))
+
+	results, err := store.Search([]float32{1, 0, 0}, 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].ID)
+	assert.InDelta(t, 1.0, results[0].Score, 1e-6)
+	assert.Equal(t, "beta", results[1].Text)
+	assert.Equal(t, "web", results[1].Metadata["source"])
+
+	require.NoError(t, store.Delete("a"))
+	_, ok, err := store.Get("a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	results, err = store.Search([]float32{1, 0, 0}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "b", results[0].ID)
+
+	assert.Error(t, store.Put(Record{ID: "d", Vector: []float32{1, 0}}), "dimension mismatch")
+	_, err = store.Search([]float32{1, 0}, 1)
+	assert.Error(t, err)
+}
+
+func TestStoreReopenAndCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := Open(dir)
+	require.NoError(t, err)
+	store.MaxSegmentSize = 32 // Start a new segment for every write
+
+	require.NoError(t, store.Put(Record{ID: "a", Text: "first", Vector: []float32{1, 2}}))
+	require.NoError(t, store.Put(Record{ID: "b", Text: "second", Vector: []float32{3, 4}}))
+	require.NoError(t, store.Put(Record{ID: "a", Text: "updated", Vector: []float32{5, 6}}))
+	require.NoError(t, store.Delete("b"))
+	require.NoError(t, store.Close())
+
+	store, err = Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	stats := store.Stats()
+	assert.Equal(t, 2, stats.Dimensions)
+	assert.Equal(t, 1, stats.Live)
+	assert.Equal(t, 3, stats.Dead)
+	assert.Greater(t, stats.Segments, 1)
+
+	record, ok, err := store.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "updated", record.Text)
+	assert.Equal(t, []float32{5, 6}, record.Vector)
+
+	require.NoError(t, store.Compact())
+	stats = store.Stats()
+	assert.Equal(t, 1, stats.Segments)
+	assert.Equal(t, 1, stats.Live)
+	assert.Zero(t, stats.Dead)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "segment-*.vec"))
+	require.NoError(t, err)
+	assert.Len(t, paths, 1)
+
+	// New writes go after the compacted segment.
+	require.NoError(t, store.Put(Record{ID: "c", Text: "third", Vector: []float32{7, 8}}))
+	assert.Equal(t, []string{"a", "c"}, store.IDs())
+}
+
+func TestStoreTruncatesPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Text: "kept", Vector: []float32{1, 2}}))
+	require.NoError(t, store.Close())
+
+	// Simulate a crash in the middle of appending a record.
+	path := filepath.Join(dir, "segment-000001.vec")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{opPut, 5, 0, 0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.Equal(t, 1, store.Len())
+	require.NoError(t, store.Put(Record{ID: "b", Text: "after", Vector: []float32{3, 4}}))
+	assert.Equal(t, []string{"a", "b"}, store.IDs())
+}
+
+func TestMigrateJSON(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "embeddings.db")
+
+	db := NewEmbeddingDB()
+	db.AddEmbeddings([]Embedding{
+		{Word: "hello world", Vector: []float64{0.5, 0.5}},
+		{Word: "goodbye", Vector: []float64{-0.5, 0.5}},
+	})
+	require.NoError(t, db.SaveEmbeddings(jsonPath))
+
+	store, err := Open(filepath.Join(dir, "vectors"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	migrated, err := MigrateJSON(store, jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+	record, ok, err := store.Get(ChunkID("", "hello world"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "hello world", record.Text)
+	assert.Equal(t, 2, store.Len())
+
+	_, err = os.Stat(jsonPath + ".migrated")
+	assert.NoError(t, err)
+
+	// The migration runs only once.
+	migrated, err = MigrateJSON(store, jsonPath)
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
+
+func TestStoreFilteredSearch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(
+		Record{ID: "a", Text: "chat", Vector: []float32{1, 0}, Metadata: map[string]any{"source": "chat", "created_at": 100}},
+		Record{ID: "b", Text: "web", Vector: []float32{0.9, 0.1}, Metadata: map[string]any{"source": "web", "created_at": 200}},
+		Record{ID: "c", Text: "upload", Vector: []float32{0.8, 0.2}, Metadata: map[string]any{"source": "upload", "created_at": 300}},
+		Record{ID: "d", Text: "plain", Vector: []float32{1, 0}},
+	))
+	require.NoError(t, store.Close())
+
+	// Metadata survives a reopen and filters the same way.
+	store, err = Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	results, err := store.Search([]float32{1, 0}, 10, Eq("source", "web"))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "b", results[0].ID)
+
+	results, err = store.Search([]float32{1, 0}, 10, In("source", "web", "upload"), Gte("created_at", 250))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "c", results[0].ID)
+
+	results, err = store.Search([]float32{1, 0}, 10, Ne("source", "chat"))
+	require.NoError(t, err)
+	assert.Len(t, results, 2, "records without the field do not match")
+
+	_, err = store.Search([]float32{1, 0}, 10, Condition{Field: "source", Op: "like"})
+	assert.Error(t, err)
+}
+
+func TestStoreDeleteMatching(t *testing.T) {
+	store, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Put(
+		Record{ID: "a1", Vector: []float32{1, 0}, Metadata: map[string]any{"document_id": "a"}},
+		Record{ID: "a2", Vector: []float32{0, 1}, Metadata: map[string]any{"document_id": "a"}},
+		Record{ID: "b1", Vector: []float32{1, 1}, Metadata: map[string]any{"document_id": "b"}},
+	))
+
+	deleted, err := store.DeleteMatching(Eq("document_id", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, []string{"b1"}, store.IDs())
+
+	deleted, err = store.DeleteMatching(Eq("document_id", "a"))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	_, err = store.DeleteMatching()
+	assert.Error(t, err)
+}
+
+func TestStoreMetadata(t *testing.T) {
+	store, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Put(
+		Record{ID: "a", Vector: []float32{1, 0}, Metadata: map[string]any{"source": "chat", "created_at": 100}},
+		Record{ID: "b", Vector: []float32{0, 1}, Metadata: map[string]any{"source": "chat", "created_at": 200, "pinned": true}},
+		Record{ID: "c", Vector: []float32{1, 1}, Metadata: map[string]any{"source": "document", "created_at": 100}},
+		Record{ID: "d", Vector: []float32{1, 1}},
+	))
+
+	all, err := store.Metadata()
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	chat, err := store.Metadata(Eq("source", "chat"), Lt("created_at", 150))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]any{"a": {"source": "chat", "created_at": float64(100)}}, chat)
+
+	// The maps are copies.
+	chat["a"]["pinned"] = true
+	pinned, err := store.Metadata(Eq("pinned", true))
+	require.NoError(t, err)
+	assert.Len(t, pinned, 1)
+	assert.Contains(t, pinned, "b")
+
+	_, err = store.Metadata(Condition{Field: "source", Op: "like"})
+	assert.Error(t, err)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"math/rand"
 	"os"
 	"sort"
 	"strings"
@@ -13,18 +12,6 @@ import (
 // Vector represents a vector of floats.
 type Vector []float64
 
-// Node is a struct that represents a node in a k-d tree. It has three fields:
-// Domain: A slice of float64 values representing the domain or feature space of the data point associated with this node.
-// Value: A float64 value representing the pivot value used to partition the data points into two subsets.
-// Left: A pointer to the left child node in the tree, or nil if there is no left child.
-// Right: A pointer to the right child node in the tree, or nil if there is no right child.
-type Node struct {
-	Domain []float64
-	Value  float64
-	Left   *Node
-	Right  *Node
-}
-
 // Embedding represents a word embedding.
 type Embedding struct {
 	Word       string
@@ -187,6 +174,8 @@ func (db *EmbeddingDB) RecreateDocument(embeddings []Embedding) string {
 // 	return dotProduct / (math.Sqrt(magnitudeA) * math.Sqrt(magnitudeB))
 // }
 
+// CosineSimilarity computes the cosine similarity of a single pair. To compare many
+// vectors, normalize them once into a Matrix and use Similarities or TopK.
 func CosineSimilarity(vecA, vecB []float64) float64 {
 	var dotProduct, normA, normB float64
 	for i, v := range vecA {
@@ -241,95 +230,6 @@ func (db *EmbeddingDB) MostSimilarWord(embeddings map[string]Embedding, targetWo
 	return mostSimilarWord, highestSimilarity
 }
 
-// TODO: Implement an Efficient Search Mechanism using KD-Trees or Ball Trees.
-// Consider integrating with a library or service that offers efficient nearest-neighbor search capabilities.
-// Placeholder function for this:
-func EfficientSearch(targetWord string) (string, float64) {
-	// Implement efficient search here
-	return "", 0.0
-}
-
-// dataPoints: A slice of slices of float64 values representing the data points to be inserted into the k-d tree. Each inner slice should have the same length, which represents the number of dimensions or features of the data point.
-// dimensions: An integer representing the number of dimensions or features of the data points.
-func BuildKdTree(dataPoints [][]float64, dimensions int) *Node {
-	if len(dataPoints) == 0 {
-		return nil
-	}
-
-	// Use a stack to store the nodes in the tree.
-	stack := make([]*Node, 0)
-
-	// Select the median value of a random dimension as the pivot.
-	pivotIndex := rand.Intn(len(dataPoints))
-	pivotValue := dataPoints[pivotIndex][rand.Intn(dimensions)]
-
-	// Partition the data points into two subsets based on the pivot value.
-	left := make([][]float64, 0)
-	right := make([][]float64, 0)
-	for _, point := range dataPoints {
-		if point[rand.Intn(dimensions)] < pivotValue {
-			left = append(left, point)
-		} else {
-			right = append(right, point)
-		}
-	}
-
-	// Build the left and right subtrees iteratively using a stack.
-	node := &Node{
-		Domain: dataPoints[pivotIndex],
-		Value:  pivotValue,
-	}
-	stack = append(stack, node)
-	for len(left) > 0 || len(right) > 0 {
-		if len(left) > 0 {
-			// Build the left subtree.
-			pivotIndex := rand.Intn(len(left))
-			pivotValue := left[pivotIndex][rand.Intn(dimensions)]
-			var partition [][]float64
-			for _, point := range left {
-				if point[rand.Intn(dimensions)] < pivotValue {
-					partition = append(partition, point)
-				}
-			}
-			node := &Node{
-				Domain: left[pivotIndex],
-				Value:  pivotValue,
-			}
-			stack[len(stack)-1].Left = node
-			if len(partition) > 0 {
-				stack = append(stack, node)
-				left = partition
-			} else {
-				left = nil
-			}
-		} else {
-			// Build the right subtree.
-			pivotIndex := rand.Intn(len(right))
-			pivotValue := right[pivotIndex][rand.Intn(dimensions)]
-			var partition [][]float64
-			for _, point := range right {
-				if point[rand.Intn(dimensions)] < pivotValue {
-					partition = append(partition, point)
-				}
-			}
-			node := &Node{
-				Domain: right[pivotIndex],
-				Value:  pivotValue,
-			}
-			stack[len(stack)-1].Right = node
-			if len(partition) > 0 {
-				stack = append(stack, node)
-				right = partition
-			} else {
-				right = nil
-			}
-		}
-	}
-
-	// Return the root node.
-	return stack[0]
-}
-
 // FindMostSimilarEmbedding finds the most similar embeddings in the database.
 func FindMostSimilarEmbedding(targetEmbedding Embedding, embeddings map[string]Embedding) (Embedding, bool) {
 	var mostSimilarEmbedding Embedding
@@ -372,17 +272,57 @@ func NormalizeL2(vec []float64) []float64 {
 }
 
 // ComputeSimilarityMatrix computes the cosine similarity matrix between two slices of embeddings.
+// The embeddings are normalized once and compared in float32 with the blocked kernels.
 func ComputeSimilarityMatrix(queryEmbeddings, keyEmbeddings []Embedding) [][]float64 {
 	matrix := make([][]float64, len(queryEmbeddings))
-	for i, query := range queryEmbeddings {
+	for i := range matrix {
 		matrix[i] = make([]float64, len(keyEmbeddings))
-		for j, key := range keyEmbeddings {
-			matrix[i][j] = CosineSimilarity(query.Vector, key.Vector)
+	}
+
+	queries, qerr := embeddingMatrix(queryEmbeddings)
+	keys, kerr := embeddingMatrix(keyEmbeddings)
+	var scores []float32
+	var err error
+	if qerr == nil && kerr == nil {
+		scores, err = Similarities(queries, keys)
+	}
+	if qerr != nil || kerr != nil || err != nil {
+		// Embeddings of mixed dimensions are compared pair by pair.
+		for i, query := range queryEmbeddings {
+			for j, key := range keyEmbeddings {
+				matrix[i][j] = CosineSimilarity(query.Vector, key.Vector)
+			}
+		}
+		return matrix
+	}
+
+	for i := range matrix {
+		for j := range matrix[i] {
+			matrix[i][j] = float64(scores[i*len(keyEmbeddings)+j])
 		}
 	}
 	return matrix
 }
 
+// embeddingMatrix converts embeddings to a matrix of normalized float32 vectors.
+func embeddingMatrix(embeddings []Embedding) (*Matrix, error) {
+	if len(embeddings) == 0 {
+		return NewMatrix(0, 0), nil
+	}
+	m := NewMatrix(len(embeddings[0].Vector), len(embeddings))
+	vector := make([]float32, 0, m.Dims())
+	for _, embedding := range embeddings {
+		vector = vector[:0]
+		for _, v := range embedding.Vector {
+			vector = append(vector, float32(v))
+		}
+		if err := m.Append(vector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
 // SimilarityWithKey is a type that holds both the similarity value and the corresponding word key.
 type SimilarityWithKey struct {
 	Similarity float64
@@ -391,15 +331,38 @@ type SimilarityWithKey struct {
 
 // FindTopNSimilarEmbeddings finds the top N most similar embeddings in the database.
 func FindTopNSimilarEmbeddings(targetEmbedding Embedding, embeddings map[string]Embedding, topN int) []Embedding {
+	keys := make([]string, 0, len(embeddings))
+	values := make([]Embedding, 0, len(embeddings))
+	for key := range embeddings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, embeddings[key])
+	}
+
+	// Compare the normalized vectors with a bounded heap rather than sorting every score.
+	queries, qerr := embeddingMatrix([]Embedding{targetEmbedding})
+	matrix, kerr := embeddingMatrix(values)
+	if qerr == nil && kerr == nil {
+		if matches, err := TopK(queries, matrix, topN); err == nil && len(matches) == 1 {
+			topEmbeddings := make([]Embedding, 0, len(matches[0]))
+			for _, m := range matches[0] {
+				embedding := values[m.Index]
+				embedding.Similarity = float64(m.Score)
+				topEmbeddings = append(topEmbeddings, embedding)
+			}
+			return topEmbeddings
+		}
+	}
+
+	// Embeddings of mixed dimensions are compared pair by pair.
 	var topEmbeddings []Embedding
 	var similarityList []SimilarityWithKey
 
 	// Compute the cosine similarity for each embedding in the database and store it with its key.
 	for key, embedding := range embeddings {
 		similarity := CosineSimilarity(targetEmbedding.Vector, embedding.Vector)
-
-		fmt.Println("Similarity:", similarity)
-
 		similarityList = append(similarityList, SimilarityWithKey{similarity, key})
 	}
 
@@ -410,13 +373,18 @@ func FindTopNSimilarEmbeddings(targetEmbedding Embedding, embeddings map[string]
 
 	// Retrieve the top N most similar embeddings.
 	for i := 0; i < topN && i < len(similarityList); i++ {
-		topEmbeddings = append(topEmbeddings, embeddings[similarityList[i].Key])
+		embedding := embeddings[similarityList[i].Key]
+		embedding.Similarity = similarityList[i].Similarity
+		topEmbeddings = append(topEmbeddings, embedding)
 	}
 
 	return topEmbeddings
 }
 
 // Reranker function reranks documents based on a weighted combination of score and length.
+//
+// Deprecated: the length of a document says nothing about its relevance. Use
+// retrieval.Rerank with a cross-encoder instead.
 func Reranker(documents []Document, weightScore float64, weightLength float64) []Document {
 	// Validate weights
 	if weightScore < 0 || weightLength < 0 || (weightScore+weightLength) == 0 {
@@ -17,6 +17,8 @@ import (
 	"github.com/chromedp/chromedp/kb"
 	"github.com/go-shiori/go-readability"
 	"github.com/pterm/pterm"
+
+	"eternal/pkg/documents"
 )
 
 var (
@@ -112,9 +114,12 @@ func WebGetHandler(address string) (string, error) {
 		return "", err
 	}
 
-	text := doc.Find("body").Text()
-
-	text = removeEmptyRows(text)
+	// Keep the headings of the article as Markdown, so it can be split by section under its
+	// title.
+	text := documents.SelectionToMarkdown(doc.Find("body"))
+	if title := strings.TrimSpace(article.Title); title != "" && !strings.HasPrefix(text, "# ") {
+		text = "# " + title + "\n\n" + text
+	}
 
 	//pterm.Info.Println("Document:", text)
 
@@ -317,16 +322,3 @@ func RemoveUrls(input string) string {
 
 	return input
 }
-
-func removeEmptyRows(input string) string {
-	lines := strings.Split(input, "\n")
-	var filteredLines []string
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			filteredLines = append(filteredLines, line)
-		}
-	}
-
-	return strings.Join(filteredLines, "\n")
-}
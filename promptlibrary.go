@@ -0,0 +1,81 @@
+// promptlibrary.go - Rendering prompts from the prompt library
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"eternal/pkg/llm"
+
+	"gorm.io/gorm"
+)
+
+// PromptRenderRequest is the body accepted by the render and run routes.
+type PromptRenderRequest struct {
+	Version   int               `json:"version" form:"version"` // Zero renders the current version
+	Model     string            `json:"model" form:"model"`     // Only used by the run route
+	Variables map[string]string `json:"variables"`
+}
+
+// declarePromptVariables adds a declaration for every placeholder in the system text and
+// template that the prompt does not declare yet. Declared variables keep their order.
+func declarePromptVariables(prompt *Prompt) {
+	declared := make(map[string]bool)
+	for _, v := range prompt.Variables {
+		declared[v.Name] = true
+	}
+
+	for _, text := range []string{prompt.System, prompt.Template} {
+		pt := llm.PromptTemplate{Template: text}
+		for _, name := range pt.Variables() {
+			if !declared[name] {
+				declared[name] = true
+				prompt.Variables = append(prompt.Variables, PromptVariable{Name: name})
+			}
+		}
+	}
+}
+
+// renderPrompt fills the placeholders of a prompt's system text and template. Values that
+// are missing or empty fall back to the variable's default. Variables without either are
+// reported as an error.
+func renderPrompt(system, template string, variables []PromptVariable, values map[string]string) (string, string, error) {
+	vars := make(map[string]string)
+	var missing []string
+	for _, v := range variables {
+		value := values[v.Name]
+		if value == "" {
+			value = v.Default
+		}
+		if value == "" {
+			missing = append(missing, v.Name)
+			continue
+		}
+		vars[v.Name] = value
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", "", fmt.Errorf("missing values for variables: %s", strings.Join(missing, ", "))
+	}
+
+	systemTemplate := llm.PromptTemplate{Template: system}
+	promptTemplate := llm.PromptTemplate{Template: template}
+
+	return systemTemplate.Format(vars), promptTemplate.Format(vars), nil
+}
+
+// loadPromptVersion returns the requested version of a prompt. Version zero returns the
+// current text of the prompt.
+func loadPromptVersion(db *gorm.DB, promptID int64, version int) (PromptVersion, error) {
+	if version > 0 {
+		return GetPromptVersion(db, promptID, version)
+	}
+
+	prompt, err := GetPrompt(db, promptID)
+	if err != nil {
+		return PromptVersion{}, err
+	}
+	return *promptVersionOf(&prompt), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeclarePromptVariables(t *testing.T) {
+	prompt := Prompt{
+		System:    "You review {language} code.",
+		Template:  "Review this {language} change for {focus}:\n{diff}\nReturn JSON like {\"ok\": true}.",
+		Variables: []PromptVariable{{Name: "focus", Default: "bugs"}},
+	}
+
+	declarePromptVariables(&prompt)
+
+	assert.Equal(t, []PromptVariable{
+		{Name: "focus", Default: "bugs"},
+		{Name: "language"},
+		{Name: "diff"},
+	}, prompt.Variables)
+}
+
+func TestRenderPrompt(t *testing.T) {
+	variables := []PromptVariable{{Name: "language"}, {Name: "focus", Default: "bugs"}}
+
+	system, prompt, err := renderPrompt("You review {language} code.", "Look for {focus}.", variables, map[string]string{"language": "Go"})
+	assert.NoError(t, err)
+	assert.Equal(t, "You review Go code.", system)
+	assert.Equal(t, "Look for bugs.", prompt)
+
+	_, _, err = renderPrompt("", "{language} {focus}", []PromptVariable{{Name: "language"}, {Name: "focus"}}, nil)
+	assert.EqualError(t, err, "missing values for variables: focus, language")
+}
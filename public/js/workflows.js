@@ -65,21 +65,43 @@ async function uploadFile(file) {
     });
 
     const data = await response.json();
-    docContent = data.content;
-
-    if (data.status === 'success' && data.callback === 'image') {
-      // Image file detected, trigger another request
-      console.log('Image file uploaded successfully')
-      return //await fetchImageProcessingResult("./public/uploads/" + file.name);
-    } else {
-      // Handle other cases or non-image files
-      console.log('File uploaded for processing:', data);
+    if (!response.ok) {
+      console.error('Upload failed:', data.error);
+      return;
     }
+
+    // Documents are ingested in the background; follow their jobs until they finish.
+    data.documents.forEach(doc => {
+      console.log('File queued for ingestion:', doc.name);
+      followIngestion('ingest-' + doc.id);
+    });
   } catch (error) {
     console.error('Error:', error);
   }
 }
 
+// followIngestion logs the progress of an ingestion job until it completes or fails.
+function followIngestion(jobID) {
+  const events = new EventSource('/jobs/events');
+
+  events.addEventListener('job', function (event) {
+    const job = JSON.parse(event.data);
+    if (job.id !== jobID) {
+      return;
+    }
+
+    const progress = job.progress || {};
+    console.log(`${job.label}: ${job.state} ${progress.step || ''} ${progress.done || 0}/${progress.total || 0}`);
+
+    if (job.state === 'completed' || job.state === 'failed' || job.state === 'canceled') {
+      if (job.error) {
+        console.error(`Ingesting ${job.label} failed:`, job.error);
+      }
+      events.close();
+    }
+  });
+}
+
 async function createChat(prompt, msg, model) {
   const chatUrl = 'http://localhost:8080/chats';
 
@@ -0,0 +1,167 @@
+// queryrewrite.go - Rewriting chat messages into search queries
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+
+	"eternal/pkg/retrieval"
+)
+
+const (
+	rewriteInstructions  = "You turn the last message of a conversation into a search query for a knowledge base and the web. Resolve references such as \"it\" or \"the second one\" using the conversation, so the query can be understood on its own. Keep names, versions and code identifiers. Reply with the query on the first line."
+	subQueryInstructions = "Then add up to %d more queries, one per line, that each search for a different part or phrasing of the question. Reply with the queries only."
+	hydeInstructions     = "Write a short passage that answers the question the way a reference document would, in at most 100 words. Do not mention that you are guessing. Reply with the passage only."
+
+	defaultRewriteHistoryTurns = 3
+	maxSubQueries              = 5
+)
+
+// queryMarkerPattern matches the numbering, bullet or label models put before a query, as in
+// "1. ", "- " or "Query 2: ".
+var queryMarkerPattern = regexp.MustCompile(`^(?:\d+[.)]\s+|[-*•]\s+)?(?i:(?:sub-?)?query(?:\s*\d+)?\s*:\s*)?`)
+
+// QueryRewriteConfig turns chat messages into search queries with a small model before memory
+// and web search. Follow-ups such as "what about the second one?" only find something once
+// the conversation is folded into the query.
+type QueryRewriteConfig struct {
+	Model        string `yaml:"model"`         // Model that writes the queries; empty disables rewriting
+	HistoryTurns int    `yaml:"history_turns"` // Recent turns used to resolve references, 3 by default
+	SubQueries   int    `yaml:"sub_queries"`   // Extra queries searched and fused with the rewritten one, up to 5
+	HyDE         bool   `yaml:"hyde"`          // Also search memory by meaning with a hypothetical answer
+}
+
+// Validate checks the turn and query counts.
+func (c QueryRewriteConfig) Validate() error {
+	if c.HistoryTurns < 0 {
+		return fmt.Errorf("history_turns cannot be negative")
+	}
+	if c.SubQueries < 0 || c.SubQueries > maxSubQueries {
+		return fmt.Errorf("sub_queries must be between 0 and %d", maxSubQueries)
+	}
+	return nil
+}
+
+// searchQuery is what memory and web search look for: a query and the variants whose results
+// are fused with its own.
+type searchQuery struct {
+	Text         string
+	SubQueries   []string
+	Hypothetical string // Hypothetical answer searched by meaning (HyDE)
+}
+
+// rewriteQuery returns the search query for a chat message. Without a rewrite model, or when
+// the model fails, the message is searched as it is.
+func rewriteQuery(config *AppConfig, sessionID int64, message string) searchQuery {
+	query := searchQuery{Text: message}
+	rc := config.Retrieval.QueryRewrite
+	if rc.Model == "" {
+		return query
+	}
+
+	history := recentHistory(rc, sessionID)
+	if history != "" || rc.SubQueries > 0 {
+		instructions := rewriteInstructions + " Reply with the query only."
+		if rc.SubQueries > 0 {
+			instructions = rewriteInstructions + " " + fmt.Sprintf(subQueryInstructions, rc.SubQueries)
+		}
+		prompt := fmt.Sprintf("CONVERSATION:\n%s\nLAST MESSAGE:\n%s", history, message)
+
+		reply, err := complete(config, rc.Model, instructions, prompt)
+		if err != nil {
+			pterm.Error.Printf("Error rewriting search query: %v\n", err)
+			return query
+		}
+		if queries := parseQueries(reply); len(queries) > 0 {
+			query.Text = queries[0]
+			query.SubQueries = queries[1:min(len(queries), rc.SubQueries+1)]
+		}
+	}
+
+	if rc.HyDE {
+		answer, err := complete(config, rc.Model, hydeInstructions, query.Text)
+		if err != nil {
+			pterm.Error.Printf("Error writing hypothetical answer: %v\n", err)
+		} else {
+			query.Hypothetical = strings.TrimSpace(answer)
+		}
+	}
+
+	return query
+}
+
+// searchWeb runs search for the query and each of its sub-queries at the same time and
+// returns the result URLs fused by reciprocal rank, so pages found for several of them come
+// first. Each URL is returned once.
+func (q searchQuery) searchWeb(search func(query string) []string) []string {
+	texts := append([]string{q.Text}, q.SubQueries...)
+	lists := make([]retrieval.HitList, len(texts))
+
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			urls := search(text)
+			hits := make([]retrieval.Hit, len(urls))
+			for j, url := range urls {
+				hits[j] = retrieval.Hit{ID: url, Text: url}
+			}
+			lists[i] = retrieval.HitList{Retriever: "web", Query: text, Hits: hits}
+		}(i, text)
+	}
+	wg.Wait()
+
+	fused := retrieval.FuseLists(retrieval.Config{}, lists)
+	urls := make([]string, len(fused))
+	for i, result := range fused {
+		urls[i] = result.ID
+	}
+	return urls
+}
+
+// recentHistory returns the last turns of the session as a transcript, or "" for a new
+// session.
+func recentHistory(rc QueryRewriteConfig, sessionID int64) string {
+	if sessionID <= 0 {
+		return ""
+	}
+	session, err := GetChatSession(sqliteDB.db, sessionID)
+	if err != nil {
+		pterm.Error.Printf("Error loading chat session %d for query rewriting: %v\n", sessionID, err)
+		return ""
+	}
+
+	turns := rc.HistoryTurns
+	if turns == 0 {
+		turns = defaultRewriteHistoryTurns
+	}
+	if len(session.ChatTurns) > turns {
+		session.ChatTurns = session.ChatTurns[len(session.ChatTurns)-turns:]
+	}
+	return formatTurns(session.ChatTurns)
+}
+
+// parseQueries returns the queries of a rewrite reply, one per line, without the numbering,
+// bullets, labels or quotes that models add. Repeated queries are dropped.
+func parseQueries(reply string) []string {
+	var queries []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(reply, "\n") {
+		line = queryMarkerPattern.ReplaceAllString(strings.TrimSpace(line), "")
+		line = strings.Trim(strings.TrimSpace(line), "\"'`")
+
+		key := strings.ToLower(line)
+		if line == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		queries = append(queries, line)
+	}
+	return queries
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueries(t *testing.T) {
+	reply := "Query: \"Lisbon tram 28 route\"\n\n1. tram 28 opening hours\n- Sub-query 2: Lisbon tram tickets\n2024 tram fares\nlisbon tram 28 route\n"
+
+	assert.Equal(t, []string{"Lisbon tram 28 route", "tram 28 opening hours", "Lisbon tram tickets", "2024 tram fares"}, parseQueries(reply))
+	assert.Empty(t, parseQueries("\n  \n"))
+}
+
+func TestRewriteQueryDisabled(t *testing.T) {
+	config := &AppConfig{}
+	assert.Equal(t, searchQuery{Text: "what about the second one?"}, rewriteQuery(config, 1, "what about the second one?"))
+}
+
+func TestQueryRewriteConfigValidate(t *testing.T) {
+	assert.NoError(t, QueryRewriteConfig{Model: "openai-gpt-4o-mini", SubQueries: 3}.Validate())
+	assert.Error(t, QueryRewriteConfig{SubQueries: maxSubQueries + 1}.Validate())
+	assert.Error(t, QueryRewriteConfig{HistoryTurns: -1}.Validate())
+}
+
+func TestSearchWebFusesSubQueries(t *testing.T) {
+	results := map[string][]string{
+		"lisbon tram":   {"https://a.example", "https://b.example", "https://c.example"},
+		"tram 28 route": {"https://c.example", "https://d.example"},
+		"tram tickets":  {"https://c.example", "https://b.example"},
+	}
+	query := searchQuery{Text: "lisbon tram", SubQueries: []string{"tram 28 route", "tram tickets"}}
+
+	urls := query.searchWeb(func(text string) []string { return results[text] })
+	assert.Equal(t, []string{"https://c.example", "https://b.example", "https://a.example", "https://d.example"}, urls)
+
+	urls = searchQuery{Text: "lisbon tram"}.searchWeb(func(text string) []string { return results[text] })
+	assert.Equal(t, results["lisbon tram"], urls)
+}
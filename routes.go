@@ -25,6 +25,28 @@ func setupRoutes(app *fiber.App, config *AppConfig, modelParams []ModelParams) {
 	app.Post("/chatsubmit", handleChatSubmit(config))
 	app.Post("/chat/role/:name", handleRoleSelection(config))
 
+	// Assistant role routes
+	app.Get("/roles", handleListRoles())
+	app.Post("/roles", handleCreateRole())
+	app.Get("/roles/menu", handleRolesMenu())
+	app.Get("/roles/export", handleExportRoles())
+	app.Post("/roles/import", handleImportRoles())
+	app.Get("/roles/:id", handleGetRole())
+	app.Put("/roles/:id", handleUpdateRole())
+	app.Delete("/roles/:id", handleDeleteRole())
+
+	// Prompt library routes
+	app.Get("/prompts", handleListPrompts())
+	app.Post("/prompts", handleCreatePrompt())
+	app.Get("/prompts/options", handlePromptOptions())
+	app.Get("/prompts/:id", handleGetPrompt())
+	app.Put("/prompts/:id", handleUpdatePrompt())
+	app.Delete("/prompts/:id", handleDeletePrompt())
+	app.Get("/prompts/:id/versions", handleListPromptVersions())
+	app.Get("/prompts/:id/form", handlePromptForm())
+	app.Post("/prompts/:id/render", handleRenderPrompt())
+	app.Post("/prompts/:id/run", handleRunPrompt(config))
+
 	// Model management routes
 	app.Post("/modelcards", handleModelCards(modelParams))
 	app.Post("/model/select/:name/:action", handleModelSelect())
@@ -39,10 +61,63 @@ func setupRoutes(app *fiber.App, config *AppConfig, modelParams []ModelParams) {
 
 	// Chat - Database routes
 	app.Get("/chats", handleGetChats())
+	app.Get("/chats/export", handleExportChats())
+	app.Post("/chats/import", handleImportChats())
+	app.Get("/chats/:id/export", handleExportChat())
+	app.Get("/sessions", handleListSessions())
+	app.Get("/sessions/:id", handleGetSession(config))
+	app.Get("/search", handleSearch())
 	app.Get("/chats/:id", handleGetChatByID())
 	app.Put("/chats/:id", handleUpdateChat())
 	app.Delete("/chats/:id", handleDeleteChat())
 
+	// Document ingestion and knowledge base routes
+	app.Get("/documents", handleListDocuments())
+	app.Get("/documents/:id", handleGetDocument())
+	app.Get("/documents/:id/file", handleDocumentFile())
+	app.Post("/documents/:id/reingest", handleReingestDocument(config))
+	app.Delete("/documents/:id", handleDeleteDocument(config))
+	app.Get("/knowledge/git", handleListGitRepositories())
+	app.Post("/knowledge/git", handleCreateGitRepository(config))
+	app.Get("/knowledge/git/:id", handleGetGitRepository())
+	app.Put("/knowledge/git/:id", handleUpdateGitRepository(config))
+	app.Post("/knowledge/git/:id/refresh", handleRefreshGitRepository(config))
+	app.Delete("/knowledge/git/:id", handleDeleteGitRepository(config))
+	// Memory management routes
+	app.Get("/memory", handleListMemory(config))
+	app.Get("/memory/policies", handleListMemoryPolicies())
+	app.Put("/memory/policies/:source", handleSaveMemoryPolicy())
+	app.Delete("/memory/policies/:source", handleDeleteMemoryPolicy())
+	app.Post("/memory/expire", handleExpireMemory())
+	app.Delete("/memory/sources/:source", handleForgetMemorySource())
+	app.Get("/memory/:store/:id", handleGetMemory())
+	app.Put("/memory/:store/:id/pin", handlePinMemory(true))
+	app.Delete("/memory/:store/:id/pin", handlePinMemory(false))
+	app.Delete("/memory/:store/:id", handleForgetMemory())
+	// Retrieval evaluation routes
+	app.Post("/eval/retrieval", handleRunRetrievalEval(config))
+	app.Get("/eval/runs", handleListEvalRuns())
+	app.Get("/eval/runs/:id", handleGetEvalRun())
+	app.Delete("/eval/runs/:id", handleDeleteEvalRun())
+
+	// Vector collection routes
+	app.Post("/collections/:name/reembed", handleReembedCollection(config))
+
+	// Search index routes
+	app.Get("/search/indexes", handleListSearchIndexes())
+	app.Post("/search/indexes/:name/rebuild", handleRebuildSearchIndex())
+	app.Delete("/search/indexes/:name", handleDeleteSearchIndex())
+
+	// Backup routes
+	app.Get("/backups", handleListBackups(config))
+	app.Post("/backups", handleCreateBackup(config))
+	app.Get("/backups/:name", handleDownloadBackup(config))
+	app.Delete("/backups/:name", handleDeleteBackup(config))
+
+	app.Get("/jobs", handleListJobs())
+	app.Get("/jobs/events", handleJobEvents())
+	app.Get("/jobs/:id", handleGetJob())
+
 	// Tool routes
 	app.Get("/tools/list", handleToolList(config))
 	app.Post("/tool/:toolName/:enabled/:topN", handleToolToggle(config))
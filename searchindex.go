@@ -0,0 +1,317 @@
+// searchindex.go - Keyword indexes of the vector collections
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/pterm/pterm"
+
+	"eternal/pkg/search"
+	"eternal/pkg/vecstore"
+)
+
+// searchIndexDir is the directory of the keyword indexes under the data path.
+const searchIndexDir = "search"
+
+// legacySearchIndex is the single keyword index of earlier versions, which held every
+// collection.
+const legacySearchIndex = "search.bleve"
+
+// errSearchIndexNotFound is returned for keyword indexes that do not exist.
+var errSearchIndexNotFound = errors.New("search index not found")
+
+// SearchIndexInfo describes a keyword index.
+type SearchIndexInfo struct {
+	Name      string `json:"name"`
+	Documents uint64 `json:"documents"`
+}
+
+// SearchIndexes are the Bleve keyword indexes, one per vector collection and named after
+// it. Chat turns and web pages are kept in the chat index, and document chunks in the index
+// of the collection they were ingested into.
+type SearchIndexes struct {
+	dir     string
+	mapping mapping.IndexMapping
+
+	mu      sync.Mutex
+	indexes map[string]bleve.Index
+}
+
+// OpenSearchIndexes opens the indexes in dir. New indexes are created with the mapping.
+func OpenSearchIndexes(dir string, m mapping.IndexMapping) (*SearchIndexes, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SearchIndexes{dir: dir, mapping: m, indexes: make(map[string]bleve.Index)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".bleve")
+		if !entry.IsDir() || !ok || !vecstore.ValidCollectionName(name) {
+			continue
+		}
+		if _, err := s.Get(name); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// path returns the directory of the named index.
+func (s *SearchIndexes) path(name string) string {
+	return filepath.Join(s.dir, name+".bleve")
+}
+
+// Get returns the named index, creating it if it does not exist.
+func (s *SearchIndexes) Get(name string) (bleve.Index, error) {
+	if !vecstore.ValidCollectionName(name) {
+		return nil, fmt.Errorf("invalid index name %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index, ok := s.indexes[name]; ok {
+		return index, nil
+	}
+	index, err := search.Open(s.path(name), s.mapping)
+	if err != nil {
+		return nil, fmt.Errorf("error opening search index %s: %v", name, err)
+	}
+	s.indexes[name] = index
+	return index, nil
+}
+
+// names returns the names of the open indexes in sorted order. The caller holds the lock.
+func (s *SearchIndexes) names() []string {
+	names := make([]string, 0, len(s.indexes))
+	for name := range s.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// All returns an alias that searches every index at once. Documents are written to the
+// index they belong to, not through the alias.
+func (s *SearchIndexes) All() bleve.IndexAlias {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]bleve.Index, 0, len(s.indexes))
+	for _, name := range s.names() {
+		indexes = append(indexes, s.indexes[name])
+	}
+	return bleve.NewIndexAlias(indexes...)
+}
+
+// Each calls fn with every index in name order.
+func (s *SearchIndexes) Each(fn func(name string, index bleve.Index) error) error {
+	s.mu.Lock()
+	names := s.names()
+	indexes := make([]bleve.Index, len(names))
+	for i, name := range names {
+		indexes[i] = s.indexes[name]
+	}
+	s.mu.Unlock()
+
+	for i, name := range names {
+		if err := fn(name, indexes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Locate returns the index that holds the document with the ID.
+func (s *SearchIndexes) Locate(id string) (bleve.Index, error) {
+	var found bleve.Index
+	err := s.Each(func(name string, index bleve.Index) error {
+		doc, err := index.Document(id)
+		if err != nil {
+			return err
+		}
+		if doc != nil && found == nil {
+			found = index
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errMemoryNotFound
+	}
+	return found, nil
+}
+
+// List describes every index.
+func (s *SearchIndexes) List() ([]SearchIndexInfo, error) {
+	var infos []SearchIndexInfo
+	err := s.Each(func(name string, index bleve.Index) error {
+		count, err := index.DocCount()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, SearchIndexInfo{Name: name, Documents: count})
+		return nil
+	})
+	return infos, err
+}
+
+// Rebuild recreates the named index with the current mapping from the documents it holds,
+// so indexes created before a mapping change analyze their fields like new ones. Writes to
+// the index while it is rebuilt may fail.
+func (s *SearchIndexes) Rebuild(ctx context.Context, name string) (SearchIndexInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, ok := s.indexes[name]
+	if !ok {
+		return SearchIndexInfo{}, errSearchIndexNotFound
+	}
+	rebuilt, copied, err := search.Rebuild(ctx, index, s.path(name), s.mapping)
+	if rebuilt == nil {
+		delete(s.indexes, name)
+	} else {
+		s.indexes[name] = rebuilt
+	}
+	if err != nil {
+		return SearchIndexInfo{}, fmt.Errorf("error rebuilding search index %s: %v", name, err)
+	}
+	return SearchIndexInfo{Name: name, Documents: uint64(copied)}, nil
+}
+
+// Drop closes the named index and deletes its files. The vectors of the collection are
+// kept, and the index is created empty the next time it is written to.
+func (s *SearchIndexes) Drop(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, ok := s.indexes[name]
+	if !ok {
+		return errSearchIndexNotFound
+	}
+	delete(s.indexes, name)
+	if err := index.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.path(name))
+}
+
+// Close closes every index.
+func (s *SearchIndexes) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for name, index := range s.indexes {
+		if err := index.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing search index %s: %v", name, err))
+		}
+	}
+	s.indexes = make(map[string]bleve.Index)
+	return errors.Join(errs...)
+}
+
+// searchIndexFor returns the index a message belongs to: chat turns and web pages go to the
+// chat index, Git files to the documents index, and document chunks to the index of the
+// collection the document was ingested into.
+func searchIndexFor(msg ChatTurnMessage, collections map[string]string) string {
+	switch {
+	case msg.Source == SourceGit:
+		return CollectionDocuments
+	case msg.DocumentID != "":
+		if collection, ok := collections[msg.DocumentID]; ok {
+			return collection
+		}
+		return CollectionDocuments
+	default:
+		return CollectionChat
+	}
+}
+
+// migrateSearchIndex moves the documents of the single index of earlier versions into the
+// index of their collection, and renames the old index so it is only migrated once.
+func migrateSearchIndex(dataPath string, indexes *SearchIndexes) error {
+	legacyPath := filepath.Join(dataPath, legacySearchIndex)
+	if _, err := os.Stat(legacyPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	legacy, err := bleve.Open(legacyPath)
+	if err != nil {
+		return err
+	}
+
+	docs, err := ListDocuments(sqliteDB.db)
+	if err != nil {
+		legacy.Close()
+		return err
+	}
+	collections := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		collections[doc.ID] = doc.Collection
+	}
+
+	batches := make(map[string]*bleve.Batch)
+	var moved int
+	err = search.Each(context.Background(), legacy, func(id string, fields map[string]interface{}) error {
+		msg := messageFromFields(id, fields)
+		name := searchIndexFor(msg, collections)
+		index, err := indexes.Get(name)
+		if err != nil {
+			return err
+		}
+
+		batch, ok := batches[name]
+		if !ok {
+			batch = index.NewBatch()
+			batches[name] = batch
+		}
+		if err := batch.Index(id, msg); err != nil {
+			return err
+		}
+		if batch.Size() >= 500 {
+			if err := index.Batch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		moved++
+		return nil
+	})
+	for name, batch := range batches {
+		if err != nil {
+			break
+		}
+		index, _ := indexes.Get(name)
+		err = index.Batch(batch)
+	}
+	if cerr := legacy.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error migrating %s: %v", legacyPath, err)
+	}
+
+	if err := os.Rename(legacyPath, legacyPath+".migrated"); err != nil {
+		return err
+	}
+	pterm.Info.Printf("Moved %d documents from %s to the search indexes of their collections\n", moved, legacySearchIndex)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchIndexes(t *testing.T) {
+	dir := t.TempDir()
+	indexes, err := OpenSearchIndexes(dir, newSearchIndexMapping())
+	require.NoError(t, err)
+
+	chat, err := indexes.Get(CollectionChat)
+	require.NoError(t, err)
+	indexTestSearchDocuments(t, chat, time.Now())
+	docs, err := indexes.Get("manuals")
+	require.NoError(t, err)
+	require.NoError(t, docs.Index("m1", ChatTurnMessage{ID: "m1", DocumentID: "d1", Response: "Indexing the manuals", Source: SourceDocument}))
+
+	_, err = indexes.Get("../escape")
+	assert.Error(t, err)
+
+	infos, err := indexes.List()
+	require.NoError(t, err)
+	assert.Equal(t, []SearchIndexInfo{{Name: CollectionChat, Documents: 4}, {Name: "manuals", Documents: 1}}, infos)
+
+	located, err := indexes.Locate("m1")
+	require.NoError(t, err)
+	assert.Same(t, docs, located)
+	_, err = indexes.Locate("missing")
+	assert.ErrorIs(t, err, errMemoryNotFound)
+
+	// The English analyzer matches other forms of a word, across every index.
+	res, err := indexes.All().Search(bleve.NewSearchRequest(bleve.NewMatchQuery("indexes")))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Total)
+	assert.Equal(t, "m1", res.Hits[0].ID)
+
+	info, err := indexes.Rebuild(context.Background(), CollectionChat)
+	require.NoError(t, err)
+	assert.Equal(t, SearchIndexInfo{Name: CollectionChat, Documents: 4}, info)
+	_, err = indexes.Rebuild(context.Background(), "missing")
+	assert.ErrorIs(t, err, errSearchIndexNotFound)
+
+	require.NoError(t, indexes.Drop("manuals"))
+	assert.NoDirExists(t, filepath.Join(dir, "manuals.bleve"))
+	assert.ErrorIs(t, indexes.Drop("manuals"), errSearchIndexNotFound)
+	require.NoError(t, indexes.Close())
+
+	// Reopening finds the indexes left on disk.
+	indexes, err = OpenSearchIndexes(dir, newSearchIndexMapping())
+	require.NoError(t, err)
+	defer indexes.Close()
+	infos, err = indexes.List()
+	require.NoError(t, err)
+	assert.Equal(t, []SearchIndexInfo{{Name: CollectionChat, Documents: 4}}, infos)
+}
+
+func TestMigrateSearchIndex(t *testing.T) {
+	withTestDB(t)
+	require.NoError(t, db.Create(&Document{ID: "migrate-doc", Name: "manual.md", Collection: "manuals"}).Error)
+	defer db.Delete(&Document{ID: "migrate-doc"})
+
+	dataPath := t.TempDir()
+	legacy, err := bleve.New(filepath.Join(dataPath, legacySearchIndex), newSearchIndexMapping())
+	require.NoError(t, err)
+	for _, msg := range []ChatTurnMessage{
+		{ID: "turn-1-0", Response: "A chat turn", Source: SourceChat},
+		{ID: "web-1", Response: "A web page", Source: SourceWeb},
+		{ID: "git-1", DocumentID: "git:1:main.go", Response: "A Git file", Source: SourceGit},
+		{ID: "chunk-1", DocumentID: "migrate-doc", Response: "A document chunk", Source: SourceDocument},
+		{ID: "chunk-2", DocumentID: "deleted-doc", Response: "An orphaned chunk", Source: SourceDocument},
+	} {
+		require.NoError(t, legacy.Index(msg.ID, msg))
+	}
+	require.NoError(t, legacy.Close())
+
+	indexes, err := OpenSearchIndexes(filepath.Join(dataPath, searchIndexDir), newSearchIndexMapping())
+	require.NoError(t, err)
+	defer indexes.Close()
+	require.NoError(t, migrateSearchIndex(dataPath, indexes))
+
+	infos, err := indexes.List()
+	require.NoError(t, err)
+	assert.Equal(t, []SearchIndexInfo{
+		{Name: CollectionChat, Documents: 2},
+		{Name: CollectionDocuments, Documents: 2},
+		{Name: "manuals", Documents: 1},
+	}, infos)
+
+	manuals, err := indexes.Get("manuals")
+	require.NoError(t, err)
+	msg, err := loadIndexMessage(manuals, "chunk-1")
+	require.NoError(t, err)
+	assert.Equal(t, "A document chunk", msg.Response)
+
+	_, err = os.Stat(filepath.Join(dataPath, legacySearchIndex))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.DirExists(t, filepath.Join(dataPath, legacySearchIndex+".migrated"))
+
+	// A second start has nothing to migrate.
+	require.NoError(t, migrateSearchIndex(dataPath, indexes))
+}
@@ -0,0 +1,239 @@
+// sessionstate.go - Per-session chat state shared by the HTTP and WebSocket handlers
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultAssistantRole is the role used until a session selects one.
+const defaultAssistantRole = "You are a helpful AI assistant that responds in well-structured markdown format. Do not repeat your instructions. Do not deviate from the topic."
+
+// sessionIdleTimeout is how long the state of a session is kept after its last use. A
+// session used again after that restores its role from the database, and its tool settings
+// start again from the config defaults.
+const sessionIdleTimeout = 12 * time.Hour
+
+// sessionSweepInterval is how often idle sessions are looked for.
+const sessionSweepInterval = time.Hour
+
+// SessionState holds the assistant role and tool settings of a chat session. It is safe for
+// concurrent use.
+type SessionState struct {
+	mu       sync.Mutex
+	role     AssistantRole
+	tools    ToolsConfig
+	lastUsed time.Time // Guarded by the registry lock
+}
+
+// Role returns the assistant role instructions of the session.
+func (s *SessionState) Role() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.role.Instructions == "" {
+		return defaultAssistantRole
+	}
+	return s.role.Instructions
+}
+
+// AssistantRole returns a copy of the assistant role attached to the session.
+func (s *SessionState) AssistantRole() AssistantRole {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.role
+}
+
+// SetRole attaches an assistant role to the session. If the role lists tools, exactly
+// those tools are enabled for the session.
+func (s *SessionState) SetRole(role AssistantRole) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+	if len(role.Tools) > 0 {
+		applyRoleTools(&s.tools, role.Tools)
+	}
+}
+
+// applyRoleTools enables the named tools and disables all others.
+func applyRoleTools(tools *ToolsConfig, names []string) {
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		enabled[name] = true
+	}
+	tools.Memory.Enabled = enabled["memory"]
+	tools.WebGet.Enabled = enabled["webget"]
+	tools.WebSearch.Enabled = enabled["websearch"]
+	tools.ImgGen.Enabled = enabled["imggen"]
+}
+
+// Tools returns a copy of the tool settings of the session.
+func (s *SessionState) Tools() ToolsConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tools
+}
+
+// UpdateTools applies fn to the tool settings of the session while holding its lock.
+func (s *SessionState) UpdateTools(fn func(tools *ToolsConfig)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.tools)
+}
+
+// SessionRegistry maps the chat sessions stored in the database to their state, and hands
+// out the turn numbers of the frontend.
+type SessionRegistry struct {
+	mu        sync.Mutex
+	config    *AppConfig
+	db        *gorm.DB
+	sessions  map[int64]*SessionState
+	lastSweep time.Time
+	turns     atomic.Int64
+}
+
+// NewSessionRegistry creates a registry that initializes new sessions from the config defaults
+// and restores their roles from db. Turn numbers start from the current time, so they keep
+// growing across restarts.
+func NewSessionRegistry(config *AppConfig, db *gorm.DB) *SessionRegistry {
+	r := &SessionRegistry{
+		config:   config,
+		db:       db,
+		sessions: make(map[int64]*SessionState),
+	}
+	r.turns.Store(time.Now().UnixMilli())
+	return r
+}
+
+// NextTurn returns a new turn number to identify frontend chat elements. Numbers are unique
+// across sessions, so the elements of several sessions can share a page.
+func (r *SessionRegistry) NextTurn() int {
+	return int(r.turns.Add(1))
+}
+
+// Get returns the state of a session, restoring it from the database and the config defaults
+// if needed. Sessions that are not in the database get default state that is not kept.
+func (r *SessionRegistry) Get(sessionID int64) *SessionState {
+	now := time.Now()
+	r.mu.Lock()
+	r.evictIdle(now)
+	state, ok := r.sessions[sessionID]
+	if ok {
+		state.lastUsed = now
+	}
+	r.mu.Unlock()
+	if ok {
+		return state
+	}
+
+	state = &SessionState{}
+	if r.config != nil {
+		state.tools = r.config.Tools
+	}
+	if !r.restore(state, sessionID) {
+		return state
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Another request may have restored the session meanwhile.
+	if existing, ok := r.sessions[sessionID]; ok {
+		existing.lastUsed = now
+		return existing
+	}
+	state.lastUsed = now
+	r.sessions[sessionID] = state
+	return state
+}
+
+// evictIdle drops the sessions unused for sessionIdleTimeout, looking for them at most once
+// per sessionSweepInterval. The caller holds the lock.
+func (r *SessionRegistry) evictIdle(now time.Time) {
+	if now.Sub(r.lastSweep) < sessionSweepInterval {
+		return
+	}
+	r.lastSweep = now
+	for id, state := range r.sessions {
+		if now.Sub(state.lastUsed) >= sessionIdleTimeout {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+// UpdateRole attaches the edited role to the sessions that use it, so they pick up its new
+// instructions, settings and tools.
+func (r *SessionRegistry) UpdateRole(role AssistantRole) {
+	for _, state := range r.withRole(role.ID) {
+		state.SetRole(role)
+	}
+}
+
+// RemoveRole returns the sessions that use a deleted role to the default role. Sessions
+// whose tools the role chose go back to the tool defaults of the config.
+func (r *SessionRegistry) RemoveRole(id int64) {
+	var defaults ToolsConfig
+	if r.config != nil {
+		defaults = r.config.Tools
+	}
+	for _, state := range r.withRole(id) {
+		state.mu.Lock()
+		if len(state.role.Tools) > 0 {
+			state.tools = defaults
+		}
+		state.role = AssistantRole{}
+		state.mu.Unlock()
+	}
+}
+
+// withRole returns the sessions that use the role with the ID.
+func (r *SessionRegistry) withRole(id int64) []*SessionState {
+	if id == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var states []*SessionState
+	for _, state := range r.sessions {
+		if state.AssistantRole().ID == id {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// parseSessionID parses a session ID sent by the frontend. It returns zero if the value is missing or invalid.
+func parseSessionID(value string) int64 {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// restore attaches the role stored on the chat session so it survives restarts. It reports
+// false if the session is not in the database.
+func (r *SessionRegistry) restore(state *SessionState, sessionID int64) bool {
+	if r.db == nil || sessionID <= 0 {
+		return false
+	}
+
+	var session ChatSession
+	if err := r.db.Select("id", "role_id").First(&session, sessionID).Error; err != nil {
+		return false
+	}
+	if session.RoleID == 0 {
+		return true
+	}
+
+	if role, err := GetAssistantRole(r.db, session.RoleID); err == nil {
+		state.SetRole(role)
+	}
+	return true
+}
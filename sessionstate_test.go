@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestSessions stores n chat sessions and returns their IDs.
+func createTestSessions(t *testing.T, n int) []int64 {
+	ids := make([]int64, n)
+	for i := range ids {
+		session, err := CreateChatSession(db)
+		require.NoError(t, err)
+		ids[i] = session.ID
+	}
+	return ids
+}
+
+func TestSessionRegistryIsolatesSessions(t *testing.T) {
+	config := &AppConfig{}
+	config.Tools.Memory.Enabled = true
+	config.Tools.Memory.TopN = 3
+
+	ids := createTestSessions(t, 2)
+	registry := NewSessionRegistry(config, db)
+	first := registry.Get(ids[0])
+	second := registry.Get(ids[1])
+
+	assert.Same(t, first, registry.Get(ids[0]))
+	assert.Equal(t, defaultAssistantRole, first.Role())
+	assert.True(t, first.Tools().Memory.Enabled)
+
+	first.SetRole(AssistantRole{Name: "code_review", Instructions: "You are a code reviewer."})
+	first.UpdateTools(func(tools *ToolsConfig) {
+		tools.Memory.Enabled = false
+		tools.WebGet.Enabled = true
+	})
+
+	assert.Equal(t, "You are a code reviewer.", first.Role())
+	assert.Equal(t, defaultAssistantRole, second.Role())
+	assert.False(t, first.Tools().Memory.Enabled)
+	assert.True(t, second.Tools().Memory.Enabled)
+	assert.False(t, second.Tools().WebGet.Enabled)
+	assert.True(t, config.Tools.Memory.Enabled, "session updates must not change the config defaults")
+}
+
+func TestSessionStateRoleTools(t *testing.T) {
+	config := &AppConfig{}
+	config.Tools.Memory.Enabled = true
+
+	state := NewSessionRegistry(config, db).Get(createTestSessions(t, 1)[0])
+	state.SetRole(AssistantRole{Name: "research", Instructions: "Research the topic.", Tools: []string{"websearch", "webget"}})
+
+	tools := state.Tools()
+	assert.False(t, tools.Memory.Enabled)
+	assert.True(t, tools.WebSearch.Enabled)
+	assert.True(t, tools.WebGet.Enabled)
+	assert.Equal(t, "research", state.AssistantRole().Name)
+
+	// Roles without tools keep the current tool settings.
+	state.SetRole(AssistantRole{Name: "chat", Instructions: "Chat."})
+	assert.True(t, state.Tools().WebSearch.Enabled)
+	assert.Equal(t, "Chat.", state.Role())
+}
+
+func TestSessionRegistryRoleChanges(t *testing.T) {
+	config := &AppConfig{}
+	config.Tools.Memory.Enabled = true
+
+	ids := createTestSessions(t, 3)
+	registry := NewSessionRegistry(config, db)
+	first, second, other := registry.Get(ids[0]), registry.Get(ids[1]), registry.Get(ids[2])
+	role := AssistantRole{ID: 7, Name: "research", Instructions: "Research the topic.", Tools: []string{"websearch"}}
+	first.SetRole(role)
+	second.SetRole(role)
+	other.SetRole(AssistantRole{ID: 8, Name: "chat", Instructions: "Chat."})
+
+	role.Instructions = "Research the topic in depth."
+	role.Tools = []string{"webget"}
+	registry.UpdateRole(role)
+	assert.Equal(t, "Research the topic in depth.", first.Role())
+	assert.True(t, second.Tools().WebGet.Enabled)
+	assert.False(t, second.Tools().WebSearch.Enabled)
+	assert.Equal(t, "Chat.", other.Role())
+
+	registry.RemoveRole(7)
+	assert.Equal(t, defaultAssistantRole, first.Role())
+	assert.Zero(t, second.AssistantRole().ID)
+	assert.Equal(t, config.Tools, second.Tools())
+	assert.Equal(t, "Chat.", other.Role())
+}
+
+func TestSessionRegistryNextTurnConcurrent(t *testing.T) {
+	registry := NewSessionRegistry(nil, nil)
+	start := registry.NextTurn()
+	assert.Greater(t, start, 0)
+
+	const workers = 100
+	turns := make(chan int, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			turns <- registry.NextTurn()
+		}()
+	}
+	wg.Wait()
+	close(turns)
+
+	seen := make(map[int]bool)
+	for turn := range turns {
+		assert.False(t, seen[turn], "turn %d handed out twice", turn)
+		assert.Greater(t, turn, start)
+		seen[turn] = true
+	}
+	assert.Len(t, seen, workers)
+
+	// A registry created later, as after a restart, does not hand out the same numbers.
+	time.Sleep(2 * time.Millisecond)
+	assert.Greater(t, NewSessionRegistry(nil, nil).NextTurn(), start)
+}
+
+func TestSessionRegistryUnknownSessions(t *testing.T) {
+	config := &AppConfig{}
+	config.Tools.Memory.Enabled = true
+	registry := NewSessionRegistry(config, db)
+
+	// Sessions that do not exist get the defaults, which are not kept.
+	state := registry.Get(1 << 40)
+	assert.True(t, state.Tools().Memory.Enabled)
+	assert.NotSame(t, state, registry.Get(1<<40))
+	assert.NotSame(t, registry.Get(0), registry.Get(0))
+	assert.Empty(t, registry.sessions)
+}
+
+func TestSessionRegistryEvictsIdleSessions(t *testing.T) {
+	ids := createTestSessions(t, 2)
+	registry := NewSessionRegistry(&AppConfig{}, db)
+	idle, active := registry.Get(ids[0]), registry.Get(ids[1])
+	idle.UpdateTools(func(tools *ToolsConfig) { tools.WebGet.Enabled = true })
+
+	now := time.Now()
+	registry.mu.Lock()
+	idle.lastUsed = now.Add(-sessionIdleTimeout)
+	registry.evictIdle(now.Add(sessionSweepInterval))
+	registry.mu.Unlock()
+
+	assert.Same(t, active, registry.Get(ids[1]))
+	restored := registry.Get(ids[0])
+	assert.NotSame(t, idle, restored)
+	assert.False(t, restored.Tools().WebGet.Enabled)
+}
+
+func TestParseSessionID(t *testing.T) {
+	assert.Equal(t, int64(42), parseSessionID("42"))
+	assert.Equal(t, int64(0), parseSessionID(""))
+	assert.Equal(t, int64(0), parseSessionID("abc"))
+	assert.Equal(t, int64(0), parseSessionID("-1"))
+}
@@ -7,16 +7,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"sync/atomic"
 
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/spf13/afero"
 )
 
 var (
-	LocalFs        = new(afero.OsFs)
-	MemFs          = afero.NewMemMapFs()
-	messageCounter int64
+	LocalFs = new(afero.OsFs)
+	MemFs   = afero.NewMemMapFs()
 )
 
 func InitServer(configPath string) (string, error) {
@@ -126,11 +124,6 @@ func CopyFiles(fsys embed.FS, srcDir, destDir string) error {
 	return nil
 }
 
-// Increments and returns a counter that gets appended to the id for frontend chat elements
-func IncrementTurn() int64 {
-	return atomic.AddInt64(&messageCounter, 1)
-}
-
 // findURLInText searches for a URL in a given text and returns it if found.
 // It returns nil if no valid URL is found.
 func URLParse(text string) *url.URL {